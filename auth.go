@@ -121,6 +121,17 @@ func computeCallerNonces(params []*sessionParam) error {
 	return nil
 }
 
+// markSessionsUnusable marks the sessions in params as unusable after a command failed in a way that means that their host-side state
+// may no longer be consistent with the TPM.
+func markSessionsUnusable(params []*sessionParam, err error) {
+	for _, param := range params {
+		if param.session == nil {
+			continue
+		}
+		param.session.markUnusable(err)
+	}
+}
+
 func computeBindName(name Name, auth Auth) Name {
 	if len(auth) > len(name) {
 		auth = auth[0:len(name)]
@@ -192,15 +203,8 @@ func buildCommandAuth(tpm *TPMContext, param *sessionParam, commandCode CommandC
 	return buildCommandSessionAuth(tpm, param, commandCode, commandHandles, cpBytes, decryptNonce, encryptNonce)
 }
 
-func processResponseSessionAuth(tpm *TPMContext, resp authResponse, param *sessionParam, commandCode CommandCode, responseCode ResponseCode, rpBytes []byte) error {
+func verifyResponseSessionAuth(resp authResponse, param *sessionParam, commandCode CommandCode, responseCode ResponseCode, rpBytes []byte) error {
 	scData := param.session.scData()
-	scData.NonceTPM = resp.Nonce
-	scData.IsAudit = resp.SessionAttrs&attrAudit > 0
-	scData.IsExclusive = resp.SessionAttrs&attrAuditExclusive > 0
-
-	if resp.SessionAttrs&attrContinueSession == 0 {
-		param.session.invalidate()
-	}
 
 	if scData.SessionType == SessionTypePolicy && scData.PolicyHMACType == policyHMACTypePassword {
 		if len(resp.HMAC) != 0 {
@@ -215,7 +219,7 @@ func processResponseSessionAuth(tpm *TPMContext, resp authResponse, param *sessi
 	}
 
 	rpHash := cryptComputeRpHash(scData.HashAlg, responseCode, commandCode, rpBytes)
-	hmac := cryptComputeSessionResponseHMAC(param.session, key, rpHash, resp.SessionAttrs)
+	hmac := cryptComputeSessionResponseHMAC(param.session, key, rpHash, resp.Nonce, resp.SessionAttrs)
 
 	if !bytes.Equal(hmac, resp.HMAC) {
 		return errors.New("incorrect HMAC")
@@ -224,12 +228,15 @@ func processResponseSessionAuth(tpm *TPMContext, resp authResponse, param *sessi
 	return nil
 }
 
-func processResponseAuth(tpm *TPMContext, resp authResponse, param *sessionParam, commandCode CommandCode, responseCode ResponseCode, rpBytes []byte) error {
-	if param.session == nil {
-		return nil
-	}
+func updateSessionFromResponseAuth(resp authResponse, param *sessionParam) {
+	scData := param.session.scData()
+	scData.NonceTPM = resp.Nonce
+	scData.IsAudit = resp.SessionAttrs&attrAudit > 0
+	scData.IsExclusive = resp.SessionAttrs&attrAuditExclusive > 0
 
-	return processResponseSessionAuth(tpm, resp, param, commandCode, responseCode, rpBytes)
+	if resp.SessionAttrs&attrContinueSession == 0 {
+		param.session.invalidate()
+	}
 }
 
 func buildCommandAuthArea(tpm *TPMContext, sessionParams []*sessionParam, commandCode CommandCode, commandHandles []Name, cpBytes []byte) (commandAuthArea, error) {
@@ -263,11 +270,22 @@ func buildCommandAuthArea(tpm *TPMContext, sessionParams []*sessionParam, comman
 }
 
 func processResponseAuthArea(tpm *TPMContext, authResponses []authResponse, sessionParams []*sessionParam, commandCode CommandCode, responseCode ResponseCode, rpBytes []byte) error {
+	// Verify the response authorization for every session before updating any of them, so that the host-side state of each session
+	// is only advanced for a response that is fully authenticated.
 	for i, resp := range authResponses {
-		if err := processResponseAuth(tpm, resp, sessionParams[i], commandCode, responseCode, rpBytes); err != nil {
-			return fmt.Errorf("encountered an error for session at index %d: %v", i, err)
+		if sessionParams[i].session == nil {
+			continue
+		}
+		if err := verifyResponseSessionAuth(resp, sessionParams[i], commandCode, responseCode, rpBytes); err != nil {
+			return &InvalidResponseAuthError{Command: commandCode, Index: i + 1, msg: err.Error()}
 		}
 	}
+	for i, resp := range authResponses {
+		if sessionParams[i].session == nil {
+			continue
+		}
+		updateSessionFromResponseAuth(resp, sessionParams[i])
+	}
 
 	if err := decryptResponseParameter(sessionParams, rpBytes); err != nil {
 		return fmt.Errorf("cannot decrypt first response parameter: %v", err)
@@ -281,6 +299,9 @@ func (t *TPMContext) validateAndAppendSessionParam(params []*sessionParam, in *s
 		if err := t.checkHandleContextParam(in.session); err != nil {
 			return nil, fmt.Errorf("invalid context for session: %v", err)
 		}
+		if err := in.session.status.unusableErr; err != nil {
+			return nil, fmt.Errorf("session is unusable because a previous command that used it failed: %v", err)
+		}
 		scData := in.session.scData()
 		if scData == nil {
 			return nil, errors.New("invalid context for session: incomplete session can only be used in TPMContext.FlushContext")
@@ -5,9 +5,14 @@
 package tpm2_test
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
+	"golang.org/x/xerrors"
+
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestHMACSessions(t *testing.T) {
@@ -210,3 +215,177 @@ func TestPolicySessions(t *testing.T) {
 		})
 	}
 }
+
+func TestHMACSessionInvalidResponseHMAC(t *testing.T) {
+	nonceTPM1 := Nonce(bytes.Repeat([]byte{0x01}, 32))
+	nonceTPM2 := Nonce(bytes.Repeat([]byte{0x02}, 32))
+
+	params, _ := mu.MarshalToBytes(Handle(0x02000000), nonceTPM1)
+	startRsp := makeMockResponse(Success, nil, params)
+
+	cmdRsp := makeMockResponse(Success, nil, nil, mockResponseAuth{Nonce: nonceTPM2, Attrs: 1, HMAC: make(Auth, 32)})
+
+	tcti := &mockTcti{responses: bytes.NewReader(append(startRsp, cmdRsp...))}
+	tpm, _ := NewTPMContext(tcti)
+
+	sc, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	if !bytes.Equal(sc.NonceTPM(), nonceTPM1) {
+		t.Errorf("Unexpected nonceTPM")
+	}
+
+	owner := tpm.OwnerHandleContext()
+	owner.SetAuthValue([]byte("foo"))
+
+	tcti.commands.Reset()
+	err = tpm.ClockRateAdjust(owner, ClockCoarseSlower, sc.WithAttrs(AttrContinueSession))
+	if err == nil {
+		t.Fatalf("ClockRateAdjust should have failed")
+	}
+	var e *InvalidResponseAuthError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e.Command != CommandClockRateAdjust || e.Index != 1 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// The response isn't authenticated, so the session state shouldn't be updated from it.
+	if !bytes.Equal(sc.NonceTPM(), nonceTPM1) {
+		t.Errorf("nonceTPM was updated from an unauthenticated response")
+	}
+
+	// Check that the command was authorized with the HMAC session rather than a password.
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 1)
+	if len(c.Auths) != 1 {
+		t.Fatalf("Unexpected number of command authorizations: %d", len(c.Auths))
+	}
+	auth := c.Auths[0]
+	if auth.Handle != sc.Handle() {
+		t.Errorf("Unexpected session handle: %v", auth.Handle)
+	}
+	if len(auth.Nonce) != 32 {
+		t.Errorf("Unexpected nonceCaller length: %d", len(auth.Nonce))
+	}
+	if len(auth.HMAC) != 32 {
+		t.Errorf("Unexpected HMAC length: %d", len(auth.HMAC))
+	}
+
+	// The session should be unusable now.
+	tcti.commands.Reset()
+	err = tpm.ClockRateAdjust(owner, ClockCoarseSlower, sc.WithAttrs(AttrContinueSession))
+	if err == nil {
+		t.Fatalf("Subsequent usage of the session should fail")
+	}
+	if !strings.HasPrefix(err.Error(), "cannot process ResourceContextWithSession for command TPM_CC_ClockRateAdjust at index 1: "+
+		"session is unusable because a previous command that used it failed: ") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.commands.Len() != 0 {
+		t.Errorf("No command should have been sent to the TPM")
+	}
+}
+
+func TestPolicyPasswordInvalidResponseHMAC(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+	startRsp := makeMockResponse(Success, nil, params)
+	policyRsp := makeMockResponse(Success, nil, nil)
+	// A policy session with a TPM2_PolicyPassword assertion must have an empty response HMAC.
+	cmdRsp := makeMockResponse(Success, nil, nil, mockResponseAuth{Nonce: make(Nonce, 32), Attrs: 1, HMAC: make(Auth, 32)})
+
+	var responses []byte
+	for _, r := range [][]byte{startRsp, policyRsp, cmdRsp} {
+		responses = append(responses, r...)
+	}
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(responses)})
+
+	sc, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	if err := tpm.PolicyPassword(sc); err != nil {
+		t.Fatalf("PolicyPassword failed: %v", err)
+	}
+
+	err = tpm.ClockRateAdjust(tpm.OwnerHandleContext(), ClockCoarseSlower, sc.WithAttrs(AttrContinueSession))
+	var e *InvalidResponseAuthError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e.Command != CommandClockRateAdjust || e.Index != 1 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestSessionStateAfterFailedCommands(t *testing.T) {
+	nonceTPM1 := Nonce(bytes.Repeat([]byte{0x01}, 32))
+	nonceTPM2 := Nonce(bytes.Repeat([]byte{0x02}, 32))
+
+	var responses [][]byte
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		rsp := responses[0]
+		responses = responses[1:]
+		return rsp
+	}}
+	tpm, _ := NewTPMContext(tcti)
+
+	params, _ := mu.MarshalToBytes(Handle(0x02000000), nonceTPM1)
+	startRsp := makeMockResponse(Success, nil, params)
+	responses = append(responses, startRsp)
+
+	sc, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	sc.SetAttrs(AttrContinueSession)
+
+	// The TPM doesn't update the state of a session when a command fails, so the session should still be usable after the TPM
+	// responds with an error.
+	failRsp := makeMockResponse(ResponseCode(0x98e), nil, nil)
+	responses = append(responses, failRsp)
+	err = tpm.ClockRateAdjust(tpm.OwnerHandleContext(), ClockCoarseSlower, sc)
+	if !IsTPMSessionError(err, ErrorAuthFail, CommandClockRateAdjust, 1) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(sc.NonceTPM(), nonceTPM1) {
+		t.Errorf("Unexpected nonceTPM")
+	}
+
+	successRsp := makeMockResponse(Success, nil, nil, mockResponseAuth{Nonce: nonceTPM2, Attrs: 1})
+	responses = append(responses, successRsp)
+	if err := tpm.ClockRateAdjust(tpm.OwnerHandleContext(), ClockCoarseSlower, sc); err != nil {
+		t.Fatalf("ClockRateAdjust failed: %v", err)
+	}
+	if !bytes.Equal(sc.NonceTPM(), nonceTPM2) {
+		t.Errorf("nonceTPM wasn't updated from the response")
+	}
+
+	// If no response is received, it isn't known whether the TPM executed the command and the session becomes unusable.
+	responses = append(responses, nil)
+	err = tpm.ClockRateAdjust(tpm.OwnerHandleContext(), ClockCoarseSlower, sc)
+	var e *TctiError
+	if !xerrors.As(err, &e) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	tcti.commands.Reset()
+	err = tpm.ClockRateAdjust(tpm.OwnerHandleContext(), ClockCoarseSlower, sc)
+	if err == nil {
+		t.Fatalf("Subsequent usage of the session should fail")
+	}
+	if !strings.HasPrefix(err.Error(), "cannot process ResourceContextWithSession for command TPM_CC_ClockRateAdjust at index 1: "+
+		"session is unusable because a previous command that used it failed: ") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.commands.Len() != 0 {
+		t.Errorf("No command should have been sent to the TPM")
+	}
+
+	// An unusable session can still be flushed.
+	flushRsp := makeMockResponse(Success, nil, nil)
+	responses = append(responses, flushRsp)
+	if err := tpm.FlushContext(sc); err != nil {
+		t.Errorf("FlushContext failed: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"fmt"
+)
+
+// Section 14 - Asymmetric Primitives
+
+// rsaMaxMessageSize returns the maximum size of a message that can be encrypted with the RSA key with the supplied public area
+// using the supplied padding scheme. If the scheme is RSASchemeNull, the scheme of the key is used. If the maximum size can't be
+// determined, this returns false.
+func rsaMaxMessageSize(public *Public, scheme *RSADecrypt) (int, bool) {
+	if public.Type != ObjectTypeRSA {
+		return 0, false
+	}
+	params, ok := public.Params.Data.(*RSAParams)
+	if !ok {
+		return 0, false
+	}
+
+	schemeId := scheme.Scheme
+	details := scheme.Details
+	if schemeId == RSASchemeNull {
+		schemeId = params.Scheme.Scheme
+		details = params.Scheme.Details
+	}
+
+	k := int(params.KeyBits) / 8
+	switch schemeId {
+	case RSASchemeNull:
+		return k, true
+	case RSASchemeRSAES:
+		return k - 11, true
+	case RSASchemeOAEP:
+		oaep, ok := details.Data.(*EncSchemeOAEP)
+		if !ok || !oaep.HashAlg.Supported() {
+			return 0, false
+		}
+		return k - 2*oaep.HashAlg.Size() - 2, true
+	default:
+		return 0, false
+	}
+}
+
+// terminateRSALabel returns a copy of label with a trailing zero byte appended, if label is not empty and does not already end
+// with one. The TPM requires a non-empty label to be zero terminated, and the terminating byte is included in the label used by
+// the padding scheme.
+func terminateRSALabel(label Label) Label {
+	if len(label) == 0 || label[len(label)-1] == 0 {
+		return label
+	}
+	out := make(Label, len(label)+1)
+	copy(out, label)
+	return out
+}
+
+// RSAEncrypt executes the TPM2_RSA_Encrypt command to perform RSA encryption of message using the public part of the RSA key
+// associated with keyContext. The key must have the AttrDecrypt attribute set, else a *TPMHandleError error with an error code of
+// ErrorAttributes will be returned for handle index 1. If keyContext does not correspond to a RSA key, a *TPMHandleError error with
+// an error code of ErrorKey will be returned for handle index 1. This command does not require authorization.
+//
+// The padding scheme is specified by inScheme. If inScheme is nil or the Scheme field is RSASchemeNull, the scheme of the key is
+// used. If the scheme of the key is not RSASchemeNull, inScheme must either be nil, have a Scheme field of RSASchemeNull or match
+// the scheme of the key, else a *TPMParameterError error with an error code of ErrorScheme will be returned for parameter index 2.
+// If both inScheme and the scheme of the key are RSASchemeNull, then no padding is applied.
+//
+// The optional label is used by the RSASchemeOAEP padding scheme. The TPM requires a non-empty label to be terminated with a zero
+// byte, and includes this byte in the padding. If label is not empty and does not end with a zero byte, one is appended before
+// sending it to the TPM so that the same label can be passed to TPMContext.RSADecrypt.
+//
+// If the public area of the key associated with keyContext is known and message is too large to be encrypted with the key and
+// selected padding scheme, an error will be returned without executing the command. Otherwise, the TPM will return a
+// *TPMParameterError error with an error code of ErrorValue for parameter index 1.
+//
+// On success, the encrypted data is returned.
+func (t *TPMContext) RSAEncrypt(keyContext ResourceContext, message PublicKeyRSA, inScheme *RSADecrypt, label Label, sessions ...SessionContext) (PublicKeyRSA, error) {
+	if inScheme == nil {
+		inScheme = &RSADecrypt{Scheme: RSASchemeNull}
+	}
+	if o, isObject := unwrapHandleContext(keyContext).(*objectContext); isObject && o.public() != nil {
+		if max, ok := rsaMaxMessageSize(o.public(), inScheme); ok && len(message) > max {
+			return nil, makeInvalidArgError("message", fmt.Sprintf("message is too large for the key and padding scheme (got "+
+				"%d bytes, maximum is %d bytes)", len(message), max))
+		}
+	}
+
+	var outData PublicKeyRSA
+	if err := t.RunCommand(CommandRSAEncrypt, sessions,
+		keyContext, Delimiter,
+		message, inScheme, terminateRSALabel(label), Delimiter,
+		Delimiter,
+		&outData); err != nil {
+		return nil, err
+	}
+
+	return outData, nil
+}
+
+// RSADecrypt executes the TPM2_RSA_Decrypt command to perform RSA decryption of cipherText using the private part of the RSA key
+// associated with keyContext. The key must have the AttrDecrypt attribute set and must not have the AttrRestricted attribute set,
+// else a *TPMHandleError error with an error code of ErrorAttributes will be returned for handle index 1. If keyContext does not
+// correspond to a RSA key, a *TPMHandleError error with an error code of ErrorKey will be returned for handle index 1. The command
+// requires authorization with the user auth role for keyContext, with session based authorization provided via
+// keyContextAuthSession.
+//
+// The padding scheme is specified by inScheme, which is interpreted in the same way as for TPMContext.RSAEncrypt. The label is
+// also handled in the same way as for TPMContext.RSAEncrypt, so the same label can be used for both commands.
+//
+// If the size of cipherText is not the same as the size of the public modulus of the key, a *TPMParameterError error with an
+// error code of ErrorSize will be returned for parameter index 1. If the decryption fails because the padding is invalid, a
+// *TPMParameterError error with an error code of ErrorValue will be returned for parameter index 1.
+//
+// On success, the decrypted data is returned.
+func (t *TPMContext) RSADecrypt(keyContext ResourceContext, cipherText PublicKeyRSA, inScheme *RSADecrypt, label Label, keyContextAuthSession SessionContext, sessions ...SessionContext) (PublicKeyRSA, error) {
+	if inScheme == nil {
+		inScheme = &RSADecrypt{Scheme: RSASchemeNull}
+	}
+
+	var message PublicKeyRSA
+	if err := t.RunCommand(CommandRSADecrypt, sessions,
+		ResourceContextWithSession{Context: keyContext, Session: keyContextAuthSession}, Delimiter,
+		cipherText, inScheme, terminateRSALabel(label), Delimiter,
+		Delimiter,
+		&message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
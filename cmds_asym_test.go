@@ -0,0 +1,152 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+func TestRSAEncryptDecrypt(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	template := Public{
+		Type:    ObjectTypeRSA,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrDecrypt,
+		Params: PublicParamsU{
+			Data: &RSAParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    RSAScheme{Scheme: RSASchemeNull},
+				KeyBits:   2048,
+				Exponent:  0}}}
+	priv, pub, _, _, _, err := tpm.Create(primary, nil, &template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	key, err := tpm.Load(primary, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer flushContext(t, tpm, key)
+
+	for _, data := range []struct {
+		desc   string
+		scheme *RSADecrypt
+		label  Label
+	}{
+		{
+			desc:   "RSAES",
+			scheme: &RSADecrypt{Scheme: RSASchemeRSAES, Details: AsymSchemeU{Data: &EncSchemeRSAES{}}},
+		},
+		{
+			desc:   "OAEP",
+			scheme: &RSADecrypt{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}},
+		},
+		{
+			desc:   "OAEPWithLabel",
+			scheme: &RSADecrypt{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}},
+			label:  Label("foo"),
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			message := PublicKeyRSA("secret message")
+
+			cipherText, err := tpm.RSAEncrypt(key, message, data.scheme, data.label)
+			if err != nil {
+				t.Fatalf("RSAEncrypt failed: %v", err)
+			}
+			if len(cipherText) != 256 {
+				t.Errorf("Unexpected ciphertext length: %d", len(cipherText))
+			}
+
+			plainText, err := tpm.RSADecrypt(key, cipherText, data.scheme, data.label, nil)
+			if err != nil {
+				t.Fatalf("RSADecrypt failed: %v", err)
+			}
+			if !bytes.Equal(plainText, message) {
+				t.Errorf("Unexpected plaintext: %x", plainText)
+			}
+		})
+	}
+}
+
+func TestRSAEncryptMock(t *testing.T) {
+	pub := &Public{
+		Type:    ObjectTypeRSA,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrUserWithAuth | AttrDecrypt,
+		Params: PublicParamsU{
+			Data: &RSAParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    RSAScheme{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}},
+				KeyBits:   2048,
+				Exponent:  0}},
+		Unique: PublicIDU{Data: make(PublicKeyRSA, 256)}}
+	key, err := CreateObjectResourceContextFromPublic(0x80000001, pub)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	t.Run("Label", func(t *testing.T) {
+		params, _ := mu.MarshalToBytes(make(PublicKeyRSA, 256))
+		rsp := makeMockResponse(Success, nil, params)
+		decryptRsp := makeMockResponse(Success, nil, params, mockPasswordAuth)
+
+		tcti := &mockTcti{responses: bytes.NewReader(append(rsp, decryptRsp...))}
+		tpm, _ := NewTPMContext(tcti)
+
+		message := make(PublicKeyRSA, 190)
+		if _, err := tpm.RSAEncrypt(key, message, nil, Label("foo")); err != nil {
+			t.Fatalf("RSAEncrypt failed: %v", err)
+		}
+
+		// The label should have a terminating zero byte appended.
+		expected, _ := mu.MarshalToBytes(message, RSASchemeNull, Label("foo\x00"))
+		if !bytes.Equal(tcti.commands.Bytes()[14:], expected) {
+			t.Errorf("Unexpected command parameters: %x", tcti.commands.Bytes()[14:])
+		}
+
+		// An already terminated label should be sent unmodified.
+		tcti.commands.Reset()
+		if _, err := tpm.RSADecrypt(key, make(PublicKeyRSA, 256), nil, Label("foo\x00"), nil); err != nil {
+			t.Fatalf("RSADecrypt failed: %v", err)
+		}
+		expected, _ = mu.MarshalToBytes(make(PublicKeyRSA, 256), RSASchemeNull, Label("foo\x00"))
+		if !bytes.HasSuffix(tcti.commands.Bytes(), expected) {
+			t.Errorf("Unexpected command parameters: %x", tcti.commands.Bytes())
+		}
+	})
+
+	for _, data := range []struct {
+		desc   string
+		scheme *RSADecrypt
+		max    int
+	}{
+		{desc: "KeyScheme", max: 190},
+		{desc: "RSAES", scheme: &RSADecrypt{Scheme: RSASchemeRSAES, Details: AsymSchemeU{Data: &EncSchemeRSAES{}}}, max: 245},
+		{desc: "OAEPSHA1", scheme: &RSADecrypt{Scheme: RSASchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA1}}}, max: 214},
+	} {
+		t.Run("MessageTooLarge/"+data.desc, func(t *testing.T) {
+			tcti := &mockTcti{}
+			tpm, _ := NewTPMContext(tcti)
+
+			_, err := tpm.RSAEncrypt(key, make(PublicKeyRSA, data.max+1), data.scheme, nil)
+			if err == nil {
+				t.Fatalf("RSAEncrypt should have failed")
+			}
+			if tcti.commands.Len() != 0 {
+				t.Errorf("RSAEncrypt shouldn't have sent a command to the TPM")
+			}
+		})
+	}
+}
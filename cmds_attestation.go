@@ -111,8 +111,12 @@ func (t *TPMContext) CertifyCreation(signContext, objectContext ResourceContext,
 // it is provided, then the specified scheme must match that of the signing key, else a *TPMParameterError error with an error code of
 // ErrorScheme will be returned for parameter index 2.
 //
-// On successful, it returns an attestation structure containing the hash of the PCRs selected by the pcrs parameter. If signContext
-// is not nil, the attestation structure will be signed by the associated key and returned too.
+// If inScheme is nil, SigSchemeAlgNull is used, which causes the TPM to sign with the scheme of the key associated with signContext.
+//
+// On success, it returns an attestation structure containing the hash of the PCRs selected by the pcrs parameter. If signContext
+// is not nil, the attestation structure will be signed by the associated key and returned too. The signature is computed over the
+// exact contents of the returned AttestRaw, so it can be verified without a TPM by computing a digest of it with the digest algorithm
+// of the signature.
 func (t *TPMContext) Quote(signContext ResourceContext, qualifyingData Data, inScheme *SigScheme, pcrs PCRSelectionList, signContextAuthSession SessionContext, sessions ...SessionContext) (AttestRaw, *Signature, error) {
 	if inScheme == nil {
 		inScheme = &SigScheme{Scheme: SigSchemeAlgNull}
@@ -154,6 +158,9 @@ func (t *TPMContext) Quote(signContext ResourceContext, qualifyingData Data, inS
 //
 // On success, it returns an attestation structure detailing the current audit digest for sessionContext. If signContext is not nil,
 // the attestation structure will be signed by the associated key and returned too.
+//
+// The attestation structure can be decoded with AttestRaw.Decode, and the session audit information obtained with
+// Attest.Attested.SessionAudit.
 func (t *TPMContext) GetSessionAuditDigest(privacyAdminContext, signContext ResourceContext, sessionContext SessionContext, qualifyingData Data, inScheme *SigScheme, privacyAdminContextAuthSession, signContextAuthSession SessionContext, sessions ...SessionContext) (AttestRaw, *Signature, error) {
 	if inScheme == nil {
 		inScheme = &SigScheme{Scheme: SigSchemeAlgNull}
@@ -237,6 +244,10 @@ func (t *TPMContext) GetCommandAuditDigest(privacyContext, signContext ResourceC
 //
 // On success, it returns an attestation structure detailing the current values of time and clock. If signContext is not nil, the
 // attestation structure will be signed by the associated key and returned too.
+//
+// The attestation structure can be decoded with AttestRaw.Decode, and the time information obtained with Attest.Attested.Time. The
+// reset and restart counts in the ClockInfo fields can be used to detect whether the TPM has been reset or restarted since a
+// previous attestation.
 func (t *TPMContext) GetTime(privacyAdminContext, signContext ResourceContext, qualifyingData Data, inScheme *SigScheme, privacyAdminContextAuthSession, signContextAuthSession SessionContext, sessions ...SessionContext) (AttestRaw, *Signature, error) {
 	if inScheme == nil {
 		inScheme = &SigScheme{Scheme: SigSchemeAlgNull}
@@ -6,11 +6,16 @@ package tpm2_test
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"reflect"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func verifyAttest(t *testing.T, tpm *TPMContext, attestRaw AttestRaw, tag StructTag, signContext ResourceContext, signHierarchy Handle, qualifyingData Data) *Attest {
@@ -650,3 +655,137 @@ func TestGetTime(t *testing.T) {
 		run(t, ak, HandleEndorsement, nil, nil, sessionContext, nil)
 	})
 }
+
+func TestQuoteMock(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pub, err := PublicFromCryptoKey(&key.PublicKey, HashAlgorithmSHA256, nil)
+	if err != nil {
+		t.Fatalf("PublicFromCryptoKey failed: %v", err)
+	}
+	signContext, err := CreateObjectResourceContextFromPublic(0x80000001, pub)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	pcrs := PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{7}}}
+	pcrDigest := sha256.Sum256([]byte("foo"))
+
+	attestRaw, _ := mu.MarshalToBytes(Attest{
+		Magic:           TPMGeneratedValue,
+		Type:            TagAttestQuote,
+		QualifiedSigner: signContext.Name(),
+		ExtraData:       Data("bar"),
+		ClockInfo:       ClockInfo{Clock: 1000, Safe: true},
+		FirmwareVersion: 1,
+		Attested:        AttestU{Data: &QuoteInfo{PCRSelect: pcrs, PCRDigest: pcrDigest[:]}}})
+	h := sha256.Sum256(attestRaw)
+	r, s, err := ecdsa.Sign(rand.Reader, key, h[:])
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	sig := Signature{
+		SigAlg: SigSchemeAlgECDSA,
+		Signature: SignatureU{
+			Data: &SignatureECDSA{Hash: HashAlgorithmSHA256, SignatureR: r.Bytes(), SignatureS: s.Bytes()}}}
+
+	params, _ := mu.MarshalToBytes(AttestRaw(attestRaw), sig)
+	rsp := makeMockResponse(Success, nil, params, mockPasswordAuth)
+
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	quoted, signature, err := tpm.Quote(signContext, Data("bar"), nil, pcrs, nil)
+	if err != nil {
+		t.Fatalf("Quote failed: %v", err)
+	}
+
+	// The command should specify the NULL scheme so that the TPM uses the key's scheme.
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 1)
+	var qualifyingData Data
+	var scheme SigSchemeId
+	if _, err := mu.UnmarshalFromBytes(c.Params, &qualifyingData, &scheme); err != nil {
+		t.Fatalf("Cannot unmarshal command parameters: %v", err)
+	}
+	if !bytes.Equal(qualifyingData, Data("bar")) {
+		t.Errorf("Unexpected qualifyingData: %x", qualifyingData)
+	}
+	if scheme != SigSchemeAlgNull {
+		t.Errorf("Unexpected scheme: %v", scheme)
+	}
+
+	// The returned attestation must be the exact bytes that were signed, so that it can be verified offline.
+	if !bytes.Equal(quoted, attestRaw) {
+		t.Errorf("Unexpected attestation")
+	}
+	h = sha256.Sum256(quoted)
+	verifySignature(t, pub, h[:], signature)
+
+	attest, err := quoted.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if attest.Magic != TPMGeneratedValue || attest.Type != TagAttestQuote {
+		t.Errorf("Unexpected attestation header")
+	}
+	if !attest.Attested.Quote().PCRSelect.Equal(pcrs) {
+		t.Errorf("Unexpected PCR selection")
+	}
+	if !bytes.Equal(attest.Attested.Quote().PCRDigest, pcrDigest[:]) {
+		t.Errorf("Unexpected PCR digest")
+	}
+}
+
+func TestGetTimeMock(t *testing.T) {
+	attestRaw, _ := mu.MarshalToBytes(Attest{
+		Magic:           TPMGeneratedValue,
+		Type:            TagAttestTime,
+		ExtraData:       Data("foo"),
+		ClockInfo:       ClockInfo{Clock: 1000, ResetCount: 5, RestartCount: 2, Safe: true},
+		FirmwareVersion: 1,
+		Attested: AttestU{Data: &TimeAttestInfo{
+			Time:            TimeInfo{Time: 500, ClockInfo: ClockInfo{Clock: 1000, ResetCount: 5, RestartCount: 2, Safe: true}},
+			FirmwareVersion: 1}}})
+
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		// Respond with an empty password authorization for each session in the command.
+		c := decodeMockCommand(t, cmd, 2)
+		auths := make([]mockResponseAuth, len(c.Auths))
+		for i := range auths {
+			auths[i] = mockPasswordAuth
+		}
+		params, _ := mu.MarshalToBytes(AttestRaw(attestRaw), Signature{SigAlg: SigSchemeAlgNull})
+		return makeMockResponse(Success, nil, params, auths...)
+	}}
+	tpm, _ := NewTPMContext(tcti)
+
+	timeInfo, signature, err := tpm.GetTime(tpm.EndorsementHandleContext(), nil, Data("foo"), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("GetTime failed: %v", err)
+	}
+	if signature.SigAlg != SigSchemeAlgNull {
+		t.Errorf("Unexpected signature algorithm: %v", signature.SigAlg)
+	}
+	if !bytes.Equal(timeInfo, attestRaw) {
+		t.Errorf("Unexpected attestation")
+	}
+
+	attest, err := timeInfo.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if attest.Type != TagAttestTime {
+		t.Errorf("Unexpected attestation type: %v", attest.Type)
+	}
+	if attest.Attested.Time().Time.Time != 500 {
+		t.Errorf("Unexpected time: %d", attest.Attested.Time().Time.Time)
+	}
+	if attest.Attested.Time().Time.ClockInfo.ResetCount != 5 {
+		t.Errorf("Unexpected reset count: %d", attest.Attested.Time().Time.ClockInfo.ResetCount)
+	}
+	if attest.Attested.Time().Time.ClockInfo.RestartCount != 2 {
+		t.Errorf("Unexpected restart count: %d", attest.Attested.Time().Time.ClockInfo.RestartCount)
+	}
+}
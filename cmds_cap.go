@@ -7,6 +7,7 @@ package tpm2
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 )
 
 // Section 30 - Capability Commands
@@ -19,7 +20,8 @@ import (
 //
 // The underlying implementation of TPM2_GetCapability is not required to (or may not be able to) return all of the requested
 // values in a single request. This function will re-execute the TPM2_GetCapability command until all of the requested properties
-// have been returned. As a consequence, any SessionContext instances provided should have the AttrContinueSession attribute defined.
+// have been returned, starting each subsequent command from the value after the last one returned. As a consequence, any
+// SessionContext instances provided should have the AttrContinueSession attribute defined.
 //
 // If capability is CapabilityHandles and property does not correspond to a valid handle type, a *TPMParameterError error with
 // an error code of ErrorHandle is returned for parameter index 2.
@@ -46,49 +48,95 @@ func (t *TPMContext) GetCapability(capability Capability, property, propertyCoun
 				data.Capability)}
 		}
 
+		var n int
+		var last uint32
+		switch data.Capability {
+		case CapabilityAlgs:
+			if l := data.Data.Algorithms(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1].Alg)
+			}
+		case CapabilityHandles:
+			if l := data.Data.Handles(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1])
+			}
+		case CapabilityCommands:
+			if l := data.Data.Command(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1].CommandCode())
+			}
+		case CapabilityPPCommands:
+			if l := data.Data.PPCommands(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1])
+			}
+		case CapabilityAuditCommands:
+			if l := data.Data.AuditCommands(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1])
+			}
+		case CapabilityPCRs:
+			// The property argument is ignored for this capability, and the TPM returns all of the data at once.
+			n = len(data.Data.AssignedPCR())
+			moreData = false
+		case CapabilityTPMProperties:
+			if l := data.Data.TPMProperties(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1].Property)
+			}
+		case CapabilityPCRProperties:
+			if l := data.Data.PCRProperties(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1].Tag)
+			}
+		case CapabilityECCCurves:
+			if l := data.Data.ECCCurves(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1])
+			}
+		case CapabilityAuthPolicies:
+			if l := data.Data.AuthPolicies(); len(l) > 0 {
+				n, last = len(l), uint32(l[len(l)-1].Handle)
+			}
+		}
+
 		if capabilityData == nil {
 			capabilityData = &data
 		} else {
-			var s int
 			switch data.Capability {
 			case CapabilityAlgs:
 				capabilityData.Data.Data = append(capabilityData.Data.Algorithms(), data.Data.Algorithms()...)
-				s = len(data.Data.Algorithms())
 			case CapabilityHandles:
 				capabilityData.Data.Data = append(capabilityData.Data.Handles(), data.Data.Handles()...)
-				s = len(data.Data.Handles())
 			case CapabilityCommands:
 				capabilityData.Data.Data = append(capabilityData.Data.Command(), data.Data.Command()...)
-				s = len(data.Data.Command())
 			case CapabilityPPCommands:
 				capabilityData.Data.Data = append(capabilityData.Data.PPCommands(), data.Data.PPCommands()...)
-				s = len(data.Data.PPCommands())
 			case CapabilityAuditCommands:
 				capabilityData.Data.Data = append(capabilityData.Data.AuditCommands(), data.Data.AuditCommands()...)
-				s = len(data.Data.AuditCommands())
-			case CapabilityPCRs:
-				capabilityData.Data.Data = append(capabilityData.Data.AssignedPCR(), data.Data.AssignedPCR()...)
-				s = len(data.Data.AssignedPCR())
 			case CapabilityTPMProperties:
 				capabilityData.Data.Data = append(capabilityData.Data.TPMProperties(), data.Data.TPMProperties()...)
-				s = len(data.Data.TPMProperties())
 			case CapabilityPCRProperties:
 				capabilityData.Data.Data = append(capabilityData.Data.PCRProperties(), data.Data.PCRProperties()...)
-				s = len(data.Data.PCRProperties())
 			case CapabilityECCCurves:
 				capabilityData.Data.Data = append(capabilityData.Data.ECCCurves(), data.Data.ECCCurves()...)
-				s = len(data.Data.ECCCurves())
 			case CapabilityAuthPolicies:
 				capabilityData.Data.Data = append(capabilityData.Data.AuthPolicies(), data.Data.AuthPolicies()...)
-				s = len(data.Data.AuthPolicies())
 			}
-			nextProperty += uint32(s)
-			remaining -= uint32(s)
 		}
 
-		if !moreData || remaining <= 0 {
+		if !moreData || uint32(n) >= remaining {
+			break
+		}
+		if n == 0 {
+			return nil, &InvalidResponseError{CommandGetCapability, "TPM indicated that more data is available but returned none"}
+		}
+
+		if last == math.MaxUint32 {
+			// There can't be any properties after this one, and last + 1 would wrap around to zero.
 			break
 		}
+		if last < nextProperty {
+			return nil, &InvalidResponseError{CommandGetCapability, fmt.Sprintf("TPM returned properties that precede the requested "+
+				"property (got %#08x, requested %#08x)", last, nextProperty)}
+		}
+
+		// Subsequent requests start from the property after the last one returned.
+		nextProperty = last + 1
+		remaining -= uint32(n)
 	}
 
 	return capabilityData, nil
@@ -151,6 +199,61 @@ func (t *TPMContext) GetCapabilityHandles(handleType Handle, propertyCount uint3
 	return data.Data.Handles(), nil
 }
 
+// getHandlesOfType returns all of the handles of resources on the TPM of the specified type, which is indicated by the
+// most-significant byte of each handle. The returned list is never nil.
+func (t *TPMContext) getHandlesOfType(handleType HandleType, sessions ...SessionContext) (HandleList, error) {
+	handles, err := t.GetCapabilityHandles(handleType.BaseHandle(), CapabilityMaxProperties, sessions...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(HandleList, 0, len(handles))
+	for _, h := range handles {
+		if h.Type() != handleType {
+			break
+		}
+		out = append(out, h)
+	}
+	return out, nil
+}
+
+// GetPersistentHandles is a helper function that wraps around TPMContext.GetCapability, and returns a list of the handles of all
+// persistent objects on the TPM. If there are no persistent objects, an empty list is returned.
+func (t *TPMContext) GetPersistentHandles(sessions ...SessionContext) (HandleList, error) {
+	return t.getHandlesOfType(HandleTypePersistent, sessions...)
+}
+
+// GetTransientHandles is a helper function that wraps around TPMContext.GetCapability, and returns a list of the handles of all
+// loaded transient objects on the TPM. If there are no loaded transient objects, an empty list is returned.
+func (t *TPMContext) GetTransientHandles(sessions ...SessionContext) (HandleList, error) {
+	return t.getHandlesOfType(HandleTypeTransient, sessions...)
+}
+
+// GetActiveSessionHandles is a helper function that wraps around TPMContext.GetCapability, and returns a list of the handles of all
+// active sessions on the TPM. This includes sessions that are loaded (HandleTypeLoadedSession) and sessions that have been saved
+// with TPMContext.ContextSave (HandleTypeSavedSession). If there are no active sessions, an empty list is returned.
+//
+// As this function executes multiple commands, any SessionContext instances provided should have the AttrContinueSession attribute
+// defined.
+func (t *TPMContext) GetActiveSessionHandles(sessions ...SessionContext) (HandleList, error) {
+	out := make(HandleList, 0)
+	for _, handleType := range []HandleType{HandleTypeLoadedSession, HandleTypeSavedSession} {
+		handles, err := t.GetCapabilityHandles(handleType.BaseHandle(), CapabilityMaxProperties, sessions...)
+		if err != nil {
+			return nil, err
+		}
+		// Loaded policy sessions have handles with a most-significant byte of HandleTypePolicySession, so both session
+		// types need to be accepted here.
+		for _, h := range handles {
+			if h.Type() != HandleTypeHMACSession && h.Type() != HandleTypePolicySession {
+				break
+			}
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
 // GetCapabilityPCRs is a helper function that wraps around TPMContext.GetCapability, and returns the current allocation of PCRs on
 // the TPM.
 func (t *TPMContext) GetCapabilityPCRs(sessions ...SessionContext) (PCRSelectionList, error) {
@@ -288,7 +391,15 @@ func (t *TPMContext) GetNVBufferMax(sessions ...SessionContext) (int, error) {
 	return int(props[0].Value), nil
 }
 
-// TestParms executes the TPM2_TestParms command to check if the specified combination of algorithm parameters is supported.
+// TestParms executes the TPM2_TestParms command to check if the specified combination of algorithm parameters is supported. This
+// can be used to determine whether a template is usable before attempting to create an object with it. The Type field of
+// parameters selects the type of the Parameters field.
+//
+// If the combination of parameters is supported, this returns nil. If it is not supported, a *TPMParameterError error will be
+// returned for parameter index 1, and this can be tested for with IsTPMParameterError using AnyErrorCode. The error code
+// indicates the reason - for example, ErrorValue or ErrorKeySize for an unsupported key size, ErrorScheme for an unsupported
+// scheme, ErrorCurve for an unsupported curve or ErrorSymmetric for an unsupported symmetric algorithm. Other errors indicate a
+// failure to execute the command.
 func (t *TPMContext) TestParms(parameters *PublicParams, sessions ...SessionContext) error {
 	return t.RunCommand(CommandTestParms, sessions, Delimiter, parameters)
 }
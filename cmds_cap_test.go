@@ -5,10 +5,13 @@
 package tpm2_test
 
 import (
+	"bytes"
 	"fmt"
+	"reflect"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestGetCapabilityAlgs(t *testing.T) {
@@ -163,6 +166,155 @@ func TestGetCapabilityHandles(t *testing.T) {
 	checkIsInList(HandlePlatformNV)
 }
 
+func makeGetCapabilityResponseForTesting(t *testing.T, moreData bool, data *CapabilityData) []byte {
+	params, err := mu.MarshalToBytes(moreData, data)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	return makeMockResponse(Success, nil, params)
+}
+
+func TestGetCapabilityPagination(t *testing.T) {
+	var rsp []byte
+	rsp = append(rsp, makeGetCapabilityResponseForTesting(t, true,
+		&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{0x80000000, 0x80000005}}})...)
+	rsp = append(rsp, makeGetCapabilityResponseForTesting(t, false,
+		&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{0x8000000a}}})...)
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	handles, err := tpm.GetCapabilityHandles(HandleTypeTransient.BaseHandle(), CapabilityMaxProperties)
+	if err != nil {
+		t.Fatalf("GetCapabilityHandles failed: %v", err)
+	}
+	if !reflect.DeepEqual(handles, HandleList{0x80000000, 0x80000005, 0x8000000a}) {
+		t.Errorf("Unexpected handles: %v", handles)
+	}
+
+	// Check that the second command started from the handle after the last one returned from the first command.
+	var expected []byte
+	for _, params := range []struct {
+		property uint32
+		count    uint32
+	}{
+		{property: 0x80000000, count: CapabilityMaxProperties},
+		{property: 0x80000006, count: CapabilityMaxProperties - 2},
+	} {
+		cmd, _ := mu.MarshalToBytes(TagNoSessions, uint32(22), CommandGetCapability, CapabilityHandles, params.property, params.count)
+		expected = append(expected, cmd...)
+	}
+	if !bytes.Equal(tcti.commands.Bytes(), expected) {
+		t.Errorf("Unexpected commands: %x", tcti.commands.Bytes())
+	}
+}
+
+func TestGetCapabilityPaginationEnd(t *testing.T) {
+	// The TPM indicates that more data is available but the last property returned is the largest possible value, so there is
+	// nothing left to request.
+	rsp := makeGetCapabilityResponseForTesting(t, true,
+		&CapabilityData{Capability: CapabilityTPMProperties, Data: CapabilitiesU{Data: TaggedTPMPropertyList{
+			{Property: 0xfffffffe, Value: 1},
+			{Property: 0xffffffff, Value: 2}}}})
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	props, err := tpm.GetCapabilityTPMProperties(0xfffffffe, CapabilityMaxProperties)
+	if err != nil {
+		t.Fatalf("GetCapabilityTPMProperties failed: %v", err)
+	}
+	if len(props) != 2 {
+		t.Errorf("Unexpected properties: %v", props)
+	}
+	if tcti.commands.Len() != 22 {
+		t.Errorf("Unexpected number of commands")
+	}
+}
+
+func TestGetCapabilityPaginationNoProgress(t *testing.T) {
+	var rsp []byte
+	rsp = append(rsp, makeGetCapabilityResponseForTesting(t, true,
+		&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{0x80000000, 0x80000005}}})...)
+	rsp = append(rsp, makeGetCapabilityResponseForTesting(t, true,
+		&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{0x80000000}}})...)
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+	_, err := tpm.GetCapabilityHandles(HandleTypeTransient.BaseHandle(), CapabilityMaxProperties)
+	if err == nil {
+		t.Fatalf("GetCapabilityHandles should have failed")
+	}
+	if err.Error() != "TPM returned an invalid response for command TPM_CC_GetCapability: TPM returned properties that precede the "+
+		"requested property (got 0x80000000, requested 0x80000006)" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestGetHandlesHelpers(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		rsp := makeGetCapabilityResponseForTesting(t, false,
+			&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{}}})
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		handles, err := tpm.GetPersistentHandles()
+		if err != nil {
+			t.Fatalf("GetPersistentHandles failed: %v", err)
+		}
+		if handles == nil || len(handles) != 0 {
+			t.Errorf("Unexpected handles: %#v", handles)
+		}
+	})
+
+	t.Run("Persistent", func(t *testing.T) {
+		var rsp []byte
+		rsp = append(rsp, makeGetCapabilityResponseForTesting(t, true,
+			&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{0x81000001, 0x81000002}}})...)
+		rsp = append(rsp, makeGetCapabilityResponseForTesting(t, false,
+			&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{0x81800000}}})...)
+		tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+		tpm, _ := NewTPMContext(tcti)
+
+		handles, err := tpm.GetPersistentHandles()
+		if err != nil {
+			t.Fatalf("GetPersistentHandles failed: %v", err)
+		}
+		if !reflect.DeepEqual(handles, HandleList{0x81000001, 0x81000002, 0x81800000}) {
+			t.Errorf("Unexpected handles: %v", handles)
+		}
+
+		var expected []byte
+		for _, params := range []struct {
+			property uint32
+			count    uint32
+		}{
+			{property: 0x81000000, count: CapabilityMaxProperties},
+			{property: 0x81000003, count: CapabilityMaxProperties - 2},
+		} {
+			cmd, _ := mu.MarshalToBytes(TagNoSessions, uint32(22), CommandGetCapability, CapabilityHandles, params.property, params.count)
+			expected = append(expected, cmd...)
+		}
+		if !bytes.Equal(tcti.commands.Bytes(), expected) {
+			t.Errorf("Unexpected commands: %x", tcti.commands.Bytes())
+		}
+	})
+
+	t.Run("ActiveSessions", func(t *testing.T) {
+		var rsp []byte
+		rsp = append(rsp, makeGetCapabilityResponseForTesting(t, false,
+			&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{0x02000000, 0x03000001}}})...)
+		rsp = append(rsp, makeGetCapabilityResponseForTesting(t, false,
+			&CapabilityData{Capability: CapabilityHandles, Data: CapabilitiesU{Data: HandleList{0x02000002}}})...)
+		tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+		tpm, _ := NewTPMContext(tcti)
+
+		handles, err := tpm.GetActiveSessionHandles()
+		if err != nil {
+			t.Fatalf("GetActiveSessionHandles failed: %v", err)
+		}
+		if !reflect.DeepEqual(handles, HandleList{0x02000000, 0x03000001, 0x02000002}) {
+			t.Errorf("Unexpected handles: %v", handles)
+		}
+	})
+}
+
 func TestGetCapabilityPCRs(t *testing.T) {
 	tpm := openTPMForTesting(t, 0)
 	defer closeTPM(t, tpm)
@@ -351,6 +503,32 @@ func TestTestParms(t *testing.T) {
 	}
 }
 
+func TestTestParmsMock(t *testing.T) {
+	parms := &PublicParams{
+		Type: ObjectTypeECC,
+		Parameters: PublicParamsU{
+			Data: &ECCParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    ECCScheme{Scheme: ECCSchemeNull},
+				CurveID:   ECCCurveNIST_P521,
+				KDF:       KDFScheme{Scheme: KDFAlgorithmNull}}}}
+
+	// TPM_RC_CURVE for parameter index 1
+	rsp := makeMockResponse(ResponseCode(0x1e6), nil, nil)
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	err := tpm.TestParms(parms)
+	if !IsTPMParameterError(err, ErrorCurve, CommandTestParms, 1) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expected, _ := mu.MarshalToBytes(parms)
+	if !bytes.Equal(tcti.commands.Bytes()[10:], expected) {
+		t.Errorf("Unexpected command parameters: %x", tcti.commands.Bytes()[10:])
+	}
+}
+
 func TestIsTPM2(t *testing.T) {
 	tpm := openTPMForTesting(t, 0)
 	defer closeTPM(t, tpm)
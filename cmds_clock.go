@@ -23,5 +23,15 @@ func (t *TPMContext) ReadClock(sessions ...SessionContext) (*TimeInfo, error) {
 // func (t *TPMContext) ClockSet(auth Handle, newTime uint64, authAuth interface{}) error {
 // }
 
-// func (t *TPMContext) ClockRateAdjust(auth Handle, rateAdjust ClockAdjust, authAuth interface{}) error {
-// }
+// ClockRateAdjust executes the TPM2_ClockRateAdjust command to adjust the rate at which clock and time are updated. The auth
+// parameter must be a ResourceContext corresponding to HandleOwner or HandlePlatform. The command requires authorization with the
+// user auth role for auth, with session based authorization provided via authAuthSession.
+//
+// The rateAdjust argument specifies the direction and size of the adjustment. Adjustments are applied in steps, and the TPM limits
+// the total adjustment that can be made. ClockNoChange can be used to make no adjustment. There is no command for reading
+// back the current rate.
+func (t *TPMContext) ClockRateAdjust(auth ResourceContext, rateAdjust ClockAdjust, authAuthSession SessionContext, sessions ...SessionContext) error {
+	return t.RunCommand(CommandClockRateAdjust, sessions,
+		ResourceContextWithSession{Context: auth, Session: authAuthSession}, Delimiter,
+		rateAdjust)
+}
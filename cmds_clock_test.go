@@ -0,0 +1,62 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func TestClockRateAdjust(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	for _, data := range []struct {
+		desc       string
+		rateAdjust ClockAdjust
+		restore    ClockAdjust
+	}{
+		{
+			desc:       "FineFaster",
+			rateAdjust: ClockFineFaster,
+			restore:    ClockFineSlower,
+		},
+		{
+			desc:       "CoarseSlower",
+			rateAdjust: ClockCoarseSlower,
+			restore:    ClockCoarseFaster,
+		},
+		{
+			desc:       "NoChange",
+			rateAdjust: ClockNoChange,
+			restore:    ClockNoChange,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			before, err := tpm.ReadClock()
+			if err != nil {
+				t.Fatalf("ReadClock failed: %v", err)
+			}
+
+			if err := tpm.ClockRateAdjust(tpm.OwnerHandleContext(), data.rateAdjust, nil); err != nil {
+				t.Fatalf("ClockRateAdjust failed: %v", err)
+			}
+			defer func() {
+				if err := tpm.ClockRateAdjust(tpm.OwnerHandleContext(), data.restore, nil); err != nil {
+					t.Errorf("ClockRateAdjust failed: %v", err)
+				}
+			}()
+
+			after, err := tpm.ReadClock()
+			if err != nil {
+				t.Fatalf("ReadClock failed: %v", err)
+			}
+			if after.ClockInfo.Clock < before.ClockInfo.Clock {
+				t.Errorf("Clock went backwards")
+			}
+		})
+	}
+}
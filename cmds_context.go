@@ -50,6 +50,16 @@ func wrapContextBlob(tpmBlob ContextData, context HandleContext) ContextData {
 // of ErrorTooManyContexts. If a context ID cannot be assigned for the session, a *TPMWarning error with a warning code of
 // WarningContextGap will be returned.
 func (t *TPMContext) ContextSave(saveContext HandleContext) (*Context, error) {
+	if err := t.checkHandleContextParam(saveContext); err != nil {
+		return nil, makeInvalidArgError("saveContext", fmt.Sprintf("%v", err))
+	}
+	switch saveContext.Handle().Type() {
+	case HandleTypeTransient, HandleTypeHMACSession, HandleTypePolicySession:
+	default:
+		return nil, makeInvalidArgError("saveContext", fmt.Sprintf("cannot save handle 0x%08x, as it does not correspond to a "+
+			"transient object or a session", saveContext.Handle()))
+	}
+
 	switch c := saveContext.(type) {
 	case *sessionContext:
 		if c.scData() == nil {
@@ -165,6 +175,8 @@ func (t *TPMContext) ContextLoad(context *Context) (HandleContext, error) {
 			return nil, &InvalidResponseError{CommandContextLoad, fmt.Sprintf("handle 0x%08x returned from TPM is incorrect", loadedHandle)}
 		}
 		sc := makeSessionContext(loadedHandle, hcData.Data.Data.(*sessionContextData))
+		t.exclusiveSessionMu.Lock()
+		defer t.exclusiveSessionMu.Unlock()
 		isExclusive := t.exclusiveSession != nil && loadedHandle == t.exclusiveSession.Handle()
 		sc.scData().IsExclusive = isExclusive
 		if isExclusive {
@@ -179,7 +191,7 @@ func (t *TPMContext) ContextLoad(context *Context) (HandleContext, error) {
 
 // FlushContext executes the TPM2_FlushContext command on the handle referenced by flushContext, in order to flush resources
 // associated with it from the TPM. If flushContext does not correspond to a transient object or a session, then it will return
-// with an error.
+// with an error without executing the command. This includes the case where flushContext has already been flushed.
 //
 // On successful completion, flushContext is invalidated. If flushContext corresponded to a session, then it will no longer be
 // possible to restore that session with TPMContext.ContextLoad, even if it was previously saved with TPMContext.ContextSave.
@@ -187,6 +199,12 @@ func (t *TPMContext) FlushContext(flushContext HandleContext) error {
 	if err := t.checkHandleContextParam(flushContext); err != nil {
 		return makeInvalidArgError("flushContext", fmt.Sprintf("%v", err))
 	}
+	switch flushContext.Handle().Type() {
+	case HandleTypeTransient, HandleTypeHMACSession, HandleTypePolicySession:
+	default:
+		return makeInvalidArgError("flushContext", fmt.Sprintf("cannot flush handle 0x%08x, as it does not correspond to a transient "+
+			"object or a session", flushContext.Handle()))
+	}
 
 	if err := t.RunCommand(CommandFlushContext, nil,
 		Delimiter,
@@ -194,6 +212,7 @@ func (t *TPMContext) FlushContext(flushContext HandleContext) error {
 		return err
 	}
 
+	t.ForgetHandle(flushContext.Handle())
 	flushContext.(handleContextPrivate).invalidate()
 	return nil
 }
@@ -223,17 +242,27 @@ func (t *TPMContext) FlushContext(flushContext HandleContext) error {
 // If a persistent object already exists at the specified handle, a *TPMError error with an error code of ErrorNVDefined will be
 // returned.
 //
+// If persistentHandle is not a persistent handle or object does not correspond to an object, an error will be returned without
+// executing the command.
+//
 // On successful completion of persisting a transient object, it returns a ResourceContext that corresponds to the persistent object.
 // On successful completion of evicting a persistent object, it returns a nil ResourceContext, and object will be invalidated.
 func (t *TPMContext) EvictControl(auth, object ResourceContext, persistentHandle Handle, authAuthSession SessionContext, sessions ...SessionContext) (ResourceContext, error) {
 	if object == nil {
 		return nil, makeInvalidArgError("object", "nil value")
 	}
+	if persistentHandle.Type() != HandleTypePersistent {
+		return nil, makeInvalidArgError("persistentHandle", fmt.Sprintf("handle 0x%08x is not a persistent handle", persistentHandle))
+	}
+	o, isObject := unwrapHandleContext(object).(*objectContext)
+	if !isObject {
+		return nil, makeInvalidArgError("object", "resource context is not an object")
+	}
 
 	var public *Public
 	if object.Handle() != persistentHandle {
 		var err error
-		public, err = object.(*objectContext).public().copy()
+		public, err = o.public().copy()
 		if err != nil {
 			return nil, fmt.Errorf("cannot copy public area of object: %v", err)
 		}
@@ -245,6 +274,7 @@ func (t *TPMContext) EvictControl(auth, object ResourceContext, persistentHandle
 		return nil, err
 	}
 
+	t.ForgetHandle(persistentHandle)
 	if object.Handle() == persistentHandle {
 		object.(handleContextPrivate).invalidate()
 		return nil, nil
@@ -7,9 +7,11 @@ package tpm2_test
 import (
 	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestContextSave(t *testing.T) {
@@ -363,3 +365,164 @@ func TestFlushContext(t *testing.T) {
 		t.Fatalf("CreateResourceContextFromTPM returned an unexpected error: %v", err)
 	}
 }
+
+func TestFlushContextInvalidHandles(t *testing.T) {
+	rsp := makeMockResponse(Success, nil, nil)
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	pub := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	pub.Unique = PublicIDU{Data: make(Digest, 32)}
+
+	transient, err := CreateObjectResourceContextFromPublic(0x80000001, pub)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+	persistent, err := CreateObjectResourceContextFromPublic(0x81000001, pub)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	if err := tpm.FlushContext(transient); err != nil {
+		t.Fatalf("FlushContext failed: %v", err)
+	}
+	n := tcti.commands.Len()
+
+	for _, data := range []struct {
+		desc    string
+		context HandleContext
+	}{
+		{desc: "Flushed", context: transient},
+		{desc: "Persistent", context: persistent},
+		{desc: "Permanent", context: tpm.OwnerHandleContext()},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			err := tpm.FlushContext(data.context)
+			if err == nil {
+				t.Fatalf("FlushContext should have failed")
+			}
+			if !strings.HasPrefix(err.Error(), "invalid flushContext argument: ") {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tcti.commands.Len() != n {
+				t.Errorf("FlushContext shouldn't have sent a command to the TPM")
+			}
+		})
+	}
+}
+
+func TestContextSaveAndLoadMock(t *testing.T) {
+	pub := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	pub.Unique = PublicIDU{Data: make(Digest, 32)}
+
+	object, err := CreateObjectResourceContextFromPublic(0x80000001, pub)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	tpmBlob := ContextData("opaque context blob")
+	saved := Context{Sequence: 54, SavedHandle: 0x80000000, Hierarchy: HandleOwner, Blob: tpmBlob}
+
+	var rsp []byte
+	params, _ := mu.MarshalToBytes(saved)
+	b := makeMockResponse(Success, nil, params)
+	rsp = append(rsp, b...)
+	b = makeMockResponse(Success, nil, nil)
+	rsp = append(rsp, b...)
+	b = makeMockResponse(Success, []Handle{0x80000002}, nil)
+	rsp = append(rsp, b...)
+
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	context, err := tpm.ContextSave(object)
+	if err != nil {
+		t.Fatalf("ContextSave failed: %v", err)
+	}
+	if context.Sequence != saved.Sequence || context.SavedHandle != saved.SavedHandle || context.Hierarchy != saved.Hierarchy {
+		t.Errorf("ContextSave returned an unexpected context: %v", context)
+	}
+
+	if err := tpm.FlushContext(object); err != nil {
+		t.Fatalf("FlushContext failed: %v", err)
+	}
+
+	tcti.commands.Reset()
+	restored, err := tpm.ContextLoad(context)
+	if err != nil {
+		t.Fatalf("ContextLoad failed: %v", err)
+	}
+	if restored.Handle() != 0x80000002 {
+		t.Errorf("ContextLoad returned the wrong handle: %v", restored.Handle())
+	}
+	expectedName, _ := pub.Name()
+	if !bytes.Equal(restored.Name(), expectedName) {
+		t.Errorf("ContextLoad returned the wrong name: %x", restored.Name())
+	}
+	if !reflect.DeepEqual(restored.(TestObjectResourceContext).GetPublic(), pub) {
+		t.Errorf("ContextLoad returned the wrong public area")
+	}
+
+	// The host-side state should have been stripped from the context blob that is sent to the TPM.
+	params, _ = mu.MarshalToBytes(saved)
+	cmd, _ := mu.MarshalToBytes(TagNoSessions, uint32(10+len(params)), CommandContextLoad, mu.RawBytes(params))
+	if !bytes.Equal(tcti.commands.Bytes(), cmd) {
+		t.Errorf("Unexpected ContextLoad command: %x", tcti.commands.Bytes())
+	}
+
+	if _, err := tpm.ContextSave(tpm.OwnerHandleContext()); err == nil {
+		t.Errorf("ContextSave should fail for a permanent handle")
+	}
+}
+
+func TestEvictControlMock(t *testing.T) {
+	pub := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	pub.Unique = PublicIDU{Data: make(Digest, 32)}
+
+	object, err := CreateObjectResourceContextFromPublic(0x80000001, pub)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	t.Run("InvalidPersistentHandle", func(t *testing.T) {
+		tcti := &mockTcti{}
+		tpm, _ := NewTPMContext(tcti)
+
+		_, err := tpm.EvictControl(tpm.OwnerHandleContext(), object, 0x80000002, nil)
+		if err == nil {
+			t.Fatalf("EvictControl should have failed")
+		}
+		if err.Error() != "invalid persistentHandle argument: handle 0x80000002 is not a persistent handle" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if tcti.commands.Len() != 0 {
+			t.Errorf("EvictControl shouldn't have sent a command to the TPM")
+		}
+	})
+
+	t.Run("NVSpace", func(t *testing.T) {
+		rsp := makeMockResponse(ResponseCode(0x14b), nil, nil)
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		_, err := tpm.EvictControl(tpm.OwnerHandleContext(), object, 0x81000001, nil)
+		if !IsTPMError(err, ErrorNVSpace, CommandEvictControl) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Persist", func(t *testing.T) {
+		rsp := makeMockResponse(Success, nil, nil, mockPasswordAuth)
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		persistent, err := tpm.EvictControl(tpm.OwnerHandleContext(), object, 0x81000001, nil)
+		if err != nil {
+			t.Fatalf("EvictControl failed: %v", err)
+		}
+		if persistent.Handle() != 0x81000001 {
+			t.Errorf("EvictControl returned the wrong handle: %v", persistent.Handle())
+		}
+		if !bytes.Equal(persistent.Name(), object.Name()) {
+			t.Errorf("EvictControl returned the wrong name: %x", persistent.Name())
+		}
+	})
+}
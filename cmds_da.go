@@ -11,6 +11,11 @@ package tpm2
 // lockContext, with session based authorization provided via lockContextAuthSession.
 //
 // On successful completion, the lockout counter will be reset to zero.
+//
+// If the lockout hierarchy authorization is currently unavailable because of a previous authorization failure, a *TPMWarning error
+// with a warning code of WarningLockout will be returned. In this case, the command can't succeed until the time specified by the
+// lockoutRecovery parameter of the last call to DictionaryAttackParameters has elapsed, or until the next TPM reset if that value
+// is zero.
 func (t *TPMContext) DictionaryAttackLockReset(lockContext ResourceContext, lockContextAuthSession SessionContext, sessions ...SessionContext) error {
 	return t.RunCommand(CommandDictionaryAttackLockReset, sessions,
 		ResourceContextWithSession{Context: lockContext, Session: lockContextAuthSession})
@@ -26,7 +31,9 @@ func (t *TPMContext) DictionaryAttackLockReset(lockContext ResourceContext, lock
 // rather than clock time.
 //
 // The lockContext parameter must be a ResourceContext corresponding to HandleLockout. The command requires authorization with the user
-// auth role for lockContext, with session based authorization provided via lockContextAuthSession.
+// auth role for lockContext, with session based authorization provided via lockContextAuthSession. If the lockout hierarchy
+// authorization is currently unavailable because of a previous authorization failure, a *TPMWarning error with a warning code of
+// WarningLockout will be returned.
 func (t *TPMContext) DictionaryAttackParameters(lockContext ResourceContext, newMaxTries, newRecoveryTime, lockoutRecovery uint32, lockContextAuthSession SessionContext, sessions ...SessionContext) error {
 	return t.RunCommand(CommandDictionaryAttackParameters, sessions,
 		ResourceContextWithSession{Context: lockContext, Session: lockContextAuthSession}, Delimiter,
@@ -5,9 +5,11 @@
 package tpm2_test
 
 import (
+	"bytes"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func getDictionaryAttackParams(t *testing.T, tpm *TPMContext) (uint32, uint32, uint32) {
@@ -206,3 +208,35 @@ func TestDictionaryAttackLockReset(t *testing.T) {
 		run(t, sessionContext.WithAttrs(AttrContinueSession))
 	})
 }
+
+func TestDictionaryAttackMock(t *testing.T) {
+	okRsp := makeMockResponse(Success, nil, nil, mockPasswordAuth)
+	lockoutRsp := makeMockResponse(ResponseCode(0x921), nil, nil)
+
+	tcti := &mockTcti{responses: bytes.NewReader(append(okRsp, lockoutRsp...))}
+	tpm, _ := NewTPMContext(tcti)
+
+	if err := tpm.DictionaryAttackParameters(tpm.LockoutHandleContext(), 32, 7200, 86400, nil); err != nil {
+		t.Fatalf("DictionaryAttackParameters failed: %v", err)
+	}
+
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 1)
+	var newMaxTries, newRecoveryTime, lockoutRecovery uint32
+	if err := mu.UnmarshalFromBytesStrict(c.Params, &newMaxTries, &newRecoveryTime, &lockoutRecovery); err != nil {
+		t.Fatalf("Cannot unmarshal command parameters: %v", err)
+	}
+	if newMaxTries != 32 || newRecoveryTime != 7200 || lockoutRecovery != 86400 {
+		t.Errorf("Unexpected command parameters (newMaxTries: %d, newRecoveryTime: %d, lockoutRecovery: %d)", newMaxTries,
+			newRecoveryTime, lockoutRecovery)
+	}
+
+	tcti.commands.Reset()
+	err := tpm.DictionaryAttackLockReset(tpm.LockoutHandleContext(), nil)
+	if !IsTPMWarning(err, WarningLockout, CommandDictionaryAttackLockReset) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err.Error() != "TPM returned a warning whilst executing command TPM_CC_DictionaryAttackLockReset: TPM_RC_LOCKOUT (authorizations "+
+		"for objects subject to DA protection are not allowed at this time because the TPM is in DA lockout mode)" {
+		t.Errorf("Unexpected error string: %v", err)
+	}
+}
@@ -66,8 +66,80 @@ func (t *TPMContext) Duplicate(objectContext, newParentContext ResourceContext,
 	return encryptionKeyOut, duplicate, outSymSeed, nil
 }
 
-// func (t *TPMContext) Rewrap(oldParent, newParent HandleContext, inDuplicate Private, name Name, inSymSeed EncryptedSecret, oldParentAuth interface{}, sessions ...SessionContext) (Private, EncryptedSecret, error) {
-// }
+// DuplicateToParent is a convenience function for duplicating the object associated with objectContext to the new parent
+// associated with newParentContext, for objects that have an authorization policy consisting of a single TPM2_PolicyDuplicationSelect
+// assertion. It starts a policy session using the name algorithm of the object, executes TPMContext.PolicyDuplicationSelect with the
+// names of objectContext and newParentContext and the value of includeObject, and then executes TPMContext.Duplicate with the
+// session to satisfy the duplication role. The session is flushed before this function returns.
+//
+// The encryptionKeyIn and symmetricAlg arguments are passed to TPMContext.Duplicate, and have the same meaning.
+//
+// On success, the returned values are those returned from TPMContext.Duplicate, which can be passed to TPMContext.Import along with
+// the public area of the object in order to import it under the new parent.
+func (t *TPMContext) DuplicateToParent(objectContext, newParentContext ResourceContext, includeObject bool, encryptionKeyIn Data, symmetricAlg *SymDefObject, sessions ...SessionContext) (Data, Private, EncryptedSecret, error) {
+	if objectContext == nil {
+		return nil, nil, nil, makeInvalidArgError("objectContext", "nil value")
+	}
+	if newParentContext == nil {
+		return nil, nil, nil, makeInvalidArgError("newParentContext", "nil value")
+	}
+
+	policySession, err := t.StartAuthSession(nil, nil, SessionTypePolicy, nil, objectContext.Name().Algorithm(), sessions...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer func() {
+		if policySession.Handle() == HandleUnassigned {
+			return
+		}
+		t.FlushContext(policySession)
+	}()
+
+	if err := t.PolicyDuplicationSelect(policySession, objectContext.Name(), newParentContext.Name(), includeObject,
+		sessions...); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return t.Duplicate(objectContext, newParentContext, encryptionKeyIn, symmetricAlg, policySession, sessions...)
+}
+
+// Rewrap executes the TPM2_Rewrap command in order to change the outer duplication wrapper of a duplicated object from the one
+// associated with oldParentContext to one associated with newParentContext, without requiring the object to be imported. Either
+// oldParentContext or newParentContext may be nil, for removing or adding an outer wrapper respectively. The inner duplication
+// wrapper, if there is one, is not modified.
+//
+// This command requires authorization with the user auth role for oldParentContext, with session based authorization provided via
+// oldParentContextAuthSession.
+//
+// The inDuplicate and inSymSeed arguments are the duplicated private area and the encrypted seed for the outer wrapper associated
+// with oldParentContext, as returned from TPMContext.Duplicate or a previous call to TPMContext.Rewrap. The name argument is the name
+// of the duplicated object, which is required in order to compute the keys for the outer wrappers.
+//
+// If oldParentContext is provided and it does not correspond to a storage parent, a *TPMHandleError error with an error code of
+// ErrorType will be returned for handle index 1. If newParentContext is provided and it does not correspond to a storage parent, a
+// *TPMHandleError error with an error code of ErrorType will be returned for handle index 2.
+//
+// If inSymSeed cannot be decrypted with the key associated with oldParentContext, a *TPMParameterError error will be returned for
+// parameter index 3, with an error code that depends on the type of key (see the documentation for TPMContext.Import). If the
+// integrity check of inDuplicate fails, a *TPMParameterError error with an error code of ErrorIntegrity will be returned for
+// parameter index 1.
+//
+// On success, the duplicated private area protected with an outer wrapper associated with newParentContext (if provided) is
+// returned, along with a new seed encrypted using the methods defined by newParentContext.
+func (t *TPMContext) Rewrap(oldParentContext, newParentContext ResourceContext, inDuplicate Private, name Name, inSymSeed EncryptedSecret, oldParentContextAuthSession SessionContext, sessions ...SessionContext) (Private, EncryptedSecret, error) {
+	var outDuplicate Private
+	var outSymSeed EncryptedSecret
+
+	if err := t.RunCommand(CommandRewrap, sessions,
+		ResourceContextWithSession{Context: oldParentContext, Session: oldParentContextAuthSession}, newParentContext, Delimiter,
+		inDuplicate, name, inSymSeed, Delimiter,
+		Delimiter,
+		&outDuplicate, &outSymSeed); err != nil {
+		return nil, nil, err
+	}
+
+	return outDuplicate, outSymSeed, nil
+}
 
 // Import executes the TPM2_Import command in order to encrypt the sensitive area of the object associated with the objectPublic and
 // duplicate arguments with the symmetric algorithm of the storage parent associated with parentContext, so that it can be loaded and
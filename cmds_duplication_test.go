@@ -410,3 +410,149 @@ func TestImport(t *testing.T) {
 		run(t, nil, duplicate, nil, nil, sessionContext.WithAttrs(AttrContinueSession))
 	})
 }
+
+func TestDuplicateToParent(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	newParent := createECCSrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, newParent)
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyDuplicationSelect(nil, newParent.Name(), false)
+
+	template := Public{
+		Type:       ObjectTypeRSA,
+		NameAlg:    HashAlgorithmSHA256,
+		Attrs:      AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrSign,
+		AuthPolicy: trial.GetDigest(),
+		Params: PublicParamsU{
+			Data: &RSAParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    RSAScheme{Scheme: RSASchemeNull},
+				KeyBits:   2048,
+				Exponent:  0}}}
+	sensitive := SensitiveCreate{UserAuth: []byte("foo")}
+	priv, pub, _, _, _, err := tpm.Create(primary, &sensitive, &template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	object, err := tpm.Load(primary, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer flushContext(t, tpm, object)
+
+	run := func(t *testing.T, encryptionKeyIn Data, symmetricAlg *SymDefObject) {
+		sessionHandles, err := tpm.GetCapabilityHandles(HandleTypeLoadedSession.BaseHandle(), CapabilityMaxProperties)
+		if err != nil {
+			t.Fatalf("GetCapabilityHandles failed: %v", err)
+		}
+
+		encryptionKeyOut, duplicate, outSymSeed, err := tpm.DuplicateToParent(object, newParent, false, encryptionKeyIn, symmetricAlg)
+		if err != nil {
+			t.Fatalf("DuplicateToParent failed: %v", err)
+		}
+
+		handles, err := tpm.GetCapabilityHandles(HandleTypeLoadedSession.BaseHandle(), CapabilityMaxProperties)
+		if err != nil {
+			t.Fatalf("GetCapabilityHandles failed: %v", err)
+		}
+		if len(handles) != len(sessionHandles) {
+			t.Errorf("DuplicateToParent leaked a session")
+		}
+
+		if len(encryptionKeyIn) > 0 {
+			encryptionKeyOut = encryptionKeyIn
+		}
+
+		priv, err := tpm.Import(newParent, encryptionKeyOut, pub, duplicate, outSymSeed, symmetricAlg, nil)
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+
+		imported, err := tpm.Load(newParent, priv, pub, nil)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		defer flushContext(t, tpm, imported)
+
+		if !bytes.Equal(imported.Name(), object.Name()) {
+			t.Errorf("Unexpected name for imported object")
+		}
+	}
+
+	t.Run("OuterWrapper", func(t *testing.T) {
+		run(t, nil, nil)
+	})
+
+	t.Run("InnerAndOuterWrapper", func(t *testing.T) {
+		run(t, nil, &SymDefObject{
+			Algorithm: SymObjectAlgorithmAES,
+			KeyBits:   SymKeyBitsU{Data: uint16(128)},
+			Mode:      SymModeU{Data: SymModeCFB}})
+	})
+
+	t.Run("WrongParent", func(t *testing.T) {
+		_, _, _, err := tpm.DuplicateToParent(object, primary, false, nil, nil)
+		if !IsTPMSessionError(err, ErrorPolicyFail, CommandDuplicate, 1) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+func TestRewrapMock(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Private("outDuplicate"), EncryptedSecret("outSymSeed"))
+	rsp := makeMockResponse(Success, nil, params, mockPasswordAuth)
+
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	newParent, err := CreateObjectResourceContextFromPublic(0x80000002, &Public{
+		Type:    ObjectTypeRSA,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrRestricted | AttrDecrypt,
+		Params: PublicParamsU{
+			Data: &RSAParams{
+				Symmetric: SymDefObject{
+					Algorithm: SymObjectAlgorithmAES,
+					KeyBits:   SymKeyBitsU{Data: uint16(128)},
+					Mode:      SymModeU{Data: SymModeCFB}},
+				Scheme:  RSAScheme{Scheme: RSASchemeNull},
+				KeyBits: 2048}},
+		Unique: PublicIDU{Data: make(PublicKeyRSA, 256)}})
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	name := Name{0x00, 0x0b, 0x01, 0x02}
+	outDuplicate, outSymSeed, err := tpm.Rewrap(nil, newParent, Private("inDuplicate"), name, nil, nil)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if !bytes.Equal(outDuplicate, []byte("outDuplicate")) {
+		t.Errorf("Unexpected outDuplicate: %x", outDuplicate)
+	}
+	if !bytes.Equal(outSymSeed, []byte("outSymSeed")) {
+		t.Errorf("Unexpected outSymSeed: %x", outSymSeed)
+	}
+
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 2)
+	if c.Code != CommandRewrap {
+		t.Errorf("Unexpected command code: %v", c.Code)
+	}
+	if c.Handles[0] != HandleNull {
+		t.Errorf("Unexpected oldParent handle: %v", c.Handles[0])
+	}
+	if c.Handles[1] != 0x80000002 {
+		t.Errorf("Unexpected newParent handle: %v", c.Handles[1])
+	}
+	expected, _ := mu.MarshalToBytes(Private("inDuplicate"), name, EncryptedSecret(nil))
+	if !bytes.Equal(c.Params, expected) {
+		t.Errorf("Unexpected command parameters: %x", c.Params)
+	}
+}
@@ -4,6 +4,10 @@
 
 package tpm2
 
+import (
+	"fmt"
+)
+
 // Section 23 - Enhanced Authorization (EA) Commands
 
 // PolicySigned executes the TPM2_PolicySigned command to include a signed authorization in a policy. This is a combined assertion
@@ -47,8 +51,17 @@ package tpm2
 // authContext and the value of policyRef. If provided, the value of cpHashA will be recorded on the session context to restrict the
 // session's usage. If expiration is non-zero, the expiration time of the session context will be updated unless it already has an
 // expiration time that is earlier. If expiration is less than zero, a timeout value and corresponding *TkAuth ticket will be
-// returned if policySession does not correspond to a trial session.
+// returned if policySession does not correspond to a trial session. If expiration is not less than zero, or policySession corresponds
+// to a trial session, the returned timeout will be empty and the returned ticket will be a NULL ticket with a hierarchy of
+// HandleNull, which cannot be used with TPMContext.PolicyTicket.
 func (t *TPMContext) PolicySigned(authContext ResourceContext, policySession SessionContext, includeNonceTPM bool, cpHashA Digest, policyRef Nonce, expiration int32, auth *Signature, sessions ...SessionContext) (Timeout, *TkAuth, error) {
+	if policySession == nil {
+		return nil, nil, makeInvalidArgError("policySession", "nil value")
+	}
+	if auth == nil {
+		return nil, nil, makeInvalidArgError("auth", "nil value")
+	}
+
 	var nonceTPM Nonce
 	if includeNonceTPM {
 		nonceTPM = policySession.NonceTPM()
@@ -91,12 +104,17 @@ func (t *TPMContext) PolicySigned(authContext ResourceContext, policySession Ses
 // *TPMParameterError error with an error code of ErrorExpired will be returned for parameter index 4.
 //
 // On successful completion, knowledge of the authorization value associated with authContext is proven. The policy digest of the
-// session associated with olicySession will be extended to include the name of authContext and the value of policyRef. If provided,
+// session associated with policySession will be extended to include the name of authContext and the value of policyRef. If provided,
 // the value of cpHashA will be recorded on the session context to restrict the session's usage. If expiration is non-zero, the
 // expiration time of the session context will be updated unless it already has an expiration time that is earlier. If expiration is
 // less than zero, a timeout value and corresponding *TkAuth ticket will be returned if policySession does not correspond to a trial
-// session.
+// session. Otherwise, the returned timeout will be empty and the returned ticket will be a NULL ticket with a hierarchy of
+// HandleNull, which cannot be used with TPMContext.PolicyTicket.
 func (t *TPMContext) PolicySecret(authContext ResourceContext, policySession SessionContext, cpHashA Digest, policyRef Nonce, expiration int32, authContextAuthSession SessionContext, sessions ...SessionContext) (Timeout, *TkAuth, error) {
+	if policySession == nil {
+		return nil, nil, makeInvalidArgError("policySession", "nil value")
+	}
+
 	var timeout Timeout
 	var policyTicket TkAuth
 
@@ -167,9 +185,22 @@ func (t *TPMContext) PolicyOR(policySession SessionContext, pHashList DigestList
 // policySession corresponds to a trial session, the digest computed from the selected PCRs is not compared to the value of pcrDigest;
 // instead, the policy digest of the session is extended to include the value of the PCR selection and the value of pcrDigest.
 //
+// If pcrDigest is provided, its length must match the size of the digest algorithm of the session associated with policySession, as
+// both the TPM and TrialAuthPolicy compute the PCR digest using this algorithm. If it doesn't, an error will be returned without
+// executing the command.
+//
 // If the PCR contents have changed since the last time this command was executed for this session, a *TPMError error will be returned
 // with an error code of ErrorPCRChanged.
 func (t *TPMContext) PolicyPCR(policySession SessionContext, pcrDigest Digest, pcrs PCRSelectionList, sessions ...SessionContext) error {
+	if len(pcrDigest) > 0 {
+		if s, isSession := policySession.(*sessionContext); isSession {
+			if scData := s.scData(); scData != nil && scData.HashAlg.Supported() && len(pcrDigest) != scData.HashAlg.Size() {
+				return makeInvalidArgError("pcrDigest", fmt.Sprintf("digest has the wrong length for the session's digest algorithm "+
+					"(got %d bytes, expected %d bytes)", len(pcrDigest), scData.HashAlg.Size()))
+			}
+		}
+	}
+
 	return t.RunCommand(CommandPolicyPCR, sessions,
 		policySession, Delimiter,
 		pcrDigest, pcrs)
@@ -181,7 +212,10 @@ func (t *TPMContext) PolicyPCR(policySession SessionContext, pcrDigest Digest, p
 // PolicyNV executes the TPM2_PolicyNV command to gate a policy based on the contents of the NV index associated with nvIndex, and is
 // an immediate assertion. The caller specifies a value to be used for the comparison via the operandB argument, an offset from the
 // start of the NV index data from which to start the comparison via the offset argument, and a comparison operator via the operation
-// argument.
+// argument. The comparison is performed with the NV index data as the first operand and operandB as the second operand, which are both
+// interpreted as big-endian integers. The OpSigned* operators treat both operands as two's complement signed integers and the
+// OpUnsigned* operators treat them as unsigned integers. The OpBitset and OpBitclear operators test that all of the bits set in
+// operandB are set or clear in the NV index data respectively.
 //
 // The command requires authorization to read the NV index, defined by the state of the AttrNVPPRead, AttrNVOwnerRead, AttrNVAuthRead
 // and AttrNVPolicyRead attributes. The handle used for authorization is specified via authContext. If the NV index has the
@@ -204,11 +238,11 @@ func (t *TPMContext) PolicyPCR(policySession SessionContext, pcrDigest Digest, p
 // not correspond to a trial session, a *TPMError with an error code of ErrorNVUninitialized will be returned.
 //
 // If the session associated with policySession is not a trial session and offset is outside of the bounds of the NV index, a
-// *TPMParameterError error with an error code of ErrorValue is returned for paramter index 2.
+// *TPMParameterError error with an error code of ErrorValue is returned for parameter index 2.
 //
 // If the session associated with policySession is not a trial session and the size of operandB in combination with the value of
 // offset would result in a read outside of the bounds of the NV index, a *TPMParameterError error with an error code of ErrorSize
-// is returned for paramter index 1.
+// is returned for parameter index 1.
 //
 // If the comparison fails and policySession does not correspond to a trial session, a *TPMError error will be returned with an error
 // code of ErrorPolicy.
@@ -224,7 +258,14 @@ func (t *TPMContext) PolicyNV(authContext, nvIndex ResourceContext, policySessio
 // PolicyCounterTimer executes the TPM2_PolicyCounterTimer command to gate a policy based on the contents of the TimeInfo structure,
 // and is an immediate assertion. The caller specifies a value to be used for the comparison via the operandB argument, an offset from
 // the start of the TimeInfo structure from which to start the comparison via the offset argument, and a comparison operator via the
-// operation argument.
+// operation argument. The fields of the marshalled TimeInfo structure are at the following offsets:
+//  - 0: Time (8 bytes)
+//  - 8: ClockInfo.Clock (8 bytes)
+//  - 16: ClockInfo.ResetCount (4 bytes)
+//  - 20: ClockInfo.RestartCount (4 bytes)
+//  - 24: ClockInfo.Safe (1 byte)
+// For example, a policy that expires can be created by comparing an 8 byte big-endian operandB with ClockInfo.Clock at offset 8
+// using OpUnsignedLT.
 //
 // If the comparison fails and policySession does not correspond to a trial session, a *TPMError error will be returned with an error
 // code of ErrorPolicy.
@@ -359,11 +400,16 @@ func (t *TPMContext) PolicyAuthorize(policySession SessionContext, approvedPolic
 // When using policySession in a subsequent authorization, the authorization value of the entity being authorized must be provided by
 // calling ResourceContext.SetAuthValue.
 func (t *TPMContext) PolicyAuthValue(policySession SessionContext, sessions ...SessionContext) error {
+	s, isSession := policySession.(*sessionContext)
+	if !isSession || s.scData() == nil {
+		return makeInvalidArgError("policySession", "unusable session context")
+	}
+
 	if err := t.RunCommand(CommandPolicyAuthValue, sessions, policySession); err != nil {
 		return err
 	}
 
-	policySession.(*sessionContext).scData().PolicyHMACType = policyHMACTypeAuth
+	s.scData().PolicyHMACType = policyHMACTypeAuth
 	return nil
 }
 
@@ -376,11 +422,16 @@ func (t *TPMContext) PolicyAuthValue(policySession SessionContext, sessions ...S
 // When using policySession in a subsequent authorization, the authorization value of the entity being authorized must be provided by
 // calling ResourceContext.SetAuthValue.
 func (t *TPMContext) PolicyPassword(policySession SessionContext, sessions ...SessionContext) error {
+	s, isSession := policySession.(*sessionContext)
+	if !isSession || s.scData() == nil {
+		return makeInvalidArgError("policySession", "unusable session context")
+	}
+
 	if err := t.RunCommand(CommandPolicyPassword, sessions, policySession); err != nil {
 		return err
 	}
 
-	policySession.(*sessionContext).scData().PolicyHMACType = policyHMACTypePassword
+	s.scData().PolicyHMACType = policyHMACTypePassword
 	return nil
 }
 
@@ -413,8 +464,23 @@ func (t *TPMContext) PolicyNvWritten(policySession SessionContext, writtenSet bo
 	return t.RunCommand(CommandPolicyNvWritten, sessions, policySession, Delimiter, writtenSet)
 }
 
-// func (t *TPMContext) PolicyTemplate(policySession HandleContext, templateHash Digest, sessions ...SessionContext) error {
-// }
+// PolicyTemplate executes the TPM2_PolicyTemplate command to bind a policy to a specific object template, so that the session can
+// only be used to authorize TPMContext.CreatePrimary, TPMContext.Create or TPMContext.CreateLoaded with that template. This is a
+// deferred assertion. The templateHash argument is the digest of the marshalled public template (excluding the size field), computed
+// with the digest algorithm for the session.
+//
+// If the size of templateHash is inconsistent with the digest algorithm for the session, a *TPMParameterError error with an error
+// code of ErrorSize will be returned.
+//
+// If the session associated with policySession already has a command parameter digest or name digest defined, or it has a template
+// digest defined that does not match templateHash, a *TPMError error with an error code of ErrorCpHash will be returned.
+//
+// On successful completion, the policy digest of the session context associated with policySession will be extended to include the
+// value of templateHash, and the value of templateHash will be recorded on the session context to limit usage of the session to
+// creating objects with the specified template.
+func (t *TPMContext) PolicyTemplate(policySession SessionContext, templateHash Digest, sessions ...SessionContext) error {
+	return t.RunCommand(CommandPolicyTemplate, sessions, policySession, Delimiter, templateHash)
+}
 
 // func (t *TPMContext) PolicyAuthorizeNV(authContext, nvIndex, policySession HandleContext, authContextAuth interface{}, sessions ...SessionContext) error {
 // }
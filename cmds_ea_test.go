@@ -11,6 +11,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/binary"
+	"reflect"
 	"testing"
 	"time"
 
@@ -150,6 +151,82 @@ func TestPolicySigned(t *testing.T) {
 	}
 }
 
+func TestPolicySignedMock(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+	startRsp := makeMockResponse(Success, nil, params)
+	params, _ = mu.MarshalToBytes(Timeout(nil), TkAuth{Tag: TagAuthSigned, Hierarchy: HandleNull})
+	signedRsp := makeMockResponse(Success, nil, params)
+
+	tcti := &mockTcti{responses: bytes.NewReader(append(startRsp, signedRsp...))}
+	tpm, _ := NewTPMContext(tcti)
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+
+	signature := &Signature{
+		SigAlg: SigSchemeAlgECDSA,
+		Signature: SignatureU{
+			Data: &SignatureECDSA{
+				Hash:       HashAlgorithmSHA256,
+				SignatureR: bytes.Repeat([]byte{0x01}, 32),
+				SignatureS: bytes.Repeat([]byte{0x02}, 32)}}}
+
+	key := tpm.OwnerHandleContext()
+
+	tcti.commands.Reset()
+	if _, _, err := tpm.PolicySigned(key, nil, false, nil, nil, 0, signature); err == nil ||
+		err.Error() != "invalid policySession argument: nil value" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, _, err := tpm.PolicySigned(key, sessionContext, false, nil, nil, 0, nil); err == nil ||
+		err.Error() != "invalid auth argument: nil value" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.commands.Len() != 0 {
+		t.Errorf("No command should have been sent to the TPM")
+	}
+
+	timeout, ticket, err := tpm.PolicySigned(key, sessionContext, false, nil, nil, 0, signature)
+	if err != nil {
+		t.Fatalf("PolicySigned failed: %v", err)
+	}
+	if len(timeout) != 0 {
+		t.Errorf("Expected an empty timeout")
+	}
+	if ticket == nil || ticket.Tag != TagAuthSigned || ticket.Hierarchy != HandleNull {
+		t.Errorf("Expected a NULL ticket")
+	}
+
+	var cmd struct {
+		AuthObject    Handle
+		PolicySession Handle
+		NonceTPM      Nonce
+		CpHashA       Digest
+		PolicyRef     Nonce
+		Expiration    int32
+		Auth          Signature
+	}
+	if _, err := mu.UnmarshalFromBytes(tcti.commands.Bytes()[10:], &cmd); err != nil {
+		t.Fatalf("Cannot unmarshal command: %v", err)
+	}
+	if cmd.AuthObject != key.Handle() || cmd.PolicySession != sessionContext.Handle() {
+		t.Errorf("Unexpected handles")
+	}
+	if len(cmd.NonceTPM) != 0 || cmd.Expiration != 0 {
+		t.Errorf("Unexpected parameters")
+	}
+	if cmd.Auth.SigAlg != SigSchemeAlgECDSA {
+		t.Errorf("Unexpected signature algorithm: %v", cmd.Auth.SigAlg)
+	}
+	sig := cmd.Auth.Signature.ECDSA()
+	if sig.Hash != HashAlgorithmSHA256 || !bytes.Equal(sig.SignatureR, signature.Signature.ECDSA().SignatureR) ||
+		!bytes.Equal(sig.SignatureS, signature.Signature.ECDSA().SignatureS) {
+		t.Errorf("Unexpected signature")
+	}
+}
+
 func TestPolicySecret(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
 	defer closeTPM(t, tpm)
@@ -606,6 +683,55 @@ func TestPolicyPCR(t *testing.T) {
 	}
 }
 
+func TestPolicyPCRMock(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+	startRsp := makeMockResponse(Success, nil, params)
+	pcrRsp := makeMockResponse(Success, nil, nil)
+
+	tcti := &mockTcti{responses: bytes.NewReader(append(startRsp, pcrRsp...))}
+	tpm, _ := NewTPMContext(tcti)
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+
+	pcrs := PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{7}}}
+
+	tcti.commands.Reset()
+	err = tpm.PolicyPCR(sessionContext, make(Digest, 20), pcrs)
+	if err == nil {
+		t.Fatalf("PolicyPCR should fail with a digest of the wrong length")
+	}
+	if err.Error() != "invalid pcrDigest argument: digest has the wrong length for the session's digest algorithm (got 20 bytes, "+
+		"expected 32 bytes)" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.commands.Len() != 0 {
+		t.Errorf("No command should have been sent to the TPM")
+	}
+
+	if err := tpm.PolicyPCR(sessionContext, nil, pcrs); err != nil {
+		t.Fatalf("PolicyPCR failed: %v", err)
+	}
+
+	var handle Handle
+	var pcrDigest Digest
+	var pcrSelection PCRSelectionList
+	if _, err := mu.UnmarshalFromBytes(tcti.commands.Bytes()[10:], &handle, &pcrDigest, &pcrSelection); err != nil {
+		t.Fatalf("Cannot unmarshal command: %v", err)
+	}
+	if handle != sessionContext.Handle() {
+		t.Errorf("Unexpected handle: %v", handle)
+	}
+	if len(pcrDigest) != 0 {
+		t.Errorf("pcrDigest should be empty for a deferred assertion")
+	}
+	if !pcrSelection.Equal(pcrs) {
+		t.Errorf("Unexpected PCR selection: %v", pcrSelection)
+	}
+}
+
 func TestPolicyCommandCode(t *testing.T) {
 	tpm := openTPMForTesting(t, 0)
 	defer closeTPM(t, tpm)
@@ -916,6 +1042,63 @@ func TestPolicyAuthorize(t *testing.T) {
 	}
 }
 
+func TestPolicyAuthorizeMock(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+	startRsp := makeMockResponse(Success, nil, params)
+	authorizeRsp := makeMockResponse(Success, nil, nil)
+
+	keySign, _ := mu.MarshalToBytes(HashAlgorithmSHA256, mu.RawBytes(make([]byte, 32)))
+
+	for _, data := range []struct {
+		desc     string
+		ticket   *TkVerified
+		expected TkVerified
+	}{
+		{
+			desc:     "Ticket",
+			ticket:   &TkVerified{Tag: TagVerified, Hierarchy: HandleOwner, Digest: Digest{0x01, 0x02, 0x03}},
+			expected: TkVerified{Tag: TagVerified, Hierarchy: HandleOwner, Digest: Digest{0x01, 0x02, 0x03}},
+		},
+		{
+			desc:     "NoTicket",
+			expected: TkVerified{Tag: TagVerified, Hierarchy: HandleNull},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			tcti := &mockTcti{responses: bytes.NewReader(append(startRsp, authorizeRsp...))}
+			tpm, _ := NewTPMContext(tcti)
+
+			sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+			if err != nil {
+				t.Fatalf("StartAuthSession failed: %v", err)
+			}
+
+			tcti.commands.Reset()
+			if err := tpm.PolicyAuthorize(sessionContext, Digest{0x04, 0x05}, Nonce("foo"), keySign, data.ticket); err != nil {
+				t.Fatalf("PolicyAuthorize failed: %v", err)
+			}
+
+			var handle Handle
+			var approvedPolicy Digest
+			var policyRef Nonce
+			var name Name
+			var ticket TkVerified
+			if _, err := mu.UnmarshalFromBytes(tcti.commands.Bytes()[10:], &handle, &approvedPolicy, &policyRef, &name, &ticket); err != nil {
+				t.Fatalf("Cannot unmarshal command: %v", err)
+			}
+			if handle != sessionContext.Handle() {
+				t.Errorf("Unexpected handle: %v", handle)
+			}
+			if !bytes.Equal(approvedPolicy, []byte{0x04, 0x05}) || !bytes.Equal(policyRef, []byte("foo")) || !bytes.Equal(name, keySign) {
+				t.Errorf("Unexpected command parameters")
+			}
+			if !reflect.DeepEqual(ticket, data.expected) {
+				t.Errorf("Unexpected ticket: %v", ticket)
+			}
+		})
+	}
+}
+
 func TestPolicyAuthValue(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
 	defer closeTPM(t, tpm)
@@ -1052,6 +1235,61 @@ func TestPolicyPassword(t *testing.T) {
 	}
 }
 
+func TestPolicyNVMock(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+	startRsp := makeMockResponse(Success, nil, params)
+	nvRsp := makeMockResponse(Success, nil, nil, mockPasswordAuth)
+
+	tcti := &mockTcti{responses: bytes.NewReader(append(startRsp, nvRsp...))}
+	tpm, _ := NewTPMContext(tcti)
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+
+	pub := NVPublic{
+		Index:   0x018100ff,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVOwnerRead | AttrNVAuthWrite | AttrNVWritten),
+		Size:    8}
+	nvIndex, err := CreateNVIndexResourceContextFromPublic(&pub)
+	if err != nil {
+		t.Fatalf("CreateNVIndexResourceContextFromPublic failed: %v", err)
+	}
+
+	owner := tpm.OwnerHandleContext()
+
+	tcti.commands.Reset()
+	if err := tpm.PolicyNV(owner, nvIndex, sessionContext, Operand{0x01, 0x02}, 6, OpUnsignedLE, nil); err != nil {
+		t.Fatalf("PolicyNV failed: %v", err)
+	}
+
+	var operandB Operand
+	var offset uint16
+	var operation ArithmeticOp
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 3)
+	if !reflect.DeepEqual(c.Handles, []Handle{owner.Handle(), nvIndex.Handle(), sessionContext.Handle()}) {
+		t.Errorf("Unexpected handles: %v", c.Handles)
+	}
+	params = c.Params
+	if len(params) != 2+2+2+2 {
+		t.Errorf("Unexpected parameter area size: %d", len(params))
+	}
+	if _, err := mu.UnmarshalFromBytes(params, &operandB, &offset, &operation); err != nil {
+		t.Fatalf("Cannot unmarshal command parameters: %v", err)
+	}
+	if !bytes.Equal(operandB, Operand{0x01, 0x02}) {
+		t.Errorf("Unexpected operandB: %x", operandB)
+	}
+	if offset != 6 {
+		t.Errorf("Unexpected offset: %d", offset)
+	}
+	if operation != OpUnsignedLE {
+		t.Errorf("Unexpected operation: %v", operation)
+	}
+}
+
 func TestPolicyNV(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerPersist)
 	defer closeTPM(t, tpm)
@@ -1300,3 +1538,100 @@ func TestPolicyNvWritten(t *testing.T) {
 		})
 	}
 }
+
+func TestPolicyTemplateMock(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+	startRsp := makeMockResponse(Success, nil, params)
+	templateRsp := makeMockResponse(Success, nil, nil)
+
+	tcti := &mockTcti{responses: bytes.NewReader(append(startRsp, templateRsp...))}
+	tpm, _ := NewTPMContext(tcti)
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+
+	templateHash := make(Digest, 32)
+	templateHash[0] = 0x01
+
+	tcti.commands.Reset()
+	if err := tpm.PolicyTemplate(sessionContext, templateHash); err != nil {
+		t.Fatalf("PolicyTemplate failed: %v", err)
+	}
+
+	var code CommandCode
+	var handle Handle
+	var digest Digest
+	if _, err := mu.UnmarshalFromBytes(tcti.commands.Bytes()[6:], &code, &handle, &digest); err != nil {
+		t.Fatalf("Cannot unmarshal command: %v", err)
+	}
+	if code != CommandPolicyTemplate {
+		t.Errorf("Unexpected command code: %v", code)
+	}
+	if handle != sessionContext.Handle() {
+		t.Errorf("Unexpected handle: %v", handle)
+	}
+	if !bytes.Equal(digest, templateHash) {
+		t.Errorf("Unexpected template hash: %x", digest)
+	}
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyTemplate(templateHash)
+	h := sha256.New()
+	h.Write(make([]byte, 32))
+	mu.MarshalToWriter(h, CommandPolicyTemplate, mu.RawBytes(templateHash))
+	if !bytes.Equal(trial.GetDigest(), h.Sum(nil)) {
+		t.Errorf("Unexpected trial digest: %x", trial.GetDigest())
+	}
+}
+
+func TestPolicyCounterTimerMock(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+	startRsp := makeMockResponse(Success, nil, params)
+	counterTimerRsp := makeMockResponse(Success, nil, nil)
+
+	tcti := &mockTcti{responses: bytes.NewReader(append(startRsp, counterTimerRsp...))}
+	tpm, _ := NewTPMContext(tcti)
+
+	sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+
+	clock := make(Operand, 8)
+	binary.BigEndian.PutUint64(clock, 100000)
+
+	tcti.commands.Reset()
+	if err := tpm.PolicyCounterTimer(sessionContext, clock, 8, OpUnsignedLT); err != nil {
+		t.Fatalf("PolicyCounterTimer failed: %v", err)
+	}
+
+	var code CommandCode
+	var handle Handle
+	var operandB Operand
+	var offset uint16
+	var operation ArithmeticOp
+	if _, err := mu.UnmarshalFromBytes(tcti.commands.Bytes()[6:], &code, &handle, &operandB, &offset, &operation); err != nil {
+		t.Fatalf("Cannot unmarshal command: %v", err)
+	}
+	if code != CommandPolicyCounterTimer || handle != sessionContext.Handle() {
+		t.Errorf("Unexpected command header")
+	}
+	if !bytes.Equal(operandB, clock) || offset != 8 || operation != OpUnsignedLT {
+		t.Errorf("Unexpected command parameters")
+	}
+
+	// policyDigest' := H(policyDigest || TPM_CC_PolicyCounterTimer || H(operandB || offset || operation))
+	args := sha256.New()
+	mu.MarshalToWriter(args, mu.RawBytes(clock), uint16(8), OpUnsignedLT)
+	h := sha256.New()
+	h.Write(make([]byte, 32))
+	mu.MarshalToWriter(h, CommandPolicyCounterTimer, mu.RawBytes(args.Sum(nil)))
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyCounterTimer(clock, 8, OpUnsignedLT)
+	if !bytes.Equal(trial.GetDigest(), h.Sum(nil)) {
+		t.Errorf("Unexpected trial digest: %x", trial.GetDigest())
+	}
+}
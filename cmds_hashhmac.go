@@ -4,6 +4,10 @@
 
 package tpm2
 
+import (
+	"fmt"
+)
+
 // Section 17 - Hash/HMAC/Event Sequences
 
 // HMACStart executes the TPM2_HMAC_Start command to begin a HMAC sequence. The context argument corresponds to a loaded HMAC
@@ -76,10 +80,58 @@ func (t *TPMContext) HashSequenceStart(auth Auth, hashAlg HashAlgorithmId, sessi
 //
 // If sequenceContext corresponds to a hash sequence and the hash sequence is intended to produce a digest that will be signed with
 // a restricted signing key, the first block of data added to this sequence must be 4 bytes and not the value of TPMGeneratedValue.
+//
+// If buffer is too large to be passed to the TPM in a single command, this function will re-execute the TPM2_SequenceUpdate command
+// until all of the data has been added to the sequence. As a consequence, any SessionContext instances provided must have the
+// AttrContinueSession attribute defined and sequenceContextAuthSession must not be a policy session in this case. These are checked
+// before any data is sent to the TPM, so that the sequence isn't left partially updated.
 func (t *TPMContext) SequenceUpdate(sequenceContext ResourceContext, buffer MaxBuffer, sequenceContextAuthSession SessionContext, sessions ...SessionContext) error {
-	return t.RunCommand(CommandSequenceUpdate, sessions,
-		ResourceContextWithSession{Context: sequenceContext, Session: sequenceContextAuthSession}, Delimiter,
-		buffer)
+	// The TPM's input buffer is required to be at least 1024 bytes, so there's no need to query its size for smaller buffers.
+	if len(buffer) > 1024 {
+		if err := t.initPropertiesIfNeeded(); err != nil {
+			return err
+		}
+	}
+
+	if t.propertiesInitialized && len(buffer) > t.maxBufferSize {
+		if w, isWrapped := sequenceContext.(*resourceContextWithAuth); isWrapped && sequenceContextAuthSession == nil {
+			sequenceContextAuthSession = w.session
+		}
+		if sequenceContextAuthSession != nil {
+			if sequenceContextAuthSession.(*sessionContext).attrs&AttrContinueSession == 0 {
+				return makeInvalidArgError("sequenceContextAuthSession", "the AttrContinueSession attribute is required for authorization sessions")
+			}
+			if scData := sequenceContextAuthSession.(*sessionContext).scData(); scData != nil && scData.SessionType == SessionTypePolicy {
+				return makeInvalidArgError("sequenceContextAuthSession", "a policy authorization session cannot be used")
+			}
+		}
+
+		for i, s := range sessions {
+			if s.(*sessionContext).attrs&AttrContinueSession == 0 {
+				return makeInvalidArgError("sessions", fmt.Sprintf("the AttrContinueSession attribute is required for session at index %d", i))
+			}
+		}
+	}
+
+	total := 0
+	for {
+		b := buffer[total:]
+		if t.propertiesInitialized && len(b) > t.maxBufferSize {
+			b = b[:t.maxBufferSize]
+		}
+		if err := t.RunCommand(CommandSequenceUpdate, sessions,
+			ResourceContextWithSession{Context: sequenceContext, Session: sequenceContextAuthSession}, Delimiter,
+			b); err != nil {
+			return err
+		}
+
+		total += len(b)
+		if len(buffer)-total == 0 {
+			break
+		}
+	}
+
+	return nil
 }
 
 // SequenceComplete executes the TPM2_SequenceComplete command to add the last part of the data the HMAC or hash sequence associated
@@ -111,6 +163,7 @@ func (t *TPMContext) SequenceComplete(sequenceContext ResourceContext, buffer Ma
 		validation = nil
 	}
 
+	t.ForgetHandle(sequenceContext.Handle())
 	sequenceContext.(handleContextPrivate).invalidate()
 	return result, validation, nil
 }
@@ -141,6 +194,7 @@ func (t *TPMContext) EventSequenceComplete(pcrContext, sequenceContext ResourceC
 		return nil, err
 	}
 
+	t.ForgetHandle(sequenceContext.Handle())
 	sequenceContext.(handleContextPrivate).invalidate()
 	return results, nil
 }
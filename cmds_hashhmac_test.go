@@ -9,12 +9,14 @@ import (
 	"crypto"
 	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"hash"
 	"reflect"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestHMACSequence(t *testing.T) {
@@ -550,3 +552,165 @@ func TestEventSequenceExecute(t *testing.T) {
 		run(t, -1, seq, session.WithAttrs(AttrContinueSession))
 	})
 }
+
+func TestHashSequenceMock(t *testing.T) {
+	// Emulate a TPM with a maximum input buffer size of 16 bytes.
+	var h hash.Hash
+	var updates []int
+	respond := func(cmd []byte) []byte {
+		var commandCode CommandCode
+		if _, err := mu.UnmarshalFromBytes(cmd[6:], &commandCode); err != nil {
+			t.Fatalf("Cannot unmarshal command code: %v", err)
+		}
+
+		switch commandCode {
+		case CommandGetCapability:
+			return makeGetCapabilityResponseForTesting(t, false, &CapabilityData{
+				Capability: CapabilityTPMProperties,
+				Data: CapabilitiesU{Data: TaggedTPMPropertyList{
+					{Property: PropertyInputBuffer, Value: 16},
+					{Property: PropertyNVBufferMax, Value: 16}}}})
+		case CommandHashSequenceStart:
+			h = sha256.New()
+			return makeMockResponse(Success, []Handle{0x80000001}, nil)
+		}
+
+		var buffer MaxBuffer
+		if _, err := mu.UnmarshalFromBytes(decodeMockCommand(t, cmd, 1).Params, &buffer); err != nil {
+			t.Fatalf("Cannot unmarshal command parameters: %v", err)
+		}
+		h.Write(buffer)
+
+		var rpBytes []byte
+		switch commandCode {
+		case CommandSequenceUpdate:
+			updates = append(updates, len(buffer))
+		case CommandSequenceComplete:
+			rpBytes, _ = mu.MarshalToBytes(Digest(h.Sum(nil)), TkHashcheck{Tag: TagHashcheck, Hierarchy: HandleNull})
+		default:
+			t.Fatalf("Unexpected command: %v", commandCode)
+		}
+
+		return makeMockResponse(Success, nil, rpBytes, mockPasswordAuth)
+	}
+	tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+	if err := tpm.InitProperties(); err != nil {
+		t.Fatalf("InitProperties failed: %v", err)
+	}
+
+	seq, err := tpm.HashSequenceStart(nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("HashSequenceStart failed: %v", err)
+	}
+
+	data := make([]byte, 40)
+	rand.Read(data)
+	if err := tpm.SequenceUpdate(seq, data, nil); err != nil {
+		t.Fatalf("SequenceUpdate failed: %v", err)
+	}
+	if !reflect.DeepEqual(updates, []int{16, 16, 8}) {
+		t.Errorf("Unexpected updates: %v", updates)
+	}
+
+	result, validation, err := tpm.SequenceComplete(seq, nil, HandleNull, nil)
+	if err != nil {
+		t.Fatalf("SequenceComplete failed: %v", err)
+	}
+	expected := sha256.Sum256(data)
+	if !bytes.Equal(result, expected[:]) {
+		t.Errorf("Unexpected result: %x", result)
+	}
+	if validation != nil {
+		t.Errorf("validation should be nil")
+	}
+	if seq.Handle() != HandleUnassigned {
+		t.Errorf("SequenceComplete should have invalidated the sequence context")
+	}
+}
+
+func TestSequenceUpdateSessionChecksMock(t *testing.T) {
+	// Emulate a TPM with a maximum input buffer size of 16 bytes.
+	respond := func(cmd []byte) []byte {
+		var commandCode CommandCode
+		if _, err := mu.UnmarshalFromBytes(cmd[6:], &commandCode); err != nil {
+			t.Fatalf("Cannot unmarshal command code: %v", err)
+		}
+
+		switch commandCode {
+		case CommandGetCapability:
+			return makeGetCapabilityResponseForTesting(t, false, &CapabilityData{
+				Capability: CapabilityTPMProperties,
+				Data: CapabilitiesU{Data: TaggedTPMPropertyList{
+					{Property: PropertyInputBuffer, Value: 16},
+					{Property: PropertyNVBufferMax, Value: 16}}}})
+		case CommandHashSequenceStart:
+			return makeMockResponse(Success, []Handle{0x80000001}, nil)
+		case CommandStartAuthSession:
+			var sessionType SessionType
+			if _, err := mu.UnmarshalFromBytes(cmd[len(cmd)-5:], &sessionType); err != nil {
+				t.Fatalf("Cannot unmarshal session type: %v", err)
+			}
+			handle := Handle(0x02000000)
+			if sessionType == SessionTypePolicy {
+				handle = 0x03000000
+			}
+			params, _ := mu.MarshalToBytes(handle, Nonce(make([]byte, 32)))
+			return makeMockResponse(Success, nil, params)
+		default:
+			t.Errorf("Unexpected command: %v", commandCode)
+			return makeMockResponse(ResponseCode(0x101), nil, nil)
+		}
+	}
+	tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+	if err := tpm.InitProperties(); err != nil {
+		t.Fatalf("InitProperties failed: %v", err)
+	}
+
+	seq, err := tpm.HashSequenceStart(nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("HashSequenceStart failed: %v", err)
+	}
+	hmacSession, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	policySession, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+
+	data := make([]byte, 40)
+
+	for _, d := range []struct {
+		desc        string
+		authSession SessionContext
+		sessions    []SessionContext
+		err         string
+	}{
+		{
+			desc:        "AuthSessionWithoutContinueSession",
+			authSession: hmacSession,
+			err:         "invalid sequenceContextAuthSession argument: the AttrContinueSession attribute is required for authorization sessions",
+		},
+		{
+			desc:        "PolicyAuthSession",
+			authSession: policySession.WithAttrs(AttrContinueSession),
+			err:         "invalid sequenceContextAuthSession argument: a policy authorization session cannot be used",
+		},
+		{
+			desc:     "ExtraSessionWithoutContinueSession",
+			sessions: []SessionContext{hmacSession.WithAttrs(AttrContinueSession | AttrAudit), hmacSession.WithAttrs(AttrAudit)},
+			err:      "invalid sessions argument: the AttrContinueSession attribute is required for session at index 1",
+		},
+	} {
+		t.Run(d.desc, func(t *testing.T) {
+			err := tpm.SequenceUpdate(seq, data, d.authSession, d.sessions...)
+			if err == nil {
+				t.Fatalf("SequenceUpdate should have failed")
+			}
+			if err.Error() != d.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
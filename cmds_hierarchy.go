@@ -163,10 +163,24 @@ func (t *TPMContext) CreatePrimary(primaryObject ResourceContext, inSensitive *S
 //
 // If state is true, then authContext must correspond to HandlePlatform. Note that the platform hierarchy can't be re-enabled by
 // this command.
+//
+// If authContext doesn't correspond to a hierarchy that is permitted to make the requested change, a *TPMError error with an error
+// code of ErrorAuthType will be returned.
+//
+// On successful completion of a command that disables a hierarchy, all ResourceContexts cached by
+// TPMContext.CreateResourceContextFromTPM are dropped, in the same way as TPMContext.Clear. Any that are still valid will be
+// recreated when they are next requested.
 func (t *TPMContext) HierarchyControl(authContext ResourceContext, enable Handle, state bool, authContextAuthSession SessionContext, sessions ...SessionContext) error {
-	return t.RunCommand(CommandHierarchyControl, sessions,
+	if err := t.RunCommand(CommandHierarchyControl, sessions,
 		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
-		enable, state)
+		enable, state); err != nil {
+		return err
+	}
+
+	if !state {
+		t.forgetAllHandles()
+	}
+	return nil
 }
 
 // Clear executes the TPM2_Clear command to remove all context associated with the current owner. The command requires knowledge of
@@ -175,12 +189,17 @@ func (t *TPMContext) HierarchyControl(authContext ResourceContext, enable Handle
 // role for authContext, with session based authorization provided via authContextAuthSession.
 //
 // On successful completion, all NV indices and objects associated with the current owner will have been evicted and subsequent use of
-// ResourceContext instances associated with these resources will fail. The authorization values of the storage, endorsement and
+// ResourceContext instances associated with these resources will fail. ResourceContexts for these resources that were created by
+// TPMContext.CreateResourceContextFromTPM are invalidated, and using them in a subsequent command returns an error that wraps
+// ErrResourceDoesNotExist without submitting the command to the TPM. Other ResourceContexts are not tracked by the TPMContext, and
+// using them will result in an error from the TPM. The authorization values of the storage, endorsement and
 // lockout hierarchies will have been cleared. It isn't necessary to update the corresponding ResourceContext instances for these
 // by calling ResourceContext.SetAuthValue in order to use them in subsequent commands that require knowledge of the authorization
 // value for those permanent resources.
 //
-// If the TPM2_Clear command has been disabled, a *TPMError error will be returned with an error code of ErrorDisabled.
+// If the TPM2_Clear command has been disabled, a *TPMError error will be returned with an error code of ErrorDisabled. If the TPM
+// returns an error, the authorization values associated with the ResourceContext instances for the permanent resources are not
+// modified.
 func (t *TPMContext) Clear(authContext ResourceContext, authContextAuthSession SessionContext, sessions ...SessionContext) error {
 	var s []*sessionParam
 	s, err := t.validateAndAppendAuthSessionParam(s, ResourceContextWithSession{Context: authContext, Session: authContextAuthSession})
@@ -193,16 +212,23 @@ func (t *TPMContext) Clear(authContext ResourceContext, authContextAuthSession S
 	}
 
 	ctx, err := t.runCommandWithoutProcessingResponse(CommandClear, s, []interface{}{authContext}, nil)
+	if err != nil {
+		return err
+	}
 
+	// The TPM will respond with a HMAC generated with a key that doesn't include the old authorization value for authContext if
+	// it corresponds to HandleLockout, so the cleared authorization values need to be updated before processing the response.
+	t.resourcesMu.Lock()
 	for _, h := range []Handle{HandleOwner, HandleEndorsement, HandleLockout} {
 		if rc, exists := t.permanentResources[h]; exists {
 			rc.auth = nil
 		}
 	}
+	t.resourcesMu.Unlock()
 
-	if err != nil {
-		return err
-	}
+	// Objects and NV indices associated with the owner have been removed. Invalidate the cached ResourceContexts for these and drop
+	// the rest - any that are still valid will be recreated by CreateResourceContextFromTPM when they are next requested.
+	t.invalidateClearedResources()
 
 	return t.processResponse(ctx, nil, nil)
 }
@@ -232,7 +258,8 @@ func (t *TPMContext) ClearControl(authContext ResourceContext, disable bool, aut
 // authorization provided via authContextAuthSession.
 //
 // If the value of newAuth is longer than the context integrity digest algorithm for the TPM, a *TPMParameterError error with an
-// error code of ErrorSize will be returned.
+// error code of ErrorSize will be returned. The value of newAuth may be empty, in which case the authorization value for the
+// hierarchy will be cleared. If the command fails, the authorization value associated with authContext is not modified.
 //
 // On successful completion, the authorization value of the hierarchy associated with authContext will be set to the value of
 // newAuth, and authContext will be updated to reflect this - it isn't necessary to update authContext with
@@ -8,7 +8,10 @@ import (
 	"bytes"
 	"testing"
 
+	"golang.org/x/xerrors"
+
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestCreatePrimary(t *testing.T) {
@@ -547,3 +550,165 @@ func TestHierarchyChangeAuth(t *testing.T) {
 		resetAuth(t, tpm.OwnerHandleContext(), sessionContext, createSrk)
 	})
 }
+
+func TestHierarchyChangeAuthMock(t *testing.T) {
+	okRsp := makeMockResponse(Success, nil, nil, mockPasswordAuth)
+	failRsp := makeMockResponse(ResponseCode(0x98e), nil, nil)
+
+	var responses []byte
+	for _, r := range [][]byte{okRsp, failRsp, okRsp} {
+		responses = append(responses, r...)
+	}
+	tcti := &mockTcti{responses: bytes.NewReader(responses)}
+	tpm, _ := NewTPMContext(tcti)
+
+	owner := tpm.OwnerHandleContext()
+	defer owner.SetAuthValue(nil)
+
+	run := func(t *testing.T, newAuth Auth, expectedPassword, expectedParams []byte) error {
+		tcti.commands.Reset()
+		err := tpm.HierarchyChangeAuth(owner, newAuth, nil)
+
+		c := decodeMockCommand(t, tcti.commands.Bytes(), 1)
+		if len(c.Auths) != 1 {
+			t.Fatalf("Unexpected number of command authorizations: %d", len(c.Auths))
+		}
+		if c.Auths[0].Handle != HandlePW {
+			t.Errorf("Unexpected session handle: %v", c.Auths[0].Handle)
+		}
+		if !bytes.Equal(c.Auths[0].HMAC, expectedPassword) {
+			t.Errorf("Unexpected password: %x", c.Auths[0].HMAC)
+		}
+		// newAuth is a TPM2B_AUTH, so it must always be prefixed with its size, even when it is empty.
+		if !bytes.Equal(c.Params, expectedParams) {
+			t.Errorf("Unexpected command parameters: %x", c.Params)
+		}
+		return err
+	}
+
+	if err := run(t, Auth("foo"), nil, []byte{0x00, 0x03, 'f', 'o', 'o'}); err != nil {
+		t.Fatalf("HierarchyChangeAuth failed: %v", err)
+	}
+
+	// The TPM rejects this command, so the cached authorization value must not change.
+	err := run(t, Auth("bar"), []byte("foo"), []byte{0x00, 0x03, 'b', 'a', 'r'})
+	if !IsTPMSessionError(err, ErrorAuthFail, CommandHierarchyChangeAuth, 1) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := run(t, nil, []byte("foo"), []byte{0x00, 0x00}); err != nil {
+		t.Fatalf("HierarchyChangeAuth failed: %v", err)
+	}
+}
+
+func TestClearMock(t *testing.T) {
+	okRsp := makeMockResponse(Success, nil, nil, mockPasswordAuth)
+	disabledRsp := makeMockResponse(ResponseCode(0x120), nil, nil)
+
+	var responses []byte
+	for _, r := range [][]byte{disabledRsp, okRsp, okRsp} {
+		responses = append(responses, r...)
+	}
+	tcti := &mockTcti{responses: bytes.NewReader(responses)}
+	tpm, _ := NewTPMContext(tcti)
+
+	lockout := tpm.LockoutHandleContext()
+	lockout.SetAuthValue([]byte("foo"))
+	defer lockout.SetAuthValue(nil)
+
+	checkPassword := func(t *testing.T, expected []byte) {
+		c := decodeMockCommand(t, tcti.commands.Bytes(), 1)
+		if len(c.Auths) != 1 {
+			t.Fatalf("Unexpected number of command authorizations: %d", len(c.Auths))
+		}
+		if !bytes.Equal(c.Auths[0].HMAC, expected) {
+			t.Errorf("Unexpected password: %x", c.Auths[0].HMAC)
+		}
+		tcti.commands.Reset()
+	}
+
+	// The TPM rejects this command, so the cached authorization value must not be cleared.
+	if err := tpm.Clear(lockout, nil); !IsTPMError(err, ErrorDisabled, CommandClear) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	checkPassword(t, []byte("foo"))
+
+	if err := tpm.Clear(lockout, nil); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	checkPassword(t, []byte("foo"))
+
+	if err := tpm.ClearControl(lockout, true, nil); err != nil {
+		t.Fatalf("ClearControl failed: %v", err)
+	}
+	if cmd := tcti.commands.Bytes(); cmd[len(cmd)-1] != 0x01 {
+		t.Errorf("Unexpected disable parameter: %x", cmd[len(cmd)-1])
+	}
+	checkPassword(t, nil)
+}
+
+func TestClearInvalidatesResourceContexts(t *testing.T) {
+	pub := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	pub.Unique = PublicIDU{Data: make(Digest, 32)}
+	name, err := pub.Name()
+	if err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+
+	respond := func(cmd []byte) []byte {
+		switch c := decodeMockCommand(t, cmd, 1); c.Code {
+		case CommandReadPublic:
+			pubBytes, _ := mu.MarshalToBytes(pub)
+			params, _ := mu.MarshalToBytes(uint16(len(pubBytes)), mu.RawBytes(pubBytes), name, Name(nil))
+			return makeMockResponse(Success, nil, params)
+		case CommandClear:
+			return makeMockResponse(Success, nil, nil, mockPasswordAuth)
+		default:
+			t.Fatalf("Unexpected command: %v", c.Code)
+		}
+		return nil
+	}
+	tcti := &mockTcti{respond: respond}
+	tpm, _ := NewTPMContext(tcti)
+
+	transient, err := tpm.CreateResourceContextFromTPM(0x80000001)
+	if err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+	platform, err := tpm.CreateResourceContextFromTPM(0x81800001)
+	if err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+
+	if err := tpm.Clear(tpm.LockoutHandleContext(), nil); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+
+	// The transient object has been removed by TPM2_Clear, so using its context shouldn't submit a command.
+	tcti.commands.Reset()
+	if _, _, _, err := tpm.ReadPublic(transient); !xerrors.Is(err, ErrResourceDoesNotExist) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.commands.Len() != 0 {
+		t.Errorf("No command should have been sent to the TPM")
+	}
+
+	// Persistent objects in the platform hierarchy aren't removed by TPM2_Clear.
+	if platform.Handle() != 0x81800001 {
+		t.Errorf("Context for platform persistent object was invalidated")
+	}
+	if _, _, _, err := tpm.ReadPublic(platform); err != nil {
+		t.Errorf("ReadPublic failed: %v", err)
+	}
+}
+
+func TestHierarchyControlAuthTypeMock(t *testing.T) {
+	// TPM_RC_AUTH_TYPE
+	rsp := makeMockResponse(ResponseCode(0x124), nil, nil)
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+	err := tpm.HierarchyControl(tpm.EndorsementHandleContext(), HandleOwner, false, nil)
+	if !IsTPMError(err, ErrorAuthType, CommandHierarchyControl) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -77,6 +77,8 @@ import (
 //
 // If there is insufficient space for the index, a *TPMError error with an error code of ErrorNVSpace will be returned.
 //
+// If the Index field of publicInfo is not a NV index handle, an error will be returned without executing the command.
+//
 // On successful completion, the NV index will be defined and a ResourceContext corresponding to the new NV index will be returned.
 // It will not be necessary to call ResourceContext.SetAuthValue on the returned ResourceContext - this function sets the correct
 // authorization value so that it can be used in subsequent commands that require knowledge of it.
@@ -84,6 +86,9 @@ func (t *TPMContext) NVDefineSpace(authContext ResourceContext, auth Auth, publi
 	if publicInfo == nil {
 		return nil, makeInvalidArgError("publicInfo", "nil value")
 	}
+	if publicInfo.Index.Type() != HandleTypeNVIndex {
+		return nil, makeInvalidArgError("publicInfo", fmt.Sprintf("handle 0x%08x is not a NV index handle", publicInfo.Index))
+	}
 	name, err := publicInfo.Name()
 	if err != nil {
 		return nil, fmt.Errorf("cannot compute name from public info: %v", err)
@@ -121,6 +126,7 @@ func (t *TPMContext) NVUndefineSpace(authContext, nvIndex ResourceContext, authC
 		return err
 	}
 
+	t.ForgetHandle(nvIndex.Handle())
 	nvIndex.(handleContextPrivate).invalidate()
 	return nil
 }
@@ -162,11 +168,17 @@ func (t *TPMContext) NVUndefineSpaceSpecial(nvIndex, platform ResourceContext, n
 		return err
 	}
 
+	t.ForgetHandle(nvIndex.Handle())
 	nvIndex.(handleContextPrivate).invalidate()
 	return nil
 }
 
 // NVReadPublic executes the TPM2_NV_ReadPublic command to read the public area of the NV index associated with nvIndex.
+//
+// The name of an NV index changes when the AttrNVWritten, AttrNVWriteLocked or AttrNVReadLocked attributes change. Most functions
+// in this package that cause these to change update nvIndex accordingly, but some events (such as TPMContext.NVGlobalWriteLock or
+// a TPM reset or restart) can leave nvIndex with an incorrect name. On success, if the returned public area differs from the one
+// associated with nvIndex only by these attributes, nvIndex is updated so that ResourceContext.Name returns the current name.
 func (t *TPMContext) NVReadPublic(nvIndex ResourceContext, sessions ...SessionContext) (*NVPublic, Name, error) {
 	var nvPublic nvPublicSized
 	var nvName Name
@@ -177,6 +189,11 @@ func (t *TPMContext) NVReadPublic(nvIndex ResourceContext, sessions ...SessionCo
 		&nvPublic, &nvName); err != nil {
 		return nil, nil, err
 	}
+
+	if rc, isNV := unwrapHandleContext(nvIndex).(*nvIndexContext); isNV {
+		rc.refreshAttrs(nvPublic.Ptr, nvName)
+	}
+
 	return nvPublic.Ptr, nvName, nil
 }
 
@@ -215,7 +232,7 @@ func (t *TPMContext) NVWriteRaw(authContext, nvIndex ResourceContext, data MaxNV
 		return err
 	}
 
-	nvIndex.(*nvIndexContext).setAttr(AttrNVWritten)
+	unwrapHandleContext(nvIndex).(*nvIndexContext).setAttr(AttrNVWritten)
 	return nil
 }
 
@@ -256,6 +273,9 @@ func (t *TPMContext) NVWrite(authContext, nvIndex ResourceContext, data []byte,
 		return err
 	}
 
+	if w, isWrapped := authContext.(*resourceContextWithAuth); isWrapped && authContextAuthSession == nil {
+		authContextAuthSession = w.session
+	}
 	if authContextAuthSession != nil {
 		if authContextAuthSession.(*sessionContext).attrs&AttrContinueSession == 0 {
 			return makeInvalidArgError("authContextAuthSession", "the AttrContinueSession attribute is required for authorization sessions")
@@ -310,7 +330,7 @@ func (t *TPMContext) NVWrite(authContext, nvIndex ResourceContext, data []byte,
 //
 // On successful completion, the AttrNVWritten flag will be set if this is the first time that the index has been written to.
 func (t *TPMContext) NVSetPinCounterParams(authContext, nvIndex ResourceContext, params *NVPinCounterParams, authContextAuthSession SessionContext, sessions ...SessionContext) error {
-	context, isNv := nvIndex.(*nvIndexContext)
+	context, isNv := unwrapHandleContext(nvIndex).(*nvIndexContext)
 	if !isNv {
 		return errors.New("nvIndex does not correspond to a NV index")
 	}
@@ -341,17 +361,24 @@ func (t *TPMContext) NVSetPinCounterParams(authContext, nvIndex ResourceContext,
 //
 // If the index has the AttrNVWriteLocked attribute set, a *TPMError error with an error code of ErrorNVLocked will be returned.
 //
-// If the type of the index is not NVTypeCounter, a *TPMHandleError error with an error code of ErrorAttributes will be returned for
-// handle index 2.
+// If nvIndex does not correspond to an index of type NVTypeCounter, an error will be returned without executing the command.
 //
 // On successful completion, the AttrNVWritten flag will be set if this is the first time that the index has been written to.
 func (t *TPMContext) NVIncrement(authContext, nvIndex ResourceContext, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	context, isNv := unwrapHandleContext(nvIndex).(*nvIndexContext)
+	if !isNv {
+		return errors.New("nvIndex does not correspond to a NV index")
+	}
+	if context.attrs().Type() != NVTypeCounter {
+		return errors.New("nvIndex does not correspond to a counter")
+	}
+
 	if err := t.RunCommand(CommandNVIncrement, sessions,
 		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, nvIndex); err != nil {
 		return err
 	}
 
-	nvIndex.(*nvIndexContext).setAttr(AttrNVWritten)
+	context.setAttr(AttrNVWritten)
 	return nil
 }
 
@@ -373,18 +400,25 @@ func (t *TPMContext) NVIncrement(authContext, nvIndex ResourceContext, authConte
 //
 // If the index has the AttrNVWriteLocked attribute set, a *TPMError error with an error code of ErrorNVLocked will be returned.
 //
-// If the type of the index is not NVTypeExtend, a *TPMHandleError error with an error code of ErrorAttributes will be returned for
-// handle index 2.
+// If nvIndex does not correspond to an index of type NVTypeExtend, an error will be returned without executing the command.
 //
 // On successful completion, the AttrNVWritten flag will be set if this is the first time that the index has been written to.
 func (t *TPMContext) NVExtend(authContext, nvIndex ResourceContext, data MaxNVBuffer, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	context, isNv := unwrapHandleContext(nvIndex).(*nvIndexContext)
+	if !isNv {
+		return errors.New("nvIndex does not correspond to a NV index")
+	}
+	if context.attrs().Type() != NVTypeExtend {
+		return errors.New("nvIndex does not correspond to an extend index")
+	}
+
 	if err := t.RunCommand(CommandNVExtend, sessions,
 		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, nvIndex, Delimiter,
 		data); err != nil {
 		return err
 	}
 
-	nvIndex.(*nvIndexContext).setAttr(AttrNVWritten)
+	context.setAttr(AttrNVWritten)
 	return nil
 }
 
@@ -405,18 +439,25 @@ func (t *TPMContext) NVExtend(authContext, nvIndex ResourceContext, data MaxNVBu
 //
 // If the index has the AttrNVWriteLocked attribute set, a *TPMError error with an error code of ErrorNVLocked will be returned.
 //
-// If the type of the index is not NVTypeBits, a *TPMHandleError error with an error code of ErrorAttributes will be returned for
-// handle index 2.
+// If nvIndex does not correspond to an index of type NVTypeBits, an error will be returned without executing the command.
 //
 // On successful completion, the AttrNVWritten flag will be set if this is the first time that the index has been written to.
 func (t *TPMContext) NVSetBits(authContext, nvIndex ResourceContext, bits uint64, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	context, isNv := unwrapHandleContext(nvIndex).(*nvIndexContext)
+	if !isNv {
+		return errors.New("nvIndex does not correspond to a NV index")
+	}
+	if context.attrs().Type() != NVTypeBits {
+		return errors.New("nvIndex does not correspond to a bit field index")
+	}
+
 	if err := t.RunCommand(CommandNVSetBits, sessions,
 		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, nvIndex, Delimiter,
 		bits); err != nil {
 		return err
 	}
 
-	nvIndex.(*nvIndexContext).setAttr(AttrNVWritten)
+	context.setAttr(AttrNVWritten)
 	return nil
 }
 
@@ -446,7 +487,7 @@ func (t *TPMContext) NVWriteLock(authContext, nvIndex ResourceContext, authConte
 		return err
 	}
 
-	nvIndex.(*nvIndexContext).setAttr(AttrNVWriteLocked)
+	unwrapHandleContext(nvIndex).(*nvIndexContext).setAttr(AttrNVWriteLocked)
 	return nil
 }
 
@@ -458,8 +499,8 @@ func (t *TPMContext) NVWriteLock(authContext, nvIndex ResourceContext, authConte
 //
 // On successful completion, the AttrNVWriteLocked attribute will be set for all NV indexes that have the AttrNVGlobalLock attribute
 // set. If an index also has the AttrNVWriteDefine attribute set, this will permanently inhibit further writes unless AttrNVWritten
-// is clear. ResourceContext instances associated with NV indices that are updated as a consequence of this function will no longer
-// be able to be used because the name will be incorrect.
+// is clear. ResourceContext instances associated with NV indices that are updated as a consequence of this function will have an
+// incorrect name until they are refreshed with TPMContext.NVReadPublic.
 func (t *TPMContext) NVGlobalWriteLock(authContext ResourceContext, authContextAuthSession SessionContext, sessions ...SessionContext) error {
 	return t.RunCommand(CommandNVGlobalWriteLock, sessions,
 		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession})
@@ -599,7 +640,7 @@ func (t *TPMContext) NVRead(authContext, nvIndex ResourceContext, size, offset u
 //
 // On successful completion, the current counter value will be returned.
 func (t *TPMContext) NVReadCounter(authContext, nvIndex ResourceContext, authContextAuthSession SessionContext, sessions ...SessionContext) (uint64, error) {
-	context, isNv := nvIndex.(*nvIndexContext)
+	context, isNv := unwrapHandleContext(nvIndex).(*nvIndexContext)
 	if !isNv {
 		return 0, errors.New("nvIndex does not correspond to a NV index")
 	}
@@ -639,7 +680,7 @@ func (t *TPMContext) NVReadCounter(authContext, nvIndex ResourceContext, authCon
 //
 // On successful completion, the current PIN count and limit will be returned.
 func (t *TPMContext) NVReadPinCounterParams(authContext, nvIndex ResourceContext, authContextAuthSession SessionContext, sessions ...SessionContext) (*NVPinCounterParams, error) {
-	context, isNv := nvIndex.(*nvIndexContext)
+	context, isNv := unwrapHandleContext(nvIndex).(*nvIndexContext)
 	if !isNv {
 		return nil, errors.New("nvIndex does not correspond to a NV index")
 	}
@@ -683,7 +724,7 @@ func (t *TPMContext) NVReadLock(authContext, nvIndex ResourceContext, authContex
 		return err
 	}
 
-	nvIndex.(*nvIndexContext).setAttr(AttrNVReadLocked)
+	unwrapHandleContext(nvIndex).(*nvIndexContext).setAttr(AttrNVReadLocked)
 	return nil
 }
 
@@ -720,7 +761,63 @@ func (t *TPMContext) NVChangeAuth(nvIndex ResourceContext, newAuth Auth, nvIndex
 	return t.processResponse(ctx, nil, nil)
 }
 
-// func (t *TPMContext) NVCertify(signContext, authContext, nvIndex HandleContext, qualifyingData Data,
-//	inScheme *SigScheme, size, offset uint16, signContextAuth, authContextAuth interface{},
-//	sessions ...SessionContext) (AttestRaw, *Signature, error) {
-// }
+// NVCertify executes the TPM2_NV_Certify command, which is used to prove the contents of the NV index associated with nvIndex. The
+// size and offset arguments select the data that is included in the attestation.
+//
+// The command requires authorization to read the index, defined by the state of the AttrNVPPRead, AttrNVOwnerRead, AttrNVAuthRead
+// and AttrNVPolicyRead attributes. The handle used for authorization is specified via authContext. If the NV index has the
+// AttrNVPPRead attribute, authorization can be satisfied with HandlePlatform. If the NV index has the AttrNVOwnerRead attribute,
+// authorization can be satisfied with HandleOwner. If the NV index has the AttrNVAuthRead or AttrNVPolicyRead attribute,
+// authorization can be satisfied with nvIndex. The command requires authorization with the user auth role for authContext, with
+// session based authorization provided via authContextAuthSession. If the resource associated with authContext is not permitted to
+// authorize this access, a *TPMError error with an error code of ErrorNVAuthorization will be returned.
+//
+// If signContext is not nil, the returned attestation will be signed by the key associated with it. This command requires
+// authorization with the user auth role for signContext, with session based authorization provided via signContextAuthSession.
+//
+// If signContext is not nil and the object associated with signContext is not a signing key, a *TPMHandleError error with an error
+// code of ErrorKey will be returned for handle index 1.
+//
+// If signContext is not nil and if the scheme of the key associated with signContext is AsymSchemeNull, then inScheme must be
+// provided to specify a valid signing scheme for the key. If it isn't, a *TPMParameterError error with an error code of ErrorScheme
+// will be returned for parameter index 2.
+//
+// If nvIndex does not correspond to a NV index, or the data selection defined by size and offset falls outside of the bounds of
+// the index, an error will be returned without executing the command.
+//
+// If the index has the AttrNVReadLocked attribute set, a *TPMError error with an error code of ErrorNVLocked will be returned.
+//
+// If the index has not been initialized (ie, the AttrNVWritten attribute is not set), a *TPMError error with an error code of
+// ErrorNVUninitialized will be returned.
+//
+// On success, it returns an attestation structure of type TagAttestNV, which contains the name of the index and the selected data.
+// This can be obtained from the Attested.NV field of the decoded attestation. If signContext is not nil, the attestation structure
+// will be signed by the associated key and returned too.
+func (t *TPMContext) NVCertify(signContext, authContext, nvIndex ResourceContext, qualifyingData Data, inScheme *SigScheme, size, offset uint16, signContextAuthSession, authContextAuthSession SessionContext, sessions ...SessionContext) (AttestRaw, *Signature, error) {
+	context, isNv := unwrapHandleContext(nvIndex).(*nvIndexContext)
+	if !isNv {
+		return nil, nil, makeInvalidArgError("nvIndex", "resource context is not a NV index")
+	}
+	if uint32(offset)+uint32(size) > uint32(context.size()) {
+		return nil, nil, makeInvalidArgError("size", fmt.Sprintf("data selection of %d bytes at offset %d is outside of the bounds of "+
+			"the index (%d bytes)", size, offset, context.size()))
+	}
+
+	if inScheme == nil {
+		inScheme = &SigScheme{Scheme: SigSchemeAlgNull}
+	}
+
+	var certifyInfo AttestRaw
+	var signature Signature
+
+	if err := t.RunCommand(CommandNVCertify, sessions,
+		ResourceContextWithSession{Context: signContext, Session: signContextAuthSession},
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, nvIndex, Delimiter,
+		qualifyingData, inScheme, size, offset, Delimiter,
+		Delimiter,
+		&certifyInfo, &signature); err != nil {
+		return nil, nil, err
+	}
+
+	return certifyInfo, &signature, nil
+}
@@ -9,9 +9,11 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"reflect"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestNVDefineAndUndefineSpace(t *testing.T) {
@@ -173,6 +175,13 @@ func TestNVUndefineSpaceSpecial(t *testing.T) {
 		if err := tpm.NVUndefineSpaceSpecial(context, platform, sessionContext, platformAuthSession); err != nil {
 			t.Errorf("NVUndefineSpaceSpecial failed: %v", err)
 		}
+
+		if context.Handle() != HandleUnassigned {
+			t.Errorf("Context should have been invalidated")
+		}
+		if _, err := tpm.CreateResourceContextFromTPM(0x0141ffff); !IsResourceUnavailableError(err, 0x0141ffff) {
+			t.Errorf("NV index should have been removed (err: %v)", err)
+		}
 	}
 
 	t.Run("NoAuth", func(t *testing.T) {
@@ -832,10 +841,13 @@ func TestNVGlobalLock(t *testing.T) {
 		}
 
 		for _, rc := range rcs {
-			pub, _, err := tpm.NVReadPublic(rc)
+			pub, name, err := tpm.NVReadPublic(rc)
 			if err != nil {
 				t.Fatalf("NVReadPublic failed: %v", err)
 			}
+			if !bytes.Equal(name, rc.Name()) {
+				t.Errorf("Name of NV resource context should have been refreshed")
+			}
 
 			if pub.Attrs&AttrNVGlobalLock > 0 && pub.Attrs&AttrNVWriteLocked == 0 {
 				t.Errorf("NV index with TPMA_NV_GLOBALLOCK set wasn't write locked")
@@ -964,3 +976,401 @@ func TestNVChangeAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestNVDefineSpaceMock(t *testing.T) {
+	pub := NVPublic{
+		Index:   0x018100ff,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthRead | AttrNVAuthWrite),
+		Size:    8}
+	name, err := pub.Name()
+	if err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+
+	t.Run("Good", func(t *testing.T) {
+		rsp := makeMockResponse(Success, nil, nil, mockPasswordAuth)
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		rc, err := tpm.NVDefineSpace(tpm.OwnerHandleContext(), nil, &pub, nil)
+		if err != nil {
+			t.Fatalf("NVDefineSpace failed: %v", err)
+		}
+		if rc.Handle() != pub.Index {
+			t.Errorf("NVDefineSpace returned the wrong handle: %v", rc.Handle())
+		}
+		if !bytes.Equal(rc.Name(), name) {
+			t.Errorf("NVDefineSpace returned the wrong name: %x", rc.Name())
+		}
+	})
+
+	t.Run("Defined", func(t *testing.T) {
+		rsp := makeMockResponse(ResponseCode(0x14c), nil, nil)
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		_, err := tpm.NVDefineSpace(tpm.OwnerHandleContext(), nil, &pub, nil)
+		if !IsTPMError(err, ErrorNVDefined, CommandNVDefineSpace) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("InvalidIndex", func(t *testing.T) {
+		tcti := &mockTcti{}
+		tpm, _ := NewTPMContext(tcti)
+
+		badPub := pub
+		badPub.Index = 0x81000001
+		_, err := tpm.NVDefineSpace(tpm.OwnerHandleContext(), nil, &badPub, nil)
+		if err == nil {
+			t.Fatalf("NVDefineSpace should have failed")
+		}
+		if err.Error() != "invalid publicInfo argument: handle 0x81000001 is not a NV index handle" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if tcti.commands.Len() != 0 {
+			t.Errorf("NVDefineSpace shouldn't have sent a command to the TPM")
+		}
+	})
+}
+
+func TestNVReadWriteChunkingMock(t *testing.T) {
+	pub := NVPublic{
+		Index:   0x018100ff,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthRead | AttrNVAuthWrite),
+		Size:    10}
+	rc, err := CreateNVIndexResourceContextFromPublic(&pub)
+	if err != nil {
+		t.Fatalf("CreateNVIndexResourceContextFromPublic failed: %v", err)
+	}
+	initialName := rc.Name()
+
+	// Emulate a TPM with a maximum NV buffer size of 4 bytes.
+	var contents [10]byte
+	var nWrites, nReads int
+	respond := func(cmd []byte) []byte {
+		var commandCode CommandCode
+		if _, err := mu.UnmarshalFromBytes(cmd[6:], &commandCode); err != nil {
+			t.Fatalf("Cannot unmarshal command code: %v", err)
+		}
+
+		if commandCode == CommandGetCapability {
+			return makeGetCapabilityResponseForTesting(t, false, &CapabilityData{
+				Capability: CapabilityTPMProperties,
+				Data:       CapabilitiesU{Data: TaggedTPMPropertyList{{Property: PropertyNVBufferMax, Value: 4}}}})
+		}
+
+		cpBytes := decodeMockCommand(t, cmd, 2).Params
+
+		var rpBytes []byte
+		switch commandCode {
+		case CommandNVWrite:
+			var data MaxNVBuffer
+			var offset uint16
+			if _, err := mu.UnmarshalFromBytes(cpBytes, &data, &offset); err != nil {
+				t.Fatalf("Cannot unmarshal NVWrite parameters: %v", err)
+			}
+			if len(data) > 4 {
+				t.Errorf("NVWrite sent too much data (%d bytes)", len(data))
+			}
+			copy(contents[offset:], data)
+			nWrites++
+		case CommandNVRead:
+			var size, offset uint16
+			if _, err := mu.UnmarshalFromBytes(cpBytes, &size, &offset); err != nil {
+				t.Fatalf("Cannot unmarshal NVRead parameters: %v", err)
+			}
+			if size > 4 {
+				t.Errorf("NVRead requested too much data (%d bytes)", size)
+			}
+			rpBytes, _ = mu.MarshalToBytes(MaxNVBuffer(contents[offset : offset+size]))
+			nReads++
+		default:
+			t.Fatalf("Unexpected command: %v", commandCode)
+		}
+
+		return makeMockResponse(Success, nil, rpBytes, mockPasswordAuth)
+	}
+	tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+
+	data := []byte("0123456789")
+	if err := tpm.NVWrite(rc, rc, data, 0, nil); err != nil {
+		t.Fatalf("NVWrite failed: %v", err)
+	}
+	if nWrites != 3 {
+		t.Errorf("Unexpected number of NVWrite commands: %d", nWrites)
+	}
+	if !bytes.Equal(contents[:], data) {
+		t.Errorf("Unexpected index contents: %x", contents)
+	}
+
+	// The name of the index changes once it has been written.
+	pub.Attrs |= AttrNVWritten
+	expectedName, _ := pub.Name()
+	if bytes.Equal(rc.Name(), initialName) || !bytes.Equal(rc.Name(), expectedName) {
+		t.Errorf("NVWrite didn't update the name of the index")
+	}
+
+	out, err := tpm.NVRead(rc, rc, 9, 1, nil)
+	if err != nil {
+		t.Fatalf("NVRead failed: %v", err)
+	}
+	if nReads != 3 {
+		t.Errorf("Unexpected number of NVRead commands: %d", nReads)
+	}
+	if !bytes.Equal(out, data[1:]) {
+		t.Errorf("NVRead returned the wrong data: %x", out)
+	}
+}
+
+type nvPublicSized struct {
+	Ptr *NVPublic `tpm2:"sized"`
+}
+
+func TestNVNameAfterFirstWriteMock(t *testing.T) {
+	pub := NVPublic{
+		Index:   0x018100ff,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthRead | AttrNVAuthWrite),
+		Size:    8}
+
+	// Emulate a TPM that sets TPMA_NV_WRITTEN on the index when it is written.
+	tpmPub := pub
+	respond := func(cmd []byte) []byte {
+		var commandCode CommandCode
+		if _, err := mu.UnmarshalFromBytes(cmd[6:], &commandCode); err != nil {
+			t.Fatalf("Cannot unmarshal command code: %v", err)
+		}
+
+		switch commandCode {
+		case CommandGetCapability:
+			return makeGetCapabilityResponseForTesting(t, false, &CapabilityData{
+				Capability: CapabilityTPMProperties,
+				Data:       CapabilitiesU{Data: TaggedTPMPropertyList{{Property: PropertyNVBufferMax, Value: 1024}}}})
+		case CommandNVDefineSpace:
+			return makeMockResponse(Success, nil, nil, mockPasswordAuth)
+		case CommandNVWrite:
+			tpmPub.Attrs |= AttrNVWritten
+			return makeMockResponse(Success, nil, nil, mockPasswordAuth)
+		case CommandNVReadPublic:
+			name, _ := tpmPub.Name()
+			rpBytes, _ := mu.MarshalToBytes(nvPublicSized{&tpmPub}, name)
+			return makeMockResponse(Success, nil, rpBytes)
+		default:
+			t.Fatalf("Unexpected command: %v", commandCode)
+		}
+		return nil
+	}
+	tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+
+	rc, err := tpm.NVDefineSpace(tpm.OwnerHandleContext(), nil, &pub, nil)
+	if err != nil {
+		t.Fatalf("NVDefineSpace failed: %v", err)
+	}
+	initialName := rc.Name()
+
+	if err := tpm.NVWrite(rc, rc, []byte("foo"), 0, nil); err != nil {
+		t.Fatalf("NVWrite failed: %v", err)
+	}
+	if bytes.Equal(rc.Name(), initialName) {
+		t.Errorf("The name of the index should have changed after the first write")
+	}
+
+	_, name, err := tpm.NVReadPublic(rc)
+	if err != nil {
+		t.Fatalf("NVReadPublic failed: %v", err)
+	}
+	if !bytes.Equal(rc.Name(), name) {
+		t.Errorf("The name of the index doesn't match the one returned from NVReadPublic (got %x, expected %x)", rc.Name(), name)
+	}
+}
+
+func TestNVCommandsWrongIndexType(t *testing.T) {
+	pub := NVPublic{
+		Index:   0x018100ff,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthRead | AttrNVAuthWrite),
+		Size:    8}
+	rc, err := CreateNVIndexResourceContextFromPublic(&pub)
+	if err != nil {
+		t.Fatalf("CreateNVIndexResourceContextFromPublic failed: %v", err)
+	}
+
+	tcti := &mockTcti{}
+	tpm, _ := NewTPMContext(tcti)
+
+	for _, data := range []struct {
+		desc string
+		fn   func() error
+		err  string
+	}{
+		{
+			desc: "NVIncrement",
+			fn:   func() error { return tpm.NVIncrement(rc, rc, nil) },
+			err:  "nvIndex does not correspond to a counter",
+		},
+		{
+			desc: "NVExtend",
+			fn:   func() error { return tpm.NVExtend(rc, rc, []byte("foo"), nil) },
+			err:  "nvIndex does not correspond to an extend index",
+		},
+		{
+			desc: "NVSetBits",
+			fn:   func() error { return tpm.NVSetBits(rc, rc, 1, nil) },
+			err:  "nvIndex does not correspond to a bit field index",
+		},
+		{
+			desc: "NotNVIndex",
+			fn:   func() error { return tpm.NVIncrement(tpm.OwnerHandleContext(), tpm.OwnerHandleContext(), nil) },
+			err:  "nvIndex does not correspond to a NV index",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			err := data.fn()
+			if err == nil {
+				t.Fatalf("Command should have failed")
+			}
+			if err.Error() != data.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tcti.commands.Len() != 0 {
+				t.Errorf("No command should have been sent to the TPM")
+			}
+		})
+	}
+}
+
+func TestNVCertifyMock(t *testing.T) {
+	pub := NVPublic{
+		Index:   0x018100ff,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeCounter.WithAttrs(AttrNVAuthRead | AttrNVAuthWrite | AttrNVWritten),
+		Size:    8}
+	nvIndex, err := CreateNVIndexResourceContextFromPublic(&pub)
+	if err != nil {
+		t.Fatalf("CreateNVIndexResourceContextFromPublic failed: %v", err)
+	}
+
+	attestRaw, _ := mu.MarshalToBytes(Attest{
+		Magic:     TPMGeneratedValue,
+		Type:      TagAttestNV,
+		ExtraData: Data("foo"),
+		ClockInfo: ClockInfo{Clock: 1000, Safe: true},
+		Attested:  AttestU{Data: &NVCertifyInfo{IndexName: nvIndex.Name(), Offset: 0, NVContents: MaxNVBuffer{0, 0, 0, 0, 0, 0, 0, 5}}}})
+
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		// Respond with an empty password authorization for each session in the command.
+		c := decodeMockCommand(t, cmd, 3)
+		auths := make([]mockResponseAuth, len(c.Auths))
+		for i := range auths {
+			auths[i] = mockPasswordAuth
+		}
+		params, _ := mu.MarshalToBytes(AttestRaw(attestRaw), Signature{SigAlg: SigSchemeAlgNull})
+		return makeMockResponse(Success, nil, params, auths...)
+	}}
+	tpm, _ := NewTPMContext(tcti)
+
+	certifyInfo, signature, err := tpm.NVCertify(nil, nvIndex, nvIndex, Data("foo"), nil, 8, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("NVCertify failed: %v", err)
+	}
+	if signature.SigAlg != SigSchemeAlgNull {
+		t.Errorf("Unexpected signature algorithm: %v", signature.SigAlg)
+	}
+
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 3)
+	if !reflect.DeepEqual(c.Handles, []Handle{HandleNull, nvIndex.Handle(), nvIndex.Handle()}) {
+		t.Errorf("Unexpected handles: %v", c.Handles)
+	}
+	var qualifyingData Data
+	var scheme SigSchemeId
+	var size, offset uint16
+	if _, err := mu.UnmarshalFromBytes(c.Params, &qualifyingData, &scheme, &size, &offset); err != nil {
+		t.Fatalf("Cannot unmarshal command parameters: %v", err)
+	}
+	if !bytes.Equal(qualifyingData, Data("foo")) || scheme != SigSchemeAlgNull || size != 8 || offset != 0 {
+		t.Errorf("Unexpected command parameters")
+	}
+
+	attest, err := certifyInfo.Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if attest.Type != TagAttestNV {
+		t.Errorf("Unexpected attestation type: %v", attest.Type)
+	}
+	if !bytes.Equal(attest.Attested.NV().IndexName, nvIndex.Name()) {
+		t.Errorf("Unexpected index name")
+	}
+	if !bytes.Equal(attest.Attested.NV().NVContents, []byte{0, 0, 0, 0, 0, 0, 0, 5}) {
+		t.Errorf("Unexpected contents: %x", attest.Attested.NV().NVContents)
+	}
+}
+
+func TestNVCertifyOutOfBounds(t *testing.T) {
+	pub := NVPublic{
+		Index:   0x018100ff,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthRead | AttrNVAuthWrite | AttrNVWritten),
+		Size:    8}
+	nvIndex, err := CreateNVIndexResourceContextFromPublic(&pub)
+	if err != nil {
+		t.Fatalf("CreateNVIndexResourceContextFromPublic failed: %v", err)
+	}
+
+	tcti := &mockTcti{}
+	tpm, _ := NewTPMContext(tcti)
+
+	for _, data := range []struct {
+		desc         string
+		size, offset uint16
+		err          string
+	}{
+		{
+			desc: "Size",
+			size: 9,
+			err:  "invalid size argument: data selection of 9 bytes at offset 0 is outside of the bounds of the index (8 bytes)",
+		},
+		{
+			desc:   "Offset",
+			size:   4,
+			offset: 6,
+			err:    "invalid size argument: data selection of 4 bytes at offset 6 is outside of the bounds of the index (8 bytes)",
+		},
+		{
+			desc:   "Overflow",
+			size:   0xffff,
+			offset: 0xffff,
+			err: "invalid size argument: data selection of 65535 bytes at offset 65535 is outside of the bounds of the index " +
+				"(8 bytes)",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			_, _, err := tpm.NVCertify(nil, nvIndex, nvIndex, nil, nil, data.size, data.offset, nil, nil)
+			if err == nil || err.Error() != data.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tcti.commands.Len() != 0 {
+				t.Errorf("No command should have been sent to the TPM")
+			}
+		})
+	}
+}
+
+func TestNVCertifyNotNVIndex(t *testing.T) {
+	object, err := CreateObjectResourceContextFromPublic(0x80000001, NewRSAStorageKeyTemplate())
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	tcti := &mockTcti{}
+	tpm, _ := NewTPMContext(tcti)
+
+	_, _, err = tpm.NVCertify(nil, object, object, nil, nil, 0, 0, nil, nil)
+	if err == nil || err.Error() != "invalid nvIndex argument: resource context is not a NV index" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.commands.Len() != 0 {
+		t.Errorf("No command should have been sent to the TPM")
+	}
+}
@@ -7,6 +7,7 @@ package tpm2
 // Section 12 - Object Commands
 
 import (
+	"bytes"
 	"fmt"
 )
 
@@ -219,7 +220,8 @@ func (t *TPMContext) Load(parentContext ResourceContext, inPrivate Private, inPu
 // LoadExternal executes the TPM2_LoadExternal command in order to load an object that is not a protected object in to the TPM.
 // The object is specified by providing the inPrivate and inPublic arguments, although inPrivate is optional. If only the public
 // part is to be loaded, the hierarchy parameter must specify a hierarchy to associate the loaded object with so that tickets can
-// be created properly. If both the public and private parts are to be loaded, then hierarchy should be HandleNull.
+// be created properly. If both the public and private parts are to be loaded, then hierarchy should be HandleNull. If inPrivate is
+// nil, it is sent to the TPM as an empty TPM2B_SENSITIVE structure. If inPublic is nil, an error will be returned.
 //
 // If there are no available slots for new objects on the TPM, a *TPMWarning error with a warning code of WarningObjectMemory will
 // be returned.
@@ -274,10 +276,16 @@ func (t *TPMContext) Load(parentContext ResourceContext, inPrivate Private, inPu
 // If the digest in the Unique field of inPublic is inconsistent with the value of the sensitive data and the seed value, a
 // *TPMError with an error code of ErrorBinding will be returned.
 //
-// On success, a ResourceContext corresponding to the newly loaded transient object will be returned. If inPrivate has been provided,
-// it will not be necessary to call ResourceContext.SetAuthValue on it - this function sets the correct authorization value so that it
-// can be used in subsequent commands that require knowledge of the authorization value.
+// On success, a ResourceContext corresponding to the newly loaded transient object will be returned. The name of the returned
+// ResourceContext is computed from inPublic and checked against the name returned from the TPM, so it can be used in subsequent
+// commands that require the name of the object, such as TPMContext.PolicySigned. If inPrivate has been provided, it will not be
+// necessary to call ResourceContext.SetAuthValue on it - this function sets the correct authorization value so that it can be used
+// in subsequent commands that require knowledge of the authorization value.
 func (t *TPMContext) LoadExternal(inPrivate *Sensitive, inPublic *Public, hierarchy Handle, sessions ...SessionContext) (ResourceContext, error) {
+	if inPublic == nil {
+		return nil, makeInvalidArgError("inPublic", "nil value")
+	}
+
 	var objectHandle Handle
 	var name Name
 
@@ -293,7 +301,7 @@ func (t *TPMContext) LoadExternal(inPrivate *Sensitive, inPublic *Public, hierar
 		return nil, &InvalidResponseError{CommandLoadExternal,
 			fmt.Sprintf("handle 0x%08x returned from TPM is the wrong type", objectHandle)}
 	}
-	if inPublic == nil || !inPublic.compareName(name) {
+	if !inPublic.compareName(name) {
 		return nil, &InvalidResponseError{CommandLoadExternal, "name returned from TPM not consistent with loaded public area"}
 	}
 
@@ -310,7 +318,9 @@ func (t *TPMContext) LoadExternal(inPrivate *Sensitive, inPublic *Public, hierar
 //
 // If objectContext corresponds to a sequence object, a *TPMError with an error code of ErrorSequence will be returned.
 //
-// On success, the public part of the object is returned, along with the object's name and qualified name.
+// On success, the public part of the object is returned, along with the object's name and qualified name. The name returned from
+// the TPM is verified against a name computed from the returned public area, and a *InvalidResponseError will be returned if they
+// don't match.
 func (t *TPMContext) ReadPublic(objectContext ResourceContext, sessions ...SessionContext) (*Public, Name, Name, error) {
 	var outPublic publicSized
 	var name Name
@@ -322,6 +332,16 @@ func (t *TPMContext) ReadPublic(objectContext ResourceContext, sessions ...Sessi
 		&outPublic, &name, &qualifiedName); err != nil {
 		return nil, nil, nil, err
 	}
+
+	if outPublic.Ptr == nil {
+		return nil, nil, nil, &InvalidResponseError{CommandReadPublic, "no public area returned from TPM"}
+	}
+	if n, err := outPublic.Ptr.Name(); err != nil {
+		return nil, nil, nil, &InvalidResponseError{CommandReadPublic, fmt.Sprintf("cannot compute name of returned public area: %v", err)}
+	} else if !bytes.Equal(n, name) {
+		return nil, nil, nil, &InvalidResponseError{CommandReadPublic, "name and public area don't match"}
+	}
+
 	return outPublic.Ptr, name, qualifiedName, nil
 }
 
@@ -420,10 +440,19 @@ func (t *TPMContext) MakeCredential(context ResourceContext, credential Digest,
 //
 // If the type of object associated with itemContext is not ObjectTypeKeyedHash, a *TPMHandleError error with an error code of
 // ErrorType will be returned. If the object associated with itemContext has either the AttrDecrypt, AttrSign or AttrRestricted
-// attributes set, a *TPMHandlerError error with an error code of ErrorAttributes will be returned.
+// attributes set, a *TPMHandlerError error with an error code of ErrorAttributes will be returned. If the public area of the object
+// is known by itemContext, these conditions are checked before executing the command, and an error is returned without executing
+// the command if the object is not a sealed data object.
 //
 // On success, the object's sensitive data is returned in decrypted form.
 func (t *TPMContext) Unseal(itemContext ResourceContext, itemContextAuthSession SessionContext, sessions ...SessionContext) (SensitiveData, error) {
+	if o, isObject := unwrapHandleContext(itemContext).(*objectContext); isObject && o.public() != nil {
+		pub := o.public()
+		if pub.Type != ObjectTypeKeyedHash || pub.Attrs&(AttrDecrypt|AttrSign|AttrRestricted) != 0 {
+			return nil, makeInvalidArgError("itemContext", "object is not a sealed data object")
+		}
+	}
+
 	var outData SensitiveData
 
 	if err := t.RunCommand(CommandUnseal, sessions,
@@ -438,7 +467,7 @@ func (t *TPMContext) Unseal(itemContext ResourceContext, itemContextAuthSession
 }
 
 // ObjectChangeAuth executes the TPM2_ObjectChangeAuth to change the authorization value of the object associated with objectContext.
-// This command requires authorization with the admin role for objectContext, with sessio based authorization provided via
+// This command requires authorization with the admin role for objectContext, with session based authorization provided via
 // objectContextAuthSession.
 //
 // The new authorization value is provided via newAuth. The parentContext parameter must correspond to the parent object for
@@ -454,7 +483,9 @@ func (t *TPMContext) Unseal(itemContext ResourceContext, itemContextAuthSession
 // of ErrorType will be returned for handle index 2.
 //
 // On success, this returns a new private area for the object associated with objectContext. This function does not make any changes
-// to the version of the object that is currently loaded in to the TPM.
+// to the version of the object that is currently loaded in to the TPM, and objectContext is not modified - it continues to use the
+// original authorization value. In order to use the new authorization value, the returned private area must be loaded in to the TPM
+// with TPMContext.Load, and the new authorization value set on the returned ResourceContext with ResourceContext.SetAuthValue.
 func (t *TPMContext) ObjectChangeAuth(objectContext, parentContext ResourceContext, newAuth Auth, objectContextAuthSession SessionContext, sessions ...SessionContext) (Private, error) {
 	var outPrivate Private
 
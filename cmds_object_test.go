@@ -13,7 +13,10 @@ import (
 	"encoding/binary"
 	"testing"
 
+	"golang.org/x/xerrors"
+
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestCreate(t *testing.T) {
@@ -742,3 +745,276 @@ func TestActivateCredential(t *testing.T) {
 		run(t, ak, sessionContext)
 	})
 }
+
+func TestLoadMarshalling(t *testing.T) {
+	pub := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	pub.Unique = PublicIDU{Data: make(Digest, 32)}
+	name, err := pub.Name()
+	if err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+	priv := Private("private")
+
+	makeResponse := func(handle Handle, name Name) []byte {
+		params, _ := mu.MarshalToBytes(name)
+		return makeMockResponse(Success, []Handle{handle}, params, mockPasswordAuth)
+	}
+
+	t.Run("Good", func(t *testing.T) {
+		rsp := makeResponse(0x80000001, name)
+		flushRsp := makeMockResponse(Success, nil, nil)
+		rsp = append(rsp, flushRsp...)
+		tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+		tpm, _ := NewTPMContext(tcti)
+
+		object, err := tpm.Load(tpm.OwnerHandleContext(), priv, pub, nil)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if object.Handle() != 0x80000001 {
+			t.Errorf("Unexpected handle: %v", object.Handle())
+		}
+		if !bytes.Equal(object.Name(), name) {
+			t.Errorf("Unexpected name: %x", object.Name())
+		}
+
+		pubBytes, _ := mu.MarshalToBytes(pub)
+		expected, _ := mu.MarshalToBytes(priv, uint16(len(pubBytes)), mu.RawBytes(pubBytes))
+		if !bytes.HasSuffix(tcti.commands.Bytes(), expected) {
+			t.Errorf("Unexpected command parameters: %x", tcti.commands.Bytes())
+		}
+
+		if err := tpm.FlushContext(object); err != nil {
+			t.Fatalf("FlushContext failed: %v", err)
+		}
+		if object.Handle() != HandleUnassigned {
+			t.Errorf("FlushContext should have invalidated the context")
+		}
+	})
+
+	for _, data := range []struct {
+		desc   string
+		handle Handle
+		name   Name
+	}{
+		{desc: "WrongHandleType", handle: 0x81000001, name: name},
+		{desc: "WrongName", handle: 0x80000001, name: append(Name{}, name[:len(name)-1]...)},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(makeResponse(data.handle, data.name))})
+
+			_, err := tpm.Load(tpm.OwnerHandleContext(), priv, pub, nil)
+			if err == nil {
+				t.Fatalf("Load should have failed")
+			}
+			var e *InvalidResponseError
+			if !xerrors.As(err, &e) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadPublicVerifiesName(t *testing.T) {
+	pub := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	pub.Unique = PublicIDU{Data: make(Digest, 32)}
+	name, err := pub.Name()
+	if err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+
+	makeResponse := func(name Name) []byte {
+		pubBytes, _ := mu.MarshalToBytes(pub)
+		params, _ := mu.MarshalToBytes(uint16(len(pubBytes)), mu.RawBytes(pubBytes), name, Name(nil))
+		return makeMockResponse(Success, nil, params)
+	}
+
+	t.Run("Good", func(t *testing.T) {
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(makeResponse(name))})
+
+		rc, err := tpm.CreateResourceContextFromTPM(0x80000001)
+		if err != nil {
+			t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+		}
+		if !bytes.Equal(rc.Name(), name) {
+			t.Errorf("Unexpected name: %x", rc.Name())
+		}
+	})
+
+	t.Run("WrongName", func(t *testing.T) {
+		badName := append(Name{}, name...)
+		badName[len(badName)-1] ^= 0xff
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(makeResponse(badName))})
+
+		_, err := tpm.CreateResourceContextFromTPM(0x80000001)
+		if err == nil {
+			t.Fatalf("CreateResourceContextFromTPM should have failed")
+		}
+		var e *InvalidResponseError
+		if !xerrors.As(err, &e) || e.Command != CommandReadPublic {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
+func TestUnsealMock(t *testing.T) {
+	sealed := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrUserWithAuth,
+		Params:  PublicParamsU{Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+		Unique:  PublicIDU{Data: make(Digest, 32)}}
+
+	t.Run("Good", func(t *testing.T) {
+		item, err := CreateObjectResourceContextFromPublic(0x80000001, &sealed)
+		if err != nil {
+			t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+		}
+
+		params, _ := mu.MarshalToBytes(SensitiveData("secret"))
+		rsp := makeMockResponse(Success, nil, params, mockPasswordAuth)
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		data, err := tpm.Unseal(item, nil)
+		if err != nil {
+			t.Fatalf("Unseal failed: %v", err)
+		}
+		if !bytes.Equal(data, []byte("secret")) {
+			t.Errorf("Unseal returned the wrong data: %x", data)
+		}
+	})
+
+	symCipher := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	symCipher.Unique = PublicIDU{Data: make(Digest, 32)}
+
+	for _, data := range []struct {
+		desc   string
+		public *Public
+	}{
+		{desc: "SymCipher", public: symCipher},
+		{desc: "HMACKey", public: &Public{
+			Type:    ObjectTypeKeyedHash,
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   AttrFixedTPM | AttrFixedParent | AttrUserWithAuth | AttrSign,
+			Params:  PublicParamsU{Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+			Unique:  PublicIDU{Data: make(Digest, 32)}}},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			item, err := CreateObjectResourceContextFromPublic(0x80000001, data.public)
+			if err != nil {
+				t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+			}
+
+			tcti := &mockTcti{}
+			tpm, _ := NewTPMContext(tcti)
+
+			_, err = tpm.Unseal(item, nil)
+			if err == nil {
+				t.Fatalf("Unseal should have failed")
+			}
+			if err.Error() != "invalid itemContext argument: object is not a sealed data object" {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tcti.commands.Len() != 0 {
+				t.Errorf("Unseal shouldn't have sent a command to the TPM")
+			}
+		})
+	}
+}
+
+func TestObjectChangeAuthMock(t *testing.T) {
+	sealed := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrUserWithAuth,
+		Params:  PublicParamsU{Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+		Unique:  PublicIDU{Data: make(Digest, 32)}}
+	item, err := CreateObjectResourceContextFromPublic(0x80000001, &sealed)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+	item.SetAuthValue(Auth("old"))
+	parent, err := CreateObjectResourceContextFromPublic(0x80000002, NewRSAStorageKeyTemplate())
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	var rsp []byte
+	for _, p := range []interface{}{Private("newPrivate"), SensitiveData("secret")} {
+		params, _ := mu.MarshalToBytes(p)
+		r := makeMockResponse(Success, nil, params, mockPasswordAuth)
+		rsp = append(rsp, r...)
+	}
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	outPrivate, err := tpm.ObjectChangeAuth(item, parent, Auth("new"), nil)
+	if err != nil {
+		t.Fatalf("ObjectChangeAuth failed: %v", err)
+	}
+	if !bytes.Equal(outPrivate, []byte("newPrivate")) {
+		t.Errorf("Unexpected private area: %x", outPrivate)
+	}
+
+	// The existing context should continue to use the original authorization value.
+	if _, err := tpm.Unseal(item, nil); err != nil {
+		t.Fatalf("Unseal failed: %v", err)
+	}
+	cmds := tcti.commands.Bytes()
+	cmd := cmds[binary.BigEndian.Uint32(cmds[2:6]):]
+	var auth Auth
+	if _, err := mu.UnmarshalFromBytes(cmd[25:], &auth); err != nil {
+		t.Fatalf("Cannot unmarshal command: %v", err)
+	}
+	if !bytes.Equal(auth, []byte("old")) {
+		t.Errorf("Unexpected password authorization for Unseal: %q", auth)
+	}
+}
+
+func TestLoadExternalMock(t *testing.T) {
+	pub := &Public{
+		Type:    ObjectTypeECC,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+		Params: PublicParamsU{
+			Data: &ECCParams{
+				Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+				Scheme:    ECCScheme{Scheme: ECCSchemeNull},
+				CurveID:   ECCCurveNIST_P256,
+				KDF:       KDFScheme{Scheme: KDFAlgorithmNull}}},
+		Unique: PublicIDU{Data: &ECCPoint{X: elliptic.P256().Params().Gx.Bytes(), Y: elliptic.P256().Params().Gy.Bytes()}}}
+	name, err := pub.Name()
+	if err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+
+	params, _ := mu.MarshalToBytes(Handle(0x80000001), name)
+	rsp := makeMockResponse(Success, nil, params)
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	if _, err := tpm.LoadExternal(nil, nil, HandleOwner); err == nil || err.Error() != "invalid inPublic argument: nil value" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.commands.Len() != 0 {
+		t.Errorf("LoadExternal shouldn't have sent a command to the TPM")
+	}
+
+	object, err := tpm.LoadExternal(nil, pub, HandleOwner)
+	if err != nil {
+		t.Fatalf("LoadExternal failed: %v", err)
+	}
+	if object.Handle() != 0x80000001 {
+		t.Errorf("Unexpected handle: %v", object.Handle())
+	}
+	if !bytes.Equal(object.Name(), name) {
+		t.Errorf("Unexpected name: %x", object.Name())
+	}
+
+	// The sensitive area should be sent as an empty TPM2B_SENSITIVE.
+	pubBytes, _ := mu.MarshalToBytes(pub)
+	expected, _ := mu.MarshalToBytes(uint16(0), uint16(len(pubBytes)), mu.RawBytes(pubBytes), HandleOwner)
+	if !bytes.Equal(tcti.commands.Bytes()[10:], expected) {
+		t.Errorf("Unexpected command parameters: %x", tcti.commands.Bytes()[10:])
+	}
+}
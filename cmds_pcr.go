@@ -95,9 +95,23 @@ func CreatePCRValuesFromListAndSelection(pcrs PCRSelectionList, digests DigestLi
 // If pcrContext is nil, this function will do nothing. The command requires authorization with the user auth role for pcrContext,
 // with session based authorization provided via pcrContextAuthSession.
 //
+// The digests argument should contain one digest for each active PCR bank. If any digest has an algorithm that is not supported by
+// this package, or has a length that does not match the size of its algorithm, an error will be returned without executing the
+// command.
+//
 // If the PCR associated with pcrContext can not be extended from the current locality, a *TPMError error with an error code of
 // ErrorLocality will be returned.
 func (t *TPMContext) PCRExtend(pcrContext ResourceContext, digests TaggedHashList, pcrContextAuthSession SessionContext, sessions ...SessionContext) error {
+	for i, d := range digests {
+		if !d.HashAlg.Supported() {
+			return makeInvalidArgError("digests", fmt.Sprintf("unsupported digest algorithm %v at index %d", d.HashAlg, i))
+		}
+		if len(d.Digest) != d.HashAlg.Size() {
+			return makeInvalidArgError("digests", fmt.Sprintf("invalid digest size for algorithm %v at index %d (got %d, "+
+				"expected %d)", d.HashAlg, i, len(d.Digest), d.HashAlg.Size()))
+		}
+	}
+
 	return t.RunCommand(CommandPCRExtend, sessions,
 		ResourceContextWithSession{Context: pcrContext, Session: pcrContextAuthSession}, Delimiter,
 		digests)
@@ -130,7 +144,12 @@ func (t *TPMContext) PCREvent(pcrContext ResourceContext, eventData Event, pcrCo
 // re-execute the TPM2_PCR_Read command until all requested values have been read. As a consequence, any SessionContext instances
 // provided should have the AttrContinueSession attribute defined.
 //
-// On success, the current value of pcrUpdateCounter is returned, as well as the requested PCR values.
+// The TPM may return values for a subset of the requested PCRs in response to a single command, in which case the values returned
+// from each command are aggregated. If the pcrUpdateCounter value changes between commands, a *InvalidResponseError error will be
+// returned because the returned values may not be consistent.
+//
+// On success, the current value of pcrUpdateCounter is returned, as well as the requested PCR values keyed by algorithm and PCR
+// index.
 func (t *TPMContext) PCRRead(pcrSelectionIn PCRSelectionList, sessions ...SessionContext) (uint32, PCRValues, error) {
 	var remaining PCRSelectionList
 	for _, s := range pcrSelectionIn {
@@ -183,6 +202,34 @@ func (t *TPMContext) PCRRead(pcrSelectionIn PCRSelectionList, sessions ...Sessio
 	return pcrUpdateCounter, pcrValues, nil
 }
 
+// PCRAllocate executes the TPM2_PCR_Allocate command to set the desired PCR allocation for the TPM. The authContext parameter must
+// correspond to HandlePlatform. The command requires authorization with the user auth role for authContext, with session based
+// authorization provided via authContextAuthSession.
+//
+// The pcrAllocation parameter specifies the PCRs to allocate for each bank. Banks that aren't included in pcrAllocation retain their
+// current allocation. A bank can be deallocated by including a PCRSelection for it with an empty selection.
+//
+// The new allocation only takes effect after the next TPM reset (TPM2_Startup with StartupClear), so the values of the currently
+// allocated PCRs are not affected by this command. If this command is executed more than once before the next TPM reset, the
+// allocation from the most recent successful invocation is used.
+//
+// If the TPM cannot accommodate the requested allocation, the command still completes and allocationSuccess will be false. The maxPCR,
+// sizeNeeded and sizeAvailable values are returned in this case too, and indicate the maximum number of PCRs per bank, the number of
+// octets required for the requested allocation and the number of octets available for PCR banks respectively.
+//
+// If pcrAllocation contains a selection for an unsupported digest algorithm, a *TPMParameterError error with an error code of
+// ErrorHash may be returned for parameter index 1.
+func (t *TPMContext) PCRAllocate(authContext ResourceContext, pcrAllocation PCRSelectionList, authContextAuthSession SessionContext, sessions ...SessionContext) (allocationSuccess bool, maxPCR, sizeNeeded, sizeAvailable uint32, err error) {
+	if err := t.RunCommand(CommandPCRAllocate, sessions,
+		ResourceContextWithSession{Context: authContext, Session: authContextAuthSession}, Delimiter,
+		pcrAllocation, Delimiter,
+		Delimiter,
+		&allocationSuccess, &maxPCR, &sizeNeeded, &sizeAvailable); err != nil {
+		return false, 0, 0, 0, err
+	}
+	return allocationSuccess, maxPCR, sizeNeeded, sizeAvailable, nil
+}
+
 // PCRReset executes the TPM2_PCR_Reset command to reset the PCR associated with pcrContext in all banks. This command requires
 // authorization with the user auth role for pcrContext, with session based authorization provided via pcrContextAuthSession.
 //
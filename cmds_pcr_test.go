@@ -9,6 +9,9 @@ import (
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
 )
 
 func TestPCRExtend(t *testing.T) {
@@ -88,6 +91,45 @@ func TestPCRExtend(t *testing.T) {
 	}
 }
 
+func TestPCRExtendInvalidDigests(t *testing.T) {
+	// The mock transport has no responses, so any attempt to execute the command will fail with a different error.
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(nil)})
+
+	for _, data := range []struct {
+		desc    string
+		digests TaggedHashList
+		err     string
+	}{
+		{
+			desc:    "WrongSize",
+			digests: TaggedHashList{{HashAlg: HashAlgorithmSHA256, Digest: make(Digest, 20)}},
+			err:     "invalid digests argument: invalid digest size for algorithm TPM_ALG_SHA256 at index 0 (got 20, expected 32)",
+		},
+		{
+			desc: "WrongSizeSecondBank",
+			digests: TaggedHashList{
+				{HashAlg: HashAlgorithmSHA1, Digest: make(Digest, 20)},
+				{HashAlg: HashAlgorithmSHA256, Digest: make(Digest, 20)}},
+			err: "invalid digests argument: invalid digest size for algorithm TPM_ALG_SHA256 at index 1 (got 20, expected 32)",
+		},
+		{
+			desc:    "UnsupportedAlgorithm",
+			digests: TaggedHashList{{HashAlg: HashAlgorithmNull, Digest: nil}},
+			err:     "invalid digests argument: unsupported digest algorithm TPM_ALG_NULL at index 0",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			err := tpm.PCRExtend(tpm.PCRHandleContext(7), data.digests, nil)
+			if err == nil {
+				t.Fatalf("PCRExtend should have failed")
+			}
+			if err.Error() != data.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
 func TestPCREvent(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityPCRChange)
 	defer closeTPM(t, tpm)
@@ -332,6 +374,62 @@ func TestPCRRead(t *testing.T) {
 	})
 }
 
+func makePCRReadResponseForTesting(t *testing.T, pcrUpdateCounter uint32, pcrSelectionOut PCRSelectionList, values DigestList) []byte {
+	params, err := mu.MarshalToBytes(pcrUpdateCounter, pcrSelectionOut, values)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	return makeMockResponse(Success, nil, params)
+}
+
+func TestPCRReadPartialResponses(t *testing.T) {
+	sha1Digest := make(Digest, HashAlgorithmSHA1.Size())
+	sha1Digest[0] = 0x01
+	sha256Digest := make(Digest, HashAlgorithmSHA256.Size())
+	sha256Digest[0] = 0x02
+
+	selection := PCRSelectionList{
+		{Hash: HashAlgorithmSHA1, Select: []int{7}},
+		{Hash: HashAlgorithmSHA256, Select: []int{7}}}
+
+	t.Run("Aggregated", func(t *testing.T) {
+		var rsp []byte
+		rsp = append(rsp, makePCRReadResponseForTesting(t, 10, PCRSelectionList{{Hash: HashAlgorithmSHA1, Select: []int{7}}}, DigestList{sha1Digest})...)
+		rsp = append(rsp, makePCRReadResponseForTesting(t, 10, PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{7}}}, DigestList{sha256Digest})...)
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		pcrUpdateCounter, values, err := tpm.PCRRead(selection)
+		if err != nil {
+			t.Fatalf("PCRRead failed: %v", err)
+		}
+		if pcrUpdateCounter != 10 {
+			t.Errorf("Unexpected pcrUpdateCounter: %d", pcrUpdateCounter)
+		}
+		if len(values) != 2 {
+			t.Errorf("Unexpected number of banks: %d", len(values))
+		}
+		if !bytes.Equal(values[HashAlgorithmSHA1][7], sha1Digest) {
+			t.Errorf("Unexpected SHA1 digest: %x", values[HashAlgorithmSHA1][7])
+		}
+		if !bytes.Equal(values[HashAlgorithmSHA256][7], sha256Digest) {
+			t.Errorf("Unexpected SHA256 digest: %x", values[HashAlgorithmSHA256][7])
+		}
+	})
+
+	t.Run("UpdateCounterChanged", func(t *testing.T) {
+		var rsp []byte
+		rsp = append(rsp, makePCRReadResponseForTesting(t, 10, PCRSelectionList{{Hash: HashAlgorithmSHA1, Select: []int{7}}}, DigestList{sha1Digest})...)
+		rsp = append(rsp, makePCRReadResponseForTesting(t, 11, PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{7}}}, DigestList{sha256Digest})...)
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		_, _, err := tpm.PCRRead(selection)
+		var e *InvalidResponseError
+		if !xerrors.As(err, &e) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
+
 func TestPCRReset(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityPCRChange)
 	defer closeTPM(t, tpm)
@@ -378,3 +476,38 @@ func TestPCRReset(t *testing.T) {
 		})
 	}
 }
+
+func TestPCRAllocateMock(t *testing.T) {
+	params, _ := mu.MarshalToBytes(false, uint32(24), uint32(128), uint32(64))
+	rsp := makeMockResponse(Success, nil, params, mockPasswordAuth)
+
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	allocationSuccess, maxPCR, sizeNeeded, sizeAvailable, err := tpm.PCRAllocate(tpm.PlatformHandleContext(),
+		PCRSelectionList{{Hash: HashAlgorithmSHA1}, {Hash: HashAlgorithmSHA256, Select: []int{0, 7, 23}}}, nil)
+	if err != nil {
+		t.Fatalf("PCRAllocate failed: %v", err)
+	}
+	if allocationSuccess {
+		t.Errorf("Unexpected allocationSuccess")
+	}
+	if maxPCR != 24 || sizeNeeded != 128 || sizeAvailable != 64 {
+		t.Errorf("Unexpected sizing information (maxPCR: %d, sizeNeeded: %d, sizeAvailable: %d)", maxPCR, sizeNeeded, sizeAvailable)
+	}
+
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 1)
+	if c.Code != CommandPCRAllocate {
+		t.Errorf("Unexpected command code: %v", c.Code)
+	}
+	if c.Handles[0] != HandlePlatform {
+		t.Errorf("Unexpected handle: %v", c.Handles[0])
+	}
+	// A count prefixed list, with a 3 octet bitmap for each bank. The empty SHA-1 selection deallocates that bank.
+	expected := []byte{0x00, 0x00, 0x00, 0x02,
+		0x00, 0x04, 0x03, 0x00, 0x00, 0x00,
+		0x00, 0x0b, 0x03, 0x81, 0x00, 0x80}
+	if !bytes.Equal(c.Params, expected) {
+		t.Errorf("Unexpected command parameters: %x", c.Params)
+	}
+}
@@ -4,21 +4,53 @@
 
 package tpm2
 
+import (
+	"fmt"
+)
+
 // Section 16 - Random Number Generator
 
+// GetRandom executes the TPM2_GetRandom command to return the requested number of bytes from the TPM's random number generator.
+//
+// The TPM will return at most the size of the largest digest that it supports in response to a single TPM2_GetRandom command. If
+// bytesRequested is larger than this, this function will execute the command repeatedly until the requested number of bytes has
+// been obtained. If bytesRequested is zero, an empty Digest is returned without executing any commands. As this function may execute
+// more than one command, any SessionContext instances provided should have the AttrContinueSession attribute defined.
+//
+// If the TPM returns more bytes than requested, or returns no bytes whilst there are bytes still to be obtained, a
+// *InvalidResponseError error will be returned.
 func (t *TPMContext) GetRandom(bytesRequested uint16, sessions ...SessionContext) (Digest, error) {
-	var randomBytes Digest
-	if err := t.RunCommand(CommandGetRandom, sessions,
-		Delimiter,
-		bytesRequested, Delimiter,
-		Delimiter,
-		&randomBytes); err != nil {
-		return nil, err
+	randomBytes := make(Digest, 0, bytesRequested)
+
+	for len(randomBytes) < int(bytesRequested) {
+		remaining := bytesRequested - uint16(len(randomBytes))
+
+		var tmpBytes Digest
+		if err := t.RunCommand(CommandGetRandom, sessions,
+			Delimiter,
+			remaining, Delimiter,
+			Delimiter,
+			&tmpBytes); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case len(tmpBytes) > int(remaining):
+			return nil, &InvalidResponseError{CommandGetRandom,
+				fmt.Sprintf("TPM returned too many bytes (got %d, expected at most %d)", len(tmpBytes), remaining)}
+		case len(tmpBytes) == 0:
+			return nil, &InvalidResponseError{CommandGetRandom, "TPM returned no bytes"}
+		}
+
+		randomBytes = append(randomBytes, tmpBytes...)
 	}
 
 	return randomBytes, nil
 }
 
+// StirRandom executes the TPM2_StirRandom command to add additional information in inData to the state of the TPM's random number
+// generator. The TPM may limit the size of inData, in which case a *TPMParameterError error with an error code of ErrorValue will
+// be returned for parameter index 1.
 func (t *TPMContext) StirRandom(inData SensitiveData, sessions ...SessionContext) error {
 	return t.RunCommand(CommandStirRandom, sessions, Delimiter, inData)
 }
@@ -7,25 +7,20 @@ package tpm2_test
 import (
 	"crypto/rand"
 	"testing"
-
-	. "github.com/canonical/go-tpm2"
 )
 
 func TestGetRandom(t *testing.T) {
 	tpm := openTPMForTesting(t, 0)
 	defer closeTPM(t, tpm)
 
-	props, err := tpm.GetCapabilityTPMProperties(PropertyMaxDigest, 1)
-	if err != nil {
-		t.Fatalf("GetCapability failed: %v", err)
-	}
-
-	maxDigest := props[0].Value
-
 	for _, data := range []struct {
 		desc  string
 		bytes uint16
 	}{
+		{
+			desc:  "0Bytes",
+			bytes: 0,
+		},
 		{
 			desc:  "20Bytes",
 			bytes: 20,
@@ -42,17 +37,21 @@ func TestGetRandom(t *testing.T) {
 			desc:  "64Bytes",
 			bytes: 64,
 		},
+		{
+			// Larger than any digest, so this requires more than one command.
+			desc:  "200Bytes",
+			bytes: 200,
+		},
 	} {
 		t.Run(data.desc, func(t *testing.T) {
 			random, err := tpm.GetRandom(data.bytes)
 			if err != nil {
 				t.Fatalf("GetRandom failed: %v", err)
 			}
-			size := int(data.bytes)
-			if uint32(data.bytes) > maxDigest {
-				size = int(maxDigest)
+			if random == nil {
+				t.Errorf("GetRandom returned a nil slice")
 			}
-			if len(random) != size {
+			if len(random) != int(data.bytes) {
 				t.Errorf("Unexpected random data length (%d)", len(random))
 			}
 		})
@@ -78,7 +78,7 @@ func (t *TPMContext) StartAuthSession(tpmKey, bind ResourceContext, sessionType
 	var encryptedSalt EncryptedSecret
 	tpmKeyHandle := HandleNull
 	if tpmKey != nil {
-		object, isObject := tpmKey.(*objectContext)
+		object, isObject := unwrapHandleContext(tpmKey).(*objectContext)
 		if !isObject {
 			return nil, makeInvalidArgError("tpmKey", "resource context is not an object")
 		}
@@ -150,8 +150,17 @@ func (t *TPMContext) StartAuthSession(tpmKey, bind ResourceContext, sessionType
 	return makeSessionContext(sessionHandle, data), nil
 }
 
-// PolicyRestart executes the TPM2_PolicyRestart command on the policy session associated with sessionContext, to reset the policy
-// authorization session to its initial state.
-func (t *TPMContext) PolicyRestart(sessionContext SessionContext, sessions ...SessionContext) error {
-	return t.RunCommand(CommandPolicyRestart, sessions, sessionContext)
+// PolicyRestart executes the TPM2_PolicyRestart command on the policy session associated with policySession, to reset the policy
+// authorization session to its initial state. On success, any requirement for the authorization value of the entity being
+// authorized that was established by a previous TPM2_PolicyAuthValue or TPM2_PolicyPassword assertion is also cleared from
+// policySession.
+func (t *TPMContext) PolicyRestart(policySession SessionContext, sessions ...SessionContext) error {
+	if err := t.RunCommand(CommandPolicyRestart, sessions, policySession); err != nil {
+		return err
+	}
+
+	if s, isSession := policySession.(*sessionContext); isSession && s.scData() != nil {
+		s.scData().PolicyHMACType = policyHMACTypeNoAuth
+	}
+	return nil
 }
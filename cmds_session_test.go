@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestStartAuthSession(t *testing.T) {
@@ -210,3 +211,58 @@ func TestPolicyRestart(t *testing.T) {
 		t.Errorf("Digest wasn't reset to zero")
 	}
 }
+
+func TestPolicyRestartClearsPolicyPassword(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+	startRsp := makeMockResponse(Success, nil, params)
+	emptyRsp := makeMockResponse(Success, nil, nil)
+	clockRsp := makeMockResponse(Success, nil, nil, mockResponseAuth{Nonce: make(Nonce, 32), Attrs: 1})
+
+	var responses []byte
+	for _, r := range [][]byte{startRsp, emptyRsp, emptyRsp, clockRsp} {
+		responses = append(responses, r...)
+	}
+	tcti := &mockTcti{responses: bytes.NewReader(responses)}
+	tpm, _ := NewTPMContext(tcti)
+
+	if err := tpm.PolicyPassword(nil); err == nil || err.Error() != "invalid policySession argument: unusable session context" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.commands.Len() != 0 {
+		t.Errorf("No command should have been sent to the TPM")
+	}
+
+	sc, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+	if err := tpm.PolicyPassword(sc); err != nil {
+		t.Fatalf("PolicyPassword failed: %v", err)
+	}
+	if err := tpm.PolicyRestart(sc); err != nil {
+		t.Fatalf("PolicyRestart failed: %v", err)
+	}
+
+	owner := tpm.OwnerHandleContext()
+	owner.SetAuthValue([]byte("foo"))
+	defer owner.SetAuthValue(nil)
+
+	tcti.commands.Reset()
+	if err := tpm.ClockRateAdjust(owner, ClockCoarseSlower, sc.WithAttrs(AttrContinueSession)); err != nil {
+		t.Fatalf("ClockRateAdjust failed: %v", err)
+	}
+
+	// The session is unbound and unsalted, and no longer has a TPM2_PolicyPassword assertion, so the authorization value of
+	// the owner hierarchy must not be sent in cleartext.
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 1)
+	if len(c.Auths) != 1 {
+		t.Fatalf("Unexpected number of command authorizations: %d", len(c.Auths))
+	}
+	auth := c.Auths[0]
+	if auth.Handle != sc.Handle() {
+		t.Errorf("Unexpected session handle: %v", auth.Handle)
+	}
+	if len(auth.HMAC) != 0 {
+		t.Errorf("Unexpected HMAC: %x", auth.HMAC)
+	}
+}
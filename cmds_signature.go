@@ -4,7 +4,7 @@
 
 package tpm2
 
-// Secion 20 - Signing and Signature Verification
+// Section 20 - Signing and Signature Verification
 
 // VerifySignature executes the TPM2_VerifySignature command to validate the provided signature against a message with the provided
 // digest, using the key associated with keyContext. If keyContext corresponds to an object that isn't a signing key, a
@@ -17,8 +17,15 @@ package tpm2
 // If keyContext corresponds to a HMAC key but only the public part is loaded, a *TPMParameterError error with an error code of
 // ErrorHandle will be returned for parameter index 2.
 //
-// On success, a valid TkVerified structure will be returned.
+// If signature is nil, an error will be returned without executing the command.
+//
+// On success, a valid TkVerified structure will be returned. This can be used as proof that the TPM verified the signature, eg, by
+// passing it to TPMContext.PolicyAuthorize.
 func (t *TPMContext) VerifySignature(keyContext ResourceContext, digest Digest, signature *Signature, sessions ...SessionContext) (*TkVerified, error) {
+	if signature == nil {
+		return nil, makeInvalidArgError("signature", "nil value")
+	}
+
 	var validation TkVerified
 	if err := t.RunCommand(CommandVerifySignature, sessions,
 		keyContext, Delimiter,
@@ -54,7 +61,8 @@ func (t *TPMContext) VerifySignature(keyContext ResourceContext, digest Digest,
 // that the supplied digest was created by the TPM. If the key associated with keyContext does not have the AttrRestricted attribute,
 // then validation may be nil. If validation is not nil and doesn't correspond to a valid ticket, or it is nil and the key associated
 // with keyContext has the AttrRestricted attribute set, a *TPMParameterError error with an error code of ErrorTicket will be returned
-// for parameter index 3.
+// for parameter index 3. A nil validation is sent to the TPM as a NULL ticket, which is sufficient for signing an externally
+// computed digest with a key that does not have the AttrRestricted attribute.
 func (t *TPMContext) Sign(keyContext ResourceContext, digest Digest, inScheme *SigScheme, validation *TkHashcheck, keyContextAuthSession SessionContext, sessions ...SessionContext) (*Signature, error) {
 	if inScheme == nil {
 		inScheme = &SigScheme{Scheme: SigSchemeAlgNull}
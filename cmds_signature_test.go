@@ -5,12 +5,16 @@
 package tpm2_test
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
 )
 
 func TestSign(t *testing.T) {
@@ -326,3 +330,95 @@ func TestVerifySignature(t *testing.T) {
 		})
 	})
 }
+
+func TestSignResponseUnionSelector(t *testing.T) {
+	for _, data := range []struct {
+		desc    string
+		sigAlg  SigSchemeId
+		invalid bool
+	}{
+		{
+			desc:   "Null",
+			sigAlg: SigSchemeAlgNull,
+		},
+		{
+			desc:    "Invalid",
+			sigAlg:  SigSchemeId(AlgorithmSHA256),
+			invalid: true,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			params, _ := mu.MarshalToBytes(data.sigAlg)
+			rsp := makeMockResponse(Success, nil, params, mockPasswordAuth)
+
+			tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+			key, _ := CreateObjectResourceContextFromPublic(0x80000001, NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeNull))
+
+			signature, err := tpm.Sign(key, make(Digest, 32), nil, nil, nil)
+			if data.invalid {
+				var e *InvalidResponseError
+				if !xerrors.As(err, &e) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+			if signature.SigAlg != SigSchemeAlgNull || signature.Signature.Data != nil {
+				t.Errorf("Unexpected signature: %v", signature)
+			}
+		})
+	}
+}
+
+func TestSignAndVerifySignatureMock(t *testing.T) {
+	key, _ := CreateObjectResourceContextFromPublic(0x80000001, NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeNull))
+	digest := make(Digest, 32)
+
+	t.Run("SignWithoutValidation", func(t *testing.T) {
+		params, _ := mu.MarshalToBytes(SigSchemeAlgNull)
+		rsp := makeMockResponse(Success, nil, params, mockPasswordAuth)
+
+		tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+		tpm, _ := NewTPMContext(tcti)
+
+		if _, err := tpm.Sign(key, digest, nil, nil, nil); err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+
+		// A nil inScheme and validation should be sent as TPM_ALG_NULL and a NULL ticket.
+		expected, _ := mu.MarshalToBytes(digest, SigSchemeAlgNull, TagHashcheck, HandleNull, Digest(nil))
+		if !bytes.HasSuffix(tcti.commands.Bytes(), expected) {
+			t.Errorf("Unexpected command parameters: %x", tcti.commands.Bytes())
+		}
+	})
+
+	t.Run("VerifyBadSignature", func(t *testing.T) {
+		rc := EncodeResponseCode(&TPMParameterError{TPMError: &TPMError{Code: ErrorSignature}, Index: 2})
+		rsp := makeMockResponse(rc, nil, nil)
+		tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+		signature := Signature{
+			SigAlg: SigSchemeAlgHMAC,
+			Signature: SignatureU{
+				Data: &TaggedHash{HashAlg: HashAlgorithmSHA256, Digest: make([]byte, 32)}}}
+		_, err := tpm.VerifySignature(key, digest, &signature)
+		if !IsTPMParameterError(err, ErrorSignature, CommandVerifySignature, 2) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("VerifyNilSignature", func(t *testing.T) {
+		tcti := &mockTcti{}
+		tpm, _ := NewTPMContext(tcti)
+
+		_, err := tpm.VerifySignature(key, digest, nil)
+		if err == nil || err.Error() != "invalid signature argument: nil value" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if tcti.commands.Len() != 0 {
+			t.Errorf("VerifySignature shouldn't have sent a command to the TPM")
+		}
+	})
+}
@@ -4,7 +4,7 @@
 
 package tpm2
 
-// Section 10 - Testing
+// Section 9 - Start-up
 
 // Startup executes the TPM2_Startup command with the specified StartupType. If this isn't preceded by _TPM_Init then it will return
 // a *TPMError error with an error code of ErrorInitialize. The shutdown and startup sequence determines how the TPM responds to this
@@ -5,6 +5,7 @@
 package tpm2_test
 
 import (
+	"bytes"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
@@ -68,3 +69,14 @@ func TestStartup(t *testing.T) {
 		}
 	})
 }
+
+func TestStartupAlreadyInitialized(t *testing.T) {
+	// TPM_RC_INITIALIZE is returned if the TPM has already been started up. This is returned to the caller as a *TPMError so that
+	// it can decide whether this is an error.
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader([]byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x00})})
+
+	err := tpm.Startup(StartupClear)
+	if !IsTPMError(err, ErrorInitialize, CommandStartup) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2
+
+import (
+	"fmt"
+)
+
+// Section 15 - Symmetric Primitives
+
+// EncryptDecrypt executes the TPM2_EncryptDecrypt command to perform symmetric encryption or decryption of the data in inData with
+// the symmetric key associated with keyContext. This command requires authorization with the user auth role for keyContext, with
+// session based authorization provided via keyContextAuthSession.
+//
+// If keyContext does not correspond to an object with the type ObjectTypeSymCipher, a *TPMHandleError error with an error code of
+// ErrorKey will be returned for handle index 1.
+//
+// If decrypt is true and the object associated with keyContext does not have the AttrDecrypt attribute set, or decrypt is false and
+// the object does not have the AttrSign attribute set, a *TPMHandleError error with an error code of ErrorAttributes will be
+// returned for handle index 1.
+//
+// The mode argument specifies the block cipher mode. If the object associated with keyContext has a mode other than SymModeNull,
+// then mode must either be SymModeNull or match the mode of the key. If the key has a mode of SymModeNull, then mode must not be
+// SymModeNull. If these conditions are not met, a *TPMParameterError error with an error code of ErrorMode will be returned for
+// parameter index 2.
+//
+// The ivIn argument specifies the initial chaining value. If the length of ivIn is not equal to the block size of the key's
+// symmetric algorithm, a *TPMParameterError error with an error code of ErrorSize will be returned for parameter index 3. If the
+// mode is SymModeECB, ivIn should be empty.
+//
+// If mode is SymModeCBC or SymModeECB and the length of inData is not a multiple of the block size, a *TPMParameterError error with
+// an error code of ErrorSize will be returned for parameter index 4.
+//
+// On success, the encrypted or decrypted data is returned, along with the chaining value that can be used as ivIn for encrypting or
+// decrypting a subsequent block of data.
+//
+// Note that TPM2_EncryptDecrypt does not support parameter encryption of inData, as it is not the first command parameter. Use
+// TPMContext.EncryptDecrypt2 where parameter encryption is required.
+func (t *TPMContext) EncryptDecrypt(keyContext ResourceContext, decrypt bool, mode SymModeId, ivIn IV, inData MaxBuffer, keyContextAuthSession SessionContext, sessions ...SessionContext) (MaxBuffer, IV, error) {
+	var outData MaxBuffer
+	var ivOut IV
+	if err := t.RunCommand(CommandEncryptDecrypt, sessions,
+		ResourceContextWithSession{Context: keyContext, Session: keyContextAuthSession}, Delimiter,
+		decrypt, mode, ivIn, inData, Delimiter,
+		Delimiter,
+		&outData, &ivOut); err != nil {
+		return nil, nil, err
+	}
+
+	return outData, ivOut, nil
+}
+
+// EncryptDecrypt2Raw executes the TPM2_EncryptDecrypt2 command. This behaves identically to TPMContext.EncryptDecrypt, except that
+// inData is the first command parameter so that it can be protected with parameter encryption. The parameter indices of errors
+// associated with inData, decrypt, mode and ivIn are 1, 2, 3 and 4 respectively.
+//
+// If inData is larger than the TPM's input buffer, a *TPMParameterError error with an error code of ErrorSize will be returned for
+// parameter index 1. Use TPMContext.EncryptDecrypt2 to process larger amounts of data.
+func (t *TPMContext) EncryptDecrypt2Raw(keyContext ResourceContext, inData MaxBuffer, decrypt bool, mode SymModeId, ivIn IV, keyContextAuthSession SessionContext, sessions ...SessionContext) (MaxBuffer, IV, error) {
+	var outData MaxBuffer
+	var ivOut IV
+	if err := t.RunCommand(CommandEncryptDecrypt2, sessions,
+		ResourceContextWithSession{Context: keyContext, Session: keyContextAuthSession}, Delimiter,
+		inData, decrypt, mode, ivIn, Delimiter,
+		Delimiter,
+		&outData, &ivOut); err != nil {
+		return nil, nil, err
+	}
+
+	return outData, ivOut, nil
+}
+
+// EncryptDecrypt2 executes the TPM2_EncryptDecrypt2 command. This behaves identically to TPMContext.EncryptDecrypt, except that
+// inData is the first command parameter so that it can be protected with parameter encryption. The parameter indices of errors
+// associated with inData, decrypt, mode and ivIn are 1, 2, 3 and 4 respectively.
+//
+// If inData is too large to be processed in a single command, this function will re-execute the TPM2_EncryptDecrypt2 command until
+// all of the data is processed, using the chaining value returned from each command as the initial chaining value for the next
+// command, and the output of each command will be concatenated. Each command other than the last one processes a whole number of
+// cipher blocks. As a consequence, any SessionContext instances provided must have the AttrContinueSession attribute defined and
+// keyContextAuthSession must not be a policy session in this case.
+func (t *TPMContext) EncryptDecrypt2(keyContext ResourceContext, inData MaxBuffer, decrypt bool, mode SymModeId, ivIn IV, keyContextAuthSession SessionContext, sessions ...SessionContext) (MaxBuffer, IV, error) {
+	if err := t.initPropertiesIfNeeded(); err != nil {
+		return nil, nil, err
+	}
+
+	// Round the chunk size down to a multiple of the largest block size of the supported symmetric algorithms, so that the
+	// chaining value returned from each command is correct for the next one.
+	chunkSize := t.maxBufferSize &^ 15
+	if chunkSize == 0 {
+		chunkSize = t.maxBufferSize
+	}
+
+	if len(inData) > chunkSize {
+		if w, isWrapped := keyContext.(*resourceContextWithAuth); isWrapped && keyContextAuthSession == nil {
+			keyContextAuthSession = w.session
+		}
+		if keyContextAuthSession != nil {
+			if keyContextAuthSession.(*sessionContext).attrs&AttrContinueSession == 0 {
+				return nil, nil, makeInvalidArgError("keyContextAuthSession", "the AttrContinueSession attribute is required for authorization sessions")
+			}
+			if scData := keyContextAuthSession.(*sessionContext).scData(); scData != nil && scData.SessionType == SessionTypePolicy {
+				return nil, nil, makeInvalidArgError("keyContextAuthSession", "a policy authorization session cannot be used")
+			}
+		}
+
+		for i, s := range sessions {
+			if s.(*sessionContext).attrs&AttrContinueSession == 0 {
+				return nil, nil, makeInvalidArgError("sessions", fmt.Sprintf("the AttrContinueSession attribute is required for session at index %d", i))
+			}
+		}
+	}
+
+	var outData MaxBuffer
+	ivOut := ivIn
+	total := 0
+	for {
+		d := inData[total:]
+		if len(d) > chunkSize {
+			d = d[:chunkSize]
+		}
+		out, iv, err := t.EncryptDecrypt2Raw(keyContext, d, decrypt, mode, ivOut, keyContextAuthSession, sessions...)
+		if err != nil {
+			return nil, nil, err
+		}
+		outData = append(outData, out...)
+		ivOut = iv
+
+		total += len(d)
+		if len(inData)-total == 0 {
+			break
+		}
+	}
+
+	return outData, ivOut, nil
+}
+
+// Hash executes the TPM2_Hash command to compute the digest of data with the algorithm specified by hashAlg. If the digest is safe
+// to sign with a restricted signing key, then a ticket that can be passed to TPMContext.Sign will be returned. In this case, the
+// hierarchy argument is used to specify the hierarchy for the ticket. If hierarchy is HandleNull, or data begins with
+// TPMGeneratedValue, no ticket will be returned.
+//
+// If hashAlg is not a supported digest algorithm, a *TPMParameterError error with an error code of ErrorValue will be returned for
+// parameter index 2. If the hierarchy specified by the hierarchy argument is disabled, a *TPMParameterError error with an error
+// code of ErrorHierarchy will be returned for parameter index 3.
+//
+// If data is too large to be passed to the TPM in a single command, this function will compute the digest with a hash sequence
+// instead, using the TPM2_HashSequenceStart, TPM2_SequenceUpdate and TPM2_SequenceComplete commands. As a consequence, any
+// SessionContext instances provided should have the AttrContinueSession attribute defined in this case.
+func (t *TPMContext) Hash(data MaxBuffer, hashAlg HashAlgorithmId, hierarchy Handle, sessions ...SessionContext) (Digest, *TkHashcheck, error) {
+	if err := t.initPropertiesIfNeeded(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(data) > t.maxBufferSize {
+		seq, err := t.HashSequenceStart(nil, hashAlg, sessions...)
+		if err != nil {
+			return nil, nil, err
+		}
+		outHash, validation, err := t.SequenceExecute(seq, data, hierarchy, nil, sessions...)
+		if err != nil {
+			t.FlushContext(seq)
+			return nil, nil, err
+		}
+		return outHash, validation, nil
+	}
+
+	var outHash Digest
+	var validation *TkHashcheck
+
+	if err := t.RunCommand(CommandHash, sessions,
+		Delimiter,
+		data, hashAlg, hierarchy, Delimiter,
+		Delimiter,
+		&outHash, &validation); err != nil {
+		return nil, nil, err
+	}
+
+	if validation.Hierarchy == HandleNull && len(validation.Digest) == 0 {
+		validation = nil
+	}
+
+	return outHash, validation, nil
+}
+
+// HMAC executes the TPM2_HMAC command to compute the HMAC of buffer using the key associated with context. This command requires
+// authorization with the user auth role for context, with session based authorization provided via contextAuthSession.
+//
+// If context does not correspond to an object with the type ObjectTypeKeyedHash, a *TPMHandleError error with an error code of
+// ErrorType will be returned. If context corresponds to an object with the AttrRestricted attribute set, a *TPMHandleError error
+// with an error code of ErrorAttributes will be returned. If context does not correspond to a signing key, a *TPMHandleError error
+// with an error code of ErrorKey will be returned.
+//
+// The hashAlg argument specifies the HMAC algorithm, and is subject to the same rules as the hashAlg argument of
+// TPMContext.HMACStart. If these are not met, a *TPMParameterError error with an error code of ErrorValue will be returned for
+// parameter index 2.
+//
+// If buffer is larger than the TPM's input buffer, a *TPMParameterError error with an error code of ErrorSize will be returned for
+// parameter index 1. In this case, TPMContext.HMACStart and TPMContext.SequenceExecute can be used instead.
+func (t *TPMContext) HMAC(context ResourceContext, buffer MaxBuffer, hashAlg HashAlgorithmId, contextAuthSession SessionContext, sessions ...SessionContext) (Digest, error) {
+	var outHMAC Digest
+
+	if err := t.RunCommand(CommandHMAC, sessions,
+		ResourceContextWithSession{Context: context, Session: contextAuthSession}, Delimiter,
+		buffer, hashAlg, Delimiter,
+		Delimiter,
+		&outHMAC); err != nil {
+		return nil, err
+	}
+
+	return outHMAC, nil
+}
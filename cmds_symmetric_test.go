@@ -0,0 +1,399 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"hash"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	template := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeNull)
+	if template.Attrs&(AttrSign|AttrDecrypt) != AttrSign|AttrDecrypt {
+		t.Errorf("Template should have both AttrSign and AttrDecrypt set")
+	}
+
+	priv, pub, _, _, _, err := tpm.Create(primary, nil, template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	key, err := tpm.Load(primary, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer flushContext(t, tpm, key)
+
+	in := MaxBuffer("1234567890abcdef1234567890abcdef")
+
+	for _, data := range []struct {
+		desc string
+		mode SymModeId
+	}{
+		{desc: "CFB", mode: SymModeCFB},
+		{desc: "CBC", mode: SymModeCBC},
+		{desc: "CTR", mode: SymModeCTR},
+	} {
+		mode := data.mode
+		t.Run(data.desc, func(t *testing.T) {
+			iv := make(IV, 16)
+
+			ciphertext, _, err := tpm.EncryptDecrypt(key, false, mode, iv, in, nil)
+			if err != nil {
+				t.Fatalf("EncryptDecrypt failed: %v", err)
+			}
+			if bytes.Equal(ciphertext, in) {
+				t.Errorf("Ciphertext should not match plaintext")
+			}
+
+			plaintext, _, err := tpm.EncryptDecrypt(key, true, mode, iv, ciphertext, nil)
+			if err != nil {
+				t.Fatalf("EncryptDecrypt failed: %v", err)
+			}
+			if !bytes.Equal(plaintext, in) {
+				t.Errorf("Unexpected plaintext")
+			}
+
+			plaintext, _, err = tpm.EncryptDecrypt2(key, ciphertext, true, mode, iv, nil)
+			if err != nil {
+				t.Fatalf("EncryptDecrypt2 failed: %v", err)
+			}
+			if !bytes.Equal(plaintext, in) {
+				t.Errorf("Unexpected plaintext")
+			}
+		})
+	}
+}
+
+func TestEncryptDecrypt2MultipleChunks(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	primary := createRSASrkForTesting(t, tpm, nil)
+	defer flushContext(t, tpm, primary)
+
+	template := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	priv, pub, _, _, _, err := tpm.Create(primary, nil, template, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	key, err := tpm.Load(primary, priv, pub, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defer flushContext(t, tpm, key)
+
+	in := make(MaxBuffer, 3*tpm.GetInputBuffer()+5)
+	rand.Read(in)
+	iv := make(IV, 16)
+
+	ciphertext, _, err := tpm.EncryptDecrypt2(key, in, false, SymModeCFB, iv, nil)
+	if err != nil {
+		t.Fatalf("EncryptDecrypt2 failed: %v", err)
+	}
+	if len(ciphertext) != len(in) {
+		t.Errorf("Unexpected ciphertext length: %d", len(ciphertext))
+	}
+
+	plaintext, _, err := tpm.EncryptDecrypt2(key, ciphertext, true, SymModeCFB, iv, nil)
+	if err != nil {
+		t.Fatalf("EncryptDecrypt2 failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, in) {
+		t.Errorf("Unexpected plaintext")
+	}
+}
+
+func TestEncryptDecrypt2ChunkingMock(t *testing.T) {
+	symKey := make([]byte, 16)
+	rand.Read(symKey)
+	block, err := aes.NewCipher(symKey)
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+
+	// Emulate a TPM with a maximum input buffer size of 40 bytes, which isn't a multiple of the AES block size.
+	var chunks []int
+	respond := func(cmd []byte) []byte {
+		var commandCode CommandCode
+		if _, err := mu.UnmarshalFromBytes(cmd[6:], &commandCode); err != nil {
+			t.Fatalf("Cannot unmarshal command code: %v", err)
+		}
+
+		if commandCode == CommandGetCapability {
+			return makeGetCapabilityResponseForTesting(t, false, &CapabilityData{
+				Capability: CapabilityTPMProperties,
+				Data: CapabilitiesU{Data: TaggedTPMPropertyList{
+					{Property: PropertyInputBuffer, Value: 40},
+					{Property: PropertyNVBufferMax, Value: 40}}}})
+		}
+		if commandCode != CommandEncryptDecrypt2 {
+			t.Fatalf("Unexpected command: %v", commandCode)
+		}
+
+		c := decodeMockCommand(t, cmd, 1)
+		var inData MaxBuffer
+		var decrypt bool
+		var mode SymModeId
+		var ivIn IV
+		if _, err := mu.UnmarshalFromBytes(c.Params, &inData, &decrypt, &mode, &ivIn); err != nil {
+			t.Fatalf("Cannot unmarshal EncryptDecrypt2 parameters: %v", err)
+		}
+		if len(inData) > 40 {
+			t.Errorf("EncryptDecrypt2 sent too much data (%d bytes)", len(inData))
+		}
+		chunks = append(chunks, len(inData))
+
+		outData := make(MaxBuffer, len(inData))
+		ciphertext := outData
+		if decrypt {
+			cipher.NewCFBDecrypter(block, ivIn).XORKeyStream(outData, inData)
+			ciphertext = inData
+		} else {
+			cipher.NewCFBEncrypter(block, ivIn).XORKeyStream(outData, inData)
+		}
+		// The chaining value is the last ciphertext block. This is only meaningful when the last block is complete.
+		ivOut := make(IV, 16)
+		if len(ciphertext) >= 16 {
+			copy(ivOut, ciphertext[len(ciphertext)-16:])
+		}
+
+		rpBytes, _ := mu.MarshalToBytes(outData, ivOut)
+		return makeMockResponse(Success, nil, rpBytes, mockPasswordAuth)
+	}
+	tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+	key, _ := CreateObjectResourceContextFromPublic(0x80000001, NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB))
+
+	in := make(MaxBuffer, 100)
+	rand.Read(in)
+	iv := make(IV, 16)
+
+	ciphertext, _, err := tpm.EncryptDecrypt2(key, in, false, SymModeCFB, iv, nil)
+	if err != nil {
+		t.Fatalf("EncryptDecrypt2 failed: %v", err)
+	}
+	if len(chunks) != 4 || chunks[0] != 32 || chunks[3] != 4 {
+		t.Errorf("Unexpected chunks: %v", chunks)
+	}
+
+	expected := make([]byte, len(in))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(expected, in)
+	if !bytes.Equal(ciphertext, expected) {
+		t.Errorf("Unexpected ciphertext")
+	}
+
+	plaintext, _, err := tpm.EncryptDecrypt2(key, ciphertext, true, SymModeCFB, iv, nil)
+	if err != nil {
+		t.Fatalf("EncryptDecrypt2 failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, in) {
+		t.Errorf("Unexpected plaintext")
+	}
+}
+
+func TestHash(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
+	defer closeTPM(t, tpm)
+
+	for _, data := range []struct {
+		desc      string
+		data      MaxBuffer
+		alg       HashAlgorithmId
+		hierarchy Handle
+		ticket    bool
+	}{
+		{desc: "SHA256", data: MaxBuffer("foo"), alg: HashAlgorithmSHA256, hierarchy: HandleOwner, ticket: true},
+		{desc: "SHA1", data: MaxBuffer("foo"), alg: HashAlgorithmSHA1, hierarchy: HandleOwner, ticket: true},
+		{desc: "NullHierarchy", data: MaxBuffer("foo"), alg: HashAlgorithmSHA256, hierarchy: HandleNull},
+		{desc: "TPMGenerated", data: MaxBuffer("\xff\x54\x43\x47foo"), alg: HashAlgorithmSHA256, hierarchy: HandleOwner},
+		{desc: "Large", data: make(MaxBuffer, 2*tpm.GetInputBuffer()+10), alg: HashAlgorithmSHA256, hierarchy: HandleOwner, ticket: true},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			digest, validation, err := tpm.Hash(data.data, data.alg, data.hierarchy)
+			if err != nil {
+				t.Fatalf("Hash failed: %v", err)
+			}
+
+			h := data.alg.NewHash()
+			h.Write(data.data)
+			if !bytes.Equal(digest, h.Sum(nil)) {
+				t.Errorf("Unexpected digest: %x", digest)
+			}
+
+			if !data.ticket {
+				if validation != nil {
+					t.Errorf("validation should be nil")
+				}
+				return
+			}
+			if validation == nil {
+				t.Fatalf("nil validation")
+			}
+			if validation.Tag != TagHashcheck || validation.Hierarchy != data.hierarchy {
+				t.Errorf("Unexpected validation: %v", validation)
+			}
+		})
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	seed := make([]byte, 32)
+
+	h := sha256.New()
+	h.Write(seed)
+	h.Write(key)
+
+	public := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrSensitiveDataOrigin | AttrUserWithAuth | AttrSign,
+		Params: PublicParamsU{
+			Data: &KeyedHashParams{
+				Scheme: KeyedHashScheme{
+					Scheme:  KeyedHashSchemeHMAC,
+					Details: SchemeKeyedHashU{Data: &SchemeHMAC{HashAlg: HashAlgorithmSHA256}}}}},
+		Unique: PublicIDU{Data: Digest(h.Sum(nil))}}
+	sensitive := Sensitive{
+		Type:      ObjectTypeKeyedHash,
+		AuthValue: make(Auth, 32),
+		SeedValue: seed,
+		Sensitive: SensitiveCompositeU{Data: SensitiveData(key)}}
+	keyContext, err := tpm.LoadExternal(&sensitive, &public, HandleNull)
+	if err != nil {
+		t.Fatalf("LoadExternal failed: %v", err)
+	}
+	defer flushContext(t, tpm, keyContext)
+
+	data := MaxBuffer("foo")
+	result, err := tpm.HMAC(keyContext, data, HashAlgorithmNull, nil)
+	if err != nil {
+		t.Fatalf("HMAC failed: %v", err)
+	}
+
+	m := hmac.New(func() hash.Hash { return sha256.New() }, key)
+	m.Write(data)
+	if !bytes.Equal(result, m.Sum(nil)) {
+		t.Errorf("Unexpected result: %x", result)
+	}
+}
+
+func TestHashMock(t *testing.T) {
+	t.Run("NullTicket", func(t *testing.T) {
+		digest := sha256.Sum256([]byte("foo"))
+		params, _ := mu.MarshalToBytes(Digest(digest[:]), TkHashcheck{Tag: TagHashcheck, Hierarchy: HandleNull})
+		rsp := makeMockResponse(Success, nil, params)
+		getCapRsp := makeGetCapabilityResponseForTesting(t, false, &CapabilityData{
+			Capability: CapabilityTPMProperties,
+			Data: CapabilitiesU{Data: TaggedTPMPropertyList{
+				{Property: PropertyInputBuffer, Value: 1024},
+				{Property: PropertyNVBufferMax, Value: 1024}}}})
+		tcti := &mockTcti{responses: bytes.NewReader(append(getCapRsp, rsp...))}
+		tpm, _ := NewTPMContext(tcti)
+
+		result, validation, err := tpm.Hash(MaxBuffer("foo"), HashAlgorithmSHA256, HandleNull)
+		if err != nil {
+			t.Fatalf("Hash failed: %v", err)
+		}
+		if !bytes.Equal(result, digest[:]) {
+			t.Errorf("Unexpected digest: %x", result)
+		}
+		if validation != nil {
+			t.Errorf("validation should be nil")
+		}
+	})
+
+	t.Run("Sequence", func(t *testing.T) {
+		// Emulate a TPM with a maximum input buffer size of 16 bytes.
+		var h hash.Hash
+		var commands []CommandCode
+		respond := func(cmd []byte) []byte {
+			var commandCode CommandCode
+			if _, err := mu.UnmarshalFromBytes(cmd[6:], &commandCode); err != nil {
+				t.Fatalf("Cannot unmarshal command code: %v", err)
+			}
+			commands = append(commands, commandCode)
+
+			switch commandCode {
+			case CommandGetCapability:
+				return makeGetCapabilityResponseForTesting(t, false, &CapabilityData{
+					Capability: CapabilityTPMProperties,
+					Data: CapabilitiesU{Data: TaggedTPMPropertyList{
+						{Property: PropertyInputBuffer, Value: 16},
+						{Property: PropertyNVBufferMax, Value: 16}}}})
+			case CommandHashSequenceStart:
+				h = sha256.New()
+				return makeMockResponse(Success, []Handle{0x80000001}, nil)
+			}
+
+			var buffer MaxBuffer
+			if _, err := mu.UnmarshalFromBytes(decodeMockCommand(t, cmd, 1).Params, &buffer); err != nil {
+				t.Fatalf("Cannot unmarshal command parameters: %v", err)
+			}
+			if len(buffer) > 16 {
+				t.Errorf("Command sent too much data (%d bytes)", len(buffer))
+			}
+			h.Write(buffer)
+
+			var rpBytes []byte
+			switch commandCode {
+			case CommandSequenceUpdate:
+			case CommandSequenceComplete:
+				rpBytes, _ = mu.MarshalToBytes(Digest(h.Sum(nil)), TkHashcheck{Tag: TagHashcheck, Hierarchy: HandleOwner, Digest: make(Digest, 32)})
+			default:
+				t.Fatalf("Unexpected command: %v", commandCode)
+			}
+
+			return makeMockResponse(Success, nil, rpBytes, mockPasswordAuth)
+		}
+		tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+
+		data := make(MaxBuffer, 40)
+		rand.Read(data)
+
+		result, validation, err := tpm.Hash(data, HashAlgorithmSHA256, HandleOwner)
+		if err != nil {
+			t.Fatalf("Hash failed: %v", err)
+		}
+		expected := sha256.Sum256(data)
+		if !bytes.Equal(result, expected[:]) {
+			t.Errorf("Unexpected digest: %x", result)
+		}
+		if validation == nil || validation.Hierarchy != HandleOwner {
+			t.Errorf("Unexpected validation: %v", validation)
+		}
+
+		expectedCommands := []CommandCode{CommandGetCapability, CommandHashSequenceStart, CommandSequenceUpdate, CommandSequenceUpdate,
+			CommandSequenceComplete}
+		if len(commands) != len(expectedCommands) {
+			t.Fatalf("Unexpected commands: %v", commands)
+		}
+		for i, c := range commands {
+			if c != expectedCommands[i] {
+				t.Errorf("Unexpected command at index %d: %v", i, c)
+			}
+		}
+	})
+}
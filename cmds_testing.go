@@ -4,12 +4,23 @@
 
 package tpm2
 
-// Section 9 - Start-up
+// Section 10 - Testing
 
+// SelfTest executes the TPM2_SelfTest command, which causes the TPM to test the functions that it hasn't already tested. If fullTest
+// is true, the TPM will test all functions, regardless of whether they have already been tested.
+//
+// The TPM may perform the tests in the background, in which case it will return a warning with the WarningTesting code. This is not
+// treated as a fatal error, but is returned as a *TPMWarning so that the caller can test for it with IsTPMWarning and then poll for
+// completion with TPMContext.GetTestResult. Unlike other commands, TPM2_SelfTest isn't resubmitted when the TPM responds with this
+// warning. If any of the tests fail, a *TPMError error with an error code of ErrorFailure will be returned.
 func (t *TPMContext) SelfTest(fullTest bool, sessions ...SessionContext) error {
 	return t.RunCommand(CommandSelfTest, sessions, Delimiter, fullTest)
 }
 
+// IncrementalSelfTest executes the TPM2_IncrementalSelfTest command, which causes the TPM to test the algorithms in toTest that
+// haven't already been tested. On success, the list of algorithms that remain untested is returned. Like TPMContext.SelfTest, a
+// *TPMWarning error with the WarningTesting code is returned if the TPM is still performing tests, and this command is not
+// resubmitted in this case.
 func (t *TPMContext) IncrementalSelfTest(toTest AlgorithmList, sessions ...SessionContext) (AlgorithmList, error) {
 	var toDoList AlgorithmList
 	if err := t.RunCommand(CommandIncrementalSelfTest, sessions,
@@ -22,6 +33,11 @@ func (t *TPMContext) IncrementalSelfTest(toTest AlgorithmList, sessions ...Sessi
 	return toDoList, nil
 }
 
+// GetTestResult executes the TPM2_GetTestResult command, which returns the status of the TPM's self tests. The returned MaxBuffer
+// contains vendor specific data. The returned ResponseCode describes the overall health of the TPM, and will be Success if all
+// tests completed successfully, a code corresponding to WarningTesting if tests are still in progress or are yet to be performed,
+// or a code corresponding to ErrorFailure if the TPM is in failure mode. It can be passed to DecodeResponseCode in order to convert
+// it in to an error type.
 func (t *TPMContext) GetTestResult(sessions ...SessionContext) (MaxBuffer, ResponseCode, error) {
 	var outData MaxBuffer
 	var testResult ResponseCode
@@ -0,0 +1,73 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+)
+
+func TestSelfTestTesting(t *testing.T) {
+	submissions := 0
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		submissions++
+		return makeMockResponse(ResponseCode(0x90a), nil, nil)
+	}}
+	tpm, _ := NewTPMContext(tcti)
+
+	err := tpm.SelfTest(false)
+	if !IsTPMWarning(err, WarningTesting, CommandSelfTest) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if submissions != 1 {
+		t.Errorf("Unexpected number of submissions: %d", submissions)
+	}
+}
+
+func TestIncrementalSelfTestMock(t *testing.T) {
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		var toTest AlgorithmList
+		if _, err := mu.UnmarshalFromBytes(cmd[10:], &toTest); err != nil {
+			return nil
+		}
+		params, _ := mu.MarshalToBytes(toTest[1:])
+		return makeMockResponse(Success, nil, params)
+	}}
+	tpm, _ := NewTPMContext(tcti)
+
+	toDoList, err := tpm.IncrementalSelfTest(AlgorithmList{AlgorithmRSA, AlgorithmSHA256, AlgorithmAES})
+	if err != nil {
+		t.Fatalf("IncrementalSelfTest failed: %v", err)
+	}
+	if !reflect.DeepEqual(toDoList, AlgorithmList{AlgorithmSHA256, AlgorithmAES}) {
+		t.Errorf("Unexpected list of untested algorithms: %v", toDoList)
+	}
+}
+
+func TestGetTestResultMock(t *testing.T) {
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		if !bytes.Equal(cmd[6:10], []byte{0x00, 0x00, 0x01, 0x7c}) {
+			return nil
+		}
+		params, _ := mu.MarshalToBytes(MaxBuffer{0x01, 0x02, 0x03}, ResponseCode(0x90a))
+		return makeMockResponse(Success, nil, params)
+	}}
+	tpm, _ := NewTPMContext(tcti)
+
+	outData, testResult, err := tpm.GetTestResult()
+	if err != nil {
+		t.Fatalf("GetTestResult failed: %v", err)
+	}
+	if !bytes.Equal(outData, []byte{0x01, 0x02, 0x03}) {
+		t.Errorf("Unexpected vendor data: %x", outData)
+	}
+	if !IsTPMWarning(DecodeResponseCode(CommandGetTestResult, testResult), WarningTesting, CommandGetTestResult) {
+		t.Errorf("Unexpected test result: %v", testResult)
+	}
+}
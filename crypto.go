@@ -5,11 +5,14 @@
 package tpm2
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"math/big"
@@ -38,6 +41,46 @@ func eccCurveToGoCurve(curve ECCCurve) elliptic.Curve {
 	return nil
 }
 
+func cryptGetPublicKey(public *Public) (crypto.PublicKey, error) {
+	switch public.Type {
+	case ObjectTypeRSA:
+		params, ok := public.Params.Data.(*RSAParams)
+		if !ok {
+			return nil, errors.New("invalid RSA parameters")
+		}
+		modulus, ok := public.Unique.Data.(PublicKeyRSA)
+		if !ok || len(modulus) == 0 {
+			return nil, errors.New("invalid RSA public key")
+		}
+		exp := int(params.Exponent)
+		if exp == 0 {
+			exp = DefaultRSAExponent
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: exp}, nil
+	case ObjectTypeECC:
+		params, ok := public.Params.Data.(*ECCParams)
+		if !ok {
+			return nil, errors.New("invalid ECC parameters")
+		}
+		point, ok := public.Unique.Data.(*ECCPoint)
+		if !ok {
+			return nil, errors.New("invalid ECC public key")
+		}
+		curve := eccCurveToGoCurve(params.CurveID)
+		if curve == nil {
+			return nil, fmt.Errorf("unsupported curve: %v", params.CurveID)
+		}
+		x := new(big.Int).SetBytes(point.X)
+		y := new(big.Int).SetBytes(point.Y)
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("public key is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", public.Type)
+	}
+}
+
 func cryptComputeCpHash(hashAlg HashAlgorithmId, commandCode CommandCode, commandHandles []Name,
 	cpBytes []byte) []byte {
 	hash := hashAlg.NewHash()
@@ -80,9 +123,9 @@ func cryptComputeSessionCommandHMAC(context *sessionContext, key, cpHash []byte,
 	return computeSessionHMAC(scData.HashAlg, key, cpHash, scData.NonceCaller, scData.NonceTPM, nonceDecrypt, nonceEncrypt, attrs)
 }
 
-func cryptComputeSessionResponseHMAC(context *sessionContext, key, rpHash []byte, attrs sessionAttrs) []byte {
+func cryptComputeSessionResponseHMAC(context *sessionContext, key, rpHash []byte, nonceTPM Nonce, attrs sessionAttrs) []byte {
 	scData := context.scData()
-	return computeSessionHMAC(scData.HashAlg, key, rpHash, scData.NonceTPM, scData.NonceCaller, nil, nil, attrs)
+	return computeSessionHMAC(scData.HashAlg, key, rpHash, nonceTPM, scData.NonceCaller, nil, nil, attrs)
 }
 
 func cryptComputeNonce(nonce []byte) error {
@@ -95,11 +138,11 @@ func cryptEncryptRSA(public *Public, paddingOverride RSASchemeId, data, label []
 		panic(fmt.Sprintf("Unsupported key type %v", public.Type))
 	}
 
-	exp := int(public.Params.RSADetail().Exponent)
-	if exp == 0 {
-		exp = DefaultRSAExponent
+	key, err := cryptGetPublicKey(public)
+	if err != nil {
+		return nil, err
 	}
-	pubKey := &rsa.PublicKey{N: new(big.Int).SetBytes(public.Unique.RSA()), E: exp}
+	pubKey := key.(*rsa.PublicKey)
 
 	padding := public.Params.RSADetail().Scheme.Scheme
 	if paddingOverride != RSASchemeNull {
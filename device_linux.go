@@ -7,7 +7,10 @@ package tpm2
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"golang.org/x/xerrors"
@@ -21,17 +24,73 @@ const (
 type TctiDeviceLinux struct {
 	f   *os.File
 	buf *bytes.Reader
+
+	wakeR, wakeW int // Pipe used to interrupt a pending poll when the read deadline changes
+
+	deadlineMu sync.Mutex // Protects deadline
+	deadline   time.Time
 }
 
-func (d *TctiDeviceLinux) readMoreData() error {
-	fds := []unix.PollFd{unix.PollFd{Fd: int32(d.f.Fd()), Events: unix.POLLIN}}
-	_, err := unix.Ppoll(fds, nil, nil)
-	if err != nil {
-		return xerrors.Errorf("polling device failed: %w", err)
+// deadlineExceededError is returned from TctiDeviceLinux.Read when the read deadline expires.
+type deadlineExceededError struct{}
+
+func (deadlineExceededError) Error() string   { return "i/o timeout" }
+func (deadlineExceededError) Timeout() bool   { return true }
+func (deadlineExceededError) Temporary() bool { return true }
+
+func (d *TctiDeviceLinux) drainWakePipe() {
+	var buf [64]byte
+	for {
+		if n, err := unix.Read(d.wakeR, buf[:]); n <= 0 || err != nil {
+			return
+		}
 	}
+}
+
+func (d *TctiDeviceLinux) readMoreData() error {
+	fds := []unix.PollFd{
+		unix.PollFd{Fd: int32(d.f.Fd()), Events: unix.POLLIN},
+		unix.PollFd{Fd: int32(d.wakeR), Events: unix.POLLIN}}
+
+	for {
+		d.deadlineMu.Lock()
+		deadline := d.deadline
+		d.deadlineMu.Unlock()
+
+		var timeout *unix.Timespec
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return deadlineExceededError{}
+			}
+			ts := unix.NsecToTimespec(remaining.Nanoseconds())
+			timeout = &ts
+		}
+
+		fds[0].Revents = 0
+		fds[1].Revents = 0
+		n, err := unix.Ppoll(fds, timeout, nil)
+		switch {
+		case err == unix.EINTR:
+			continue
+		case err != nil:
+			return xerrors.Errorf("polling device failed: %w", err)
+		case n == 0:
+			// Timed out - the deadline is checked again at the start of the loop.
+			continue
+		}
 
-	if fds[0].Events != fds[0].Revents {
-		return fmt.Errorf("invalid poll events returned: %d", fds[0].Revents)
+		if fds[1].Revents != 0 {
+			// The read deadline changed.
+			d.drainWakePipe()
+		}
+		if fds[0].Revents == 0 {
+			continue
+		}
+		if fds[0].Events != fds[0].Revents {
+			return fmt.Errorf("invalid poll events returned: %d", fds[0].Revents)
+		}
+		break
 	}
 
 	buf := make([]byte, maxCommandSize)
@@ -44,6 +103,8 @@ func (d *TctiDeviceLinux) readMoreData() error {
 	return nil
 }
 
+// Read reads response data from the TPM. The kernel returns the complete response packet from a single read, which is buffered so
+// that it can be consumed with multiple calls.
 func (d *TctiDeviceLinux) Read(data []byte) (int, error) {
 	if d.buf == nil || d.buf.Len() == 0 {
 		if err := d.readMoreData(); err != nil {
@@ -54,17 +115,46 @@ func (d *TctiDeviceLinux) Read(data []byte) (int, error) {
 	return d.buf.Read(data)
 }
 
+// Write submits the command packet in data to the TPM. The kernel requires that a complete command packet is submitted in a single
+// write, so this returns an error if the device doesn't accept all of it. Any unread data from a previous response is discarded.
 func (d *TctiDeviceLinux) Write(data []byte) (int, error) {
-	return d.f.Write(data)
+	d.buf = nil
+	n, err := d.f.Write(data)
+	if err != nil {
+		return n, err
+	}
+	if n != len(data) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// SetReadDeadline sets the time after which a pending or subsequent Read fails with a timeout error if it is waiting for a response
+// from the TPM. A zero value disables the deadline. Response data that has already been received can still be read after the
+// deadline has expired. This implements TCTIWithReadDeadline.
+func (d *TctiDeviceLinux) SetReadDeadline(t time.Time) error {
+	d.deadlineMu.Lock()
+	d.deadline = t
+	d.deadlineMu.Unlock()
+
+	// Wake up a pending Read so that it picks up the new deadline.
+	if _, err := unix.Write(d.wakeW, []byte{0}); err != nil && err != unix.EAGAIN {
+		return xerrors.Errorf("cannot wake up pending read: %w", err)
+	}
+	return nil
 }
 
 func (d *TctiDeviceLinux) Close() error {
+	unix.Close(d.wakeR)
+	unix.Close(d.wakeW)
 	return d.f.Close()
 }
 
 // OpenTPMDevice attempts to open a connection to the Linux TPM character device at the specified path. If successful, it returns a
 // new TctiDeviceLinux instance which can be passed to NewTPMContext. Failure to open the TPM character device will result in a
-// wrapped *os.PathError being returned
+// wrapped *os.PathError being returned, which can be obtained with xerrors.As. If the caller does not have permission to access the
+// device, os.IsPermission will return true for the unwrapped *os.PathError, in which case the permissions of the device node
+// (normally configured by udev rules) should be checked.
 func OpenTPMDevice(path string) (*TctiDeviceLinux, error) {
 	f, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
@@ -73,12 +163,20 @@ func OpenTPMDevice(path string) (*TctiDeviceLinux, error) {
 
 	s, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, xerrors.Errorf("cannot stat linux TPM device: %w", err)
 	}
 
 	if s.Mode()&os.ModeDevice == 0 {
+		f.Close()
 		return nil, fmt.Errorf("unsupported file mode %v", s.Mode())
 	}
 
-	return &TctiDeviceLinux{f: f}, nil
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		f.Close()
+		return nil, xerrors.Errorf("cannot create pipe: %w", err)
+	}
+
+	return &TctiDeviceLinux{f: f, wakeR: p[0], wakeW: p[1]}, nil
 }
@@ -0,0 +1,50 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+
+	"golang.org/x/xerrors"
+)
+
+func TestOpenTPMDeviceErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-tpm2-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("NotExist", func(t *testing.T) {
+		_, err := OpenTPMDevice(filepath.Join(dir, "tpm0"))
+		if err == nil {
+			t.Fatalf("OpenTPMDevice should have failed")
+		}
+		var pe *os.PathError
+		if !xerrors.As(err, &pe) || !os.IsNotExist(pe) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("NotADevice", func(t *testing.T) {
+		path := filepath.Join(dir, "file")
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		_, err := OpenTPMDevice(path)
+		if err == nil {
+			t.Fatalf("OpenTPMDevice should have failed")
+		}
+		if !strings.HasPrefix(err.Error(), "unsupported file mode") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+}
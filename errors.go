@@ -6,6 +6,7 @@ package tpm2
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 
 	"golang.org/x/xerrors"
@@ -36,6 +37,17 @@ const (
 	AnyWarningCode WarningCode = 0x80
 )
 
+// ErrResourceDoesNotExist is returned from TPMContext.RunCommand and any TPMContext method that executes a command if it is called
+// with a HandleContext that has been invalidated because the corresponding resource no longer exists on the TPM. A HandleContext is
+// invalidated when the resource is flushed, evicted or undefined using the TPMContext, and ResourceContexts cached by
+// TPMContext.CreateResourceContextFromTPM are invalidated when TPMContext.Clear removes the corresponding resources. It is wrapped
+// in other errors, so xerrors.Is should be used to test for it. No command is submitted to the TPM in this case.
+var ErrResourceDoesNotExist = errors.New("resource has been closed")
+
+// InvalidResponseCode is returned from EncodeResponseCode and ResponseCodeFromError for errors that cannot be encoded as a
+// ResponseCode. It isn't a value that a TPM can return.
+const InvalidResponseCode ResponseCode = 0xffffffff
+
 // ResourceUnavailableError is returned from TPMContext.GetOrCreateResourceContext or TPMContext.GetOrCreateSessionContext if it is
 // called with a handle that does not correspond to a resource that is available on the TPM. This could be because the resource
 // doesn't exist on the TPM, or it lives within a hierarchy that is disabled.
@@ -47,12 +59,29 @@ func (e ResourceUnavailableError) Error() string {
 	return fmt.Sprintf("a resource at handle 0x%08x is not available on the TPM", e.Handle)
 }
 
+// ResourceNameMismatchError is returned from TPMContext.RunCommand and any TPMContext method that executes a command if verification
+// of resource names is enabled (see TPMContext.SetVerifyResourceNames) and the name of a resource reported by the TPM doesn't match
+// the name of the corresponding ResourceContext. This indicates that the resource at Handle is not the one that the ResourceContext
+// was created for, and so the authorization of the command would not be bound to the expected resource. The command is not submitted
+// to the TPM in this case.
+type ResourceNameMismatchError struct {
+	Command  CommandCode
+	Handle   Handle
+	Expected Name // The name of the ResourceContext
+	Actual   Name // The name reported by the TPM
+}
+
+func (e *ResourceNameMismatchError) Error() string {
+	return fmt.Sprintf("cannot verify authorization binding for command %s: name of resource at handle 0x%08x reported by the TPM (%x) "+
+		"doesn't match the expected name (%x)", e.Command, e.Handle, []byte(e.Actual), []byte(e.Expected))
+}
+
 // InvalidResponseError is returned from any TPMContext method that executes a TPM command if the TPM's response is invalid. An
 // invalid response could be one that is shorter than the response header, one with an invalid responseSize field, a payload that is
-// shorter than the responseSize field indicates, a payload that unmarshals incorrectly because of an invalid union selector value,
-// or an invalid response authorization.
+// shorter than the responseSize field indicates, or a payload that unmarshals incorrectly because of an invalid union selector
+// value. An invalid response authorization is indicated by *InvalidResponseAuthError instead.
 //
-// Any sessions used in the command that caused this error should be considered invalid.
+// Any sessions used in the command that caused this error are marked as unusable, and should be flushed with TPMContext.FlushContext.
 //
 // If any function that executes a command which allocates objects on the TPM returns this error, it is possible that these objects
 // were allocated and now exist on the TPM without a corresponding HandleContext being created or any knowledge of the handle of
@@ -69,6 +98,54 @@ func (e *InvalidResponseError) Error() string {
 	return fmt.Sprintf("TPM returned an invalid response for command %s: %v", e.Command, e.msg)
 }
 
+// InvalidResponseAuthError is returned from any TPMContext method that executes a TPM command if the response authorization for
+// one of the sessions used in the command fails verification, such as when the response HMAC doesn't match the expected value or a
+// policy session with a TPM2_PolicyPassword assertion has a non-empty response HMAC. This indicates that the response may not have
+// been produced by the TPM, or that it was modified in transit.
+//
+// Any sessions used in the command that caused this error are marked as unusable, and should be flushed with TPMContext.FlushContext.
+// As with *InvalidResponseError, the TPM may have executed the command.
+type InvalidResponseAuthError struct {
+	Command CommandCode
+	Index   int // Index of the session in the authorization area, starting from 1
+	msg     string
+}
+
+func (e *InvalidResponseAuthError) Error() string {
+	return fmt.Sprintf("TPM returned an invalid response authorization for session %d for command %s: %v", e.Index, e.Command, e.msg)
+}
+
+// CommandExecutionError is returned from TPMContext.RunCommand and any TPMContext method that executes a command if an error occurs
+// once the command packet has been constructed, such as if the transmission interface returns an error, the response is invalid or
+// the TPM responds with an error. It contains the command code and the complete command packet that was sent to the TPM, which is
+// useful for debugging.
+//
+// The original error is returned from Unwrap, so functions such as IsTPMError, xerrors.Is and xerrors.As can be used to test for
+// specific errors. The string representation of this error is the same as that of the original error.
+type CommandExecutionError struct {
+	Command      CommandCode
+	CommandBytes []byte // The complete command packet, including the header
+	err          error
+}
+
+func (e *CommandExecutionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *CommandExecutionError) Unwrap() error {
+	return e.err
+}
+
+// InvalidAttestMagicError is returned from VerifyAttestationSignature if the Magic field of the supplied attestation structure is
+// not TPMGeneratedValue. This indicates that the structure was not generated by a TPM.
+type InvalidAttestMagicError struct {
+	Magic TPMGenerated
+}
+
+func (e *InvalidAttestMagicError) Error() string {
+	return fmt.Sprintf("invalid attestation magic value 0x%08x", uint32(e.Magic))
+}
+
 // TctiError is returned from any TPMContext method if the underlying TCTI returns an error.
 type TctiError struct {
 	Op  string // The operation that caused the error
@@ -94,6 +171,11 @@ func (e *TPM1Error) Error() string {
 	return fmt.Sprintf("TPM returned a 1.2 error whilst executing command %s: 0x%08x", e.Command, e.Code)
 }
 
+// ResponseCode returns the response code associated with this error.
+func (e *TPM1Error) ResponseCode() ResponseCode {
+	return e.Code
+}
+
 // TPMVendorError is returned from DecodeResponseCode and and TPMContext method that executes a command on the TPM if the TPM response
 // code indicates a vendor-specific error.
 type TPMVendorError struct {
@@ -105,6 +187,11 @@ func (e *TPMVendorError) Error() string {
 	return fmt.Sprintf("TPM returned a vendor defined error whilst executing command %s: 0x%08x", e.Command, e.Code)
 }
 
+// ResponseCode returns the response code associated with this error.
+func (e *TPMVendorError) ResponseCode() ResponseCode {
+	return e.Code
+}
+
 // WarningCode represents a response from the TPM that is not necessarily an error.
 type WarningCode ResponseCode
 
@@ -124,6 +211,21 @@ func (e *TPMWarning) Error() string {
 	return builder.String()
 }
 
+// ResponseCode returns the response code that corresponds to this error.
+func (e *TPMWarning) ResponseCode() ResponseCode {
+	return EncodeResponseCode(e)
+}
+
+// Is indicates whether target is a *TPMWarning that matches this error, for the benefit of errors.Is. The target may use
+// AnyWarningCode and AnyCommandCode to match any warning code or command code.
+func (e *TPMWarning) Is(target error) bool {
+	t, ok := target.(*TPMWarning)
+	if !ok || t == nil {
+		return false
+	}
+	return (t.Code == AnyWarningCode || e.Code == t.Code) && (t.Command == AnyCommandCode || e.Command == t.Command)
+}
+
 // ErrorCode represents an error code from the TPM.
 type ErrorCode ResponseCode
 
@@ -143,6 +245,25 @@ func (e *TPMError) Error() string {
 	return builder.String()
 }
 
+// ResponseCode returns the response code that corresponds to this error.
+func (e *TPMError) ResponseCode() ResponseCode {
+	return encodeTPMError(e)
+}
+
+func (e *TPMError) matches(target *TPMError) bool {
+	if target == nil {
+		return false
+	}
+	return (target.Code == AnyErrorCode || e.Code == target.Code) && (target.Command == AnyCommandCode || e.Command == target.Command)
+}
+
+// Is indicates whether target is a *TPMError that matches this error, for the benefit of errors.Is. The target may use
+// AnyErrorCode and AnyCommandCode to match any error code or command code.
+func (e *TPMError) Is(target error) bool {
+	t, ok := target.(*TPMError)
+	return ok && e.matches(t)
+}
+
 // TPMParameterError is returned from DecodeResponseCode and any TPMContext method that executes a command on the TPM if the TPM
 // response code indicates an error that is associated with a command parameter. It wraps a *TPMError.
 type TPMParameterError struct {
@@ -159,10 +280,29 @@ func (e *TPMParameterError) Error() string {
 	return builder.String()
 }
 
+// ResponseCode returns the response code that corresponds to this error. If the error code cannot be associated with a handle,
+// parameter or session, the response code that corresponds to the wrapped *TPMError is returned instead.
+func (e *TPMParameterError) ResponseCode() ResponseCode {
+	if rc, ok := encodeFmt1ResponseCode(e.TPMError, fmt1ParameterMask|(ResponseCode(e.Index)<<fmt1IndexShift)&fmt1ParameterIndexMask); ok {
+		return rc
+	}
+	return e.TPMError.ResponseCode()
+}
+
 func (e *TPMParameterError) Unwrap() error {
 	return e.TPMError
 }
 
+// Is indicates whether target is a *TPMParameterError that matches this error, for the benefit of errors.Is. The target may use
+// AnyErrorCode, AnyCommandCode and AnyParameterIndex to match any error code, command code or parameter index.
+func (e *TPMParameterError) Is(target error) bool {
+	t, ok := target.(*TPMParameterError)
+	if !ok || t == nil {
+		return false
+	}
+	return e.TPMError.matches(t.TPMError) && (t.Index == AnyParameterIndex || e.Index == t.Index)
+}
+
 // TPMSessionError is returned from DecodeResponseCode and any TPMContext method that executes a command on the TPM if the TPM
 // response code indicates an error that is associated with a session. It wraps a *TPMError.
 type TPMSessionError struct {
@@ -179,10 +319,29 @@ func (e *TPMSessionError) Error() string {
 	return builder.String()
 }
 
+// ResponseCode returns the response code that corresponds to this error. If the error code cannot be associated with a handle,
+// parameter or session, the response code that corresponds to the wrapped *TPMError is returned instead.
+func (e *TPMSessionError) ResponseCode() ResponseCode {
+	if rc, ok := encodeFmt1ResponseCode(e.TPMError, fmt1SessionMask|(ResponseCode(e.Index)<<fmt1IndexShift)&fmt1HandleOrSessionIndexMask); ok {
+		return rc
+	}
+	return e.TPMError.ResponseCode()
+}
+
 func (e *TPMSessionError) Unwrap() error {
 	return e.TPMError
 }
 
+// Is indicates whether target is a *TPMSessionError that matches this error, for the benefit of errors.Is. The target may use
+// AnyErrorCode, AnyCommandCode and AnySessionIndex to match any error code, command code or session index.
+func (e *TPMSessionError) Is(target error) bool {
+	t, ok := target.(*TPMSessionError)
+	if !ok || t == nil {
+		return false
+	}
+	return e.TPMError.matches(t.TPMError) && (t.Index == AnySessionIndex || e.Index == t.Index)
+}
+
 // TPMHandleError is returned from DecodeResponseCode and any TPMContext method that executes a command on the TPM if the TPM
 // response code indicates an error that is associated with a command handle. It wraps a *TPMError.
 type TPMHandleError struct {
@@ -201,10 +360,29 @@ func (e *TPMHandleError) Error() string {
 	return builder.String()
 }
 
+// ResponseCode returns the response code that corresponds to this error. If the error code cannot be associated with a handle,
+// parameter or session, the response code that corresponds to the wrapped *TPMError is returned instead.
+func (e *TPMHandleError) ResponseCode() ResponseCode {
+	if rc, ok := encodeFmt1ResponseCode(e.TPMError, (ResponseCode(e.Index)<<fmt1IndexShift)&fmt1HandleOrSessionIndexMask); ok {
+		return rc
+	}
+	return e.TPMError.ResponseCode()
+}
+
 func (e *TPMHandleError) Unwrap() error {
 	return e.TPMError
 }
 
+// Is indicates whether target is a *TPMHandleError that matches this error, for the benefit of errors.Is. The target may use
+// AnyErrorCode, AnyCommandCode and AnyHandleIndex to match any error code, command code or handle index.
+func (e *TPMHandleError) Is(target error) bool {
+	t, ok := target.(*TPMHandleError)
+	if !ok || t == nil {
+		return false
+	}
+	return e.TPMError.matches(t.TPMError) && (t.Index == AnyHandleIndex || e.Index == t.Index)
+}
+
 func AsResourceUnavailableError(err error, handle Handle, out *ResourceUnavailableError) bool {
 	return xerrors.As(err, out) && (handle == AnyHandle || (*out).Handle == handle)
 }
@@ -311,6 +489,9 @@ const (
 // DecodeResponseCode decodes the ResponseCode provided via resp. If the specified response code is Success, it returns no error,
 // else it returns an error that is appropriate for the response code. The command code is used for adding context to the returned
 // error.
+//
+// Every possible 32-bit value is handled, including those with reserved bits set. This function never returns a nil error for a
+// response code other than Success.
 func DecodeResponseCode(command CommandCode, resp ResponseCode) error {
 	switch {
 	case resp == ResponseCode(Success):
@@ -343,3 +524,94 @@ func DecodeResponseCode(command CommandCode, resp ResponseCode) error {
 
 	}
 }
+
+// EncodeResponseCode is the inverse of DecodeResponseCode, and returns the ResponseCode that the TPM would return for the specified
+// error. This is useful for constructing responses in tests and mock TPM implementations. If err is nil, Success is returned.
+// Otherwise, err must be one of the *TPMError, *TPMParameterError, *TPMSessionError, *TPMHandleError, *TPMWarning, *TPMVendorError
+// or *TPM1Error types, else InvalidResponseCode is returned. The command code associated with err is ignored. To obtain the
+// response code for an error returned from a TPMContext method, which may be wrapped, use ResponseCodeFromError.
+//
+// The parameter index of a *TPMParameterError must be between 1 and 15, and the handle or session index of a *TPMHandleError or
+// *TPMSessionError must be between 0 and 7. The Code field of a *TPMError and the errors that wrap it must be a valid ErrorCode. The
+// Code field of a *TPMParameterError, *TPMSessionError or *TPMHandleError must also be a format-one error code, as only these can be
+// associated with a handle, parameter or session, else InvalidResponseCode is returned.
+func EncodeResponseCode(err error) ResponseCode {
+	var rc ResponseCode
+	var ok bool
+
+	switch e := err.(type) {
+	case nil:
+		return ResponseCode(Success)
+	case *TPMParameterError:
+		rc, ok = encodeFmt1ResponseCode(e.TPMError, fmt1ParameterMask|(ResponseCode(e.Index)<<fmt1IndexShift)&fmt1ParameterIndexMask)
+	case *TPMSessionError:
+		rc, ok = encodeFmt1ResponseCode(e.TPMError, fmt1SessionMask|(ResponseCode(e.Index)<<fmt1IndexShift)&fmt1HandleOrSessionIndexMask)
+	case *TPMHandleError:
+		rc, ok = encodeFmt1ResponseCode(e.TPMError, (ResponseCode(e.Index)<<fmt1IndexShift)&fmt1HandleOrSessionIndexMask)
+	case *TPMError:
+		return encodeTPMError(e)
+	case *TPMWarning:
+		return fmt0VersionMask | fmt0SeverityMask | ResponseCode(e.Code)&fmt0ErrorCodeMask
+	case *TPMVendorError:
+		return e.Code
+	case *TPM1Error:
+		return e.Code
+	}
+
+	if !ok {
+		return InvalidResponseCode
+	}
+	return rc
+}
+
+// ResponseCodeFromError returns the ResponseCode that the TPM returned for the specified error, which may be an error returned from
+// a TPMContext method that wraps one of the error types supported by EncodeResponseCode. It walks the chain of wrapped errors with
+// xerrors.Unwrap and returns the response code of the first error that can be encoded with EncodeResponseCode. If err is nil,
+// Success is returned. If err doesn't contain an error that can be encoded, InvalidResponseCode is returned.
+func ResponseCodeFromError(err error) ResponseCode {
+	if err == nil {
+		return ResponseCode(Success)
+	}
+	for ; err != nil; err = xerrors.Unwrap(err) {
+		if rc := EncodeResponseCode(err); rc != InvalidResponseCode {
+			return rc
+		}
+	}
+	return InvalidResponseCode
+}
+
+func encodeTPMError(e *TPMError) ResponseCode {
+	if e.Code >= errorCode1Start {
+		return formatMask | ResponseCode(e.Code-errorCode1Start)&fmt1ErrorCodeMask
+	}
+	return fmt0VersionMask | ResponseCode(e.Code)&fmt0ErrorCodeMask
+}
+
+// encodeFmt1ResponseCode encodes the supplied *TPMError as a format-one response code with the specified handle, parameter or
+// session bits. It returns false if the error code cannot be associated with a handle, parameter or session.
+func encodeFmt1ResponseCode(e *TPMError, bits ResponseCode) (ResponseCode, bool) {
+	if e == nil || e.Code < errorCode1Start {
+		return 0, false
+	}
+	return encodeTPMError(e) | bits, true
+}
+
+// DecodeResponseCodeDetails decodes the fields of the ResponseCode provided via resp without constructing an error. The format
+// return value is 0 for format-zero response codes and 1 for format-one response codes. The code return value is the error or
+// warning number, which does not include the offset that distinguishes format-one ErrorCode values. For format-one response codes,
+// index is the parameter, handle or session index associated with the error, and is zero otherwise. The isVendor and isWarning
+// return values indicate whether a format-zero response code is vendor defined or a warning respectively.
+//
+// This performs no validation of resp, and the results are meaningless for Success and for TPM 1.2 response codes.
+func DecodeResponseCodeDetails(resp ResponseCode) (format int, code uint8, index int, isVendor, isWarning bool) {
+	if resp&formatMask == 0 {
+		return 0, uint8(resp & fmt0ErrorCodeMask), 0, resp&fmt0VendorMask > 0, resp&fmt0SeverityMask > 0
+	}
+
+	if resp&fmt1ParameterMask > 0 {
+		index = int((resp & fmt1ParameterIndexMask) >> fmt1IndexShift)
+	} else {
+		index = int((resp & fmt1HandleOrSessionIndexMask) >> fmt1IndexShift)
+	}
+	return 1, uint8(resp & fmt1ErrorCodeMask), index, false, false
+}
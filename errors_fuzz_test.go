@@ -0,0 +1,23 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+//go:build go1.18
+// +build go1.18
+
+package tpm2_test
+
+import (
+	"testing"
+
+	. "github.com/canonical/go-tpm2"
+)
+
+func FuzzDecodeResponseCode(f *testing.F) {
+	for _, rc := range []uint32{0, 0x155, 0xa5a5057e, 0x923, 0x5e7, 0xb9c, 0x496, 0x84, 0xffffffff} {
+		f.Add(rc)
+	}
+	f.Fuzz(func(t *testing.T, rc uint32) {
+		checkDecodedResponseCode(t, ResponseCode(rc))
+	})
+}
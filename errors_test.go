@@ -5,9 +5,13 @@
 package tpm2_test
 
 import (
+	"bytes"
+	"reflect"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+
+	"golang.org/x/xerrors"
 )
 
 func TestDecodeResponse(t *testing.T) {
@@ -60,3 +64,273 @@ func TestDecodeResponse(t *testing.T) {
 		t.Errorf("Unexpected error: %v", err)
 	}
 }
+
+func checkDecodedResponseCode(t *testing.T, rc ResponseCode) {
+	err := DecodeResponseCode(CommandStartup, rc)
+	if rc == ResponseCode(Success) {
+		if err != nil {
+			t.Errorf("Unexpected error for success: %v", err)
+		}
+		return
+	}
+
+	switch e := err.(type) {
+	case *TPM1Error:
+		if e.Code != rc {
+			t.Errorf("Unexpected code for response code 0x%08x: 0x%08x", rc, e.Code)
+		}
+	case *TPMVendorError:
+		if e.Code != rc {
+			t.Errorf("Unexpected code for response code 0x%08x: 0x%08x", rc, e.Code)
+		}
+	case *TPMWarning:
+		if e.Code >= AnyWarningCode {
+			t.Errorf("Unexpected warning code for response code 0x%08x: %v", rc, e.Code)
+		}
+	case *TPMError, *TPMParameterError, *TPMSessionError, *TPMHandleError:
+		if !IsTPMError(err, AnyErrorCode, CommandStartup) {
+			t.Errorf("Response code 0x%08x did not produce a *TPMError", rc)
+		}
+	default:
+		t.Errorf("Unexpected error type for response code 0x%08x: %T (%v)", rc, err, err)
+	}
+	if err != nil {
+		// Make sure that formatting the error doesn't panic either.
+		_ = err.Error()
+	}
+}
+
+func TestDecodeResponseCodeIsTotal(t *testing.T) {
+	// The low 12 bits contain all of the fields interpreted by DecodeResponseCode. Test all combinations of these with a
+	// selection of values for the reserved upper bits.
+	for _, upper := range []ResponseCode{0, 0x1000, 0xa5a5a000, 0xfffff000} {
+		for lower := ResponseCode(0); lower <= 0xfff; lower++ {
+			checkDecodedResponseCode(t, upper|lower)
+		}
+	}
+}
+
+func TestEncodeResponseCode(t *testing.T) {
+	for _, err := range []error{
+		nil,
+		&TPMError{Command: CommandClear, Code: ErrorSensitive},
+		&TPMError{Command: CommandClear, Code: ErrorValue},
+		&TPMParameterError{TPMError: &TPMError{Command: CommandClear, Code: ErrorECCPoint}, Index: 5},
+		&TPMParameterError{TPMError: &TPMError{Command: CommandClear, Code: ErrorSize}, Index: 15},
+		&TPMSessionError{TPMError: &TPMError{Command: CommandClear, Code: ErrorKey}, Index: 3},
+		&TPMHandleError{TPMError: &TPMError{Command: CommandClear, Code: ErrorSymmetric}, Index: 4},
+		&TPMWarning{Command: CommandClear, Code: WarningRetry},
+		&TPMWarning{Command: CommandClear, Code: WarningNVUnavailable},
+		&TPMVendorError{Command: CommandClear, Code: 0xa5a5057e},
+		&TPM1Error{Command: CommandClear, Code: 0x00000003},
+	} {
+		rc := EncodeResponseCode(err)
+		if rc == InvalidResponseCode {
+			t.Errorf("Cannot encode %v", err)
+			continue
+		}
+		decoded := DecodeResponseCode(CommandClear, rc)
+		if !reflect.DeepEqual(decoded, err) {
+			t.Errorf("Unexpected round trip for %v: %v", err, decoded)
+		}
+	}
+
+	// Format-zero TPM 2.0 errors and warnings don't retain bit 9, which is reserved.
+	for rc := ResponseCode(0); rc <= 0xfff; rc++ {
+		if rc&0x780 == 0x300 {
+			continue
+		}
+		if encoded := EncodeResponseCode(DecodeResponseCode(CommandClear, rc)); encoded != rc {
+			t.Errorf("Unexpected round trip for response code 0x%08x: 0x%08x", rc, encoded)
+		}
+	}
+}
+
+func TestEncodeResponseCodeInvalid(t *testing.T) {
+	for _, err := range []error{
+		xerrors.New("some error"),
+		&CommandExecutionError{Command: CommandClear},
+		&TPMParameterError{TPMError: &TPMError{Command: CommandClear, Code: ErrorSensitive}, Index: 1},
+		&TPMSessionError{TPMError: &TPMError{Command: CommandClear, Code: ErrorAuthMissing}, Index: 1},
+		&TPMHandleError{TPMError: &TPMError{Command: CommandClear, Code: ErrorInitialize}, Index: 1},
+	} {
+		if rc := EncodeResponseCode(err); rc != InvalidResponseCode {
+			t.Errorf("Unexpected response code for %v: 0x%08x", err, rc)
+		}
+	}
+
+	// The ResponseCode accessor falls back to the response code of the wrapped *TPMError.
+	err := &TPMParameterError{TPMError: &TPMError{Command: CommandClear, Code: ErrorSensitive}, Index: 1}
+	if rc := err.ResponseCode(); rc != 0x155 {
+		t.Errorf("Unexpected response code: 0x%08x", rc)
+	}
+}
+
+func TestResponseCodeFromError(t *testing.T) {
+	// Errors returned from TPMContext methods are wrapped in a *CommandExecutionError.
+	rsp := makeMockResponse(ResponseCode(0x5e7), nil, nil)
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+	_, err := tpm.GetRandom(16)
+	if _, isExecErr := err.(*CommandExecutionError); !isExecErr {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rc := ResponseCodeFromError(err); rc != 0x5e7 {
+		t.Errorf("Unexpected response code: 0x%08x", rc)
+	}
+
+	if rc := ResponseCodeFromError(xerrors.Errorf("cannot do something: %w", &TPMWarning{Command: CommandClear, Code: WarningRetry})); rc != 0x922 {
+		t.Errorf("Unexpected response code for wrapped warning: 0x%08x", rc)
+	}
+	if rc := ResponseCodeFromError(nil); rc != ResponseCode(Success) {
+		t.Errorf("Unexpected response code for nil error: 0x%08x", rc)
+	}
+	if rc := ResponseCodeFromError(xerrors.Errorf("cannot do something: %w", xerrors.New("some error"))); rc != InvalidResponseCode {
+		t.Errorf("Unexpected response code for unencodable error: 0x%08x", rc)
+	}
+}
+
+func TestDecodeResponseCodeDetails(t *testing.T) {
+	for _, data := range []struct {
+		rc        ResponseCode
+		format    int
+		code      uint8
+		index     int
+		isVendor  bool
+		isWarning bool
+	}{
+		{rc: 0x155, format: 0, code: 0x55},
+		{rc: 0x922, format: 0, code: 0x22, isWarning: true},
+		{rc: 0x57e, format: 0, code: 0x7e, isVendor: true},
+		{rc: 0x5e7, format: 1, code: 0x27, index: 5},
+		{rc: 0xb9c, format: 1, code: 0x1c, index: 3},
+		{rc: 0x496, format: 1, code: 0x16, index: 4},
+		{rc: 0x84, format: 1, code: 0x04},
+	} {
+		format, code, index, isVendor, isWarning := DecodeResponseCodeDetails(data.rc)
+		if format != data.format || code != data.code || index != data.index || isVendor != data.isVendor || isWarning != data.isWarning {
+			t.Errorf("Unexpected details for response code 0x%08x: %d, 0x%02x, %d, %v, %v", data.rc, format, code, index, isVendor,
+				isWarning)
+		}
+
+		// Make sure the errors returned from DecodeResponseCode return the original response code.
+		err := DecodeResponseCode(CommandClear, data.rc)
+		e, ok := err.(interface{ ResponseCode() ResponseCode })
+		if !ok {
+			t.Errorf("Error for response code 0x%08x has no ResponseCode method", data.rc)
+			continue
+		}
+		if e.ResponseCode() != data.rc {
+			t.Errorf("Unexpected response code from %T: 0x%08x", err, e.ResponseCode())
+		}
+	}
+}
+
+func TestErrorsIs(t *testing.T) {
+	for _, data := range []struct {
+		desc   string
+		rc     ResponseCode
+		target error
+		match  bool
+	}{
+		{
+			desc:   "TPMError",
+			rc:     0x155,
+			target: &TPMError{Command: CommandClear, Code: ErrorSensitive},
+			match:  true,
+		},
+		{
+			desc:   "TPMErrorAny",
+			rc:     0x155,
+			target: &TPMError{Command: AnyCommandCode, Code: AnyErrorCode},
+			match:  true,
+		},
+		{
+			desc:   "TPMErrorWrongCode",
+			rc:     0x155,
+			target: &TPMError{Command: CommandClear, Code: ErrorValue},
+		},
+		{
+			desc:   "TPMErrorWrongCommand",
+			rc:     0x155,
+			target: &TPMError{Command: CommandLoad, Code: ErrorSensitive},
+		},
+		{
+			desc:   "TPMWarning",
+			rc:     0x922,
+			target: &TPMWarning{Command: AnyCommandCode, Code: WarningRetry},
+			match:  true,
+		},
+		{
+			desc:   "TPMWarningWrongCode",
+			rc:     0x922,
+			target: &TPMWarning{Command: AnyCommandCode, Code: WarningYielded},
+		},
+		{
+			desc:   "TPMParameterError",
+			rc:     0x5e7,
+			target: &TPMParameterError{TPMError: &TPMError{Command: CommandClear, Code: ErrorECCPoint}, Index: 5},
+			match:  true,
+		},
+		{
+			desc:   "TPMParameterErrorAnyIndex",
+			rc:     0x5e7,
+			target: &TPMParameterError{TPMError: &TPMError{Command: AnyCommandCode, Code: ErrorECCPoint}, Index: AnyParameterIndex},
+			match:  true,
+		},
+		{
+			desc:   "TPMParameterErrorWrongIndex",
+			rc:     0x5e7,
+			target: &TPMParameterError{TPMError: &TPMError{Command: CommandClear, Code: ErrorECCPoint}, Index: 4},
+		},
+		{
+			desc:   "TPMParameterErrorAsTPMError",
+			rc:     0x5e7,
+			target: &TPMError{Command: CommandClear, Code: ErrorECCPoint},
+			match:  true,
+		},
+		{
+			desc:   "TPMSessionError",
+			rc:     0xb9c,
+			target: &TPMSessionError{TPMError: &TPMError{Command: AnyCommandCode, Code: ErrorKey}, Index: 3},
+			match:  true,
+		},
+		{
+			desc:   "TPMSessionErrorAsHandleError",
+			rc:     0xb9c,
+			target: &TPMHandleError{TPMError: &TPMError{Command: AnyCommandCode, Code: ErrorKey}, Index: AnyHandleIndex},
+		},
+		{
+			desc:   "TPMHandleError",
+			rc:     0x496,
+			target: &TPMHandleError{TPMError: &TPMError{Command: AnyCommandCode, Code: ErrorSymmetric}, Index: 4},
+			match:  true,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			err := xerrors.Errorf("wrapped: %w", DecodeResponseCode(CommandClear, data.rc))
+			if xerrors.Is(err, data.target) != data.match {
+				t.Errorf("Unexpected result for %v", err)
+			}
+		})
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	err := xerrors.Errorf("wrapped: %w", DecodeResponseCode(CommandClear, 0x5e7))
+
+	var pe *TPMParameterError
+	if !xerrors.As(err, &pe) {
+		t.Fatalf("Expected a *TPMParameterError")
+	}
+	if pe.Code != ErrorECCPoint || pe.Command != CommandClear || pe.Index != 5 {
+		t.Errorf("Unexpected error: %v", pe)
+	}
+
+	var e *TPMError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Expected a *TPMError")
+	}
+	if e.Code != ErrorECCPoint || e.Command != CommandClear {
+		t.Errorf("Unexpected error: %v", e)
+	}
+}
@@ -42,3 +42,7 @@ type TestSessionContext interface {
 }
 
 var TestComputeBindName = computeBindName
+
+const TestMaxRetryBackoff = maxRetryBackoff
+
+var TestComputeRetryDelay = retryDelay
@@ -11,11 +11,20 @@ import (
 	"crypto/rand"
 	_ "crypto/sha1"
 	_ "crypto/sha256"
+	"encoding/hex"
 	"testing"
 
 	. "github.com/canonical/go-tpm2/internal"
 )
 
+func decodeHexString(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("DecodeString failed: %v", err)
+	}
+	return b
+}
+
 func TestSymmetricAES(t *testing.T) {
 	for _, data := range []struct {
 		desc      string
@@ -108,3 +117,18 @@ func TestXORObfuscation(t *testing.T) {
 		})
 	}
 }
+
+func TestXORObfuscationMask(t *testing.T) {
+	// Known answer vector for a mask that is longer than one digest, computed independently from the definition of KDFa in
+	// section 11.4.10.2 of part 1 of the TPM Library Specification and of CryptXORObfuscation in the reference implementation.
+	key := decodeHexString(t, "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	contextU := decodeHexString(t, "a0a1a2a3a4a5a6a7a8a9aaabacadaeafb0b1b2b3b4b5b6b7b8b9babbbcbdbebf")
+	contextV := decodeHexString(t, "c0c1c2c3c4c5c6c7c8c9cacbcccdcecfd0d1d2d3d4d5d6d7d8d9dadbdcdddedf")
+	expected := decodeHexString(t, "166e5e6d1e28778900a23d352ff9a9530ff1260ce59494e7a4941aa3a2ddddc010c436cbb6fac1f6")
+
+	mask := make([]byte, len(expected))
+	XORObfuscation(crypto.SHA256, key, contextU, contextV, mask)
+	if !bytes.Equal(mask, expected) {
+		t.Errorf("Unexpected mask %x (expected %x)", mask, expected)
+	}
+}
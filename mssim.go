@@ -8,8 +8,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/canonical/go-tpm2/mu"
 
@@ -42,29 +43,40 @@ type TctiMssim struct {
 	tpm      net.Conn
 	platform net.Conn
 
-	buf *bytes.Reader
+	rbuf []byte // Partially received response frame
+	buf  *bytes.Reader
 }
 
+// readMoreData reads the next response frame from the TPM command channel. The received bytes are accumulated until the frame is
+// complete, so that a read that is interrupted by the read deadline can be resumed later without losing any data.
 func (t *TctiMssim) readMoreData() error {
-	var size uint32
-	if err := binary.Read(t.tpm, binary.BigEndian, &size); err != nil {
-		return xerrors.Errorf("cannot read response size from TPM command channel: %w", err)
-	}
-
-	buf := make([]byte, size)
-	if _, err := io.ReadFull(t.tpm, buf); err != nil {
-		return xerrors.Errorf("cannot read response from TPM command channel: %w", err)
-	}
-
-	t.buf = bytes.NewReader(buf)
+	for {
+		if len(t.rbuf) >= binary.Size(uint32(0)) {
+			// The frame consists of the 32-bit response size, the response and 4 zero bytes.
+			size := binary.BigEndian.Uint32(t.rbuf)
+			end := uint64(size) + 8
+			if uint64(len(t.rbuf)) >= end {
+				frame := t.rbuf
+				t.buf = bytes.NewReader(frame[4 : end-4])
+				t.rbuf = nil
+				if uint64(len(frame)) > end {
+					t.rbuf = append(t.rbuf, frame[end:]...)
+				}
+				return nil
+			}
+		}
 
-	var trash uint32
-	if err := binary.Read(t.tpm, binary.BigEndian, &trash); err != nil {
-		return xerrors.Errorf("cannot read zero bytes from TPM command channel after response: %w", err)
+		var buf [4096]byte
+		n, err := t.tpm.Read(buf[:])
+		t.rbuf = append(t.rbuf, buf[:n]...)
+		if err != nil {
+			return xerrors.Errorf("cannot read response from TPM command channel: %w", err)
+		}
 	}
-	return nil
 }
 
+// Read reads response data from the simulator. Each response is framed with its 32-bit length and is followed by a 32-bit
+// acknowledgement, which is consumed and discarded.
 func (t *TctiMssim) Read(data []byte) (int, error) {
 	if t.buf == nil || t.buf.Len() == 0 {
 		if err := t.readMoreData(); err != nil {
@@ -74,12 +86,24 @@ func (t *TctiMssim) Read(data []byte) (int, error) {
 	return t.buf.Read(data)
 }
 
+// Write submits the command packet in data to the simulator. It is framed with the TPM_SEND_COMMAND code, the locality from the
+// Locality field and the 32-bit length of the packet.
 func (t *TctiMssim) Write(data []byte) (int, error) {
 	buf, err := mu.MarshalToBytes(cmdTPMSendCommand, t.Locality, uint32(len(data)), mu.RawBytes(data))
 	if err != nil {
 		panic(fmt.Sprintf("cannot marshal command: %v", err))
 	}
-	return t.tpm.Write(buf)
+	if _, err := t.tpm.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// SetReadDeadline sets the time after which a pending or subsequent Read fails with a timeout error if it is waiting for a response
+// from the simulator. A partially received response is retained, so that it can be completed by a subsequent Read. A zero value
+// disables the deadline. This implements TCTIWithReadDeadline.
+func (t *TctiMssim) SetReadDeadline(deadline time.Time) error {
+	return t.tpm.SetReadDeadline(deadline)
 }
 
 func sendSessionEnd(conn net.Conn) error {
@@ -136,7 +160,7 @@ func (t *TctiMssim) Stop() (out error) {
 	if err := sendStop(t.tpm); err != nil {
 		out = xerrors.Errorf("cannot send stop command on TPM command channel: %w", err)
 	}
-	return nil
+	return
 }
 
 // OpenMssim attempts to open a connection to a TPM simulator on the specified host. tpmPort is the port on which the TPM command
@@ -149,8 +173,8 @@ func OpenMssim(host string, tpmPort, platformPort uint) (*TctiMssim, error) {
 		host = "localhost"
 	}
 
-	tpmAddress := fmt.Sprintf("%s:%d", host, tpmPort)
-	platformAddress := fmt.Sprintf("%s:%d", host, platformPort)
+	tpmAddress := net.JoinHostPort(host, strconv.FormatUint(uint64(tpmPort), 10))
+	platformAddress := net.JoinHostPort(host, strconv.FormatUint(uint64(platformPort), 10))
 
 	tcti := new(TctiMssim)
 	tcti.Locality = 3
@@ -169,9 +193,13 @@ func OpenMssim(host string, tpmPort, platformPort uint) (*TctiMssim, error) {
 	tcti.platform = platform
 
 	if err := tcti.platformCommand(cmdPowerOn); err != nil {
+		tcti.tpm.Close()
+		tcti.platform.Close()
 		return nil, xerrors.Errorf("cannot complete power on command: %w", err)
 	}
 	if err := tcti.platformCommand(cmdNVOn); err != nil {
+		tcti.tpm.Close()
+		tcti.platform.Close()
 		return nil, xerrors.Errorf("cannot complete NV on command: %w", err)
 	}
 
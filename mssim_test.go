@@ -0,0 +1,177 @@
+// Copyright 2019 Canonical Ltd.
+// Licensed under the LGPLv3 with static-linking exception.
+// See LICENCE file for details.
+
+package tpm2_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
+)
+
+type fakeMssimCommand struct {
+	locality uint8
+	packet   []byte
+}
+
+// runFakeMssim implements enough of the Microsoft TPM2 simulator interface to accept connections on the TPM command and platform
+// channels. Platform commands are acknowledged, and TPM_SEND_COMMAND requests are recorded and answered with response. If release
+// is not nil, the first part of the response frame to the first command is sent immediately and the rest is sent once release
+// is closed.
+func runFakeMssim(t *testing.T, response []byte, release <-chan struct{}) (tpmPort, platformPort uint, commands <-chan fakeMssimCommand) {
+	tpmListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	platformListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	ch := make(chan fakeMssimCommand, 1)
+
+	go func() {
+		defer platformListener.Close()
+		conn, err := platformListener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var cmd uint32
+			if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil {
+				return
+			}
+			if cmd == 20 {
+				// TPM_SESSION_END
+				return
+			}
+			binary.Write(conn, binary.BigEndian, uint32(0))
+		}
+	}()
+
+	go func() {
+		defer tpmListener.Close()
+		defer close(ch)
+		conn, err := tpmListener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var cmd uint32
+			if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil || cmd != 8 {
+				// Anything other than TPM_SEND_COMMAND (eg, TPM_SESSION_END)
+				return
+			}
+			var locality uint8
+			var size uint32
+			if _, err := mu.UnmarshalFromReader(conn, &locality, &size); err != nil {
+				return
+			}
+			packet := make([]byte, size)
+			if _, err := io.ReadFull(conn, packet); err != nil {
+				return
+			}
+			ch <- fakeMssimCommand{locality: locality, packet: packet}
+
+			rsp, _ := mu.MarshalToBytes(uint32(len(response)), mu.RawBytes(response), uint32(0))
+			if release != nil {
+				conn.Write(rsp[:6])
+				<-release
+				rsp = rsp[6:]
+				release = nil
+			}
+			conn.Write(rsp)
+		}
+	}()
+
+	return uint(tpmListener.Addr().(*net.TCPAddr).Port), uint(platformListener.Addr().(*net.TCPAddr).Port), ch
+}
+
+func TestMssimWireFormat(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02, 0x03, 0x04})
+	rsp, _ := mu.MarshalToBytes(TagNoSessions, uint32(10+len(params)), Success, mu.RawBytes(params))
+
+	tpmPort, platformPort, commands := runFakeMssim(t, rsp, nil)
+
+	tcti, err := OpenMssim("127.0.0.1", tpmPort, platformPort)
+	if err != nil {
+		t.Fatalf("OpenMssim failed: %v", err)
+	}
+	tcti.Locality = 1
+
+	tpm, _ := NewTPMContext(tcti)
+	defer tpm.Close()
+
+	for i := 0; i < 2; i++ {
+		random, err := tpm.GetRandom(4)
+		if err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+		if !bytes.Equal(random, []byte{0x01, 0x02, 0x03, 0x04}) {
+			t.Errorf("Unexpected response: %x", random)
+		}
+
+		cmd := <-commands
+		if cmd.locality != 1 {
+			t.Errorf("Unexpected locality: %d", cmd.locality)
+		}
+		if !bytes.Equal(cmd.packet, []byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x01, 0x7b, 0x00, 0x04}) {
+			t.Errorf("Unexpected command packet: %x", cmd.packet)
+		}
+	}
+}
+
+func TestMssimCancelledCommand(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02, 0x03, 0x04})
+	rsp, _ := mu.MarshalToBytes(TagNoSessions, uint32(10+len(params)), Success, mu.RawBytes(params))
+
+	release := make(chan struct{})
+	tpmPort, platformPort, commands := runFakeMssim(t, rsp, release)
+
+	tcti, err := OpenMssim("127.0.0.1", tpmPort, platformPort)
+	if err != nil {
+		t.Fatalf("OpenMssim failed: %v", err)
+	}
+
+	tpm, _ := NewTPMContext(tcti)
+	defer tpm.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var random Digest
+	err = tpm.RunCommandContext(ctx, CommandGetRandom, nil, Delimiter, uint16(4), Delimiter, Delimiter, &random)
+	if err == nil {
+		t.Fatalf("RunCommandContext should have failed")
+	}
+	var e *TctiError
+	if !xerrors.As(err, &e) || !xerrors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	<-commands
+
+	// The partially received response to the cancelled command should be completed and discarded before the next command is sent.
+	close(release)
+	for i := 0; i < 2; i++ {
+		random, err := tpm.GetRandom(4)
+		if err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+		if !bytes.Equal(random, []byte{0x01, 0x02, 0x03, 0x04}) {
+			t.Errorf("Unexpected response: %x", random)
+		}
+		<-commands
+	}
+}
@@ -12,6 +12,9 @@ Go types are marshalled to and from the TPM wire format according to the followi
  * INT32 <-> int32
  * UINT64 <-> uint64
  * INT64 <-> int64
+ * The go int, uint and uintptr types have a platform dependent size and are not supported. Attempting to marshal or unmarshal
+ these returns an error rather than producing an encoding with an unexpected width, so TPM enums and other integer types must
+ be declared with a fixed width underlying type that matches the TPM type.
  * TPM2B prefixed types (sized buffers with a 2-byte size field) fall in to 2 categories:
     * Byte buffer <-> []byte, or any type with an identical underlying type.
     * Sized structure <-> struct referenced via a pointer field in an enclosing struct, where the field has the `tpm2:"sized"` tag. A
@@ -21,9 +24,13 @@ Go types are marshalled to and from the TPM wire format according to the followi
  * TPML prefixed types (lists with a 4-byte length field) <-> slice of whichever go type corresponds to the underlying TPM type.
  * TPMS prefixed types (structures) <-> struct
  * TPMT prefixed types (structures with a tag field used as a union selector) <-> struct
+ * Fixed length arrays <-> go array types. These are marshalled and unmarshalled without a length field, as the length is known
+ statically. Byte arrays are marshalled contiguously in the same way as RawBytes.
  * TPMU prefixed types (unions) <-> struct with a single field and which implements the Union interface. These must be referenced
  from a field in an enclosing struct, where the field has the `tpm2:"selector:<field_name>"` tag referencing a valid selector
- field name in the enclosing struct.
+ field name in the enclosing struct. Where a union member is a sized structure, the Union implementation should select a struct
+ type with a single pointer field that has the `tpm2:"sized"` tag. Unions that need to look beyond the selector field, such as
+ at a field in the struct that encloses the union's container, can implement the UnionWithContainer interface instead.
 
 TPMI prefixed types (interface types) are generally not explicitly supported. These are used by the TPM for type checking during
 unmarshalling. Some TPMI prefixed types that use TPM_ALG_ID as the underlying concrete type are implemented.
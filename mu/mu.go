@@ -18,14 +18,16 @@ import (
 )
 
 var (
-	customMarshallerType reflect.Type = reflect.TypeOf((*CustomMarshaller)(nil)).Elem()
-	unionType            reflect.Type = reflect.TypeOf((*Union)(nil)).Elem()
-	nilValueType         reflect.Type = reflect.TypeOf(NilUnionValue)
-	rawBytesType         reflect.Type = reflect.TypeOf(RawBytes(nil))
+	customMarshallerType           reflect.Type = reflect.TypeOf((*CustomMarshaller)(nil)).Elem()
+	contextualCustomMarshallerType reflect.Type = reflect.TypeOf((*ContextualCustomMarshaller)(nil)).Elem()
+	unionType                      reflect.Type = reflect.TypeOf((*Union)(nil)).Elem()
+	nilValueType                   reflect.Type = reflect.TypeOf(NilUnionValue)
+	rawBytesType                   reflect.Type = reflect.TypeOf(RawBytes(nil))
 )
 
 // InvalidSelectorError may be returned as a wrapped error from UnmarshalFromBytes or UnmarshalFromReader when a union type indicates
-// that a selector value is invalid.
+// that a selector value is invalid by returning nil from Union.Select. It is not returned for selector values for which the union
+// type returns the type of NilUnionValue, as these are valid selector values that have no associated data.
 type InvalidSelectorError struct {
 	Selector reflect.Value
 }
@@ -34,6 +36,38 @@ func (e *InvalidSelectorError) Error() string {
 	return fmt.Sprintf("invalid selector value: %v", e.Selector)
 }
 
+// TrailingBytesError is returned from UnmarshalFromBytesStrict if the supplied data contains bytes that weren't consumed after
+// unmarshalling all of the supplied values.
+type TrailingBytesError struct {
+	N int // The number of unconsumed bytes
+}
+
+func (e *TrailingBytesError) Error() string {
+	return fmt.Sprintf("%d trailing byte(s) after unmarshalling", e.N)
+}
+
+// InputTooLargeError is returned from UnmarshalFromReaderN, and is wrapped by the error returned from UnmarshalFromReaderLimited and
+// UnmarshalFromBytesLimited, if the supplied values could not be unmarshalled without reading more than the specified maximum number
+// of bytes.
+type InputTooLargeError struct {
+	Max int64 // The maximum number of bytes
+}
+
+func (e *InputTooLargeError) Error() string {
+	return fmt.Sprintf("input exceeds max bytes (%d)", e.Max)
+}
+
+// BufferTooSmallError is returned from UnmarshalSizedBufferInto if the supplied buffer is too small for the sized buffer being
+// unmarshalled.
+type BufferTooSmallError struct {
+	Size int // The size of the sized buffer
+	Cap  int // The length of the supplied buffer
+}
+
+func (e *BufferTooSmallError) Error() string {
+	return fmt.Sprintf("buffer too small for sized buffer (need %d bytes, have %d)", e.Size, e.Cap)
+}
+
 // CustomMarshaller is implemented by types that require custom marshalling and unmarshalling behaviour because they are non-standard
 // and not directly supported by the marshalling code.
 type CustomMarshaller interface {
@@ -41,8 +75,28 @@ type CustomMarshaller interface {
 	Unmarshal(buf io.Reader) (int, error)
 }
 
+// ContextualCustomMarshaller is an alternative to CustomMarshaller for types whose encoding depends on other fields in the
+// structure that contains them, such as a flags field that appears earlier in the same structure. When a value of a type that
+// implements this is marshalled or unmarshalled, the container argument is set to a copy of the value that contains it (a struct
+// or slice), or nil if it is the top-level value. During unmarshalling, only the fields of container that precede the value have
+// been populated.
+type ContextualCustomMarshaller interface {
+	Marshal(buf io.Writer, container interface{}) (int, error)
+	Unmarshal(buf io.Reader, container interface{}) (int, error)
+}
+
 type empty struct{}
 
+// sizer is an io.Writer that discards everything written to it, keeping a count of the number of bytes.
+type sizer struct {
+	n int
+}
+
+func (s *sizer) Write(data []byte) (int, error) {
+	s.n += len(data)
+	return len(data), nil
+}
+
 // NilUnionValue is a special value, the type of which should be returned from implementations of Union.Select to indicate
 // that a union contains no data for a particular selector value.
 var NilUnionValue empty
@@ -55,11 +109,27 @@ type RawBytes []byte
 // a single member of the empty interface type.
 type Union interface {
 	// Select is called by the marshalling code with the value of the selector field from the enclosing struct. The implementation
-	// should respond with the type that will be marshalled or unmarshalled for the selector value. If no data should be marshalled
-	// or unmarshalled, it should respond with the type of NilUnionValue.
+	// should respond with the type that will be marshalled or unmarshalled for the selector value. If the selector value is valid
+	// but no data should be marshalled or unmarshalled (eg, for a TPM_ALG_NULL selector), it should respond with the type of
+	// NilUnionValue.
+	//
+	// If the selector value is not valid, it should respond with nil. In this case, nothing is marshalled, and unmarshalling fails
+	// with a wrapped *InvalidSelectorError.
 	Select(selector reflect.Value) reflect.Type
 }
 
+// UnionWithContainer is an optional extension of Union for union types where the selected type depends on more than the value of
+// the selector field, such as when the details of a scheme depend on an algorithm field in the structure that encloses the union's
+// container. If a Union implements this, SelectWithContainer is called by the marshalling code instead of Select.
+type UnionWithContainer interface {
+	Union
+
+	// SelectWithContainer is called by the marshalling code with the value of the selector field, the value of the struct that
+	// contains the union and the value that contains that struct. The parent value is invalid if the union's container is the
+	// top-level value being marshalled or unmarshalled. It should respond in the same way as Union.Select.
+	SelectWithContainer(selector, container, parent reflect.Value) reflect.Type
+}
+
 type muError struct {
 	kind      string
 	val       reflect.Value
@@ -141,10 +211,30 @@ func makeListElemMuError(s reflect.Value, index int, err error) error {
 	return &listElemMuError{val: s, index: index, err: err}
 }
 
+// makeErrorPath returns the path of the value that caused the supplied error, by walking the chain of struct field and list element
+// errors.
+func makeErrorPath(err error) (path []string) {
+	for err != nil {
+		switch e := err.(type) {
+		case *structFieldMuError:
+			path = append(path, e.field.Name)
+		case *listElemMuError:
+			path = append(path, fmt.Sprintf("[%d]", e.index))
+		}
+		err = xerrors.Unwrap(err)
+	}
+	return path
+}
+
 // MarshalError indicates an error during marshalling and may be returned from MarshalToBytes or MarshalToWriter.
 type MarshalError struct {
 	Index int // The index of the argument that caused the error
-	err   error
+
+	// Path is the path of the value that caused the error, relative to the argument. Each element is either the name of a struct
+	// field or the index of a list element in the form "[n]". It is empty if the error is associated with the argument itself.
+	Path []string
+
+	err error
 }
 
 func (e *MarshalError) Error() string {
@@ -158,7 +248,12 @@ func (e *MarshalError) Unwrap() error {
 // UnmarshalError indicates an error during unmarshalling and may be returned from UnmarshalFromBytes or UnmarshalFromReader.
 type UnmarshalError struct {
 	Index int // The index of the argument that caused the error
-	err   error
+
+	// Path is the path of the value that caused the error, relative to the argument. Each element is either the name of a struct
+	// field or the index of a list element in the form "[n]". It is empty if the error is associated with the argument itself.
+	Path []string
+
+	err error
 }
 
 func (e *UnmarshalError) Error() string {
@@ -190,33 +285,133 @@ func parseStructFieldMuOptions(f reflect.StructField) (out muOptions) {
 	return
 }
 
+// UnmarshalLimits specifies limits that are enforced during unmarshalling in order to protect against malformed or malicious input,
+// which may contain length or size fields that would otherwise trigger excessively large allocations.
+type UnmarshalLimits struct {
+	// MaxDepth is the maximum depth of nested values that will be unmarshalled, where each struct, pointer, list, union or sized
+	// value adds a level of nesting. Zero means that there is no limit.
+	MaxDepth int
+
+	// MaxAllocSize is the maximum total number of bytes that will be allocated for new slices and pointer values during a single
+	// call. Zero means that there is no limit.
+	MaxAllocSize int
+
+	// MaxReadSize is the maximum total number of bytes that will be read from the source during a single call. This protects
+	// against untrusted streams that would otherwise be consumed indefinitely. If the limit is exceeded, the returned error wraps
+	// a *InputTooLargeError. Zero means that there is no limit.
+	MaxReadSize int
+}
+
+type unmarshalLimiter struct {
+	limits    UnmarshalLimits
+	depth     int
+	allocated uint64
+}
+
+func (l *unmarshalLimiter) enterValue() error {
+	if l.limits.MaxDepth > 0 && l.depth >= l.limits.MaxDepth {
+		return fmt.Errorf("maximum nesting depth of %d exceeded", l.limits.MaxDepth)
+	}
+	l.depth++
+	return nil
+}
+
+func (l *unmarshalLimiter) exitValue() {
+	l.depth--
+}
+
+func (l *unmarshalLimiter) alloc(t reflect.Type, n uint64) error {
+	size := uint64(t.Size()) * n
+	if t.Size() > 0 && size/uint64(t.Size()) != n {
+		return fmt.Errorf("allocation of %d elements of type %s overflows", n, t)
+	}
+	if l.limits.MaxAllocSize > 0 && l.allocated+size > uint64(l.limits.MaxAllocSize) {
+		return fmt.Errorf("allocation of %d bytes for type %s would exceed the limit of %d bytes", size, t, l.limits.MaxAllocSize)
+	}
+	l.allocated += size
+	return nil
+}
+
+// limitedReader is an io.Reader that returns a *InputTooLargeError if an attempt is made to read more than max bytes. This is
+// used to enforce UnmarshalLimits.MaxReadSize and the limit supplied to UnmarshalFromReaderN.
+type limitedReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (r *limitedReader) Read(data []byte) (int, error) {
+	if r.read >= r.max {
+		return 0, &InputTooLargeError{Max: r.max}
+	}
+	if remaining := r.max - r.read; int64(len(data)) > remaining {
+		data = data[:remaining]
+	}
+	n, err := r.r.Read(data)
+	r.read += int64(n)
+	return n, err
+}
+
 type muContext struct {
 	nbytes    int
 	container reflect.Value
+	parent    reflect.Value // The value that contains container
 	options   muOptions
+	limiter   *unmarshalLimiter
+}
+
+// containerInterface returns the value that contains the value currently being processed, or nil if it is the top-level value.
+func (c *muContext) containerInterface() interface{} {
+	if !c.container.IsValid() {
+		return nil
+	}
+	return c.container.Interface()
+}
+
+func (c *muContext) enterValue() (exit func(), err error) {
+	if c.limiter == nil {
+		return func() {}, nil
+	}
+	if err := c.limiter.enterValue(); err != nil {
+		return nil, err
+	}
+	return c.limiter.exitValue, nil
+}
+
+func (c *muContext) alloc(t reflect.Type, n uint64) error {
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.alloc(t, n)
 }
 
 func (c *muContext) enterStructField(s reflect.Value, i int) (f reflect.Value, exit func()) {
 	opts := parseStructFieldMuOptions(s.Type().Field(i))
 	origContainer := c.container
+	origParent := c.parent
 	origOptions := c.options
+	c.parent = c.container
 	c.container = s
 	c.options = opts
 
 	return s.Field(i), func() {
 		c.container = origContainer
+		c.parent = origParent
 		c.options = origOptions
 	}
 }
 
 func (c *muContext) enterListElem(l reflect.Value, i int) (elem reflect.Value, exit func()) {
 	origContainer := c.container
+	origParent := c.parent
 	origOptions := c.options
+	c.parent = c.container
 	c.container = l
 	c.options = muOptions{}
 
 	return l.Index(i), func() {
 		c.container = origContainer
+		c.parent = origParent
 		c.options = origOptions
 	}
 }
@@ -235,7 +430,13 @@ func (c *muContext) enterUnionElem(u reflect.Value, unmarshal bool) (elem reflec
 			c.options.selector, u.Type(), c.container.Type()))
 	}
 
-	selectedType := u.Interface().(Union).Select(selectorVal)
+	var selectedType reflect.Type
+	switch v := u.Interface().(type) {
+	case UnionWithContainer:
+		selectedType = v.SelectWithContainer(selectorVal, c.container, c.parent)
+	case Union:
+		selectedType = v.Select(selectorVal)
+	}
 	switch {
 	case selectedType == nil && unmarshal:
 		return reflect.Value{}, nil, &InvalidSelectorError{selectorVal}
@@ -311,7 +512,7 @@ func tpmKind(t reflect.Type) TPMKind {
 		t = t.Elem()
 	}
 
-	if reflect.PtrTo(t).Implements(customMarshallerType) {
+	if reflect.PtrTo(t).Implements(customMarshallerType) || reflect.PtrTo(t).Implements(contextualCustomMarshallerType) {
 		return TPMKindCustom
 	}
 
@@ -326,6 +527,12 @@ func tpmKind(t reflect.Type) TPMKind {
 			return TPMKindSized
 		}
 		return TPMKindList
+	case reflect.Array:
+		// The length of an array is known statically, so it is always marshalled and unmarshalled without a size or length field.
+		if t.Elem().Kind() == reflect.Uint8 {
+			return TPMKindRawBytes
+		}
+		return TPMKindRawList
 	case reflect.Struct:
 		if t.Implements(unionType) && t.NumField() == 1 && t.Field(0).Type.Kind() == reflect.Interface && t.Field(0).Type.NumMethod() == 0 {
 			return TPMKindUnion
@@ -336,6 +543,17 @@ func tpmKind(t reflect.Type) TPMKind {
 	}
 }
 
+// isPlatformDependentInt indicates whether t is an integer type with a platform dependent size. These can't be marshalled because
+// there is no way to determine the size of the corresponding TPM type.
+func isPlatformDependentInt(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Uint, reflect.Uintptr:
+		return true
+	default:
+		return false
+	}
+}
+
 // DetermineTPMKind returns the TPMKind associated with the supplied go value. It will automatically dereference pointer types.
 // Single field structures will be unwrapped and the TPMKind associated with the structure field will be returned.
 func DetermineTPMKind(i interface{}) TPMKind {
@@ -385,10 +603,27 @@ func marshalSized(w io.Writer, val reflect.Value, ctx *muContext) error {
 		return nil
 	}
 
+	if s, isSizer := w.(*sizer); isSizer {
+		// We're only computing the size, so there's no need to marshal to a temporary buffer first.
+		s.n += binary.Size(uint16(0))
+		ctx.nbytes += binary.Size(uint16(0))
+		start := s.n
+		if err := marshalValue(w, val, ctx); err != nil {
+			return err
+		}
+		if s.n-start > math.MaxUint16 {
+			return errors.New("sized value size greater than 2^16-1")
+		}
+		return nil
+	}
+
+	// The bytes written to the temporary buffer are accounted for when it is copied to w.
+	nbytes := ctx.nbytes
 	tmpBuf := new(bytes.Buffer)
 	if err := marshalValue(tmpBuf, val, ctx); err != nil {
 		return err
 	}
+	ctx.nbytes = nbytes
 	if tmpBuf.Len() > math.MaxUint16 {
 		return errors.New("sized value size greater than 2^16-1")
 	}
@@ -416,10 +651,23 @@ func marshalRawList(w io.Writer, slice reflect.Value, ctx *muContext) error {
 	return nil
 }
 
+func rawBytes(val reflect.Value) []byte {
+	switch {
+	case val.Kind() == reflect.Slice:
+		return val.Bytes()
+	case val.CanAddr():
+		return val.Slice(0, val.Len()).Bytes()
+	default:
+		b := make([]byte, val.Len())
+		reflect.Copy(reflect.ValueOf(b), val)
+		return b
+	}
+}
+
 func marshalRaw(w io.Writer, slice reflect.Value, ctx *muContext) error {
 	switch slice.Type().Elem().Kind() {
 	case reflect.Uint8:
-		n, err := w.Write(slice.Bytes())
+		n, err := w.Write(rawBytes(slice))
 		ctx.nbytes += n
 		return err
 	default:
@@ -489,7 +737,14 @@ func marshalCustom(w io.Writer, val reflect.Value, ctx *muContext) error {
 	if val.Kind() != reflect.Ptr {
 		val = val.Addr()
 	}
-	n, err := val.Interface().(CustomMarshaller).Marshal(w)
+	var n int
+	var err error
+	switch m := val.Interface().(type) {
+	case ContextualCustomMarshaller:
+		n, err = m.Marshal(w, ctx.containerInterface())
+	default:
+		n, err = m.(CustomMarshaller).Marshal(w)
+	}
 	ctx.nbytes += n
 	return err
 }
@@ -541,7 +796,15 @@ func marshalValue(w io.Writer, val reflect.Value, ctx *muContext) error {
 		if err := marshalRaw(w, val, ctx); err != nil {
 			return makeRawTypeMuError(val, ctx, err)
 		}
+	case TPMKindRawList:
+		if err := marshalRaw(w, val, ctx); err != nil {
+			return makeRawTypeMuError(val, ctx, err)
+		}
 	default:
+		if isPlatformDependentInt(val.Type()) {
+			return makePrimitiveTypeMuError(val, ctx, fmt.Errorf("type %s has a platform dependent size, so a fixed width integer type "+
+				"must be used instead", val.Type()))
+		}
 		panic(fmt.Sprintf("cannot marshal unsupported type %s", val.Type()))
 	}
 
@@ -564,6 +827,9 @@ func unmarshalSized(r io.Reader, val reflect.Value, ctx *muContext) error {
 	case size == 0:
 		return nil
 	case val.Kind() == reflect.Slice:
+		if err := ctx.alloc(val.Type().Elem(), uint64(size)); err != nil {
+			return err
+		}
 		val.Set(reflect.MakeSlice(val.Type(), int(size), int(size)))
 	}
 
@@ -572,7 +838,7 @@ func unmarshalSized(r io.Reader, val reflect.Value, ctx *muContext) error {
 }
 
 func unmarshalRawList(r io.Reader, slice reflect.Value, ctx *muContext) error {
-	if slice.IsNil() {
+	if slice.Kind() == reflect.Slice && slice.IsNil() {
 		return errors.New("nil raw slice")
 	}
 
@@ -590,7 +856,7 @@ func unmarshalRawList(r io.Reader, slice reflect.Value, ctx *muContext) error {
 func unmarshalRaw(r io.Reader, slice reflect.Value, ctx *muContext) error {
 	switch slice.Type().Elem().Kind() {
 	case reflect.Uint8:
-		n, err := io.ReadFull(r, slice.Bytes())
+		n, err := io.ReadFull(r, rawBytes(slice))
 		ctx.nbytes += n
 		return err
 	default:
@@ -600,6 +866,9 @@ func unmarshalRaw(r io.Reader, slice reflect.Value, ctx *muContext) error {
 
 func unmarshalPtr(r io.Reader, ptr reflect.Value, ctx *muContext) error {
 	if ptr.IsNil() {
+		if err := ctx.alloc(ptr.Type().Elem(), 1); err != nil {
+			return err
+		}
 		ptr.Set(reflect.New(ptr.Type().Elem()))
 	}
 	return unmarshalValue(r, ptr.Elem(), ctx)
@@ -620,6 +889,9 @@ func unmarshalList(r io.Reader, slice reflect.Value, ctx *muContext) error {
 		return xerrors.Errorf("cannot read length of list: %w", err)
 	}
 	ctx.nbytes += binary.Size(uint32(0))
+	if err := ctx.alloc(slice.Type().Elem(), uint64(length)); err != nil {
+		return err
+	}
 	slice.Set(reflect.MakeSlice(slice.Type(), int(length), int(length)))
 
 	return unmarshalRawList(r, slice, ctx)
@@ -653,12 +925,25 @@ func unmarshalCustom(r io.Reader, val reflect.Value, ctx *muContext) error {
 	if val.Kind() != reflect.Ptr {
 		val = val.Addr()
 	}
-	n, err := val.Interface().(CustomMarshaller).Unmarshal(r)
+	var n int
+	var err error
+	switch m := val.Interface().(type) {
+	case ContextualCustomMarshaller:
+		n, err = m.Unmarshal(r, ctx.containerInterface())
+	default:
+		n, err = m.(CustomMarshaller).Unmarshal(r)
+	}
 	ctx.nbytes += n
 	return err
 }
 
 func unmarshalValue(r io.Reader, val reflect.Value, ctx *muContext) error {
+	exit, err := ctx.enterValue()
+	if err != nil {
+		return err
+	}
+	defer exit()
+
 	switch {
 	case ctx.options.sized:
 		if err := unmarshalSized(r, val, ctx); err != nil {
@@ -705,7 +990,15 @@ func unmarshalValue(r io.Reader, val reflect.Value, ctx *muContext) error {
 		if err := unmarshalRaw(r, val, ctx); err != nil {
 			return makeRawTypeMuError(val, ctx, err)
 		}
+	case TPMKindRawList:
+		if err := unmarshalRaw(r, val, ctx); err != nil {
+			return makeRawTypeMuError(val, ctx, err)
+		}
 	default:
+		if isPlatformDependentInt(val.Type()) {
+			return makePrimitiveTypeMuError(val, ctx, fmt.Errorf("type %s has a platform dependent size, so a fixed width integer type "+
+				"must be used instead", val.Type()))
+		}
 		panic(fmt.Sprintf("cannot marshal unsupported type %s", val.Type()))
 	}
 
@@ -723,7 +1016,7 @@ func MarshalToWriter(w io.Writer, vals ...interface{}) (int, error) {
 	for i, val := range vals {
 		ctx := new(muContext)
 		if err := marshalValue(w, reflect.ValueOf(val), ctx); err != nil {
-			return totalBytes + ctx.nbytes, &MarshalError{Index: i, err: err}
+			return totalBytes + ctx.nbytes, &MarshalError{Index: i, Path: makeErrorPath(err), err: err}
 		}
 		totalBytes += ctx.nbytes
 	}
@@ -743,6 +1036,19 @@ func MarshalToBytes(vals ...interface{}) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// MarshalledSize returns the number of bytes that vals would occupy when marshalled to the TPM wire format, according to the rules
+// specified in the package description. This does not copy the marshalled data anywhere. It accounts for the size fields of sized
+// buffers and sized structures, the length fields of lists, and the output of any CustomMarshaller implementations.
+//
+// If the size cannot be determined because marshalling would fail, an error will be returned.
+func MarshalledSize(vals ...interface{}) (int, error) {
+	s := new(sizer)
+	if _, err := MarshalToWriter(s, vals...); err != nil {
+		return 0, err
+	}
+	return s.n, nil
+}
+
 // UnmarshalFromReader unmarshals data in the TPM wire format from r to vals, according to the rules specified in the package
 // description. The values supplied to this function must be pointers to the destination values. Nil pointers encountered during
 // unmarshalling will be initialized to point to newly allocated memory, unless the pointer represents a zero-sized structure. New
@@ -752,6 +1058,14 @@ func MarshalToBytes(vals ...interface{}) ([]byte, error) {
 // The number of bytes read from r are returned. If this function does not complete successfully, it will return an error and
 // the number of bytes read. In this case, partial results may have been unmarshalled to the supplied destination values.
 func UnmarshalFromReader(r io.Reader, vals ...interface{}) (int, error) {
+	return unmarshalFromReader(r, nil, vals...)
+}
+
+func unmarshalFromReader(r io.Reader, limiter *unmarshalLimiter, vals ...interface{}) (int, error) {
+	if limiter != nil && limiter.limits.MaxReadSize > 0 {
+		r = &limitedReader{r: r, max: int64(limiter.limits.MaxReadSize)}
+	}
+
 	var totalBytes int
 	for i, val := range vals {
 		v := reflect.ValueOf(val)
@@ -763,15 +1077,31 @@ func UnmarshalFromReader(r io.Reader, vals ...interface{}) (int, error) {
 			panic(fmt.Sprintf("cannot unmarshal to nil pointer of type %s", v.Type()))
 		}
 
-		ctx := new(muContext)
+		ctx := &muContext{limiter: limiter}
 		if err := unmarshalValue(r, v.Elem(), ctx); err != nil {
-			return totalBytes + ctx.nbytes, &UnmarshalError{Index: i, err: err}
+			return totalBytes + ctx.nbytes, &UnmarshalError{Index: i, Path: makeErrorPath(err), err: err}
 		}
 		totalBytes += ctx.nbytes
 	}
 	return totalBytes, nil
 }
 
+// UnmarshalFromReaderN behaves like UnmarshalFromReader, except that no more than max bytes will be read from r. If the supplied
+// values can't be unmarshalled without reading beyond this limit, an *InputTooLargeError will be returned. In this case, partial
+// results may have been unmarshalled to the supplied destination values. This is intended for use at a transport boundary where the
+// size of a message is known in advance. It enforces the same limit as UnmarshalLimits.MaxReadSize, but it does not limit the size
+// of allocations made for length fields in the input - see UnmarshalFromReaderLimited for that.
+func UnmarshalFromReaderN(r io.Reader, max int64, vals ...interface{}) error {
+	if _, err := unmarshalFromReader(&limitedReader{r: r, max: max}, nil, vals...); err != nil {
+		var e *InputTooLargeError
+		if xerrors.As(err, &e) {
+			return e
+		}
+		return err
+	}
+	return nil
+}
+
 // UnmarshalFromBytes unmarshals data in the TPM wire format from b to vals, according to the rules specified in the package
 // description. The values supplied to this function must be pointers to the destination values. Nil pointers encountered during
 // unmarshalling will be initialized to point to newly allocated memory, unless the pointer represents a zero-sized structure. New
@@ -785,3 +1115,91 @@ func UnmarshalFromBytes(b []byte, vals ...interface{}) (int, error) {
 	buf := bytes.NewReader(b)
 	return UnmarshalFromReader(buf, vals...)
 }
+
+// UnmarshalSizedBufferInto unmarshals a sized buffer (a TPM2B prefixed type with a 16-bit size field followed by that number of
+// bytes) from r directly in to the caller supplied buffer dst, without allocating. This is useful in loops that decode many
+// buffers, such as when parsing a log of PCR measurements, where the caller can reuse the same buffer for each iteration.
+//
+// On success, it returns the size of the sized buffer, which is the number of bytes written to the start of dst. The total number
+// of bytes consumed from r is this plus 2. If dst is shorter than the sized buffer, a *BufferTooSmallError is returned after the
+// size field has been consumed, and none of the data is read from r. The contents of dst are unspecified if an error is returned.
+func UnmarshalSizedBufferInto(r io.Reader, dst []byte) (int, error) {
+	// Use the caller's buffer to read the size field where possible, to avoid an allocation.
+	var scratch []byte
+	if len(dst) >= 2 {
+		scratch = dst[:2]
+	} else {
+		scratch = make([]byte, 2)
+	}
+	if _, err := io.ReadFull(r, scratch); err != nil {
+		return 0, xerrors.Errorf("cannot read size of sized buffer: %w", err)
+	}
+	size := int(binary.BigEndian.Uint16(scratch))
+	if size > len(dst) {
+		return 0, &BufferTooSmallError{Size: size, Cap: len(dst)}
+	}
+	if _, err := io.ReadFull(r, dst[:size]); err != nil {
+		return 0, xerrors.Errorf("cannot read sized buffer: %w", err)
+	}
+	return size, nil
+}
+
+// UnmarshalFromBytesStrict behaves like UnmarshalFromBytes, except that it requires all of the data in b to be consumed. If any
+// bytes remain after unmarshalling all of the supplied values, a *TrailingBytesError will be returned. In this case, the supplied
+// destination values will have been unmarshalled.
+func UnmarshalFromBytesStrict(b []byte, vals ...interface{}) error {
+	n, err := UnmarshalFromBytes(b, vals...)
+	if err != nil {
+		return err
+	}
+	if n < len(b) {
+		return &TrailingBytesError{N: len(b) - n}
+	}
+	return nil
+}
+
+// CopyValue copies the value of src to dst by marshalling src to the TPM wire format and then unmarshalling the result to dst,
+// according to the rules specified in the package description. This produces a deep copy, and values are normalized in the same
+// way as they would be when sent to and received from the TPM - for example, nil slices become empty slices. CustomMarshaller
+// implementations are honoured.
+//
+// The dst argument must be a non-nil pointer to a value of the same type as src, or of the same type that src points to if it is a
+// pointer, else an error will be returned without modifying dst. As with UnmarshalFromBytes, RawBytes types and struct fields with
+// the `tpm2:"raw"` tag must be preallocated in dst.
+func CopyValue(dst, src interface{}) error {
+	d := reflect.ValueOf(dst)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return errors.New("destination must be a non-nil pointer")
+	}
+	s := reflect.ValueOf(src)
+	if !s.IsValid() {
+		return errors.New("nil source value")
+	}
+	if d.Type().Elem() != s.Type() && d.Type() != s.Type() {
+		return fmt.Errorf("cannot copy value of type %s to destination of type %s", s.Type(), d.Type())
+	}
+
+	b, err := MarshalToBytes(src)
+	if err != nil {
+		return xerrors.Errorf("cannot marshal source value: %w", err)
+	}
+	if _, err := UnmarshalFromBytes(b, dst); err != nil {
+		return xerrors.Errorf("cannot unmarshal to destination value: %w", err)
+	}
+	return nil
+}
+
+// UnmarshalFromReaderLimited behaves like UnmarshalFromReader, but enforces the limits specified by limits. The limits apply to
+// the whole call rather than to each value individually. If a limit is exceeded, an error will be returned and partial results may
+// have been unmarshalled to the supplied destination values.
+func UnmarshalFromReaderLimited(r io.Reader, limits UnmarshalLimits, vals ...interface{}) (int, error) {
+	return unmarshalFromReader(r, &unmarshalLimiter{limits: limits}, vals...)
+}
+
+// UnmarshalFromBytesLimited behaves like UnmarshalFromBytes, but enforces the limits specified by limits. The limits apply to the
+// whole call rather than to each value individually. If a limit is exceeded, an error will be returned and partial results may
+// have been unmarshalled to the supplied destination values.
+func UnmarshalFromBytesLimited(b []byte, limits UnmarshalLimits, vals ...interface{}) (int, error) {
+	buf := bytes.NewReader(b)
+	return UnmarshalFromReaderLimited(buf, limits, vals...)
+}
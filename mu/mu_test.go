@@ -13,6 +13,8 @@ import (
 
 	"github.com/canonical/go-tpm2"
 	. "github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
 )
 
 func TestMarshalBasic(t *testing.T) {
@@ -421,6 +423,78 @@ func TestMarshalRawBytesInStruct(t *testing.T) {
 //	}
 //}
 
+type testStructWithArrays struct {
+	A [4]byte
+	B [2]uint16
+	C [2]TestStructSimple
+}
+
+type testStructWithSizedArrays struct {
+	S *testStructWithArrays `tpm2:"sized"`
+}
+
+func TestMarshalArrays(t *testing.T) {
+	for _, data := range []struct {
+		desc string
+		in   interface{}
+		out  []byte
+	}{
+		{
+			desc: "Bytes",
+			in:   [4]byte{0xf4, 0x7d, 0x01, 0x9c},
+			out:  []byte{0xf4, 0x7d, 0x01, 0x9c},
+		},
+		{
+			desc: "Uint32",
+			in:   [3]uint32{56, 453, 3233},
+			out:  []byte{0x00, 0x00, 0x00, 0x38, 0x00, 0x00, 0x01, 0xc5, 0x00, 0x00, 0x0c, 0xa1},
+		},
+		{
+			desc: "Structs",
+			in: testStructWithArrays{
+				A: [4]byte{0xf4, 0x7d, 0x01, 0x9c},
+				B: [2]uint16{5643, 23},
+				C: [2]TestStructSimple{{A: 5, B: 10, C: true, D: TestListUint32{1}}, {A: 6, B: 11, D: TestListUint32{}}}},
+			out: []byte{0xf4, 0x7d, 0x01, 0x9c, 0x16, 0x0b, 0x00, 0x17, 0x00, 0x05, 0x00, 0x00, 0x00, 0x0a, 0x01, 0x00, 0x00, 0x00,
+				0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			desc: "Sized",
+			in: testStructWithSizedArrays{
+				S: &testStructWithArrays{
+					A: [4]byte{0xf4, 0x7d, 0x01, 0x9c},
+					B: [2]uint16{5643, 23},
+					C: [2]TestStructSimple{{A: 5, B: 10, C: true, D: TestListUint32{1}}, {A: 6, B: 11, D: TestListUint32{}}}}},
+			out: []byte{0x00, 0x22, 0xf4, 0x7d, 0x01, 0x9c, 0x16, 0x0b, 0x00, 0x17, 0x00, 0x05, 0x00, 0x00, 0x00, 0x0a, 0x01, 0x00,
+				0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00, 0x00, 0x0b, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			out, err := MarshalToBytes(data.in)
+			if err != nil {
+				t.Fatalf("MarshalToBytes failed: %v", err)
+			}
+
+			if !bytes.Equal(out, data.out) {
+				t.Errorf("MarshalToBytes returned an unexpected sequence of bytes: %x", out)
+			}
+
+			a := reflect.New(reflect.TypeOf(data.in))
+			n, err := UnmarshalFromBytes(out, a.Interface())
+			if err != nil {
+				t.Fatalf("UnmarshalFromBytes failed: %v", err)
+			}
+			if n != len(out) {
+				t.Errorf("UnmarshalFromBytes consumed the wrong number of bytes (%d)", n)
+			}
+
+			if !reflect.DeepEqual(data.in, a.Elem().Interface()) {
+				t.Errorf("UnmarshalFromBytes didn't return the original data")
+			}
+		})
+	}
+}
+
 type TestSizedStruct struct {
 	A uint32
 	B TestListUint32
@@ -607,6 +681,79 @@ func TestMarshalUnion(t *testing.T) {
 	}
 }
 
+type testSizedUnion struct {
+	Data interface{}
+}
+
+func (t testSizedUnion) Select(selector reflect.Value) reflect.Type {
+	switch selector.Interface().(uint32) {
+	case 1:
+		return reflect.TypeOf(uint16(0))
+	case 2:
+		return reflect.TypeOf(TestStructWithPointerSizedStruct{})
+	default:
+		return nil
+	}
+}
+
+type testSizedUnionContainer struct {
+	Select uint32
+	Union  testSizedUnion `tpm2:"selector:Select"`
+}
+
+func TestMarshalUnionWithSizedStruct(t *testing.T) {
+	for _, data := range []struct {
+		desc string
+		in   testSizedUnionContainer
+		out  []byte
+	}{
+		{
+			desc: "Plain",
+			in:   testSizedUnionContainer{Select: 1, Union: testSizedUnion{uint16(4321)}},
+			out:  []byte{0x00, 0x00, 0x00, 0x01, 0x10, 0xe1},
+		},
+		{
+			desc: "Sized",
+			in: testSizedUnionContainer{
+				Select: 2,
+				Union: testSizedUnion{TestStructWithPointerSizedStruct{
+					S: &TestSizedStruct{A: 754122, B: TestListUint32{22189, 854543, 445888654}}}}},
+			out: []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x14, 0x00, 0x0b, 0x81, 0xca, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x56, 0xad,
+				0x00, 0x0d, 0x0a, 0x0f, 0x1a, 0x93, 0xb8, 0x8e},
+		},
+		{
+			desc: "ZeroSized",
+			in:   testSizedUnionContainer{Select: 2, Union: testSizedUnion{TestStructWithPointerSizedStruct{}}},
+			out:  []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			out, err := MarshalToBytes(data.in)
+			if err != nil {
+				t.Fatalf("MarshalToBytes failed: %v", err)
+			}
+
+			if !bytes.Equal(out, data.out) {
+				t.Errorf("MarshalToBytes returned an unexpected sequence of bytes: %x", out)
+			}
+
+			var a testSizedUnionContainer
+
+			n, err := UnmarshalFromBytes(out, &a)
+			if err != nil {
+				t.Fatalf("UnmarshalFromBytes failed: %v", err)
+			}
+			if n != len(out) {
+				t.Errorf("UnmarshalFromBytes consumed the wrong number of bytes (%d)", n)
+			}
+
+			if !reflect.DeepEqual(data.in, a) {
+				t.Errorf("UnmarshalFromBytes didn't return the original data")
+			}
+		})
+	}
+}
+
 func TestMarshalUnionWithNilUnionValue(t *testing.T) {
 	a := TestUnionContainer{Select: 2}
 	out, err := MarshalToBytes(a)
@@ -655,6 +802,129 @@ func TestMarshalUnionWithInvalidSelector(t *testing.T) {
 	}
 }
 
+type testUnionWithContainer struct {
+	Data interface{}
+}
+
+func (t testUnionWithContainer) Select(selector reflect.Value) reflect.Type {
+	panic("not reached")
+}
+
+func (t testUnionWithContainer) SelectWithContainer(selector, container, parent reflect.Value) reflect.Type {
+	if selector.Interface().(uint32) == 0 {
+		return reflect.TypeOf(NilUnionValue)
+	}
+	switch parent.FieldByName("Alg").Interface().(uint16) {
+	case 1:
+		return reflect.TypeOf(uint16(0))
+	case 2:
+		return reflect.TypeOf(uint32(0))
+	default:
+		return nil
+	}
+}
+
+type testStructWithUnionWithContainer struct {
+	Mode  uint32
+	Union testUnionWithContainer `tpm2:"selector:Mode"`
+}
+
+type testNestedUnionContainer struct {
+	Alg     uint16
+	Details testStructWithUnionWithContainer
+}
+
+func TestMarshalUnionWithContainer(t *testing.T) {
+	for _, data := range []struct {
+		desc string
+		in   testNestedUnionContainer
+		out  []byte
+	}{
+		{
+			desc: "1",
+			in:   testNestedUnionContainer{Alg: 1, Details: testStructWithUnionWithContainer{Mode: 1, Union: testUnionWithContainer{uint16(4321)}}},
+			out:  []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x10, 0xe1},
+		},
+		{
+			desc: "2",
+			in:   testNestedUnionContainer{Alg: 2, Details: testStructWithUnionWithContainer{Mode: 1, Union: testUnionWithContainer{uint32(4321)}}},
+			out:  []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x10, 0xe1},
+		},
+		{
+			desc: "Nil",
+			in:   testNestedUnionContainer{Alg: 2},
+			out:  []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x00},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			out, err := MarshalToBytes(data.in)
+			if err != nil {
+				t.Fatalf("MarshalToBytes failed: %v", err)
+			}
+
+			if !bytes.Equal(out, data.out) {
+				t.Errorf("MarshalToBytes returned an unexpected sequence of bytes: %x", out)
+			}
+
+			var a testNestedUnionContainer
+
+			n, err := UnmarshalFromBytes(out, &a)
+			if err != nil {
+				t.Fatalf("UnmarshalFromBytes failed: %v", err)
+			}
+			if n != len(out) {
+				t.Errorf("UnmarshalFromBytes consumed the wrong number of bytes (%d)", n)
+			}
+
+			if !reflect.DeepEqual(data.in, a) {
+				t.Errorf("UnmarshalFromBytes didn't return the original data")
+			}
+		})
+	}
+}
+
+type testStructWithUnionList struct {
+	A uint32
+	L []TestUnionContainer
+}
+
+func TestErrorPath(t *testing.T) {
+	a := testStructWithUnionList{L: []TestUnionContainer{{Select: 3, Union: TestUnion{uint16(1)}}, {Select: 2, Union: TestUnion{uint16(56)}}}}
+	_, err := MarshalToBytes(uint16(0), a)
+	var me *MarshalError
+	if !xerrors.As(err, &me) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if me.Index != 1 {
+		t.Errorf("Unexpected index: %d", me.Index)
+	}
+	if !reflect.DeepEqual(me.Path, []string{"L", "[1]", "Union"}) {
+		t.Errorf("Unexpected path: %v", me.Path)
+	}
+
+	b := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x01, 0x03}
+	var ao testStructWithUnionList
+	_, err = UnmarshalFromBytes(b, &ao)
+	var ue *UnmarshalError
+	if !xerrors.As(err, &ue) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ue.Index != 0 {
+		t.Errorf("Unexpected index: %d", ue.Index)
+	}
+	if !reflect.DeepEqual(ue.Path, []string{"L", "[1]", "Union"}) {
+		t.Errorf("Unexpected path: %v", ue.Path)
+	}
+
+	_, err = UnmarshalFromBytes([]byte{0x00, 0x00}, new(uint32))
+	if !xerrors.As(err, &ue) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(ue.Path) != 0 {
+		t.Errorf("Unexpected path: %v", ue.Path)
+	}
+}
+
 func TestMarshalUnionWithIncorrectType(t *testing.T) {
 	a := TestUnionContainer{Select: 2, Union: TestUnion{uint16(56)}}
 	_, err := MarshalToBytes(a)
@@ -900,6 +1170,104 @@ func TestMarshalStructWithSizedCustomMarshaller(t *testing.T) {
 	}
 }
 
+// testFlagDependentValue is marshalled as a uint32 if bit 0 of the Flags field of the containing struct is set, or as a uint16
+// otherwise.
+type testFlagDependentValue uint32
+
+func (v *testFlagDependentValue) Marshal(buf io.Writer, container interface{}) (int, error) {
+	if container.(testStructWithContextualCustomMarshaller).Flags&1 != 0 {
+		return MarshalToWriter(buf, uint32(*v))
+	}
+	return MarshalToWriter(buf, uint16(*v))
+}
+
+func (v *testFlagDependentValue) Unmarshal(buf io.Reader, container interface{}) (int, error) {
+	if container.(testStructWithContextualCustomMarshaller).Flags&1 != 0 {
+		var x uint32
+		n, err := UnmarshalFromReader(buf, &x)
+		*v = testFlagDependentValue(x)
+		return n, err
+	}
+	var x uint16
+	n, err := UnmarshalFromReader(buf, &x)
+	*v = testFlagDependentValue(x)
+	return n, err
+}
+
+type testStructWithContextualCustomMarshaller struct {
+	Flags uint8
+	Value testFlagDependentValue
+}
+
+func TestMarshalStructWithContextualCustomMarshaller(t *testing.T) {
+	for _, data := range []struct {
+		desc string
+		in   testStructWithContextualCustomMarshaller
+		out  []byte
+	}{
+		{
+			desc: "16",
+			in:   testStructWithContextualCustomMarshaller{Flags: 0, Value: 4321},
+			out:  []byte{0x00, 0x10, 0xe1},
+		},
+		{
+			desc: "32",
+			in:   testStructWithContextualCustomMarshaller{Flags: 1, Value: 4321},
+			out:  []byte{0x01, 0x00, 0x00, 0x10, 0xe1},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			out, err := MarshalToBytes(&data.in)
+			if err != nil {
+				t.Fatalf("MarshalToBytes failed: %v", err)
+			}
+
+			if !bytes.Equal(out, data.out) {
+				t.Errorf("MarshalToBytes returned an unexpected sequence of bytes: %x", out)
+			}
+
+			var a testStructWithContextualCustomMarshaller
+
+			n, err := UnmarshalFromBytes(out, &a)
+			if err != nil {
+				t.Fatalf("UnmarshalFromBytes failed: %v", err)
+			}
+			if n != len(out) {
+				t.Errorf("UnmarshalFromBytes consumed the wrong number of bytes (%d)", n)
+			}
+
+			if !reflect.DeepEqual(data.in, a) {
+				t.Errorf("UnmarshalFromBytes didn't return the original data")
+			}
+		})
+	}
+}
+
+type testPlatformDependentEnum int
+
+type testStructWithPlatformDependentEnum struct {
+	A uint16
+	B testPlatformDependentEnum
+}
+
+func TestMarshalPlatformDependentInt(t *testing.T) {
+	_, err := MarshalToBytes(testStructWithPlatformDependentEnum{A: 1, B: 2})
+	if err == nil {
+		t.Fatalf("MarshalToBytes should fail to marshal an int")
+	}
+	if err.Error() != "cannot marshal argument at index 0: cannot process struct type mu_test.testStructWithPlatformDependentEnum: "+
+		"cannot process field B from struct type mu_test.testStructWithPlatformDependentEnum: cannot process primitive type "+
+		"mu_test.testPlatformDependentEnum, inside container type mu_test.testStructWithPlatformDependentEnum: type "+
+		"mu_test.testPlatformDependentEnum has a platform dependent size, so a fixed width integer type must be used instead" {
+		t.Errorf("MarshalToBytes returned an unexpected error: %v", err)
+	}
+
+	var out testStructWithPlatformDependentEnum
+	if _, err := UnmarshalFromBytes([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x02}, &out); err == nil {
+		t.Errorf("UnmarshalFromBytes should fail to unmarshal an int")
+	}
+}
+
 func TestDetemineTPMKind(t *testing.T) {
 	for _, data := range []struct {
 		desc string
@@ -908,7 +1276,7 @@ func TestDetemineTPMKind(t *testing.T) {
 	}{
 		{
 			desc: "Unsupported",
-			d:    [3]uint16{1, 2, 3},
+			d:    "foo",
 			k:    TPMKindUnsupported,
 		},
 		{
@@ -961,6 +1329,16 @@ func TestDetemineTPMKind(t *testing.T) {
 			d:    testUint16RawSlice{},
 			k:    TPMKindRawList,
 		},
+		{
+			desc: "ByteArray",
+			d:    [4]byte{},
+			k:    TPMKindRawBytes,
+		},
+		{
+			desc: "Array",
+			d:    [3]uint16{1, 2, 3},
+			k:    TPMKindRawList,
+		},
 	} {
 		t.Run(data.desc, func(t *testing.T) {
 			k := DetermineTPMKind(data.d)
@@ -970,3 +1348,358 @@ func TestDetemineTPMKind(t *testing.T) {
 		})
 	}
 }
+
+func TestMarshalledSize(t *testing.T) {
+	for _, data := range []struct {
+		desc string
+		in   []interface{}
+	}{
+		{
+			desc: "Primitives",
+			in:   []interface{}{uint16(1156), true, uint32(45623564)},
+		},
+		{
+			desc: "SizedBuffer",
+			in:   []interface{}{TestSizedBuffer{0x2f, 0x74, 0x68, 0x3f, 0x15}},
+		},
+		{
+			desc: "Struct",
+			in: []interface{}{TestStructWithEmbeddedStructs{
+				A: true,
+				B: 7644,
+				C: TestStructSimple{A: 543, B: 44322323, D: TestListUint32{43221, 565675}},
+				D: &TestStructSimple{A: 8903, B: 3321211, C: true, D: TestListUint32{22143432}}}},
+		},
+		{
+			desc: "Union",
+			in: []interface{}{TestUnionContainer{
+				Select: 1,
+				Union:  TestUnion{&TestStructSimple{56324, 657763432, true, TestListUint32{98767643, 5453423}}}}},
+		},
+		{
+			desc: "SizedStruct",
+			in: []interface{}{TestStructWithPointerSizedStruct{
+				S: &TestSizedStruct{A: 754122, B: TestListUint32{22189, 854543, 445888654}}}},
+		},
+		{
+			desc: "NilSizedStruct",
+			in:   []interface{}{TestStructWithPointerSizedStruct{}},
+		},
+		{
+			desc: "Custom",
+			in:   []interface{}{TestStructWithSizedCustomMarshallerType{A: 6, B: &TestStructWithCustomMarshaller{A: 500, B: TestListUint32{1, 2}}}},
+		},
+		{
+			desc: "Multiple",
+			in:   []interface{}{uint32(10), TestSizedBuffer{0x01, 0x02}, TestListUint32{4, 5, 6}},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			b, err := MarshalToBytes(data.in...)
+			if err != nil {
+				t.Fatalf("MarshalToBytes failed: %v", err)
+			}
+
+			n, err := MarshalledSize(data.in...)
+			if err != nil {
+				t.Fatalf("MarshalledSize failed: %v", err)
+			}
+			if n != len(b) {
+				t.Errorf("MarshalledSize returned an unexpected size (got %d, expected %d)", n, len(b))
+			}
+
+			n, err = MarshalToWriter(new(bytes.Buffer), data.in...)
+			if err != nil {
+				t.Fatalf("MarshalToWriter failed: %v", err)
+			}
+			if n != len(b) {
+				t.Errorf("MarshalToWriter returned an unexpected number of bytes (got %d, expected %d)", n, len(b))
+			}
+		})
+	}
+}
+
+func TestUnmarshalLimited(t *testing.T) {
+	for _, data := range []struct {
+		desc   string
+		in     []byte
+		val    interface{}
+		limits UnmarshalLimits
+		err    string
+	}{
+		{
+			desc:   "NoLimits",
+			in:     []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x2e, 0x00, 0x45, 0xa1, 0xdd},
+			val:    new(TestListUint32),
+			limits: UnmarshalLimits{},
+		},
+		{
+			desc:   "WithinLimits",
+			in:     []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x2e, 0x00, 0x45, 0xa1, 0xdd},
+			val:    new(TestListUint32),
+			limits: UnmarshalLimits{MaxDepth: 2, MaxAllocSize: 8},
+		},
+		{
+			desc:   "LargeList",
+			in:     []byte{0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x2e},
+			val:    new(TestListUint32),
+			limits: UnmarshalLimits{MaxAllocSize: 1024},
+			err: "cannot unmarshal argument at index 0: cannot process list type mu_test.TestListUint32: allocation of 17179869180 " +
+				"bytes for type uint32 would exceed the limit of 1024 bytes",
+		},
+		{
+			desc:   "LargeSizedBuffer",
+			in:     []byte{0x00, 0x10, 0x2f, 0x74, 0x68, 0x3f, 0x15, 0x43, 0x1d, 0x01, 0xea, 0x28, 0xad, 0xe2, 0x6c, 0x4d, 0x00, 0x9b},
+			val:    new(TestSizedBuffer),
+			limits: UnmarshalLimits{MaxAllocSize: 8},
+			err: "cannot unmarshal argument at index 0: cannot process sized type mu_test.TestSizedBuffer: allocation of 16 bytes " +
+				"for type uint8 would exceed the limit of 8 bytes",
+		},
+		{
+			desc: "Depth",
+			in: []byte{0x00, 0x1d, 0xdc, 0x02, 0x1f, 0x02, 0xa4, 0x4e, 0x13, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0xa8,
+				0xd5, 0x00, 0x08, 0xa1, 0xab, 0x22, 0xc7, 0x00, 0x32, 0xad, 0x7b, 0x01, 0x00, 0x00, 0x00, 0x01, 0x01, 0x51, 0xe1, 0xc8},
+			val:    new(TestStructWithEmbeddedStructs),
+			limits: UnmarshalLimits{MaxDepth: 2},
+			err: "cannot unmarshal argument at index 0: cannot process struct type mu_test.TestStructWithEmbeddedStructs: cannot " +
+				"process field C from struct type mu_test.TestStructWithEmbeddedStructs: cannot process struct type " +
+				"mu_test.TestStructSimple, inside container type mu_test.TestStructWithEmbeddedStructs: cannot process field A from " +
+				"struct type mu_test.TestStructSimple: maximum nesting depth " +
+				"of 2 exceeded",
+		},
+		{
+			desc:   "WithinReadSize",
+			in:     []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x2e, 0x00, 0x45, 0xa1, 0xdd},
+			val:    new(TestListUint32),
+			limits: UnmarshalLimits{MaxReadSize: 12},
+		},
+		{
+			desc:   "ReadSize",
+			in:     []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x2e, 0x00, 0x45, 0xa1, 0xdd},
+			val:    new(TestListUint32),
+			limits: UnmarshalLimits{MaxReadSize: 10},
+			err: "cannot unmarshal argument at index 0: cannot process list type mu_test.TestListUint32: cannot process " +
+				"element at index 1 from list type mu_test.TestListUint32: cannot process primitive type uint32, inside " +
+				"container type mu_test.TestListUint32: input exceeds max bytes (10)",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			n, err := UnmarshalFromBytesLimited(data.in, data.limits, data.val)
+			if data.err == "" {
+				if err != nil {
+					t.Fatalf("UnmarshalFromBytesLimited failed: %v", err)
+				}
+				if n != len(data.in) {
+					t.Errorf("UnmarshalFromBytesLimited consumed the wrong number of bytes (%d)", n)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("UnmarshalFromBytesLimited should have failed")
+			}
+			if err.Error() != data.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+type zeroReader struct{}
+
+func (r zeroReader) Read(data []byte) (int, error) {
+	for i := range data {
+		data[i] = 0
+	}
+	return len(data), nil
+}
+
+func TestUnmarshalFromReaderLimitedReadSize(t *testing.T) {
+	// A stream that never ends, and which claims to contain a list of 1048576 elements.
+	r := io.MultiReader(bytes.NewReader([]byte{0x00, 0x10, 0x00, 0x00}), zeroReader{})
+
+	var val TestListUint32
+	n, err := UnmarshalFromReaderLimited(r, UnmarshalLimits{MaxReadSize: 1024}, &val)
+	if err == nil {
+		t.Fatalf("UnmarshalFromReaderLimited should have failed")
+	}
+	var e *InputTooLargeError
+	if !xerrors.As(err, &e) || e.Max != 1024 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if n != 1024 {
+		t.Errorf("UnmarshalFromReaderLimited consumed the wrong number of bytes (%d)", n)
+	}
+}
+
+func TestUnmarshalFromReaderN(t *testing.T) {
+	b, _ := MarshalToBytes(TestListUint32{1, 2, 3})
+
+	t.Run("WithinLimit", func(t *testing.T) {
+		var val TestListUint32
+		if err := UnmarshalFromReaderN(bytes.NewReader(b), int64(len(b)), &val); err != nil {
+			t.Fatalf("UnmarshalFromReaderN failed: %v", err)
+		}
+		if !reflect.DeepEqual(val, TestListUint32{1, 2, 3}) {
+			t.Errorf("Unexpected value: %v", val)
+		}
+	})
+
+	t.Run("ExceedsLimit", func(t *testing.T) {
+		// A stream that never ends, and which claims to contain a list of 1048576 elements.
+		r := io.MultiReader(bytes.NewReader([]byte{0x00, 0x10, 0x00, 0x00}), zeroReader{})
+
+		var val TestListUint32
+		err := UnmarshalFromReaderN(r, 1024, &val)
+		if err == nil {
+			t.Fatalf("UnmarshalFromReaderN should have failed")
+		}
+		var e *InputTooLargeError
+		if !xerrors.As(err, &e) || e.Max != 1024 {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if err.Error() != "input exceeds max bytes (1024)" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Zero", func(t *testing.T) {
+		var val TestListUint32
+		err := UnmarshalFromReaderN(bytes.NewReader(b), 0, &val)
+		var e *InputTooLargeError
+		if !xerrors.As(err, &e) || e.Max != 0 {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		var val TestListUint32
+		err := UnmarshalFromReaderN(bytes.NewReader(b[:len(b)-2]), 1024, &val)
+		if err == nil {
+			t.Fatalf("UnmarshalFromReaderN should have failed")
+		}
+		var e *InputTooLargeError
+		if xerrors.As(err, &e) {
+			t.Errorf("UnmarshalFromReaderN returned an unexpected error: %v", err)
+		}
+	})
+}
+
+func TestUnmarshalSizedBufferInto(t *testing.T) {
+	data, _ := MarshalToBytes(tpm2.Digest{0x01, 0x02, 0x03}, tpm2.Digest{}, tpm2.Digest{0x04, 0x05, 0x06, 0x07, 0x08})
+
+	r := bytes.NewReader(data)
+	buf := make([]byte, 4)
+	for _, expected := range [][]byte{{0x01, 0x02, 0x03}, {}} {
+		n, err := UnmarshalSizedBufferInto(r, buf)
+		if err != nil {
+			t.Fatalf("UnmarshalSizedBufferInto failed: %v", err)
+		}
+		if !bytes.Equal(buf[:n], expected) {
+			t.Errorf("Unexpected data: %x", buf[:n])
+		}
+	}
+
+	_, err := UnmarshalSizedBufferInto(r, buf)
+	var e *BufferTooSmallError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e.Size != 5 || e.Cap != 4 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, err = UnmarshalSizedBufferInto(bytes.NewReader([]byte{0x00, 0x02, 0x01}), buf)
+	if !xerrors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		r.Reset(data)
+		if _, err := UnmarshalSizedBufferInto(r, buf); err != nil {
+			t.Fatalf("UnmarshalSizedBufferInto failed: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Unexpected number of allocations: %v", allocs)
+	}
+}
+
+func TestUnmarshalFromBytesStrict(t *testing.T) {
+	b := []byte{0x04, 0x84, 0x01, 0x02, 0xb8, 0x29, 0x0c}
+
+	var a uint16
+	var c bool
+	var d uint32
+	if err := UnmarshalFromBytesStrict(b, &a, &c, &d); err != nil {
+		t.Fatalf("UnmarshalFromBytesStrict failed: %v", err)
+	}
+	if a != 1156 || !c || d != 45623564 {
+		t.Errorf("UnmarshalFromBytesStrict didn't return the original data")
+	}
+
+	err := UnmarshalFromBytesStrict(b, &a, &c)
+	if err == nil {
+		t.Fatalf("UnmarshalFromBytesStrict should have failed")
+	}
+	e, ok := err.(*TrailingBytesError)
+	if !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+	if e.N != 4 {
+		t.Errorf("Unexpected number of trailing bytes: %d", e.N)
+	}
+	if err.Error() != "4 trailing byte(s) after unmarshalling" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestCopyValue(t *testing.T) {
+	a := TestStructWithEmbeddedStructs{
+		A: true,
+		B: 754,
+		C: TestStructSimple{56324, 657763432, true, TestListUint32{4232, 567785}},
+		D: &TestStructSimple{1, 2, false, TestListUint32{3}}}
+
+	var b TestStructWithEmbeddedStructs
+	if err := CopyValue(&b, a); err != nil {
+		t.Fatalf("CopyValue failed: %v", err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("CopyValue didn't produce an identical value")
+	}
+
+	a.C.D[0] = 0
+	a.D.D[0] = 0
+	a.D.A = 0
+	if b.C.D[0] != 4232 || b.D.D[0] != 3 || b.D.A != 1 {
+		t.Errorf("CopyValue didn't produce a deep copy")
+	}
+
+	var c TestStructWithEmbeddedStructs
+	if err := CopyValue(&c, &b); err != nil {
+		t.Fatalf("CopyValue failed: %v", err)
+	}
+	if !reflect.DeepEqual(b, c) {
+		t.Errorf("CopyValue didn't produce an identical value from a pointer source")
+	}
+
+	var d TestStructSimple
+	err := CopyValue(&d, a)
+	if err == nil {
+		t.Fatalf("CopyValue should fail with mismatched types")
+	}
+	if err.Error() != "cannot copy value of type mu_test.TestStructWithEmbeddedStructs to destination of type *mu_test.TestStructSimple" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(d, TestStructSimple{}) {
+		t.Errorf("CopyValue modified the destination on failure")
+	}
+
+	if err := CopyValue(nil, a); err == nil || err.Error() != "destination must be a non-nil pointer" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	var p *TestStructSimple
+	if err := CopyValue(p, a.C); err == nil || err.Error() != "destination must be a non-nil pointer" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -6,9 +6,12 @@ package tpm2_test
 
 import (
 	"bytes"
+	"crypto"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/internal"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestParameterEncryptionSingleExtra(t *testing.T) {
@@ -369,3 +372,125 @@ func TestParameterEncryptionMultipleExtra(t *testing.T) {
 		})
 	}
 }
+
+// mockParamCryptTPM emulates the parameter encryption behaviour of a TPM for a single unsalted and unbound session, in order to test
+// parameter encryption without a TPM.
+type mockParamCryptTPM struct {
+	t         *testing.T
+	symmetric SymDef
+	nonceTPM  Nonce
+	random    []byte
+	stirred   []byte
+}
+
+func (m *mockParamCryptTPM) cryptParam(data []byte, nonceNewer, nonceOlder Nonce, encrypt bool) {
+	switch m.symmetric.Algorithm {
+	case SymAlgorithmAES:
+		keyBits := int(m.symmetric.KeyBits.Sym())
+		k := internal.KDFa(crypto.SHA256, nil, []byte("CFB"), nonceNewer, nonceOlder, keyBits+128)
+		var err error
+		if encrypt {
+			err = internal.EncryptSymmetricAES(k[:keyBits/8], internal.SymmetricModeCFB, data, k[keyBits/8:])
+		} else {
+			err = internal.DecryptSymmetricAES(k[:keyBits/8], internal.SymmetricModeCFB, data, k[keyBits/8:])
+		}
+		if err != nil {
+			m.t.Fatalf("AES failed: %v", err)
+		}
+	case SymAlgorithmXOR:
+		mask := internal.KDFa(crypto.SHA256, nil, []byte("XOR"), nonceNewer, nonceOlder, len(data)*8)
+		for i := range data {
+			data[i] ^= mask[i]
+		}
+	}
+}
+
+func (m *mockParamCryptTPM) respond(cmd []byte) []byte {
+	c := decodeMockCommand(m.t, cmd, 0)
+	commandCode := c.Code
+
+	nonceTPM := make(Nonce, 32)
+	nonceTPM[0] = m.nonceTPM[0] + 1
+
+	if commandCode == CommandStartAuthSession {
+		m.nonceTPM = nonceTPM
+		params, _ := mu.MarshalToBytes(Handle(0x02000000), nonceTPM)
+		return makeMockResponse(Success, nil, params)
+	}
+
+	if len(c.Auths) != 1 {
+		m.t.Fatalf("Unexpected number of command authorizations: %d", len(c.Auths))
+	}
+	auth := c.Auths[0]
+	cpBytes := c.Params
+
+	var rpBytes []byte
+	switch commandCode {
+	case CommandGetRandom:
+		if auth.Attrs&0x40 == 0 {
+			m.t.Errorf("Expected the encrypt attribute to be set")
+		}
+		data := make([]byte, len(m.random))
+		copy(data, m.random)
+		m.cryptParam(data, nonceTPM, auth.Nonce, true)
+		rpBytes, _ = mu.MarshalToBytes(Digest(data))
+	case CommandStirRandom:
+		if auth.Attrs&0x20 == 0 {
+			m.t.Errorf("Expected the decrypt attribute to be set")
+		}
+		var data SensitiveData
+		if _, err := mu.UnmarshalFromBytes(cpBytes, &data); err != nil {
+			m.t.Fatalf("Cannot unmarshal command parameters: %v", err)
+		}
+		m.cryptParam(data, auth.Nonce, m.nonceTPM, false)
+		m.stirred = data
+	default:
+		m.t.Fatalf("Unexpected command: %v", commandCode)
+	}
+	m.nonceTPM = nonceTPM
+
+	return makeMockResponse(Success, nil, rpBytes, mockResponseAuth{Nonce: nonceTPM, Attrs: auth.Attrs & 0x01})
+}
+
+func runParameterEncryptionMockTest(t *testing.T, symmetric SymDef, size int) {
+	random := make([]byte, size)
+	for i := range random {
+		random[i] = byte(i)
+	}
+	m := &mockParamCryptTPM{t: t, symmetric: symmetric, nonceTPM: Nonce{0}, random: random}
+	tpm, _ := NewTPMContext(&mockTcti{respond: m.respond})
+
+	sc, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, &symmetric, HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("StartAuthSession failed: %v", err)
+	}
+
+	data, err := tpm.GetRandom(uint16(size), sc.WithAttrs(AttrContinueSession|AttrResponseEncrypt))
+	if err != nil {
+		t.Fatalf("GetRandom failed: %v", err)
+	}
+	if !bytes.Equal(data, random) {
+		t.Errorf("GetRandom returned the wrong data: %x", data)
+	}
+
+	if err := tpm.StirRandom(SensitiveData(random), sc.WithAttrs(AttrContinueSession|AttrCommandEncrypt)); err != nil {
+		t.Fatalf("StirRandom failed: %v", err)
+	}
+	if !bytes.Equal(m.stirred, random) {
+		t.Errorf("StirRandom sent the wrong data: %x", m.stirred)
+	}
+}
+
+func TestParameterEncryptionAESMock(t *testing.T) {
+	runParameterEncryptionMockTest(t, SymDef{
+		Algorithm: SymAlgorithmAES,
+		KeyBits:   SymKeyBitsU{Data: uint16(128)},
+		Mode:      SymModeU{Data: SymModeCFB}}, 32)
+}
+
+func TestParameterEncryptionXORMock(t *testing.T) {
+	// Use parameters that are longer than a single digest so that the mask is constructed from more than one KDFa block.
+	runParameterEncryptionMockTest(t, SymDef{
+		Algorithm: SymAlgorithmXOR,
+		KeyBits:   SymKeyBitsU{Data: HashAlgorithmSHA256}}, 100)
+}
@@ -96,8 +96,14 @@ type ResourceContext interface {
 
 	// SetAuthValue sets the authorization value that will be used in authorization roles where knowledge of the authorization
 	// value is required. Functions that create resources on the TPM and return a ResourceContext will set this automatically,
-	// else it will need to be set manually.
+	// else it will need to be set manually, eg, for a ResourceContext created with TPMContext.CreateResourceContextFromTPM. The
+	// supplied value is copied, so the caller is free to modify or clear it afterwards.
 	SetAuthValue([]byte)
+
+	// WithAuth returns a duplicate of this ResourceContext with the specified session attached to it. If the returned
+	// ResourceContext is supplied to a command in a role that requires authorization and no authorization session is explicitly
+	// provided, the attached session will be used instead of passphrase authorization.
+	WithAuth(session SessionContext) ResourceContext
 }
 
 type resourceContextPrivate interface {
@@ -299,6 +305,10 @@ func (r *dummyContext) SerializeToWriter(io.Writer) error {
 func (r *dummyContext) SetAuthValue([]byte) {
 }
 
+func (r *dummyContext) WithAuth(session SessionContext) ResourceContext {
+	return &resourceContextWithAuth{ResourceContext: r, session: session}
+}
+
 func (r *dummyContext) invalidate() {}
 
 func (r *dummyContext) data() *handleContextData {
@@ -333,7 +343,12 @@ func (r *permanentContext) SerializeToWriter(w io.Writer) error {
 }
 
 func (r *permanentContext) SetAuthValue(value []byte) {
-	r.auth = value
+	r.auth = make([]byte, len(value))
+	copy(r.auth, value)
+}
+
+func (r *permanentContext) WithAuth(session SessionContext) ResourceContext {
+	return &resourceContextWithAuth{ResourceContext: r, session: session}
 }
 
 func (r *permanentContext) invalidate() {}
@@ -374,7 +389,12 @@ func (r *objectContext) SerializeToWriter(w io.Writer) error {
 }
 
 func (r *objectContext) SetAuthValue(value []byte) {
-	r.auth = value
+	r.auth = make([]byte, len(value))
+	copy(r.auth, value)
+}
+
+func (r *objectContext) WithAuth(session SessionContext) ResourceContext {
+	return &resourceContextWithAuth{ResourceContext: r, session: session}
 }
 
 func (r *objectContext) invalidate() {
@@ -405,11 +425,6 @@ func (t *TPMContext) makeObjectContextFromTPM(context ResourceContext, sessions
 	if err != nil {
 		return nil, err
 	}
-	if n, err := pub.Name(); err != nil {
-		return nil, &InvalidResponseError{CommandReadPublic, fmt.Sprintf("cannot compute name of returned public area: %v", err)}
-	} else if !bytes.Equal(n, name) {
-		return nil, &InvalidResponseError{CommandReadPublic, "name and public area don't match"}
-	}
 	return makeObjectContext(context.Handle(), name, pub), nil
 }
 
@@ -435,7 +450,12 @@ func (r *nvIndexContext) SerializeToWriter(w io.Writer) error {
 }
 
 func (r *nvIndexContext) SetAuthValue(value []byte) {
-	r.auth = value
+	r.auth = make([]byte, len(value))
+	copy(r.auth, value)
+}
+
+func (r *nvIndexContext) WithAuth(session SessionContext) ResourceContext {
+	return &resourceContextWithAuth{ResourceContext: r, session: session}
 }
 
 func (r *nvIndexContext) invalidate() {
@@ -465,10 +485,39 @@ func (r *nvIndexContext) clearAttr(a NVAttributes) {
 	r.d.Name = name
 }
 
+// refreshAttrs updates the attributes of this context from a public area and name read back from the TPM. Only the attributes that
+// the TPM changes during the lifetime of an index are updated, and only if the rest of the public area matches the current one and
+// the name is consistent with it.
+func (r *nvIndexContext) refreshAttrs(public *NVPublic, name Name) {
+	const mutableAttrs = AttrNVWriteLocked | AttrNVWritten | AttrNVReadLocked
+
+	current := r.d.Data.Data.(*NVPublic)
+	switch {
+	case current == nil || public == nil:
+		return
+	case public.Index != current.Index || public.NameAlg != current.NameAlg || public.Size != current.Size:
+		return
+	case !bytes.Equal(public.AuthPolicy, current.AuthPolicy):
+		return
+	case public.Attrs&^mutableAttrs != current.Attrs&^mutableAttrs:
+		return
+	}
+	if n, err := public.Name(); err != nil || !bytes.Equal(n, name) {
+		return
+	}
+
+	current.Attrs = public.Attrs
+	r.d.Name = name
+}
+
 func (r *nvIndexContext) attrs() NVAttributes {
 	return r.d.Data.Data.(*NVPublic).Attrs
 }
 
+func (r *nvIndexContext) size() uint16 {
+	return r.d.Data.Data.(*NVPublic).Size
+}
+
 func makeNVIndexContext(name Name, public *NVPublic) *nvIndexContext {
 	return &nvIndexContext{d: handleContextData{Type: handleContextTypeNvIndex, Handle: public.Index, Name: name, Data: handleContextDataU{public}}}
 }
@@ -489,9 +538,49 @@ func (t *TPMContext) makeNVIndexContextFromTPM(context ResourceContext, sessions
 	return makeNVIndexContext(name, pub), nil
 }
 
+// resourceContextWithAuth is a ResourceContext returned from ResourceContext.WithAuth, which associates another ResourceContext with
+// a default authorization session.
+type resourceContextWithAuth struct {
+	ResourceContext
+	session SessionContext
+}
+
+func (r *resourceContextWithAuth) WithAuth(session SessionContext) ResourceContext {
+	return r.ResourceContext.WithAuth(session)
+}
+
+func (r *resourceContextWithAuth) invalidate() {
+	r.ResourceContext.(handleContextPrivate).invalidate()
+}
+
+func (r *resourceContextWithAuth) data() *handleContextData {
+	return r.ResourceContext.(handleContextPrivate).data()
+}
+
+func (r *resourceContextWithAuth) authValue() []byte {
+	return r.ResourceContext.(resourceContextPrivate).authValue()
+}
+
+// unwrapHandleContext returns the ResourceContext associated with hc if it was returned from ResourceContext.WithAuth, else it
+// returns hc.
+func unwrapHandleContext(hc HandleContext) HandleContext {
+	if w, isWrapped := hc.(*resourceContextWithAuth); isWrapped {
+		return w.ResourceContext
+	}
+	return hc
+}
+
+// sessionStatus contains host-side state for a session that is not serialized.
+type sessionStatus struct {
+	// unusableErr is set to the error that caused the session to become unusable, if a command that used it failed in a way that
+	// means that the host-side state of the session may no longer be consistent with the TPM.
+	unusableErr error
+}
+
 type sessionContext struct {
-	d     *handleContextData
-	attrs SessionAttributes
+	d      *handleContextData
+	attrs  SessionAttributes
+	status *sessionStatus // Shared with copies created by WithAttrs, IncludeAttrs and ExcludeAttrs
 }
 
 func (r *sessionContext) Handle() Handle {
@@ -539,15 +628,15 @@ func (r *sessionContext) SetAttrs(attrs SessionAttributes) {
 }
 
 func (r *sessionContext) WithAttrs(attrs SessionAttributes) SessionContext {
-	return &sessionContext{d: r.d, attrs: attrs}
+	return &sessionContext{d: r.d, attrs: attrs, status: r.status}
 }
 
 func (r *sessionContext) IncludeAttrs(attrs SessionAttributes) SessionContext {
-	return &sessionContext{d: r.d, attrs: r.attrs | attrs}
+	return &sessionContext{d: r.d, attrs: r.attrs | attrs, status: r.status}
 }
 
 func (r *sessionContext) ExcludeAttrs(attrs SessionAttributes) SessionContext {
-	return &sessionContext{d: r.d, attrs: r.attrs &^ attrs}
+	return &sessionContext{d: r.d, attrs: r.attrs &^ attrs, status: r.status}
 }
 
 func (r *sessionContext) invalidate() {
@@ -560,6 +649,12 @@ func (r *sessionContext) data() *handleContextData {
 	return r.d
 }
 
+// markUnusable marks this session as unusable because a command that used it failed with the specified error in a way that means
+// that the host-side state of the session may no longer be consistent with the TPM.
+func (r *sessionContext) markUnusable(err error) {
+	r.status.unusableErr = err
+}
+
 func (r *sessionContext) scData() *sessionContextData {
 	return r.d.Data.Data.(*sessionContextData)
 }
@@ -567,7 +662,8 @@ func (r *sessionContext) scData() *sessionContextData {
 func makeSessionContext(handle Handle, data *sessionContextData) *sessionContext {
 	name := make(Name, binary.Size(Handle(0)))
 	binary.BigEndian.PutUint32(name, uint32(handle))
-	return &sessionContext{d: &handleContextData{Type: handleContextTypeSession, Handle: handle, Name: name, Data: handleContextDataU{data}}}
+	return &sessionContext{d: &handleContextData{Type: handleContextTypeSession, Handle: handle, Name: name, Data: handleContextDataU{data}},
+		status: new(sessionStatus)}
 }
 
 func (t *TPMContext) checkHandleContextParam(hc HandleContext) error {
@@ -575,7 +671,7 @@ func (t *TPMContext) checkHandleContextParam(hc HandleContext) error {
 		return errors.New("nil value")
 	}
 	if hc.Handle() == HandleUnassigned {
-		return errors.New("resource has been closed")
+		return ErrResourceDoesNotExist
 	}
 	return nil
 }
@@ -589,6 +685,12 @@ func (t *TPMContext) checkHandleContextParam(hc HandleContext) error {
 // on the second read once the name is known. This second read provides an assurance that an entity with the name of the returned
 // ResourceContext actually lives on the TPM.
 //
+// The returned ResourceContext is cached. If this function is called again for the same handle without any sessions, the cached
+// ResourceContext will be returned without executing any commands, as long as it hasn't been invalidated. The cache entry for a
+// handle is removed when the corresponding resource is flushed, evicted or undefined using this TPMContext, or when
+// TPMContext.Clear succeeds. If the resource is flushed or evicted by another process, TPMContext.ForgetHandle should be used to
+// remove the stale cache entry.
+//
 // This function will panic if handle doesn't correspond to a NV index, transient object or persistent object.
 //
 // If subsequent use of the returned ResourceContext requires knowledge of the authorization value of the corresponding TPM resource,
@@ -600,6 +702,12 @@ func (t *TPMContext) CreateResourceContextFromTPM(handle Handle, sessions ...Ses
 		panic("invalid handle type")
 	}
 
+	if len(sessions) == 0 {
+		if rc := t.cachedResourceContext(handle); rc != nil {
+			return rc, nil
+		}
+	}
+
 	var rc ResourceContext = makeDummyContext(handle)
 	var s []SessionContext
 	for i := 0; i < 2; i++ {
@@ -625,9 +733,71 @@ func (t *TPMContext) CreateResourceContextFromTPM(handle Handle, sessions ...Ses
 		s = sessions
 	}
 
+	t.resourcesMu.Lock()
+	t.resources[handle] = rc
+	t.resourcesMu.Unlock()
 	return rc, nil
 }
 
+// cachedResourceContext returns the ResourceContext for handle cached by TPMContext.CreateResourceContextFromTPM, or nil if there
+// isn't one.
+func (t *TPMContext) cachedResourceContext(handle Handle) ResourceContext {
+	t.resourcesMu.Lock()
+	defer t.resourcesMu.Unlock()
+
+	if rc, exists := t.resources[handle]; exists && rc.Handle() == handle {
+		return rc
+	}
+	return nil
+}
+
+// forgetAllHandles drops all of the ResourceContexts cached by TPMContext.CreateResourceContextFromTPM.
+func (t *TPMContext) forgetAllHandles() {
+	t.resourcesMu.Lock()
+	defer t.resourcesMu.Unlock()
+	t.resources = make(map[Handle]ResourceContext)
+}
+
+// platformPersistentHandleFirst is the first persistent handle in the range reserved for the platform by the TCG Handle and
+// Localities specification. Persistent objects in this range are in the platform hierarchy and are not removed by TPM2_Clear.
+const platformPersistentHandleFirst Handle = 0x81800000
+
+// invalidateClearedResources invalidates and drops the ResourceContexts cached by TPMContext.CreateResourceContextFromTPM that
+// correspond to resources removed by TPM2_Clear. This is all transient objects, persistent objects in the owner or endorsement
+// hierarchies and NV indices that weren't created by the platform. Any other ResourceContexts are dropped from the cache without
+// being invalidated.
+func (t *TPMContext) invalidateClearedResources() {
+	t.resourcesMu.Lock()
+	defer t.resourcesMu.Unlock()
+
+	for _, rc := range t.resources {
+		switch r := rc.(type) {
+		case *objectContext:
+			if r.Handle().Type() == HandleTypePersistent && r.Handle() >= platformPersistentHandleFirst {
+				continue
+			}
+			r.invalidate()
+		case *nvIndexContext:
+			if r.attrs()&AttrNVPlatformCreate != 0 {
+				continue
+			}
+			r.invalidate()
+		}
+	}
+	t.resources = make(map[Handle]ResourceContext)
+}
+
+// ForgetHandle removes the cached ResourceContext for the specified handle that was created by
+// TPMContext.CreateResourceContextFromTPM, so that the next call to TPMContext.CreateResourceContextFromTPM for handle reads the
+// public area from the TPM again. This should be used when the resource associated with handle has been flushed or evicted by
+// another process. ResourceContext instances that have already been returned are not modified. This does nothing if there is no
+// cached ResourceContext for handle.
+func (t *TPMContext) ForgetHandle(handle Handle) {
+	t.resourcesMu.Lock()
+	defer t.resourcesMu.Unlock()
+	delete(t.resources, handle)
+}
+
 // CreateIncompleteSessionContext creates and returns a new SessionContext for the specified handle. The returned SessionContext will
 // not be complete and the session associated with it cannot be used in any command other than TPMContext.FlushContext.
 //
@@ -650,6 +820,9 @@ func CreateIncompleteSessionContext(handle Handle) SessionContext {
 func (t *TPMContext) GetPermanentContext(handle Handle) ResourceContext {
 	switch handle.Type() {
 	case HandleTypePermanent, HandleTypePCR:
+		t.resourcesMu.Lock()
+		defer t.resourcesMu.Unlock()
+
 		if rc, exists := t.permanentResources[handle]; exists {
 			return rc
 		}
@@ -727,13 +900,13 @@ func CreateHandleContextFromReader(r io.Reader) (HandleContext, error) {
 	}
 
 	var data *handleContextData
-	n, err := mu.UnmarshalFromBytes(b, &data)
-	if err != nil {
+	if err := mu.UnmarshalFromBytesStrict(b, &data); err != nil {
+		var e *mu.TrailingBytesError
+		if xerrors.As(err, &e) {
+			return nil, errors.New("context blob contains trailing bytes")
+		}
 		return nil, xerrors.Errorf("cannot unmarshal context data: %w", err)
 	}
-	if n < len(b) {
-		return nil, errors.New("context blob contains trailing bytes")
-	}
 
 	if data.Type == handleContextTypePermanent {
 		return nil, errors.New("cannot create a permanent context from serialized data")
@@ -750,7 +923,7 @@ func CreateHandleContextFromReader(r io.Reader) (HandleContext, error) {
 	case handleContextTypeNvIndex:
 		hc = &nvIndexContext{d: *data}
 	case handleContextTypeSession:
-		hc = &sessionContext{d: data}
+		hc = &sessionContext{d: data, status: new(sessionStatus)}
 	default:
 		panic("not reached")
 	}
@@ -6,9 +6,11 @@ package tpm2_test
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
+	"github.com/canonical/go-tpm2/mu"
 )
 
 func TestCreateResourceContextFromTPM(t *testing.T) {
@@ -344,3 +346,303 @@ func TestSessionContextExcludeAttrs(t *testing.T) {
 		t.Errorf("SessionContext.ExcludeAttrs didn't work")
 	}
 }
+
+func TestResourceContextWithAuth(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy|testCapabilityOwnerPersist)
+	defer closeTPM(t, tpm)
+
+	t.Run("Object", func(t *testing.T) {
+		srk := createRSASrkForTesting(t, tpm, Auth("1234"))
+		defer flushContext(t, tpm, srk)
+
+		sc, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sc)
+
+		parent := srk.WithAuth(sc.WithAttrs(AttrContinueSession | AttrAudit))
+		if parent.Handle() != srk.Handle() {
+			t.Errorf("WithAuth returned a context with the wrong handle")
+		}
+		if !bytes.Equal(parent.Name(), srk.Name()) {
+			t.Errorf("WithAuth returned a context with the wrong name")
+		}
+
+		template := Public{
+			Type:    ObjectTypeKeyedHash,
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   AttrFixedTPM | AttrFixedParent | AttrUserWithAuth,
+			Params:  PublicParamsU{Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}}}
+		sensitive := SensitiveCreate{Data: []byte("foo")}
+
+		priv, pub, _, _, _, err := tpm.Create(parent, &sensitive, &template, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if !sc.IsAudit() {
+			t.Errorf("Create didn't use the attached session")
+		}
+
+		object, err := tpm.Load(parent, priv, pub, nil)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		defer flushContext(t, tpm, object)
+
+		data, err := tpm.Unseal(object.WithAuth(sc.WithAttrs(AttrContinueSession)), nil)
+		if err != nil {
+			t.Fatalf("Unseal failed: %v", err)
+		}
+		if !bytes.Equal(data, sensitive.Data) {
+			t.Errorf("Unseal returned the wrong data")
+		}
+	})
+
+	t.Run("NV", func(t *testing.T) {
+		pub := NVPublic{
+			Index:   0x018100ff,
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   NVTypeOrdinary.WithAttrs(AttrNVAuthRead | AttrNVAuthWrite),
+			Size:    8}
+		rc, err := tpm.NVDefineSpace(tpm.OwnerHandleContext(), nil, &pub, nil)
+		if err != nil {
+			t.Fatalf("NVDefineSpace failed: %v", err)
+		}
+		defer undefineNVSpace(t, tpm, rc, tpm.OwnerHandleContext())
+
+		sc, err := tpm.StartAuthSession(nil, nil, SessionTypeHMAC, nil, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sc)
+
+		index := rc.WithAuth(sc.WithAttrs(AttrContinueSession))
+		if err := tpm.NVWrite(index, index, []byte("bar"), 0, nil); err != nil {
+			t.Fatalf("NVWrite failed: %v", err)
+		}
+		data, err := tpm.NVRead(index, index, 3, 0, nil)
+		if err != nil {
+			t.Fatalf("NVRead failed: %v", err)
+		}
+		if !bytes.Equal(data, []byte("bar")) {
+			t.Errorf("NVRead returned the wrong data")
+		}
+
+		rc2, err := tpm.CreateResourceContextFromTPM(rc.Handle())
+		if err != nil {
+			t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+		}
+		if !bytes.Equal(rc.Name(), rc2.Name()) {
+			t.Errorf("NVWrite didn't update the name of the wrapped context")
+		}
+	})
+}
+
+func TestResourceContextSetAuthValue(t *testing.T) {
+	sealed := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrUserWithAuth,
+		Params:  PublicParamsU{Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+		Unique:  PublicIDU{Data: make(Digest, 32)}}
+
+	// Emulate a persistent sealed object for which a new ResourceContext has been created, which has no knowledge of the
+	// authorization value.
+	item, err := CreateObjectResourceContextFromPublic(0x81000001, &sealed)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	params, _ := mu.MarshalToBytes(SensitiveData("secret"))
+	rsp := makeMockResponse(Success, nil, params, mockPasswordAuth)
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	auth := []byte("1234")
+	item.SetAuthValue(auth)
+	// Modifying the supplied slice should not affect the authorization value of the ResourceContext.
+	auth[0] = 'x'
+
+	data, err := tpm.Unseal(item, nil)
+	if err != nil {
+		t.Fatalf("Unseal failed: %v", err)
+	}
+	if !bytes.Equal(data, []byte("secret")) {
+		t.Errorf("Unseal returned the wrong data: %x", data)
+	}
+
+	c := decodeMockCommand(t, tcti.commands.Bytes(), 1)
+	if len(c.Auths) != 1 {
+		t.Fatalf("Unexpected number of command authorizations: %d", len(c.Auths))
+	}
+	cmdAuth := c.Auths[0]
+	if cmdAuth.Handle != HandlePW {
+		t.Errorf("Unexpected session handle: %v", cmdAuth.Handle)
+	}
+	if !bytes.Equal(cmdAuth.HMAC, []byte("1234")) {
+		t.Errorf("Unexpected password: %q", cmdAuth.HMAC)
+	}
+}
+
+func TestConcurrentResourceCache(t *testing.T) {
+	pub := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	pub.Unique = PublicIDU{Data: make(Digest, 32)}
+	name, err := pub.Name()
+	if err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+
+	respond := func(cmd []byte) []byte {
+		var commandCode CommandCode
+		if _, err := mu.UnmarshalFromBytes(cmd[6:], &commandCode); err != nil {
+			return nil
+		}
+
+		switch commandCode {
+		case CommandReadPublic:
+			pubBytes, _ := mu.MarshalToBytes(pub)
+			params, _ := mu.MarshalToBytes(uint16(len(pubBytes)), mu.RawBytes(pubBytes), name, Name(nil))
+			return makeMockResponse(Success, nil, params)
+		case CommandFlushContext:
+			return makeMockResponse(Success, nil, nil)
+		default:
+			return nil
+		}
+	}
+	tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+
+	// Create and flush contexts for different objects from multiple goroutines, which updates the cache of ResourceContexts
+	// concurrently. This is intended to be run with the race detector enabled.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(handle Handle) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				tpm.OwnerHandleContext()
+				rc, err := tpm.CreateResourceContextFromTPM(handle)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if err := tpm.FlushContext(rc); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(Handle(0x80000000 + i))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestCreateResourceContextFromTPMCache(t *testing.T) {
+	pub := NewSymCipherTemplate(SymObjectAlgorithmAES, 128, SymModeCFB)
+	pub.Unique = PublicIDU{Data: make(Digest, 32)}
+	name, err := pub.Name()
+	if err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+
+	var readPublicCount int
+	respond := func(cmd []byte) []byte {
+		var commandCode CommandCode
+		if _, err := mu.UnmarshalFromBytes(cmd[6:], &commandCode); err != nil {
+			t.Fatalf("Cannot unmarshal command code: %v", err)
+		}
+
+		switch commandCode {
+		case CommandReadPublic:
+			readPublicCount++
+			pubBytes, _ := mu.MarshalToBytes(pub)
+			params, _ := mu.MarshalToBytes(uint16(len(pubBytes)), mu.RawBytes(pubBytes), name, Name(nil))
+			return makeMockResponse(Success, nil, params)
+		case CommandFlushContext:
+			return makeMockResponse(Success, nil, nil)
+		case CommandClear, CommandHierarchyControl:
+			return makeMockResponse(Success, nil, nil, mockPasswordAuth)
+		default:
+			t.Fatalf("Unexpected command: %v", commandCode)
+		}
+		return nil
+	}
+	tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+
+	rc1, err := tpm.CreateResourceContextFromTPM(0x80000001)
+	if err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+	rc2, err := tpm.CreateResourceContextFromTPM(0x80000001)
+	if err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+	if rc1 != rc2 {
+		t.Errorf("CreateResourceContextFromTPM should have returned the cached context")
+	}
+	if readPublicCount != 1 {
+		t.Errorf("Unexpected number of TPM2_ReadPublic commands: %d", readPublicCount)
+	}
+
+	// Forgetting an unknown handle is a no-op.
+	tpm.ForgetHandle(0x80000002)
+
+	tpm.ForgetHandle(0x80000001)
+	rc3, err := tpm.CreateResourceContextFromTPM(0x80000001)
+	if err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+	if rc3 == rc1 {
+		t.Errorf("CreateResourceContextFromTPM should have returned a new context after ForgetHandle")
+	}
+	if readPublicCount != 2 {
+		t.Errorf("Unexpected number of TPM2_ReadPublic commands: %d", readPublicCount)
+	}
+
+	// Flushing a different context for the same handle should remove the cache entry.
+	if err := tpm.FlushContext(rc1); err != nil {
+		t.Fatalf("FlushContext failed: %v", err)
+	}
+	rc4, err := tpm.CreateResourceContextFromTPM(0x80000001)
+	if err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+	if rc4 == rc3 || readPublicCount != 3 {
+		t.Errorf("CreateResourceContextFromTPM should have read the public area again after FlushContext")
+	}
+
+	if err := tpm.Clear(tpm.LockoutHandleContext(), nil); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if _, err := tpm.CreateResourceContextFromTPM(0x80000001); err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+	if readPublicCount != 4 {
+		t.Errorf("CreateResourceContextFromTPM should have read the public area again after Clear")
+	}
+
+	// Enabling a hierarchy shouldn't affect the cache, but disabling one should.
+	if err := tpm.HierarchyControl(tpm.PlatformHandleContext(), HandleOwner, true, nil); err != nil {
+		t.Fatalf("HierarchyControl failed: %v", err)
+	}
+	if _, err := tpm.CreateResourceContextFromTPM(0x80000001); err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+	if readPublicCount != 4 {
+		t.Errorf("CreateResourceContextFromTPM should have returned the cached context after enabling a hierarchy")
+	}
+	if err := tpm.HierarchyControl(tpm.OwnerHandleContext(), HandleOwner, false, nil); err != nil {
+		t.Fatalf("HierarchyControl failed: %v", err)
+	}
+	if _, err := tpm.CreateResourceContextFromTPM(0x80000001); err != nil {
+		t.Fatalf("CreateResourceContextFromTPM failed: %v", err)
+	}
+	if readPublicCount != 5 {
+		t.Errorf("CreateResourceContextFromTPM should have read the public area again after disabling a hierarchy")
+	}
+}
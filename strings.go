@@ -95,14 +95,32 @@ func (c CommandCode) String() string {
 		return "TPM_CC_HierarchyControl"
 	case CommandNVUndefineSpace:
 		return "TPM_CC_NV_UndefineSpace"
+	case CommandChangeEPS:
+		return "TPM_CC_ChangeEPS"
+	case CommandChangePPS:
+		return "TPM_CC_ChangePPS"
 	case CommandClear:
 		return "TPM_CC_Clear"
 	case CommandClearControl:
 		return "TPM_CC_ClearControl"
+	case CommandClockSet:
+		return "TPM_CC_ClockSet"
 	case CommandHierarchyChangeAuth:
 		return "TPM_CC_HierarchyChangeAuth"
 	case CommandNVDefineSpace:
 		return "TPM_CC_NV_DefineSpace"
+	case CommandPCRAllocate:
+		return "TPM_CC_PCR_Allocate"
+	case CommandPCRSetAuthPolicy:
+		return "TPM_CC_PCR_SetAuthPolicy"
+	case CommandPPCommands:
+		return "TPM_CC_PP_Commands"
+	case CommandSetPrimaryPolicy:
+		return "TPM_CC_SetPrimaryPolicy"
+	case CommandFieldUpgradeStart:
+		return "TPM_CC_FieldUpgradeStart"
+	case CommandClockRateAdjust:
+		return "TPM_CC_ClockRateAdjust"
 	case CommandCreatePrimary:
 		return "TPM_CC_CreatePrimary"
 	case CommandNVGlobalWriteLock:
@@ -131,8 +149,12 @@ func (c CommandCode) String() string {
 		return "TPM_CC_PCR_Reset"
 	case CommandSequenceComplete:
 		return "TPM_CC_SequenceComplete"
+	case CommandSetAlgorithmSet:
+		return "TPM_CC_SetAlgorithmSet"
 	case CommandSetCommandCodeAuditStatus:
 		return "TPM_CC_SetCommandCodeAuditStatus"
+	case CommandFieldUpgradeData:
+		return "TPM_CC_FieldUpgradeData"
 	case CommandIncrementalSelfTest:
 		return "TPM_CC_IncrementalSelfTest"
 	case CommandSelfTest:
@@ -165,14 +187,22 @@ func (c CommandCode) String() string {
 		return "TPM_CC_ObjectChangeAuth"
 	case CommandPolicySecret:
 		return "TPM_CC_PolicySecret"
+	case CommandRewrap:
+		return "TPM_CC_Rewrap"
 	case CommandCreate:
 		return "TPM_CC_Create"
+	case CommandECDHZGen:
+		return "TPM_CC_ECDH_ZGen"
+	case CommandHMAC:
+		return "TPM_CC_HMAC"
 	case CommandImport:
 		return "TPM_CC_Import"
 	case CommandLoad:
 		return "TPM_CC_Load"
 	case CommandQuote:
 		return "TPM_CC_Quote"
+	case CommandRSADecrypt:
+		return "TPM_CC_RSA_Decrypt"
 	case CommandHMACStart:
 		return "TPM_CC_HMAC_Start"
 	case CommandSequenceUpdate:
@@ -187,6 +217,10 @@ func (c CommandCode) String() string {
 		return "TPM_CC_ContextLoad"
 	case CommandContextSave:
 		return "TPM_CC_ContextSave"
+	case CommandECDHKeyGen:
+		return "TPM_CC_ECDH_KeyGen"
+	case CommandEncryptDecrypt:
+		return "TPM_CC_EncryptDecrypt"
 	case CommandFlushContext:
 		return "TPM_CC_FlushContext"
 	case CommandLoadExternal:
@@ -205,6 +239,8 @@ func (c CommandCode) String() string {
 		return "TPM_CC_PolicyCounterTimer"
 	case CommandPolicyCpHash:
 		return "TPM_CC_PolicyCpHash"
+	case CommandPolicyLocality:
+		return "TPM_CC_PolicyLocality"
 	case CommandPolicyNameHash:
 		return "TPM_CC_PolicyNameHash"
 	case CommandPolicyOR:
@@ -213,16 +249,24 @@ func (c CommandCode) String() string {
 		return "TPM_CC_PolicyTicket"
 	case CommandReadPublic:
 		return "TPM_CC_ReadPublic"
+	case CommandRSAEncrypt:
+		return "TPM_CC_RSA_Encrypt"
 	case CommandStartAuthSession:
 		return "TPM_CC_StartAuthSession"
 	case CommandVerifySignature:
 		return "TPM_CC_VerifySignature"
+	case CommandECCParameters:
+		return "TPM_CC_ECC_Parameters"
+	case CommandFirmwareRead:
+		return "TPM_CC_FirmwareRead"
 	case CommandGetCapability:
 		return "TPM_CC_GetCapability"
 	case CommandGetRandom:
 		return "TPM_CC_GetRandom"
 	case CommandGetTestResult:
 		return "TPM_CC_GetTestResult"
+	case CommandHash:
+		return "TPM_CC_Hash"
 	case CommandPCRRead:
 		return "TPM_CC_PCR_Read"
 	case CommandPolicyPCR:
@@ -233,24 +277,56 @@ func (c CommandCode) String() string {
 		return "TPM_CC_ReadClock"
 	case CommandPCRExtend:
 		return "TPM_CC_PCR_Extend"
+	case CommandPCRSetAuthValue:
+		return "TPM_CC_PCR_SetAuthValue"
+	case CommandNVCertify:
+		return "TPM_CC_NV_Certify"
 	case CommandEventSequenceComplete:
 		return "TPM_CC_EventSequenceComplete"
 	case CommandHashSequenceStart:
 		return "TPM_CC_HashSequenceStart"
+	case CommandPolicyPhysicalPresence:
+		return "TPM_CC_PolicyPhysicalPresence"
 	case CommandPolicyDuplicationSelect:
 		return "TPM_CC_PolicyDuplicationSelect"
 	case CommandPolicyGetDigest:
 		return "TPM_CC_PolicyGetDigest"
 	case CommandTestParms:
 		return "TPM_CC_TestParms"
+	case CommandCommit:
+		return "TPM_CC_Commit"
 	case CommandPolicyPassword:
 		return "TPM_CC_PolicyPassword"
+	case CommandZGen2Phase:
+		return "TPM_CC_ZGen_2Phase"
+	case CommandECEphemeral:
+		return "TPM_CC_EC_Ephemeral"
 	case CommandPolicyNvWritten:
 		return "TPM_CC_PolicyNvWritten"
+	case CommandPolicyTemplate:
+		return "TPM_CC_PolicyTemplate"
 	case CommandCreateLoaded:
 		return "TPM_CC_CreateLoaded"
+	case CommandPolicyAuthorizeNV:
+		return "TPM_CC_PolicyAuthorizeNV"
+	case CommandEncryptDecrypt2:
+		return "TPM_CC_EncryptDecrypt2"
+	case CommandACGetCapability:
+		return "TPM_CC_AC_GetCapability"
+	case CommandACSend:
+		return "TPM_CC_AC_Send"
+	case CommandPolicyACSendSelect:
+		return "TPM_CC_Policy_AC_SendSelect"
+	case CommandCertifyX509:
+		return "TPM_CC_CertifyX509"
+	case CommandACTSetTimeout:
+		return "TPM_CC_ACT_SetTimeout"
+	case CommandECCEncrypt:
+		return "TPM_CC_ECC_Encrypt"
+	case CommandECCDecrypt:
+		return "TPM_CC_ECC_Decrypt"
 	default:
-		return fmt.Sprintf("0x%08x", uint32(c))
+		return fmt.Sprintf("TPM_CC_0x%08x", uint32(c))
 	}
 }
 
@@ -514,6 +590,36 @@ func (h Handle) Format(s fmt.State, f rune) {
 	}
 }
 
+func (t HandleType) String() string {
+	switch t {
+	case HandleTypePCR:
+		return "TPM_HT_PCR"
+	case HandleTypeNVIndex:
+		return "TPM_HT_NV_INDEX"
+	case HandleTypeHMACSession:
+		return "TPM_HT_HMAC_SESSION"
+	case HandleTypePolicySession:
+		return "TPM_HT_POLICY_SESSION"
+	case HandleTypePermanent:
+		return "TPM_HT_PERMANENT"
+	case HandleTypeTransient:
+		return "TPM_HT_TRANSIENT"
+	case HandleTypePersistent:
+		return "TPM_HT_PERSISTENT"
+	default:
+		return fmt.Sprintf("0x%02x", uint8(t))
+	}
+}
+
+func (t HandleType) Format(s fmt.State, f rune) {
+	switch f {
+	case 's', 'v':
+		fmt.Fprintf(s, "%s", t.String())
+	default:
+		fmt.Fprintf(s, makeDefaultFormatter(s, f), uint8(t))
+	}
+}
+
 func (a AlgorithmId) String() string {
 	switch a {
 	case AlgorithmRSA:
@@ -678,6 +784,46 @@ func (c Capability) Format(s fmt.State, f rune) {
 	}
 }
 
+func (o ArithmeticOp) String() string {
+	switch o {
+	case OpEq:
+		return "TPM_EO_EQ"
+	case OpNeq:
+		return "TPM_EO_NEQ"
+	case OpSignedGT:
+		return "TPM_EO_SIGNED_GT"
+	case OpUnsignedGT:
+		return "TPM_EO_UNSIGNED_GT"
+	case OpSignedLT:
+		return "TPM_EO_SIGNED_LT"
+	case OpUnsignedLT:
+		return "TPM_EO_UNSIGNED_LT"
+	case OpSignedGE:
+		return "TPM_EO_SIGNED_GE"
+	case OpUnsignedGE:
+		return "TPM_EO_UNSIGNED_GE"
+	case OpSignedLE:
+		return "TPM_EO_SIGNED_LE"
+	case OpUnsignedLE:
+		return "TPM_EO_UNSIGNED_LE"
+	case OpBitset:
+		return "TPM_EO_BITSET"
+	case OpBitclear:
+		return "TPM_EO_BITCLEAR"
+	default:
+		return fmt.Sprintf("0x%04x", uint16(o))
+	}
+}
+
+func (o ArithmeticOp) Format(s fmt.State, f rune) {
+	switch f {
+	case 's', 'v':
+		fmt.Fprintf(s, "%s", o.String())
+	default:
+		fmt.Fprintf(s, makeDefaultFormatter(s, f), uint16(o))
+	}
+}
+
 var (
 	errorCodeDescriptions = map[ErrorCode]string{
 		ErrorInitialize:      "TPM not initialized by TPM2_Startup or already initialized",
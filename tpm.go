@@ -6,11 +6,16 @@ package tpm2
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/canonical/go-tpm2/mu"
 
@@ -22,12 +27,25 @@ func makeInvalidArgError(name, msg string) error {
 }
 
 func wrapMarshallingError(commandCode CommandCode, context string, err error) error {
-	return fmt.Errorf("cannot marshal %s for command %s: %v", context, commandCode, err)
+	return xerrors.Errorf("cannot marshal %s for command %s: %w", context, commandCode, err)
 }
 
-func handleUnmarshallingError(context *cmdContext, scope string, err error) error {
+// handleUnmarshallingError converts an error that occurred whilst unmarshalling part of a response in to the appropriate error
+// type. The n argument is the number of bytes of the part of the response that were consumed before the error occurred.
+func handleUnmarshallingError(context *cmdContext, scope string, n int, err error) error {
 	var s *mu.InvalidSelectorError
-	if xerrors.Is(err, io.EOF) || xerrors.Is(err, io.ErrUnexpectedEOF) || xerrors.As(err, &s) {
+	switch {
+	case xerrors.As(err, &s):
+		// An invalid selector is a sign of a firmware bug or of corruption in the transport, so include the raw selector value
+		// and the location of the error to aid in diagnosing these.
+		selector := fmt.Sprintf("%v", s.Selector)
+		switch s.Selector.Kind() {
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			selector = fmt.Sprintf("0x%0*x", s.Selector.Type().Size()*2, s.Selector.Uint())
+		}
+		return &InvalidResponseError{context.commandCode, fmt.Sprintf("cannot unmarshal %s: invalid union selector value %s "+
+			"after %d bytes: %v", scope, selector, n, err)}
+	case xerrors.Is(err, io.EOF) || xerrors.Is(err, io.ErrUnexpectedEOF) || xerrors.As(err, new(*mu.InputTooLargeError)):
 		return &InvalidResponseError{context.commandCode, fmt.Sprintf("cannot unmarshal %s: %v", scope, err)}
 	}
 
@@ -67,12 +85,20 @@ type responseHeader struct {
 
 type cmdContext struct {
 	commandCode   CommandCode
+	commandBytes  []byte
 	sessionParams []*sessionParam
 	responseCode  ResponseCode
 	responseTag   StructTag
 	responseBytes []byte
 }
 
+// DefaultMaxResponseSize is the default maximum responseSize value accepted from the TPM. See TPMContext.SetMaxResponseSize.
+const DefaultMaxResponseSize = 65536
+
+// maxRetryBackoff is the upper limit on the delay between submissions of a command that is being retried, unless the backoff
+// configured with TPMContext.SetRetryBackoff is already larger than this.
+const maxRetryBackoff = 10 * time.Second
+
 type delimiterSentinel struct{}
 
 // Delimiter is a sentinel value used to delimit command handle, command parameter, response handle pointer and response
@@ -87,30 +113,108 @@ type ResourceContextWithSession struct {
 }
 
 // TODO: Implement commands from the following sections of part 3 of the TPM library spec:
-// Section 14 - Asymmetric Primitives
-// Section 15 - Symmetric Primitives
-// Section 17 - Hash/HMAC/Event Sequences
 // Section 19 - Ephemeral EC Keys
 // Section 26 - Miscellaneous Management Functions
 // Section 27 - Field Upgrade
 
+// TCTI represents a transmission interface to a TPM implementation. A command packet is submitted with a single call to Write, and the
+// complete response packet must then be available to read with one or more calls to Read before the next command is submitted.
+//
+// This package provides implementations for Linux TPM character devices (TctiDeviceLinux, returned from OpenTPMDevice) and for TPM
+// simulators that implement the Microsoft TPM2 simulator interface (TctiMssim, returned from OpenMssim). Other implementations can be
+// provided, such as an in-memory implementation that records command packets and returns canned responses for testing.
+type TCTI interface {
+	io.ReadWriteCloser
+}
+
+// TCTIWithReadDeadline is an optional interface that can be implemented by a TCTI in order to allow a pending read to be
+// interrupted. If the TCTI supplied to NewTPMContext implements this, RunCommandContext and the other context aware methods use it
+// to stop waiting for a response when their context is cancelled or expires.
+//
+// SetReadDeadline sets the time after which pending and subsequent calls to Read fail with an error, and a zero value disables the
+// deadline. A Read that fails because of the deadline must not consume any part of a response, so that the complete response can be
+// read once the deadline has been cleared. TctiDeviceLinux and TctiMssim implement this interface.
+type TCTIWithReadDeadline interface {
+	TCTI
+	SetReadDeadline(t time.Time) error
+}
+
+// CommandStat contains timing statistics for a single command code, as returned from TPMContext.GetCommandStats.
+type CommandStat struct {
+	Count uint          // The number of times that the command was submitted to the TPM
+	Min   time.Duration // The shortest round trip time
+	Max   time.Duration // The longest round trip time
+	Total time.Duration // The sum of all round trip times
+}
+
+// commandProfiler aggregates the round trip times of commands submitted to the TPM.
+type commandProfiler struct {
+	mu    sync.Mutex
+	stats map[CommandCode]CommandStat
+}
+
+func (p *commandProfiler) record(commandCode CommandCode, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stat := p.stats[commandCode]
+	if stat.Count == 0 || d < stat.Min {
+		stat.Min = d
+	}
+	if d > stat.Max {
+		stat.Max = d
+	}
+	stat.Count++
+	stat.Total += d
+	p.stats[commandCode] = stat
+}
+
 // TPMContext is the main entry point by which commands are executed on a TPM device using this package. It communicates with the
-// underlying device via a transmission interface, which is an implementation of io.ReadWriteCloser provided to NewTPMContext.
+// underlying device via a transmission interface, which is an implementation of TCTI provided to NewTPMContext.
 //
 // Methods that execute commands on the TPM will return errors where the TPM responds with them. These are in the form of *TPMError,
-// *TPMWarning, *TPMHandleError, *TPMSessionError, *TPMParameterError and *TPMVendorError types.
+// *TPMWarning, *TPMHandleError, *TPMSessionError, *TPMParameterError and *TPMVendorError types. These, and any other error that
+// occurs whilst executing a command, are wrapped in a *CommandExecutionError, so they must be tested for with xerrors.As or with
+// helpers such as IsTPMError, IsTPMWarning, IsTPMHandleError, IsTPMSessionError and IsTPMParameterError rather than with a type
+// assertion.
 //
 // Some methods also accept a variable number of optional SessionContext arguments - these are for sessions that don't provide
 // authorization for a corresponding TPM resource. These sessions may be used for the purposes of session based parameter encryption
 // or command auditing.
+//
+// The exchange of each command and response packet with the TPM is serialized, so it is safe to execute commands that don't share
+// any sessions or resource contexts from multiple goroutines without corrupting the command and response streams. The ResourceContexts
+// cached by TPMContext.GetPermanentContext and TPMContext.CreateResourceContextFromTPM are also protected. Other state is not
+// protected, so methods that configure the TPMContext, and sessions and resource contexts, must not be used concurrently. A sequence
+// of commands is not executed atomically with respect to commands executed from other goroutines - callers that require this (eg,
+// when executing a sequence of policy assertions) must provide their own synchronization.
+//
+// If the TPM responds with a responseSize value that is invalid or exceeds the limit set by TPMContext.SetMaxResponseSize, the
+// remaining part of the response can't be safely read and discarded. In this case, the command and response streams are no longer
+// synchronized, and any attempt to execute a subsequent command with this TPMContext will fail with a *TctiError without it being
+// sent to the TPM. A new TPMContext must be created in order to continue.
 type TPMContext struct {
-	tcti                  io.ReadWriteCloser
+	tcti                  TCTI
+	resourcesMu           sync.Mutex // Protects permanentResources and resources
 	permanentResources    map[Handle]*permanentContext
+	resources             map[Handle]ResourceContext
 	maxSubmissions        uint
+	retryBackoff          time.Duration
 	propertiesInitialized bool
 	maxNVBufferSize       int
 	maxBufferSize         int
+	exclusiveSessionMu    sync.Mutex // Protects exclusiveSession
 	exclusiveSession      *sessionContext
+	lastResponseCode      uint32 // Accessed atomically
+	maxResponseSize       uint32
+	commandLogger         func(commandCode CommandCode, command, response []byte)
+	profiler              *commandProfiler
+	verifyResourceNames   bool
+	exchangeMu            sync.Mutex // Serializes the exchange of command and response packets, and protects abandonedResponse
+	abandonedResponse     bool       // The response to an abandoned command is queued in a TCTIWithReadDeadline
+	streamErr             error      // Set if the command and response streams are no longer synchronized, protected by exchangeMu
+	pendingMu             sync.Mutex // Protects pendingResponse
+	pendingResponse       chan struct{}
 }
 
 // Close calls Close on the transmission interface.
@@ -131,6 +235,14 @@ func (t *TPMContext) Close() error {
 // the returned response structure is correctly formed, but will return an error if marshalling of the command header or
 // unmarshalling of the response header fails, or the transmission interface returns an error.
 func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, commandBytes []byte) (ResponseCode, StructTag, []byte, error) {
+	ctx := context.Background()
+	if err := t.checkCanSubmit(ctx); err != nil {
+		return 0, 0, nil, err
+	}
+	return t.runCommandPacketContext(ctx, commandCode, makeCommandPacket(tag, commandCode, commandBytes))
+}
+
+func makeCommandPacket(tag StructTag, commandCode CommandCode, commandBytes []byte) []byte {
 	cHeader := commandHeader{tag, 0, commandCode}
 	cHeader.CommandSize = uint32(binary.Size(cHeader) + len(commandBytes))
 
@@ -138,6 +250,168 @@ func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, com
 	if err != nil {
 		panic(fmt.Sprintf("cannot marshal complete command packet bytes: %v", err))
 	}
+	return bytes
+}
+
+// runCommandPacketContext submits the command packet in bytes to the TPM and waits for the response. If ctx is cancelled or expires
+// before the response is received, it returns early with a *TctiError that wraps the context's error.
+//
+// If the TCTI implements TCTIWithReadDeadline, the pending read is interrupted and the response remains queued in the TCTI. It is
+// read and discarded before the next command is submitted. Otherwise, the exchange continues in the background and subsequent
+// commands are rejected until the response has been received and discarded. In both cases, this keeps the command and response
+// streams synchronized. The caller must call checkCanSubmit before calling this.
+func (t *TPMContext) runCommandPacketContext(ctx context.Context, commandCode CommandCode, bytes []byte) (ResponseCode, StructTag, []byte, error) {
+	if ctx.Done() == nil {
+		return t.runCommandPacket(commandCode, bytes)
+	}
+	if tcti, ok := t.tcti.(TCTIWithReadDeadline); ok {
+		return t.runCommandPacketWithDeadline(ctx, tcti, commandCode, bytes)
+	}
+
+	type result struct {
+		responseCode  ResponseCode
+		responseTag   StructTag
+		responseBytes []byte
+		err           error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		var r result
+		r.responseCode, r.responseTag, r.responseBytes, r.err = t.runCommandPacket(commandCode, bytes)
+		ch <- r
+	}()
+
+	select {
+	case r := <-ch:
+		return r.responseCode, r.responseTag, r.responseBytes, r.err
+	case <-ctx.Done():
+		pending := make(chan struct{})
+		t.pendingMu.Lock()
+		t.pendingResponse = pending
+		t.pendingMu.Unlock()
+		go func() {
+			<-ch
+			close(pending)
+		}()
+		return 0, 0, nil, &TctiError{"read", ctx.Err()}
+	}
+}
+
+// interruptReadOnDone arranges for the read deadline of tcti to be set when ctx is done, so that a pending read is interrupted. The
+// returned function must be called once the read has completed. It clears the read deadline.
+func interruptReadOnDone(ctx context.Context, tcti TCTIWithReadDeadline) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			tcti.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		tcti.SetReadDeadline(time.Time{})
+	}
+}
+
+// runCommandPacketWithDeadline submits the command packet in bytes to the TPM and waits for the response, using the read deadline of
+// tcti to interrupt the read if ctx is cancelled or expires. In this case, the response is marked as abandoned so that it is
+// discarded by discardAbandonedResponse before the next command is submitted.
+func (t *TPMContext) runCommandPacketWithDeadline(ctx context.Context, tcti TCTIWithReadDeadline, commandCode CommandCode, bytes []byte) (ResponseCode, StructTag, []byte, error) {
+	t.exchangeMu.Lock()
+	defer t.exchangeMu.Unlock()
+
+	stop := interruptReadOnDone(ctx, tcti)
+	responseCode, responseTag, responseBytes, err := t.exchangeCommandPacket(commandCode, bytes)
+	stop()
+
+	if e, isTctiErr := err.(*TctiError); isTctiErr && e.Op == "read" && ctx.Err() != nil {
+		t.abandonedResponse = true
+		return 0, 0, nil, &TctiError{"read", ctx.Err()}
+	}
+	return responseCode, responseTag, responseBytes, err
+}
+
+// checkCanSubmit returns an error if a command can't be submitted to the TPM, either because ctx has already been cancelled or
+// because the response to a previously abandoned command hasn't been received yet.
+func (t *TPMContext) checkCanSubmit(ctx context.Context) error {
+	if err := t.checkPendingResponse(ctx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return &TctiError{"write", err}
+	}
+	return nil
+}
+
+// checkPendingResponse returns an error if the response to a previously abandoned command has not been received yet. If the response
+// is queued in a TCTIWithReadDeadline, this waits for it to be received and discarded, unless ctx is cancelled or expires first.
+func (t *TPMContext) checkPendingResponse(ctx context.Context) error {
+	t.pendingMu.Lock()
+	if t.pendingResponse != nil {
+		select {
+		case <-t.pendingResponse:
+			t.pendingResponse = nil
+		default:
+			t.pendingMu.Unlock()
+			return &TctiError{"write", errors.New("the response to a previously cancelled command has not been received yet")}
+		}
+	}
+	t.pendingMu.Unlock()
+
+	return t.discardAbandonedResponse(ctx)
+}
+
+// discardAbandonedResponse reads and discards the response to a command that was abandoned by runCommandPacketWithDeadline, if there
+// is one. If ctx is cancelled or expires first, the response remains queued and an error is returned.
+func (t *TPMContext) discardAbandonedResponse(ctx context.Context) error {
+	t.exchangeMu.Lock()
+	defer t.exchangeMu.Unlock()
+
+	if !t.abandonedResponse {
+		return nil
+	}
+
+	stop := interruptReadOnDone(ctx, t.tcti.(TCTIWithReadDeadline))
+	defer stop()
+
+	var rHeader responseHeader
+	if _, err := mu.UnmarshalFromReader(t.tcti, &rHeader); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return &TctiError{"write", xerrors.Errorf("the response to a previously cancelled command has not been received yet: %w", err)}
+	}
+	if rHeader.ResponseSize < uint32(binary.Size(rHeader)) || rHeader.ResponseSize > t.maxResponseSize {
+		t.abandonedResponse = false
+		t.streamErr = fmt.Errorf("the response to a previously cancelled command has an invalid responseSize value (%d)",
+			rHeader.ResponseSize)
+		return &TctiError{"write", t.streamErr}
+	}
+	if _, err := io.CopyN(ioutil.Discard, t.tcti, int64(rHeader.ResponseSize)-int64(binary.Size(rHeader))); err != nil {
+		return &TctiError{"write", xerrors.Errorf("cannot discard the response to a previously cancelled command: %w", err)}
+	}
+
+	t.abandonedResponse = false
+	return nil
+}
+
+func (t *TPMContext) runCommandPacket(commandCode CommandCode, bytes []byte) (ResponseCode, StructTag, []byte, error) {
+	t.exchangeMu.Lock()
+	defer t.exchangeMu.Unlock()
+	return t.exchangeCommandPacket(commandCode, bytes)
+}
+
+// exchangeCommandPacket submits the command packet in bytes to the TPM and reads the response. The caller must hold exchangeMu.
+func (t *TPMContext) exchangeCommandPacket(commandCode CommandCode, bytes []byte) (ResponseCode, StructTag, []byte, error) {
+	if t.streamErr != nil {
+		return 0, 0, nil, &TctiError{"write", xerrors.Errorf("the command and response streams are no longer synchronized: %w", t.streamErr)}
+	}
+
+	start := time.Now()
 
 	if _, err := t.tcti.Write(bytes); err != nil {
 		return 0, 0, nil, &TctiError{"write", err}
@@ -146,7 +420,29 @@ func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, com
 	var rHeader responseHeader
 	rHeaderSize := uint32(binary.Size(rHeader))
 	rHeaderBytes := make([]byte, rHeaderSize)
+	var responseBytes []byte
+
+	if t.commandLogger != nil {
+		defer func() {
+			command := make([]byte, len(bytes))
+			copy(command, bytes)
+			response := make([]byte, 0, len(rHeaderBytes)+len(responseBytes))
+			response = append(response, rHeaderBytes...)
+			response = append(response, responseBytes...)
+			t.commandLogger(commandCode, command, response)
+		}()
+	}
+
+	if t.profiler != nil {
+		// This is registered after the command logger so that it runs first, and the recorded time excludes the time spent in the
+		// logger.
+		defer func() {
+			t.profiler.record(commandCode, time.Since(start))
+		}()
+	}
+
 	if n, err := io.ReadFull(t.tcti, rHeaderBytes); err != nil {
+		rHeaderBytes = rHeaderBytes[:n]
 		if xerrors.Is(err, io.ErrUnexpectedEOF) {
 			return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response header (got %d, "+
 				"expected %d)", n, rHeaderSize)}
@@ -158,15 +454,29 @@ func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, com
 		panic(fmt.Sprintf("cannot unmarshal response header: %v", err))
 	}
 
+	atomic.StoreUint32(&t.lastResponseCode, uint32(rHeader.ResponseCode))
+
+	// The remaining part of the response can't be read and discarded if the responseSize value is invalid, so the TPMContext can't
+	// be used for any subsequent commands.
 	if rHeader.ResponseSize < rHeaderSize {
-		return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("invalid responseSize value (%d)", rHeader.ResponseSize)}
+		err := &InvalidResponseError{commandCode, fmt.Sprintf("invalid responseSize value (%d)", rHeader.ResponseSize)}
+		t.streamErr = err
+		return 0, 0, nil, err
+	}
+	if rHeader.ResponseSize > t.maxResponseSize {
+		err := &InvalidResponseError{commandCode, fmt.Sprintf("responseSize value (%d) exceeds the maximum (%d)",
+			rHeader.ResponseSize, t.maxResponseSize)}
+		t.streamErr = err
+		return 0, 0, nil, err
 	}
 
-	responseBytes := make([]byte, rHeader.ResponseSize-rHeaderSize)
+	responseBytes = make([]byte, rHeader.ResponseSize-rHeaderSize)
 	if n, err := io.ReadFull(t.tcti, responseBytes); err != nil {
+		expected := len(responseBytes)
+		responseBytes = responseBytes[:n]
 		if xerrors.Is(err, io.ErrUnexpectedEOF) {
 			return 0, 0, nil, &InvalidResponseError{commandCode, fmt.Sprintf("insufficient bytes for response payload (got %d, "+
-				"expected %d)", n, len(responseBytes))}
+				"expected %d)", n, expected)}
 		}
 		return 0, 0, nil, &TctiError{"read", err}
 	}
@@ -175,6 +485,28 @@ func (t *TPMContext) RunCommandBytes(tag StructTag, commandCode CommandCode, com
 }
 
 func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode, sessionParams []*sessionParam, resources, params []interface{}) (*cmdContext, error) {
+	return t.runCommandWithoutProcessingResponseContext(context.Background(), commandCode, sessionParams, resources, params)
+}
+
+// isRetryableWarning indicates whether a command that failed with the specified warning should be resubmitted. WarningTesting is not
+// retried for the commands in section 10 of the TPM Library Specification, so that callers of those commands can poll for the
+// completion of self tests.
+func isRetryableWarning(commandCode CommandCode, code WarningCode) bool {
+	switch code {
+	case WarningYielded, WarningRetry:
+		return true
+	case WarningTesting:
+		switch commandCode {
+		case CommandSelfTest, CommandIncrementalSelfTest, CommandGetTestResult:
+			return false
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *TPMContext) runCommandWithoutProcessingResponseContext(ctx context.Context, commandCode CommandCode, sessionParams []*sessionParam, resources, params []interface{}) (*cmdContext, error) {
 	handles := make([]interface{}, 0, len(resources))
 	handleNames := make([]Name, 0, len(resources))
 
@@ -182,7 +514,7 @@ func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode
 		switch r := resource.(type) {
 		case HandleContext:
 			if err := t.checkHandleContextParam(r); err != nil {
-				return nil, wrapMarshallingError(commandCode, "command handles", fmt.Errorf("cannot process HandleContext at index %d: %v", i, err))
+				return nil, wrapMarshallingError(commandCode, "command handles", xerrors.Errorf("cannot process HandleContext at index %d: %w", i, err))
 			}
 			handles = append(handles, r.Handle())
 			handleNames = append(handleNames, r.Name())
@@ -226,15 +558,24 @@ func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode
 		panic(fmt.Sprintf("cannot write command parameter bytes to command buffer: %v", err))
 	}
 
+	commandBytes := makeCommandPacket(tag, commandCode, cBytes.Bytes())
+
 	var responseCode ResponseCode
 	var responseTag StructTag
 	var responseBytes []byte
 
 	for tries := uint(1); ; tries++ {
+		// The command isn't submitted if this fails, so the state of any sessions is unaffected.
+		if err := t.checkCanSubmit(ctx); err != nil {
+			return nil, &CommandExecutionError{Command: commandCode, CommandBytes: commandBytes, err: err}
+		}
+
 		var err error
-		responseCode, responseTag, responseBytes, err = t.RunCommandBytes(tag, commandCode, cBytes.Bytes())
+		responseCode, responseTag, responseBytes, err = t.runCommandPacketContext(ctx, commandCode, commandBytes)
 		if err != nil {
-			return nil, err
+			// It isn't known whether the TPM executed the command, so the state of any sessions is unknown.
+			markSessionsUnusable(sessionParams, err)
+			return nil, &CommandExecutionError{Command: commandCode, CommandBytes: commandBytes, err: err}
 		}
 
 		err = DecodeResponseCode(commandCode, responseCode)
@@ -243,22 +584,53 @@ func (t *TPMContext) runCommandWithoutProcessingResponse(commandCode CommandCode
 		}
 
 		if tries >= t.maxSubmissions {
-			return nil, err
+			return nil, &CommandExecutionError{Command: commandCode, CommandBytes: commandBytes, err: err}
 		}
-		if e, ok := err.(*TPMWarning); !ok || !(e.Code == WarningYielded || e.Code == WarningTesting || e.Code == WarningRetry) {
-			return nil, err
+		if e, ok := err.(*TPMWarning); !ok || !isRetryableWarning(commandCode, e.Code) {
+			return nil, &CommandExecutionError{Command: commandCode, CommandBytes: commandBytes, err: err}
+		}
+
+		if t.retryBackoff > 0 {
+			select {
+			case <-time.After(retryDelay(t.retryBackoff, tries)):
+			case <-ctx.Done():
+				// Nothing is pending on the transport here, so this isn't a TCTI error.
+				return nil, &CommandExecutionError{Command: commandCode, CommandBytes: commandBytes, err: ctx.Err()}
+			}
 		}
 	}
 
 	return &cmdContext{
 		commandCode:   commandCode,
+		commandBytes:  commandBytes,
 		sessionParams: sessionParams,
 		responseCode:  responseCode,
 		responseTag:   responseTag,
 		responseBytes: responseBytes}, nil
 }
 
+// retryDelay returns the time to wait after the specified number of unsuccessful submissions of a command, when the initial
+// delay is backoff. The delay doubles for each submission, but is capped at maxRetryBackoff so that it doesn't overflow.
+func retryDelay(backoff time.Duration, tries uint) time.Duration {
+	delay := backoff
+	for i := uint(1); i < tries && delay < maxRetryBackoff; i++ {
+		delay <<= 1
+		if delay > maxRetryBackoff {
+			delay = maxRetryBackoff
+		}
+	}
+	return delay
+}
+
 func (t *TPMContext) processResponse(context *cmdContext, handles, params []interface{}) error {
+	if err := t.processResponseInternal(context, handles, params); err != nil {
+		markSessionsUnusable(context.sessionParams, err)
+		return &CommandExecutionError{Command: context.commandCode, CommandBytes: context.commandBytes, err: err}
+	}
+	return nil
+}
+
+func (t *TPMContext) processResponseInternal(context *cmdContext, handles, params []interface{}) error {
 	for i, handle := range handles {
 		_, isHandle := handle.(*Handle)
 		if !isHandle {
@@ -270,8 +642,8 @@ func (t *TPMContext) processResponse(context *cmdContext, handles, params []inte
 	buf := bytes.NewReader(context.responseBytes)
 
 	if len(handles) > 0 {
-		if _, err := mu.UnmarshalFromReader(buf, handles...); err != nil {
-			return handleUnmarshallingError(context, "response handles", err)
+		if n, err := mu.UnmarshalFromReader(buf, handles...); err != nil {
+			return handleUnmarshallingError(context, "response handles", n, err)
 		}
 	}
 
@@ -280,21 +652,30 @@ func (t *TPMContext) processResponse(context *cmdContext, handles, params []inte
 	switch context.responseTag {
 	case TagSessions:
 		var parameterSize uint32
-		if _, err := mu.UnmarshalFromReader(buf, &parameterSize); err != nil {
-			return handleUnmarshallingError(context, "parameterSize field", err)
+		if n, err := mu.UnmarshalFromReader(buf, &parameterSize); err != nil {
+			return handleUnmarshallingError(context, "parameterSize field", n, err)
+		}
+		// The parameter area is copied so that it can be authenticated and decrypted before the parameters are unmarshalled.
+		// Check that it fits within the response before allocating the copy.
+		if int64(parameterSize) > int64(buf.Len()) {
+			return &InvalidResponseError{context.commandCode, fmt.Sprintf("parameterSize value (%d) exceeds the remaining "+
+				"response size (%d)", parameterSize, buf.Len())}
 		}
 		rpBytes := make([]byte, parameterSize)
-		if _, err := io.ReadFull(buf, rpBytes); err != nil {
-			return handleUnmarshallingError(context, "response parameters",
+		if n, err := io.ReadFull(buf, rpBytes); err != nil {
+			return handleUnmarshallingError(context, "response parameters", n,
 				fmt.Errorf("error reading parameters to temporary buffer: %v", err))
 		}
 
 		authArea := responseAuthAreaRawSlice{make([]authResponse, len(context.sessionParams))}
-		if _, err := mu.UnmarshalFromReader(buf, &authArea); err != nil {
-			return handleUnmarshallingError(context, "response auth area", err)
+		if n, err := mu.UnmarshalFromReader(buf, &authArea); err != nil {
+			return handleUnmarshallingError(context, "response auth area", n, err)
 		}
 		if err := processResponseAuthArea(t, authArea.Data, context.sessionParams, context.commandCode, context.responseCode,
 			rpBytes); err != nil {
+			if e, isAuthErr := err.(*InvalidResponseAuthError); isAuthErr {
+				return e
+			}
 			return &InvalidResponseError{context.commandCode, fmt.Sprintf("cannot process response auth area: %v", err)}
 		}
 
@@ -306,6 +687,7 @@ func (t *TPMContext) processResponse(context *cmdContext, handles, params []inte
 	}
 
 	if isSessionAllowed(context.commandCode) {
+		t.exclusiveSessionMu.Lock()
 		if t.exclusiveSession != nil {
 			t.exclusiveSession.scData().IsExclusive = false
 		}
@@ -323,14 +705,20 @@ func (t *TPMContext) processResponse(context *cmdContext, handles, params []inte
 		if t.exclusiveSession != nil {
 			t.exclusiveSession.scData().IsExclusive = true
 		}
+		t.exclusiveSessionMu.Unlock()
 	}
 
 	if len(params) > 0 {
-		if _, err := mu.UnmarshalFromReader(rpBuf, params...); err != nil {
-			return handleUnmarshallingError(context, "response parameters", err)
+		// The response parameters must not extend beyond the parameter area.
+		rpSize := rpBuf.Len()
+		if err := mu.UnmarshalFromReaderN(rpBuf, int64(rpSize), params...); err != nil {
+			return handleUnmarshallingError(context, "response parameters", rpSize-rpBuf.Len(), err)
 		}
 	}
 
+	if rpBuf != buf && rpBuf.Len() > 0 {
+		return &InvalidResponseError{context.commandCode, fmt.Sprintf("response parameter area contains %d trailing bytes", rpBuf.Len())}
+	}
 	if buf.Len() > 0 {
 		return &InvalidResponseError{context.commandCode, fmt.Sprintf("response contains %d trailing bytes", buf.Len())}
 	}
@@ -351,7 +739,9 @@ func (t *TPMContext) processResponse(context *cmdContext, handles, params []inte
 // Command handles are provided as HandleContext types if they do not require an authorization. For command handles that require an
 // authorization, they are provided using the ResourceContextWithSession type. This links the ResourceContext to an optional
 // authorization session. If the authorization value of the TPM entity is required as part of the authorization, this will be obtained
-// from the supplied ResourceContext. A nil HandleContext will automatically be converted to a handle with the value of HandleNull.
+// from the supplied ResourceContext. If the supplied ResourceContext was returned from ResourceContext.WithAuth and no session is
+// supplied, the session attached to it will be used. A nil HandleContext will automatically be converted to a handle with the value
+// of HandleNull.
 //
 // Command parameters are provided as the go equivalent types for the types defined in the TPM Library Specification.
 //
@@ -360,16 +750,49 @@ func (t *TPMContext) processResponse(context *cmdContext, handles, params []inte
 // Response parameters are provided as pointers to values of the go equivalent types for the types defined in the TPM Library
 // Specification.
 //
-// If the TPM responds with a warning that indicates the command could not be started and should be retried, this function will
-// resubmit the command a finite number of times before returning an error. The maximum number of retries can be set via
-// TPMContext.SetMaxSubmissions.
+// Trailing groups that are empty can be omitted along with their preceding Delimiter. An error that identifies the malformed group
+// is returned without submitting the command if more than 3 Delimiter values are supplied, if a response handle argument is not a
+// *Handle, or if a response parameter argument is not a non-nil pointer. This catches commands that are wired up incorrectly, such
+// as when a Delimiter is missing.
+//
+// If the TPM responds with a warning that indicates the command could not be started and should be retried (WarningYielded,
+// WarningTesting or WarningRetry), this function will resubmit the same command packet a finite number of times before returning the
+// last warning. WarningTesting is not retried for TPM2_SelfTest, TPM2_IncrementalSelfTest or TPM2_GetTestResult. Other warnings
+// are never retried. The maximum number of submissions can be set via TPMContext.SetMaxSubmissions, and
+// the delay between them can be set via TPMContext.SetRetryBackoff.
 //
 // The caller can provide additional sessions that aren't associated with a TPM entity (and therefore not used for authorization) via
 // the sessions parameter, for the purposes of command auditing or session based parameter encryption.
 //
 // In addition to returning an error if any marshalling or unmarshalling fails, or if the transmission backend returns an error,
 // this function will also return an error if the TPM responds with any ResponseCode other than Success.
+//
+// Errors that occur once the command packet has been constructed are returned wrapped in a *CommandExecutionError, which contains
+// the command packet that was sent to the TPM.
+//
+// The host-side state of HMAC and policy sessions, such as the last nonce generated by the TPM, is only updated once the
+// authorizations for all sessions in a response have been verified. If the TPM responds with an error, the TPM doesn't update the
+// state of any sessions either, so they can continue to be used. If no valid response is received because the transmission
+// interface returns an error or the response is invalid, it isn't known whether the state of the sessions used for the command is
+// consistent with the TPM. In this case, these sessions are marked as unusable and any subsequent attempt to use them for a command
+// will return an error without submitting the command. Unusable sessions can still be flushed with TPMContext.FlushContext.
 func (t *TPMContext) RunCommand(commandCode CommandCode, sessions []SessionContext, params ...interface{}) error {
+	return t.RunCommandContext(context.Background(), commandCode, sessions, params...)
+}
+
+// RunCommandContext behaves like RunCommand, but the supplied context can be used to abandon the command if it takes too long or
+// the transmission interface stops responding. If ctx is cancelled or its deadline expires before the response is received, this
+// returns a *TctiError wrapped in a *CommandExecutionError, and the original error from ctx can be tested for with xerrors.Is. If
+// ctx is cancelled or its deadline expires whilst waiting to resubmit a command after a warning (see TPMContext.SetRetryBackoff), the
+// error from ctx is wrapped directly in a *CommandExecutionError.
+//
+// The TPM cannot be interrupted, so an abandoned command continues to execute and its response must still be read and discarded. If
+// the TCTI implements TCTIWithReadDeadline, the pending read is interrupted and the response is discarded before the next command is
+// submitted, waiting for it if necessary. Otherwise, the response is read and discarded in the background and, until this has
+// happened, any attempt to execute another command with this TPMContext will fail with a *TctiError. Any sessions used with an
+// abandoned command are marked as unusable, and if the command allocates or removes resources on the TPM, it is not known whether it
+// succeeded.
+func (t *TPMContext) RunCommandContext(ctx context.Context, commandCode CommandCode, sessions []SessionContext, params ...interface{}) error {
 	commandHandles := make([]interface{}, 0, len(params))
 	commandParams := make([]interface{}, 0, len(params))
 	responseHandles := make([]interface{}, 0, len(params))
@@ -377,9 +800,13 @@ func (t *TPMContext) RunCommand(commandCode CommandCode, sessions []SessionConte
 	sessionParams := make([]*sessionParam, 0, 3)
 
 	sentinels := 0
-	for _, param := range params {
+	for i, param := range params {
 		if param == Delimiter {
 			sentinels++
+			if sentinels > 3 {
+				return fmt.Errorf("invalid parameters for command %s: unexpected Delimiter at index %d after the response parameter area",
+					commandCode, i)
+			}
 			continue
 		}
 
@@ -387,6 +814,12 @@ func (t *TPMContext) RunCommand(commandCode CommandCode, sessions []SessionConte
 		case 0:
 			switch p := param.(type) {
 			case ResourceContextWithSession:
+				if w, isWrapped := p.Context.(*resourceContextWithAuth); isWrapped {
+					p.Context = w.ResourceContext
+					if p.Session == nil {
+						p.Session = w.session
+					}
+				}
 				commandHandles = append(commandHandles, p.Context)
 				var err error
 				sessionParams, err = t.validateAndAppendAuthSessionParam(sessionParams, p)
@@ -405,17 +838,110 @@ func (t *TPMContext) RunCommand(commandCode CommandCode, sessions []SessionConte
 		}
 	}
 
+	// Check the response areas before the command is submitted, so that a miswired call doesn't execute the command and then fail
+	// to process the response.
+	for i, handle := range responseHandles {
+		if _, isHandle := handle.(*Handle); !isHandle {
+			return fmt.Errorf("invalid parameters for command %s: response handle area argument at index %d has invalid type (%s), "+
+				"expected *Handle", commandCode, i, reflect.TypeOf(handle))
+		}
+	}
+	for i, param := range responseParams {
+		if v := reflect.ValueOf(param); v.Kind() != reflect.Ptr || v.IsNil() {
+			return fmt.Errorf("invalid parameters for command %s: response parameter area argument at index %d is not a non-nil "+
+				"pointer (%s)", commandCode, i, reflect.TypeOf(param))
+		}
+	}
+
 	sessionParams, err := t.validateAndAppendExtraSessionParams(sessionParams, sessions)
 	if err != nil {
 		return fmt.Errorf("cannot process non-auth SessionContext parameters for command %s: %v", commandCode, err)
 	}
 
-	ctx, err := t.runCommandWithoutProcessingResponse(commandCode, sessionParams, commandHandles, commandParams)
+	if t.verifyResourceNames {
+		if err := t.verifyCommandHandleNames(commandCode, sessionParams, commandHandles); err != nil {
+			return err
+		}
+	}
+
+	cmdCtx, err := t.runCommandWithoutProcessingResponseContext(ctx, commandCode, sessionParams, commandHandles, commandParams)
 	if err != nil {
 		return err
 	}
 
-	return t.processResponse(ctx, responseHandles, responseParams)
+	return t.processResponse(cmdCtx, responseHandles, responseParams)
+}
+
+// verifyCommandHandleNames checks that the name of each object and NV index ResourceContext in commandHandles matches the name
+// reported by the TPM, if any HMAC or policy sessions are being used for the command. ResourceContexts that don't have a public area,
+// such as those for sequence objects, are skipped. Verification is also skipped if any session has the AttrAuditExclusive
+// attribute set, as executing another command would cause the TPM to clear the exclusivity of the audit session.
+func (t *TPMContext) verifyCommandHandleNames(commandCode CommandCode, sessionParams []*sessionParam, commandHandles []interface{}) error {
+	hasSession := false
+	for _, s := range sessionParams {
+		if s.session == nil {
+			continue
+		}
+		if s.session.attrs&AttrAuditExclusive > 0 {
+			return nil
+		}
+		hasSession = true
+	}
+	if !hasSession {
+		return nil
+	}
+
+	for _, h := range commandHandles {
+		rc, isResource := h.(ResourceContext)
+		if !isResource || rc == nil {
+			continue
+		}
+		hc, isPrivate := rc.(handleContextPrivate)
+		if !isPrivate {
+			continue
+		}
+
+		expected := rc.Name()
+
+		var actual Name
+		switch d := hc.data(); d.Type {
+		case handleContextTypeObject:
+			if pub, _ := d.Data.Data.(*Public); pub == nil {
+				continue
+			}
+			_, name, _, err := t.ReadPublic(rc)
+			if err != nil {
+				return fmt.Errorf("cannot verify name of resource at handle 0x%08x for command %s: %v", rc.Handle(), commandCode, err)
+			}
+			actual = name
+		case handleContextTypeNvIndex:
+			// NVReadPublic updates the name of rc if the public area only differs by attributes that are changed by the TPM, so the
+			// expected name is obtained from rc afterwards.
+			_, name, err := t.NVReadPublic(rc)
+			if err != nil {
+				return fmt.Errorf("cannot verify name of resource at handle 0x%08x for command %s: %v", rc.Handle(), commandCode, err)
+			}
+			expected = rc.Name()
+			actual = name
+		default:
+			continue
+		}
+
+		if !bytes.Equal(expected, actual) {
+			return &ResourceNameMismatchError{Command: commandCode, Handle: rc.Handle(), Expected: expected, Actual: actual}
+		}
+	}
+
+	return nil
+}
+
+// LastResponseCode returns the ResponseCode from the header of the most recent response received from the TPM, regardless of
+// whether the command succeeded. If a command fails because the transmission interface returns an error or because the response
+// header is invalid, this will continue to return the value from the last response that was received. The returned value is only
+// meaningful when this TPMContext is used from a single goroutine, as it may otherwise correspond to a command executed from another
+// goroutine.
+func (t *TPMContext) LastResponseCode() ResponseCode {
+	return ResponseCode(atomic.LoadUint32(&t.lastResponseCode))
 }
 
 // SetMaxSubmissions sets the maximum number of times that RunCommand will attempt to submit a command before failing with an error.
@@ -424,6 +950,85 @@ func (t *TPMContext) SetMaxSubmissions(max uint) {
 	t.maxSubmissions = max
 }
 
+// SetRetryBackoff sets the time that RunCommand will wait before resubmitting a command after the TPM responds with a warning that
+// indicates that it should be retried. The delay is doubled for each subsequent submission of the same command, up to a maximum of
+// 10 seconds. The default value is zero, which means that commands are resubmitted immediately.
+func (t *TPMContext) SetRetryBackoff(backoff time.Duration) {
+	t.retryBackoff = backoff
+}
+
+// SetMaxResponseSize sets the maximum responseSize value that will be accepted in a response header. If the TPM responds with a
+// larger value, the response payload will not be read and a *InvalidResponseError error will be returned. This protects against
+// large allocations triggered by a malfunctioning TPM. The default value is DefaultMaxResponseSize, which is larger than the
+// response buffer of any known TPM. The value reported by the TPM for PropertyMaxResponseSize can be used to apply a tighter limit.
+// Setting a value of zero restores the default.
+func (t *TPMContext) SetMaxResponseSize(max uint32) {
+	if max == 0 {
+		max = DefaultMaxResponseSize
+	}
+	t.maxResponseSize = max
+}
+
+// SetVerifyResourceNames enables or disables verification of the names of resources used in commands that are authorized with or
+// use HMAC or policy sessions. When enabled, RunCommand reads the public area of each object and NV index passed as a command handle
+// with TPM2_ReadPublic or TPM2_NV_ReadPublic before submitting the command, and checks that the name reported by the TPM matches the
+// name returned from ResourceContext.Name. This detects the case where a resource manager has substituted a different resource
+// behind a handle, which would otherwise cause the TPM to compute session HMACs and cpHash values over a name that the caller did
+// not expect. If the names don't match, a *ResourceNameMismatchError error is returned and the command is not submitted.
+//
+// Verification applies to ResourceContexts created by this package with a public area, which includes those returned from functions
+// that load or create objects and NV indices and from TPMContext.CreateResourceContextFromTPM. It doesn't apply to sequence objects,
+// or to commands that use a session with the AttrAuditExclusive attribute set. Verification requires an extra command to be executed
+// for each resource, and is enabled by default. It can be disabled for this TPMContext when the TPM is accessed via a trusted path
+// and the extra commands are undesirable.
+func (t *TPMContext) SetVerifyResourceNames(enable bool) {
+	t.verifyResourceNames = enable
+}
+
+// SetCommandLogger sets a function that is called after each command packet is exchanged with the TPM, which is useful for
+// debugging. It is called with the command code, the complete command packet and the complete response packet, including the
+// headers. It is called even if the TPM responds with an error or the response is invalid, in which case the response may be
+// incomplete. The supplied buffers are copies that the function may retain. Setting logger to nil disables logging, and there is no
+// logging overhead in this case.
+func (t *TPMContext) SetCommandLogger(logger func(commandCode CommandCode, command, response []byte)) {
+	t.commandLogger = logger
+}
+
+// SetCommandProfiling enables or disables the collection of timing statistics for commands submitted to the TPM. When enabled, the
+// time taken to write each command packet to the transmission interface and read the complete response is recorded, which excludes
+// the time spent marshalling and unmarshalling. Each submission is recorded separately, including commands that are resubmitted
+// because of a warning. The statistics can be obtained with TPMContext.GetCommandStats. Enabling profiling when it is already
+// enabled has no effect, and disabling it discards any statistics that have been collected. Profiling is disabled by default.
+//
+// This function must not be called concurrently with any other method on TPMContext.
+func (t *TPMContext) SetCommandProfiling(enable bool) {
+	switch {
+	case enable && t.profiler == nil:
+		t.profiler = &commandProfiler{stats: make(map[CommandCode]CommandStat)}
+	case !enable:
+		t.profiler = nil
+	}
+}
+
+// GetCommandStats returns a copy of the timing statistics that have been collected for each command code since profiling was
+// enabled with TPMContext.SetCommandProfiling. It returns nil if profiling is not enabled. It is safe to call this from a different
+// goroutine to the one executing commands.
+func (t *TPMContext) GetCommandStats() map[CommandCode]CommandStat {
+	p := t.profiler
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make(map[CommandCode]CommandStat)
+	for k, v := range p.stats {
+		stats[k] = v
+	}
+	return stats
+}
+
 // InitProperties executes a TPM2_GetCapability command to initialize properties used internally by TPMContext. This is normally done
 // automatically by functions that require these properties when they are used for the first time, but this function is provided so
 // that the command can be audited, and so the exclusivity of an audit session can be preserved.
@@ -459,11 +1064,14 @@ func (t *TPMContext) initPropertiesIfNeeded() error {
 	return t.InitProperties()
 }
 
-func newTpmContext(tcti io.ReadWriteCloser) *TPMContext {
+func newTpmContext(tcti TCTI) *TPMContext {
 	r := new(TPMContext)
 	r.tcti = tcti
 	r.permanentResources = make(map[Handle]*permanentContext)
+	r.resources = make(map[Handle]ResourceContext)
 	r.maxSubmissions = 5
+	r.maxResponseSize = DefaultMaxResponseSize
+	r.verifyResourceNames = true
 
 	return r
 }
@@ -478,18 +1086,20 @@ func newTpmContext(tcti io.ReadWriteCloser) *TPMContext {
 // It will return an error if a TPM interface cannot be detected.
 //
 // If the tcti parameter is not nil, this function never returns an error.
-func NewTPMContext(tcti io.ReadWriteCloser) (*TPMContext, error) {
+func NewTPMContext(tcti TCTI) (*TPMContext, error) {
 	if tcti == nil {
 		for _, path := range []string{"/dev/tpmrm0", "/dev/tpm0"} {
-			var err error
-			tcti, err = OpenTPMDevice(path)
-			if err == nil {
+			// Don't assign the result directly to tcti, as a nil *TctiDeviceLinux is not a nil TCTI.
+			if device, err := OpenTPMDevice(path); err == nil {
+				tcti = device
 				break
 			}
 		}
 	}
 	if tcti == nil {
-		tcti, _ = OpenMssim("localhost", 2321, 2322)
+		if mssim, err := OpenMssim("localhost", 2321, 2322); err == nil {
+			tcti = mssim
+		}
 	}
 
 	if tcti == nil {
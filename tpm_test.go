@@ -6,19 +6,25 @@ package tpm2_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"errors"
 	"flag"
 	"fmt"
 	"math/big"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	. "github.com/canonical/go-tpm2"
 	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
 )
 
 type testCapabilityFlags uint32
@@ -267,21 +273,8 @@ func verifySignature(t *testing.T, pub *Public, digest []byte, signature *Signat
 }
 
 func createRSASrkForTesting(t *testing.T, tpm *TPMContext, userAuth Auth) ResourceContext {
-	template := Public{
-		Type:    ObjectTypeRSA,
-		NameAlg: HashAlgorithmSHA256,
-		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrRestricted | AttrDecrypt,
-		Params: PublicParamsU{
-			Data: &RSAParams{
-				Symmetric: SymDefObject{
-					Algorithm: SymObjectAlgorithmAES,
-					KeyBits:   SymKeyBitsU{Data: uint16(128)},
-					Mode:      SymModeU{Data: SymModeCFB}},
-				Scheme:   RSAScheme{Scheme: RSASchemeNull},
-				KeyBits:  2048,
-				Exponent: 0}}}
 	sensitiveCreate := SensitiveCreate{UserAuth: userAuth}
-	objectHandle, _, _, _, _, err := tpm.CreatePrimary(tpm.OwnerHandleContext(), &sensitiveCreate, &template, nil, nil, nil)
+	objectHandle, _, _, _, _, err := tpm.CreatePrimary(tpm.OwnerHandleContext(), &sensitiveCreate, NewRSAStorageKeyTemplate(), nil, nil, nil)
 	if err != nil {
 		t.Fatalf("CreatePrimary failed: %v", err)
 	}
@@ -510,6 +503,955 @@ func closeTPM(t *testing.T, tpm *TPMContext) {
 	}
 }
 
+func TestLastResponseCode(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	if _, err := tpm.CreateResourceContextFromTPM(0x80ffffff); !IsResourceUnavailableError(err, 0x80ffffff) {
+		t.Fatalf("CreateResourceContextFromTPM returned an unexpected error: %v", err)
+	}
+	rc := tpm.LastResponseCode()
+	if !IsTPMHandleError(DecodeResponseCode(CommandReadPublic, rc), ErrorHandle, CommandReadPublic, 1) {
+		t.Errorf("Unexpected response code after failed command: 0x%08x", rc)
+	}
+
+	if _, err := tpm.GetRandom(16); err != nil {
+		t.Fatalf("GetRandom failed: %v", err)
+	}
+	if rc := tpm.LastResponseCode(); rc != ResponseCode(Success) {
+		t.Errorf("Unexpected response code after successful command: 0x%08x", rc)
+	}
+}
+
+var (
+	_ TCTI = (*TctiDeviceLinux)(nil)
+	_ TCTI = (*TctiMssim)(nil)
+	_ TCTI = (*mockTcti)(nil)
+)
+
+type mockTcti struct {
+	commands  bytes.Buffer
+	responses *bytes.Reader
+
+	// respond, if set, is called with each command packet in order to construct the response to it.
+	respond func(cmd []byte) []byte
+}
+
+func (t *mockTcti) Read(data []byte) (int, error) {
+	return t.responses.Read(data)
+}
+
+func (t *mockTcti) Write(data []byte) (int, error) {
+	if t.respond != nil {
+		t.responses = bytes.NewReader(t.respond(data))
+	}
+	return t.commands.Write(data)
+}
+
+func (t *mockTcti) Close() error {
+	return nil
+}
+
+// mockCommandAuth is a command authorization decoded by decodeMockCommand. For a password session, HMAC contains the password.
+type mockCommandAuth struct {
+	Handle Handle
+	Nonce  Nonce
+	Attrs  uint8
+	HMAC   Auth
+}
+
+// mockCommand is a command packet decoded by decodeMockCommand.
+type mockCommand struct {
+	Tag     StructTag
+	Code    CommandCode
+	Handles []Handle
+	Auths   []mockCommandAuth
+	Params  []byte // The command parameter area
+}
+
+// decodeMockCommand decodes the command packet in cmd, which has the specified number of handles in its handle area.
+func decodeMockCommand(t *testing.T, cmd []byte, numHandles int) *mockCommand {
+	var c mockCommand
+	var size uint32
+	n, err := mu.UnmarshalFromBytes(cmd, &c.Tag, &size, &c.Code)
+	if err != nil {
+		t.Fatalf("Cannot unmarshal command header: %v", err)
+	}
+	cmd = cmd[n:]
+
+	c.Handles = make([]Handle, numHandles)
+	for i := range c.Handles {
+		n, err := mu.UnmarshalFromBytes(cmd, &c.Handles[i])
+		if err != nil {
+			t.Fatalf("Cannot unmarshal command handle at index %d: %v", i, err)
+		}
+		cmd = cmd[n:]
+	}
+
+	if c.Tag == TagSessions {
+		var authSize uint32
+		n, err := mu.UnmarshalFromBytes(cmd, &authSize)
+		if err != nil {
+			t.Fatalf("Cannot unmarshal command auth area size: %v", err)
+		}
+		cmd = cmd[n:]
+		if int64(authSize) > int64(len(cmd)) {
+			t.Fatalf("Invalid command auth area size: %d", authSize)
+		}
+		auths := cmd[:authSize]
+		cmd = cmd[authSize:]
+		for len(auths) > 0 {
+			var auth mockCommandAuth
+			n, err := mu.UnmarshalFromBytes(auths, &auth)
+			if err != nil {
+				t.Fatalf("Cannot unmarshal command auth at index %d: %v", len(c.Auths), err)
+			}
+			c.Auths = append(c.Auths, auth)
+			auths = auths[n:]
+		}
+	}
+
+	c.Params = cmd
+	return &c
+}
+
+// mockResponseAuth is a response authorization in a response packet constructed by makeMockResponse.
+type mockResponseAuth struct {
+	Nonce Nonce
+	Attrs uint8
+	HMAC  Auth
+}
+
+// mockPasswordAuth is the response authorization for a password session.
+var mockPasswordAuth = mockResponseAuth{Attrs: uint8(AttrContinueSession)}
+
+// makeMockResponse returns a response packet with the response code rc, the response handle area in handles and the response
+// parameter area in params. If any response authorizations are supplied, the response has the TagSessions tag and an
+// authorization area containing them. Otherwise, it has the TagNoSessions tag.
+func makeMockResponse(rc ResponseCode, handles []Handle, params []byte, auths ...mockResponseAuth) []byte {
+	var body []interface{}
+	for _, h := range handles {
+		body = append(body, h)
+	}
+	tag := TagNoSessions
+	if len(auths) > 0 {
+		tag = TagSessions
+		body = append(body, uint32(len(params)))
+	}
+	body = append(body, mu.RawBytes(params))
+	for _, a := range auths {
+		body = append(body, a)
+	}
+
+	b, err := mu.MarshalToBytes(body...)
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal response body: %v", err))
+	}
+	rsp, err := mu.MarshalToBytes(tag, uint32(10+len(b)), rc, mu.RawBytes(b))
+	if err != nil {
+		panic(fmt.Sprintf("cannot marshal response: %v", err))
+	}
+	return rsp
+}
+
+func TestCommandExecutionError(t *testing.T) {
+	tcti := &mockTcti{responses: bytes.NewReader([]byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x55})}
+	tpm, _ := NewTPMContext(tcti)
+
+	_, err := tpm.GetRandom(16)
+	if err == nil {
+		t.Fatalf("GetRandom should have failed")
+	}
+
+	var e *CommandExecutionError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Unexpected error type: %T", err)
+	}
+	if e.Command != CommandGetRandom {
+		t.Errorf("Unexpected command code: %v", e.Command)
+	}
+	if !bytes.Equal(e.CommandBytes, tcti.commands.Bytes()) {
+		t.Errorf("Unexpected command bytes: %x", e.CommandBytes)
+	}
+	if !bytes.Equal(e.CommandBytes, []byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x01, 0x7b, 0x00, 0x10}) {
+		t.Errorf("Unexpected command bytes: %x", e.CommandBytes)
+	}
+
+	var tpmErr *TPMError
+	if !xerrors.As(err, &tpmErr) {
+		t.Fatalf("Expected a *TPMError")
+	}
+	if tpmErr.Code != ErrorSensitive || tpmErr.Command != CommandGetRandom {
+		t.Errorf("Unexpected error: %v", tpmErr)
+	}
+	if err.Error() != tpmErr.Error() {
+		t.Errorf("Unexpected error string: %v", err)
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	for _, data := range []struct {
+		desc         string
+		responseSize uint32
+		set          bool
+		max          uint32
+		err          string
+	}{
+		{
+			desc:         "Default",
+			responseSize: 0xffffffff,
+			err:          "TPM returned an invalid response for command TPM_CC_GetRandom: responseSize value (4294967295) exceeds the maximum (65536)",
+		},
+		{
+			desc:         "Custom",
+			responseSize: 4097,
+			set:          true,
+			max:          4096,
+			err:          "TPM returned an invalid response for command TPM_CC_GetRandom: responseSize value (4097) exceeds the maximum (4096)",
+		},
+		{
+			desc:         "Zero",
+			responseSize: 65537,
+			set:          true,
+			err:          "TPM returned an invalid response for command TPM_CC_GetRandom: responseSize value (65537) exceeds the maximum (65536)",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			rsp, _ := mu.MarshalToBytes(TagNoSessions, data.responseSize, Success)
+			tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+			if data.set {
+				tpm.SetMaxResponseSize(data.max)
+			}
+
+			_, err := tpm.GetRandom(16)
+			var e *InvalidResponseError
+			if !xerrors.As(err, &e) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err.Error() != data.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxResponseSizeSubsequentCommand(t *testing.T) {
+	// The oversized response is followed by part of its payload and then a valid response, which must not be mistaken for the
+	// response to the next command.
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02, 0x03, 0x04})
+	rsp, _ := mu.MarshalToBytes(TagNoSessions, uint32(0x20000), Success, mu.RawBytes(make([]byte, 16)),
+		mu.RawBytes(makeMockResponse(Success, nil, params)))
+	tcti := &mockTcti{responses: bytes.NewReader(rsp)}
+	tpm, _ := NewTPMContext(tcti)
+
+	_, err := tpm.GetRandom(4)
+	var e *InvalidResponseError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	n := tcti.commands.Len()
+
+	for i := 0; i < 2; i++ {
+		_, err = tpm.GetRandom(4)
+		var te *TctiError
+		if !xerrors.As(err, &te) || !xerrors.As(err, &e) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err.Error() != "cannot complete write operation on TCTI: the command and response streams are no longer synchronized: "+
+			"TPM returned an invalid response for command TPM_CC_GetRandom: responseSize value (131072) exceeds the maximum (65536)" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if tcti.commands.Len() != n {
+		t.Errorf("Subsequent commands should not have been sent to the TPM")
+	}
+}
+
+func TestInvalidParameterSize(t *testing.T) {
+	params, _ := mu.MarshalToBytes(uint32(0xffffffff), Nonce(nil), uint8(1), Auth(nil))
+	rsp, _ := mu.MarshalToBytes(TagSessions, uint32(10+len(params)), Success, mu.RawBytes(params))
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+	err := tpm.HierarchyChangeAuth(tpm.OwnerHandleContext(), nil, nil)
+	var e *InvalidResponseError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err.Error() != "TPM returned an invalid response for command TPM_CC_HierarchyChangeAuth: parameterSize value (4294967295) "+
+		"exceeds the remaining response size (5)" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestTrailingBytesInParameterArea(t *testing.T) {
+	params, _ := mu.MarshalToBytes(uint32(2), uint16(0), Nonce(nil), uint8(1), Auth(nil))
+	rsp, _ := mu.MarshalToBytes(TagSessions, uint32(10+len(params)), Success, mu.RawBytes(params))
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+	err := tpm.HierarchyChangeAuth(tpm.OwnerHandleContext(), nil, nil)
+	var e *InvalidResponseError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err.Error() != "TPM returned an invalid response for command TPM_CC_HierarchyChangeAuth: response parameter area contains 2 "+
+		"trailing bytes" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestInvalidUnionSelector(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02}, SigSchemeId(0x7fff))
+	rsp := makeMockResponse(Success, nil, params)
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(rsp)})
+
+	var digest Digest
+	var signature Signature
+	err := tpm.RunCommand(CommandSign, nil, Delimiter, Delimiter, Delimiter, &digest, &signature)
+	var e *InvalidResponseError
+	if !xerrors.As(err, &e) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "TPM returned an invalid response for command TPM_CC_Sign: cannot unmarshal response "+
+		"parameters: invalid union selector value 0x7fff after 6 bytes: ") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestCommandLogger(t *testing.T) {
+	type logEntry struct {
+		commandCode CommandCode
+		command     []byte
+		response    []byte
+	}
+
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02, 0x03, 0x04})
+	rsp1 := makeMockResponse(Success, nil, params)
+	rsp2 := makeMockResponse(ResponseCode(0x120), nil, nil)
+	rsp3, _ := mu.MarshalToBytes(TagNoSessions, uint32(20), Success, uint16(0))
+
+	tcti := &mockTcti{responses: bytes.NewReader(append(append(rsp1, rsp2...), rsp3...))}
+	tpm, _ := NewTPMContext(tcti)
+
+	var log []logEntry
+	tpm.SetCommandLogger(func(commandCode CommandCode, command, response []byte) {
+		log = append(log, logEntry{commandCode, command, response})
+	})
+
+	if _, err := tpm.GetRandom(4); err != nil {
+		t.Fatalf("GetRandom failed: %v", err)
+	}
+	if _, err := tpm.GetRandom(4); err == nil {
+		t.Fatalf("GetRandom should have failed")
+	}
+	if _, err := tpm.GetRandom(4); err == nil {
+		t.Fatalf("GetRandom should have failed")
+	}
+
+	if len(log) != 3 {
+		t.Fatalf("Unexpected number of log entries: %d", len(log))
+	}
+	cmd := []byte{0x80, 0x01, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x01, 0x7b, 0x00, 0x04}
+	for i, expected := range [][]byte{rsp1, rsp2, rsp3[:12]} {
+		if log[i].commandCode != CommandGetRandom {
+			t.Errorf("Unexpected command code for entry %d: %v", i, log[i].commandCode)
+		}
+		if !bytes.Equal(log[i].command, cmd) {
+			t.Errorf("Unexpected command for entry %d: %x", i, log[i].command)
+		}
+		if !bytes.Equal(log[i].response, expected) {
+			t.Errorf("Unexpected response for entry %d: %x", i, log[i].response)
+		}
+	}
+
+	// Disabling the logger should stop further calls.
+	tpm.SetCommandLogger(nil)
+	tcti.responses = bytes.NewReader(rsp1)
+	if _, err := tpm.GetRandom(4); err != nil {
+		t.Fatalf("GetRandom failed: %v", err)
+	}
+	if len(log) != 3 {
+		t.Errorf("Unexpected number of log entries: %d", len(log))
+	}
+}
+
+func TestCommandProfiling(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02, 0x03, 0x04})
+	rsp := makeMockResponse(Success, nil, params)
+	errRsp := makeMockResponse(ResponseCode(0x120), nil, nil)
+
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		time.Sleep(time.Millisecond)
+		if bytes.Equal(cmd[6:10], []byte{0x00, 0x00, 0x01, 0x46}) {
+			return errRsp
+		}
+		return rsp
+	}}
+	tpm, _ := NewTPMContext(tcti)
+
+	if stats := tpm.GetCommandStats(); stats != nil {
+		t.Errorf("GetCommandStats should return nil when profiling is disabled")
+	}
+
+	tpm.SetCommandProfiling(true)
+
+	done := make(chan struct{})
+	go func() {
+		// Read the statistics concurrently with command execution.
+		for i := 0; i < 10; i++ {
+			tpm.GetCommandStats()
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		if _, err := tpm.GetRandom(4); err != nil {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+	}
+	if err := tpm.StirRandom(SensitiveData("foo")); err == nil {
+		t.Fatalf("StirRandom should have failed")
+	}
+	<-done
+
+	stats := tpm.GetCommandStats()
+	if len(stats) != 2 {
+		t.Fatalf("Unexpected number of commands: %d", len(stats))
+	}
+	for _, data := range []struct {
+		code  CommandCode
+		count uint
+	}{
+		{code: CommandGetRandom, count: 3},
+		{code: CommandStirRandom, count: 1},
+	} {
+		stat, ok := stats[data.code]
+		if !ok {
+			t.Fatalf("No statistics for %v", data.code)
+		}
+		if stat.Count != data.count {
+			t.Errorf("Unexpected count for %v: %d", data.code, stat.Count)
+		}
+		if stat.Min < time.Millisecond || stat.Max < stat.Min || stat.Total < stat.Min*time.Duration(stat.Count) {
+			t.Errorf("Unexpected timings for %v: %+v", data.code, stat)
+		}
+	}
+
+	tpm.SetCommandProfiling(false)
+	if stats := tpm.GetCommandStats(); stats != nil {
+		t.Errorf("GetCommandStats should return nil when profiling is disabled")
+	}
+}
+
+func TestCommandProfilingExcludesLogger(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02, 0x03, 0x04})
+	tpm, _ := NewTPMContext(&mockTcti{responses: bytes.NewReader(makeMockResponse(Success, nil, params))})
+
+	tpm.SetCommandProfiling(true)
+	tpm.SetCommandLogger(func(CommandCode, []byte, []byte) {
+		time.Sleep(50 * time.Millisecond)
+	})
+
+	if _, err := tpm.GetRandom(4); err != nil {
+		t.Fatalf("GetRandom failed: %v", err)
+	}
+
+	stat := tpm.GetCommandStats()[CommandGetRandom]
+	if stat.Count != 1 {
+		t.Fatalf("Unexpected count: %d", stat.Count)
+	}
+	if stat.Total >= 50*time.Millisecond {
+		t.Errorf("Recorded time includes the time spent in the command logger: %v", stat.Total)
+	}
+}
+
+// blockingTcti is a mockTcti where reads block until the release channel is closed.
+type blockingTcti struct {
+	mockTcti
+	release chan struct{}
+
+	mu     sync.Mutex
+	writes int
+}
+
+func (t *blockingTcti) Read(data []byte) (int, error) {
+	<-t.release
+	return t.mockTcti.Read(data)
+}
+
+func (t *blockingTcti) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writes++
+	return t.mockTcti.Write(data)
+}
+
+func (t *blockingTcti) numWrites() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writes
+}
+
+func TestRunCommandContext(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02, 0x03, 0x04})
+	rsp1 := makeMockResponse(Success, nil, params)
+	params, _ = mu.MarshalToBytes(Digest{0x05, 0x06, 0x07, 0x08})
+	rsp2 := makeMockResponse(Success, nil, params)
+
+	tcti := &blockingTcti{mockTcti: mockTcti{responses: bytes.NewReader(append(rsp1, rsp2...))}, release: make(chan struct{})}
+	tpm, _ := NewTPMContext(tcti)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var random Digest
+	err := tpm.RunCommandContext(ctx, CommandGetRandom, nil, Delimiter, uint16(4), Delimiter, Delimiter, &random)
+	if err == nil {
+		t.Fatalf("RunCommandContext should have failed")
+	}
+	var e *TctiError
+	if !xerrors.As(err, &e) || !xerrors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// The response to the abandoned command hasn't been received, so the next command should be rejected without being sent.
+	if _, err := tpm.GetRandom(4); err == nil || !xerrors.As(err, &e) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.numWrites() != 1 {
+		t.Errorf("Command should not have been sent")
+	}
+
+	// Unblock the transport, and wait for the response to the abandoned command to be discarded.
+	close(tcti.release)
+	for i := 0; ; i++ {
+		random, err = tpm.GetRandom(4)
+		if err == nil {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("GetRandom failed: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !bytes.Equal(random, []byte{0x05, 0x06, 0x07, 0x08}) {
+		t.Errorf("Unexpected response: %x", random)
+	}
+}
+
+// deadlineTcti is a mockTcti that implements TCTIWithReadDeadline. Reads block until the release channel is closed or the read
+// deadline expires.
+type deadlineTcti struct {
+	mockTcti
+	release chan struct{}
+
+	mu       sync.Mutex
+	deadline time.Time
+	changed  chan struct{}
+	writes   int
+}
+
+func newDeadlineTcti(responses []byte) *deadlineTcti {
+	return &deadlineTcti{
+		mockTcti: mockTcti{responses: bytes.NewReader(responses)},
+		release:  make(chan struct{}),
+		changed:  make(chan struct{})}
+}
+
+func (t *deadlineTcti) Read(data []byte) (int, error) {
+	for {
+		t.mu.Lock()
+		deadline, changed := t.deadline, t.changed
+		t.mu.Unlock()
+
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		select {
+		case <-t.release:
+			return t.mockTcti.Read(data)
+		case <-expired:
+			return 0, errors.New("i/o timeout")
+		case <-changed:
+		}
+	}
+}
+
+func (t *deadlineTcti) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writes++
+	return t.mockTcti.Write(data)
+}
+
+func (t *deadlineTcti) SetReadDeadline(deadline time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deadline = deadline
+	close(t.changed)
+	t.changed = make(chan struct{})
+	return nil
+}
+
+func (t *deadlineTcti) numWrites() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writes
+}
+
+func TestRunCommandContextWithReadDeadline(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02, 0x03, 0x04})
+	rsp1 := makeMockResponse(Success, nil, params)
+	params, _ = mu.MarshalToBytes(Digest{0x05, 0x06, 0x07, 0x08})
+	rsp2 := makeMockResponse(Success, nil, params)
+
+	tcti := newDeadlineTcti(append(rsp1, rsp2...))
+	tpm, _ := NewTPMContext(tcti)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var random Digest
+	err := tpm.RunCommandContext(ctx, CommandGetRandom, nil, Delimiter, uint16(4), Delimiter, Delimiter, &random)
+	if err == nil {
+		t.Fatalf("RunCommandContext should have failed")
+	}
+	var e *TctiError
+	if !xerrors.As(err, &e) || !xerrors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// The response to the cancelled command is still queued, so the next command should time out waiting for it to be discarded
+	// without being sent.
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	err = tpm.RunCommandContext(ctx2, CommandGetRandom, nil, Delimiter, uint16(4), Delimiter, Delimiter, &random)
+	if err == nil || !xerrors.As(err, &e) || !xerrors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if tcti.numWrites() != 1 {
+		t.Errorf("Command should not have been sent")
+	}
+
+	// Once the transport is unblocked, the response to the cancelled command should be discarded before the next command is sent.
+	close(tcti.release)
+	random, err = tpm.GetRandom(4)
+	if err != nil {
+		t.Fatalf("GetRandom failed: %v", err)
+	}
+	if !bytes.Equal(random, []byte{0x05, 0x06, 0x07, 0x08}) {
+		t.Errorf("Unexpected response: %x", random)
+	}
+	if tcti.numWrites() != 2 {
+		t.Errorf("Unexpected number of commands: %d", tcti.numWrites())
+	}
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	// Respond to each TPM2_GetRandom command with a buffer of the requested size, filled with bytes equal to the size.
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		var size uint16
+		if _, err := mu.UnmarshalFromBytes(cmd[10:], &size); err != nil {
+			return nil
+		}
+		params, _ := mu.MarshalToBytes(Digest(bytes.Repeat([]byte{byte(size)}, int(size))))
+		return makeMockResponse(Success, nil, params)
+	}}
+	tpm, _ := NewTPMContext(tcti)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(size uint16) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				random, err := tpm.GetRandom(size)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(random, bytes.Repeat([]byte{byte(size)}, int(size))) {
+					errs <- fmt.Errorf("unexpected response for size %d: %x", size, random)
+					return
+				}
+			}
+		}(uint16(i))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("%v", err)
+	}
+}
+
+func TestRetryOnWarning(t *testing.T) {
+	params, _ := mu.MarshalToBytes(Digest{0x01, 0x02})
+	successRsp := makeMockResponse(Success, nil, params)
+
+	for _, data := range []struct {
+		desc           string
+		warnings       []ResponseCode
+		maxSubmissions uint
+		submissions    int
+		err            error
+	}{
+		{
+			desc:        "Retry",
+			warnings:    []ResponseCode{0x922, 0x908, 0x90a},
+			submissions: 4,
+		},
+		{
+			desc:           "Limit",
+			warnings:       []ResponseCode{0x922, 0x922, 0x922},
+			maxSubmissions: 3,
+			submissions:    3,
+			err:            &TPMWarning{Command: CommandGetRandom, Code: WarningRetry},
+		},
+		{
+			desc:        "NotRetryable",
+			warnings:    []ResponseCode{0x910},
+			submissions: 1,
+			err:         &TPMWarning{Command: CommandGetRandom, Code: WarningReferenceH0},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			submissions := 0
+			tcti := &mockTcti{respond: func(cmd []byte) []byte {
+				submissions++
+				if submissions > len(data.warnings) {
+					return successRsp
+				}
+				return makeMockResponse(data.warnings[submissions-1], nil, nil)
+			}}
+			tpm, _ := NewTPMContext(tcti)
+			if data.maxSubmissions > 0 {
+				tpm.SetMaxSubmissions(data.maxSubmissions)
+			}
+			tpm.SetRetryBackoff(time.Millisecond)
+
+			random, err := tpm.GetRandom(2)
+			if submissions != data.submissions {
+				t.Errorf("Unexpected number of submissions: %d", submissions)
+			}
+			if !bytes.Equal(tcti.commands.Bytes(), bytes.Repeat(tcti.commands.Bytes()[:12], submissions)) {
+				t.Errorf("Each submission should contain the same command packet")
+			}
+			if data.err == nil {
+				if err != nil {
+					t.Fatalf("GetRandom failed: %v", err)
+				}
+				if !bytes.Equal(random, []byte{0x01, 0x02}) {
+					t.Errorf("Unexpected response: %x", random)
+				}
+				return
+			}
+			var e *TPMWarning
+			if !xerrors.As(err, &e) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(e, data.err) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRetryBackoffCancelled(t *testing.T) {
+	submissions := 0
+	tcti := &mockTcti{respond: func(cmd []byte) []byte {
+		submissions++
+		return makeMockResponse(0x922, nil, nil)
+	}}
+	tpm, _ := NewTPMContext(tcti)
+	tpm.SetRetryBackoff(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var random Digest
+	err := tpm.RunCommandContext(ctx, CommandGetRandom, nil, Delimiter, uint16(4), Delimiter, Delimiter, &random)
+	var e *CommandExecutionError
+	if !xerrors.As(err, &e) || !xerrors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var te *TctiError
+	if xerrors.As(err, &te) {
+		t.Errorf("Cancellation during the retry backoff should not be reported as a TCTI error: %v", err)
+	}
+	if submissions != 1 {
+		t.Errorf("Unexpected number of submissions: %d", submissions)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for _, data := range []struct {
+		desc     string
+		backoff  time.Duration
+		tries    uint
+		expected time.Duration
+	}{
+		{desc: "First", backoff: time.Millisecond, tries: 1, expected: time.Millisecond},
+		{desc: "Third", backoff: time.Millisecond, tries: 3, expected: 4 * time.Millisecond},
+		{desc: "Capped", backoff: time.Second, tries: 10, expected: TestMaxRetryBackoff},
+		{desc: "NoOverflow", backoff: time.Millisecond, tries: 100, expected: TestMaxRetryBackoff},
+		{desc: "LargeBackoff", backoff: time.Minute, tries: 3, expected: time.Minute},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			if delay := TestComputeRetryDelay(data.backoff, data.tries); delay != data.expected {
+				t.Errorf("Unexpected delay: %v", delay)
+			}
+		})
+	}
+}
+
+func TestVerifyResourceNames(t *testing.T) {
+	newSealedObject := func(unique byte) *Public {
+		return &Public{
+			Type:    ObjectTypeKeyedHash,
+			NameAlg: HashAlgorithmSHA256,
+			Attrs:   AttrFixedTPM | AttrFixedParent,
+			Params:  PublicParamsU{Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+			Unique:  PublicIDU{Data: Digest(bytes.Repeat([]byte{unique}, 32))}}
+	}
+	template := newSealedObject(0x00)
+	substituted := newSealedObject(0x01)
+
+	object, err := CreateObjectResourceContextFromPublic(0x81000001, template)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	for _, data := range []struct {
+		desc     string
+		disable  bool
+		public   *Public
+		commands []CommandCode
+		err      bool
+	}{
+		{
+			desc:     "Disabled",
+			disable:  true,
+			public:   substituted,
+			commands: []CommandCode{CommandStartAuthSession, CommandUnseal},
+		},
+		{
+			desc:     "Match",
+			public:   template,
+			commands: []CommandCode{CommandStartAuthSession, CommandReadPublic, CommandUnseal},
+		},
+		{
+			desc:     "Mismatch",
+			public:   substituted,
+			commands: []CommandCode{CommandStartAuthSession, CommandReadPublic},
+			err:      true,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			var commands []CommandCode
+			tcti := &mockTcti{respond: func(cmd []byte) []byte {
+				var code CommandCode
+				mu.UnmarshalFromBytes(cmd[6:], &code)
+				commands = append(commands, code)
+
+				var params []byte
+				var auths []mockResponseAuth
+				switch code {
+				case CommandStartAuthSession:
+					params, _ = mu.MarshalToBytes(Handle(0x03000000), Nonce(make([]byte, 32)))
+				case CommandReadPublic:
+					pub, _ := mu.MarshalToBytes(data.public)
+					name, _ := data.public.Name()
+					params, _ = mu.MarshalToBytes(uint16(len(pub)), mu.RawBytes(pub), name, Name(nil))
+				case CommandUnseal:
+					params, _ = mu.MarshalToBytes(SensitiveData("secret"))
+					auths = append(auths, mockResponseAuth{})
+				}
+				return makeMockResponse(Success, nil, params, auths...)
+			}}
+			tpm, _ := NewTPMContext(tcti)
+			if data.disable {
+				tpm.SetVerifyResourceNames(false)
+			}
+
+			session, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+			if err != nil {
+				t.Fatalf("StartAuthSession failed: %v", err)
+			}
+
+			secret, err := tpm.Unseal(object, session)
+			if !reflect.DeepEqual(commands, data.commands) {
+				t.Errorf("Unexpected commands: %v", commands)
+			}
+			if data.err {
+				var e *ResourceNameMismatchError
+				if !xerrors.As(err, &e) {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if e.Command != CommandUnseal || e.Handle != object.Handle() || !bytes.Equal(e.Expected, object.Name()) {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unseal failed: %v", err)
+			}
+			if !bytes.Equal(secret, []byte("secret")) {
+				t.Errorf("Unexpected secret: %x", secret)
+			}
+		})
+	}
+}
+
+func TestRunCommandInvalidDelimiters(t *testing.T) {
+	var random Digest
+	var handle Handle
+
+	for _, data := range []struct {
+		desc   string
+		params []interface{}
+		err    string
+	}{
+		{
+			desc:   "TooManyDelimiters",
+			params: []interface{}{Delimiter, uint16(8), Delimiter, Delimiter, &random, Delimiter},
+			err:    "invalid parameters for command TPM_CC_GetRandom: unexpected Delimiter at index 5 after the response parameter area",
+		},
+		{
+			desc:   "MissingResponseHandleDelimiter",
+			params: []interface{}{Delimiter, uint16(8), Delimiter, &random},
+			err: "invalid parameters for command TPM_CC_GetRandom: response handle area argument at index 0 has invalid type " +
+				"(*tpm2.Digest), expected *Handle",
+		},
+		{
+			desc:   "NonPointerResponseParam",
+			params: []interface{}{Delimiter, uint16(8), Delimiter, &handle, Delimiter, random},
+			err: "invalid parameters for command TPM_CC_GetRandom: response parameter area argument at index 0 is not a non-nil " +
+				"pointer (tpm2.Digest)",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			tcti := &mockTcti{}
+			tpm, _ := NewTPMContext(tcti)
+
+			err := tpm.RunCommand(CommandGetRandom, nil, data.params...)
+			if err == nil {
+				t.Fatalf("RunCommand should have failed")
+			}
+			if err.Error() != data.err {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tcti.commands.Len() != 0 {
+				t.Errorf("No command should have been sent to the TPM")
+			}
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	os.Exit(func() int {
@@ -12,6 +12,7 @@ import (
 	_ "crypto/sha256"
 	_ "crypto/sha512"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -59,6 +60,9 @@ type CommandCode uint32
 // ResponseCode corresponds to the TPM_RC type.
 type ResponseCode uint32
 
+// ClockAdjust corresponds to the TPM_CLOCK_ADJUST type.
+type ClockAdjust int8
+
 // ArithmeticOp corresponds to the TPM_EO type.
 type ArithmeticOp uint16
 
@@ -103,9 +107,43 @@ func (h HandleType) BaseHandle() Handle {
 // AlgorithmAttributes corresponds to the TPMA_ALGORITHM type and represents the attributes for an algorithm.
 type AlgorithmAttributes uint32
 
-// ObjectAttributes corresponds to the TPMA_OBJECT type, and represents the attributes for an object.
+// ObjectAttributes corresponds to the TPMA_OBJECT type, and represents the attributes for an object. Attributes are combined and
+// tested with the AttrFixedTPM, AttrRestricted etc constants using the bitwise operators, eg, attrs&AttrSign != 0. Validate can be
+// used to check for combinations that the TPM would reject.
 type ObjectAttributes uint32
 
+// Validate checks that this set of attributes is valid for a public template passed to TPMContext.Create, TPMContext.CreatePrimary
+// or TPMContext.CreateLoaded, returning an error for combinations that would cause the TPM to respond with a *TPMParameterError
+// with an error code of ErrorAttributes. This permits these errors to be detected without executing a command. The checks are:
+//  - no reserved bits are set.
+//  - AttrFixedParent is set if AttrFixedTPM is set.
+//  - AttrEncryptedDuplication is not set if AttrFixedTPM or AttrFixedParent are set.
+//  - exactly one of AttrSign and AttrDecrypt is set if AttrRestricted is set.
+// Some checks performed by the TPM depend on the type and parameters of the object or on the parent, and these are not performed
+// here.
+func (a ObjectAttributes) Validate() error {
+	const defined = AttrFixedTPM | AttrStClear | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrAdminWithPolicy |
+		AttrNoDA | AttrEncryptedDuplication | AttrRestricted | AttrDecrypt | AttrSign
+	if reserved := a &^ defined; reserved != 0 {
+		return fmt.Errorf("reserved bits are set (0x%08x)", uint32(reserved))
+	}
+	if a&AttrFixedTPM != 0 && a&AttrFixedParent == 0 {
+		return errors.New("fixedTPM is set without fixedParent")
+	}
+	if a&AttrEncryptedDuplication != 0 && a&(AttrFixedTPM|AttrFixedParent) != 0 {
+		return errors.New("encryptedDuplication is set for an object that cannot be duplicated")
+	}
+	if a&AttrRestricted != 0 {
+		switch a & (AttrSign | AttrDecrypt) {
+		case AttrSign | AttrDecrypt:
+			return errors.New("restricted is set with both sign and decrypt")
+		case 0:
+			return errors.New("restricted is set without sign or decrypt")
+		}
+	}
+	return nil
+}
+
 // Locality corresponds to the TPMA_LOCALITY type.
 type Locality uint8
 
@@ -457,9 +495,87 @@ type DigestList []Digest
 // TaggedHashList is a slice of TaggedHash values, and corresponds to the TPML_DIGEST_VALUES type.
 type TaggedHashList []TaggedHash
 
+// TaggedHashMap is a map of digest algorithms to digests, and is an alternative representation of the TPML_DIGEST_VALUES type.
+// Unlike a Go map marshalled in iteration order, it always marshals to a canonical encoding with the entries sorted by algorithm,
+// so equal maps marshal to identical bytes. When unmarshalling, an encoding that contains the same algorithm more than once is
+// rejected.
+type TaggedHashMap map[HashAlgorithmId]Digest
+
+// ToList returns the contents of m as a TaggedHashList, sorted by algorithm.
+func (m TaggedHashMap) ToList() TaggedHashList {
+	algs := make([]HashAlgorithmId, 0, len(m))
+	for alg := range m {
+		algs = append(algs, alg)
+	}
+	sort.Slice(algs, func(i, j int) bool { return algs[i] < algs[j] })
+
+	l := make(TaggedHashList, 0, len(algs))
+	for _, alg := range algs {
+		l = append(l, TaggedHash{HashAlg: alg, Digest: m[alg]})
+	}
+	return l
+}
+
+func (m *TaggedHashMap) Marshal(buf io.Writer) (int, error) {
+	return mu.MarshalToWriter(buf, m.ToList())
+}
+
+func (m *TaggedHashMap) Unmarshal(buf io.Reader) (int, error) {
+	var l TaggedHashList
+	n, err := mu.UnmarshalFromReader(buf, &l)
+	if err != nil {
+		return n, err
+	}
+
+	out := make(TaggedHashMap)
+	for _, d := range l {
+		if _, exists := out[d.HashAlg]; exists {
+			return n, fmt.Errorf("duplicate digest for algorithm %v", d.HashAlg)
+		}
+		out[d.HashAlg] = d.Digest
+	}
+	*m = out
+	return n, nil
+}
+
 // PCRSelectionList is a slice of PCRSelection values, and corresponds to the TPML_PCR_SELECTION type.
 type PCRSelectionList []PCRSelection
 
+// NewPCRSelectionList creates a list of PCR selections from the supplied map of PCR banks to PCR indexes. The returned list is
+// sorted by algorithm, and each PCR bank contains a sorted list of PCRs with any duplicates removed. A PCR bank with no PCRs is
+// retained in the returned list as an empty selection. Because of this, logically equal maps always result in lists that marshal to
+// identical bytes.
+func NewPCRSelectionList(selections map[HashAlgorithmId][]int) (out PCRSelectionList) {
+	for alg, pcrs := range selections {
+		s := PCRSelection{Hash: alg, Select: make([]int, 0, len(pcrs))}
+		seen := make(map[int]bool)
+		for _, pcr := range pcrs {
+			if seen[pcr] {
+				continue
+			}
+			seen[pcr] = true
+			s.Select = append(s.Select, pcr)
+		}
+		out = append(out, s)
+	}
+	return out.Sort()
+}
+
+// Contains indicates whether the PCR with the specified index is selected for the specified PCR bank in this list of PCR selections.
+func (l PCRSelectionList) Contains(alg HashAlgorithmId, pcr int) bool {
+	for _, s := range l {
+		if s.Hash != alg {
+			continue
+		}
+		for _, x := range s.Select {
+			if x == pcr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Equal indicates whether l and r contain the same PCR selections. Equal selections will marshal to the same bytes in the TPM
 // wire format. To be considered equal, each set of selections must be identical length, contain the same PCR banks in the same
 // order, and each PCR bank must contain the same set of PCRs - the order of the PCRs in each bank are not important.
@@ -761,6 +877,31 @@ type TimeInfo struct {
 	ClockInfo ClockInfo // Clock information
 }
 
+// ClockIfSafe returns the value of ClockInfo.Clock, which is the time in milliseconds that the TPM has been powered since it was
+// last cleared. The second return value is false if ClockInfo.Safe is not set, in which case the TPM may have lost power before the
+// value was persisted and it may repeat a value that has already been reported. A value that is not safe should not be relied on for
+// anti-rollback purposes.
+//
+// The returned value doesn't take ClockInfo.ResetCount or ClockInfo.RestartCount into account, so it is not suitable on its own for
+// ordering readings that may have been taken either side of a TPM reset or restart. Use TimeInfo.IsAfter for this.
+func (t *TimeInfo) ClockIfSafe() (uint64, bool) {
+	return t.ClockInfo.Clock, t.ClockInfo.Safe
+}
+
+// IsAfter indicates whether this time was read after other, by comparing the ClockInfo.ResetCount, ClockInfo.RestartCount and
+// ClockInfo.Clock fields in that order. A reading taken after a TPM reset or restart is considered to be after one taken
+// beforehand, even if its Clock value is smaller. It returns false if the readings are identical.
+func (t *TimeInfo) IsAfter(other *TimeInfo) bool {
+	switch {
+	case t.ClockInfo.ResetCount != other.ClockInfo.ResetCount:
+		return t.ClockInfo.ResetCount > other.ClockInfo.ResetCount
+	case t.ClockInfo.RestartCount != other.ClockInfo.RestartCount:
+		return t.ClockInfo.RestartCount > other.ClockInfo.RestartCount
+	default:
+		return t.ClockInfo.Clock > other.ClockInfo.Clock
+	}
+}
+
 // 10.12 Attestation Structures
 
 // TimeAttestInfo corresponds to the TPMS_TIME_ATTEST_INFO type, and is returned by TPMContext.GetTime.
@@ -997,6 +1138,9 @@ type SymDefObject struct {
 // SymKey corresponds to the TPM2B_SYM_KEY type.
 type SymKey []byte
 
+// IV corresponds to the TPM2B_IV type.
+type IV []byte
+
 // SymCipherParams corresponds to the TPMS_SYMCIPHER_PARMS type, and contains the parameters for a symmetric object.
 type SymCipherParams struct {
 	Sym SymDefObject
@@ -1370,6 +1514,13 @@ type RSAScheme struct {
 	Details AsymSchemeU `tpm2:"selector:Scheme"` // Scheme specific parameters.
 }
 
+// RSADecrypt corresponds to the TPMT_RSA_DECRYPT type, and specifies the padding scheme for TPMContext.RSAEncrypt and
+// TPMContext.RSADecrypt. Valid values for Scheme are RSASchemeRSAES, RSASchemeOAEP and RSASchemeNull.
+type RSADecrypt struct {
+	Scheme  RSASchemeId // Scheme selector
+	Details AsymSchemeU `tpm2:"selector:Scheme"` // Scheme specific parameters.
+}
+
 // PublicKeyRSA corresponds to the TPM2B_PUBLIC_KEY_RSA type.
 type PublicKeyRSA []byte
 
@@ -1686,6 +1837,14 @@ func (p *Public) Name() (Name, error) {
 	return name, nil
 }
 
+// Public returns the public key associated with this object as a crypto.PublicKey, for use with the standard Go crypto packages.
+// For RSA objects, this is a *rsa.PublicKey, where an exponent of zero is interpreted as the default exponent of 65537. For ECC
+// objects, this is a *ecdsa.PublicKey, and the curve must be one of the NIST curves supported by the crypto/elliptic package. An
+// error will be returned for other object types, or if the public area is malformed.
+func (p *Public) Public() (crypto.PublicKey, error) {
+	return cryptGetPublicKey(p)
+}
+
 func (p *Public) copy() (*Public, error) {
 	b, err := mu.MarshalToBytes(p)
 	if err != nil {
@@ -1858,7 +2017,9 @@ type NVPinCounterParams struct {
 }
 
 // NVAttributes corresponds to the TPMA_NV type, and represents the attributes of a NV index. When exchanged with the TPM, some bits
-// are reserved to encode the type of the NV index (NVType).
+// are reserved to encode the type of the NV index (NVType). A value is normally constructed with NVType.WithAttrs, and attributes are
+// tested with the AttrNVAuthRead, AttrNVWritten etc constants using the bitwise operators. Validate can be used to check for
+// combinations that the TPM would reject.
 type NVAttributes uint32
 
 // Type returns the NVType encoded in a NVAttributes value.
@@ -1871,6 +2032,51 @@ func (a NVAttributes) AttrsOnly() NVAttributes {
 	return a & ^NVAttributes(0xf0)
 }
 
+// Validate checks that this set of attributes, including the encoded NVType, is valid for a public area passed to
+// TPMContext.NVDefineSpace, returning an error for combinations that would cause the TPM to respond with an error code of
+// ErrorAttributes. This permits these errors to be detected without executing a command. The checks are:
+//  - no reserved bits are set and the encoded NVType is valid.
+//  - at least one of AttrNVPPWrite, AttrNVOwnerWrite, AttrNVAuthWrite and AttrNVPolicyWrite is set.
+//  - at least one of AttrNVPPRead, AttrNVOwnerRead, AttrNVAuthRead and AttrNVPolicyRead is set.
+//  - none of AttrNVWriteLocked, AttrNVReadLocked and AttrNVWritten are set, as these are set by the TPM.
+//  - AttrNVClearStClear is not set for counter and PIN indices.
+//  - AttrNVNoDA is set for PIN fail indices.
+//  - AttrNVPlatformCreate is set if AttrNVPolicyDelete is set.
+// Checks that depend on the authorization handle or the size of the index are not performed here.
+func (a NVAttributes) Validate() error {
+	const reserved = NVAttributes(0x01f00300)
+	if r := a & reserved; r != 0 {
+		return fmt.Errorf("reserved bits are set (0x%08x)", uint32(r))
+	}
+	switch a.Type() {
+	case NVTypeOrdinary, NVTypeCounter, NVTypeBits, NVTypeExtend, NVTypePinFail, NVTypePinPass:
+	default:
+		return fmt.Errorf("invalid type %d", a.Type())
+	}
+	if a&(AttrNVPPWrite|AttrNVOwnerWrite|AttrNVAuthWrite|AttrNVPolicyWrite) == 0 {
+		return errors.New("no write authorization attributes are set")
+	}
+	if a&(AttrNVPPRead|AttrNVOwnerRead|AttrNVAuthRead|AttrNVPolicyRead) == 0 {
+		return errors.New("no read authorization attributes are set")
+	}
+	if a&(AttrNVWriteLocked|AttrNVReadLocked|AttrNVWritten) != 0 {
+		return errors.New("attributes that can only be set by the TPM are set")
+	}
+	switch a.Type() {
+	case NVTypeCounter, NVTypePinFail, NVTypePinPass:
+		if a&AttrNVClearStClear != 0 {
+			return fmt.Errorf("clearStClear is set for an index of type %d", a.Type())
+		}
+	}
+	if a.Type() == NVTypePinFail && a&AttrNVNoDA == 0 {
+		return errors.New("noDA is not set for a PIN fail index")
+	}
+	if a&AttrNVPolicyDelete != 0 && a&AttrNVPlatformCreate == 0 {
+		return errors.New("policyDelete is set without platformCreate")
+	}
+	return nil
+}
+
 // NVPublic corresponds to the TPMS_NV_PUBLIC type, which describes a NV index.
 type NVPublic struct {
 	Index      Handle          // Handle of the NV index
@@ -6,8 +6,13 @@ package tpm2_test
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -20,51 +25,81 @@ func TestHandle(t *testing.T) {
 		desc       string
 		handle     Handle
 		handleType HandleType
+		str        string
 	}{
 		{
 			desc:       "PCR",
 			handle:     0x0000000a,
 			handleType: HandleTypePCR,
+			str:        "TPM_HT_PCR",
 		},
 		{
 			desc:       "NVIndex",
 			handle:     0x0180ff00,
 			handleType: HandleTypeNVIndex,
+			str:        "TPM_HT_NV_INDEX",
 		},
 		{
 			desc:       "HMACSession",
 			handle:     0x02000001,
 			handleType: HandleTypeHMACSession,
+			str:        "TPM_HT_HMAC_SESSION",
 		},
 		{
 			desc:       "PolicySession",
 			handle:     0x03000001,
 			handleType: HandleTypePolicySession,
+			str:        "TPM_HT_POLICY_SESSION",
 		},
 		{
 			desc:       "Permanent",
 			handle:     HandleOwner,
 			handleType: HandleTypePermanent,
+			str:        "TPM_HT_PERMANENT",
 		},
 		{
 			desc:       "Transient",
 			handle:     0x80000003,
 			handleType: HandleTypeTransient,
+			str:        "TPM_HT_TRANSIENT",
 		},
 		{
 			desc:       "Persistent",
 			handle:     0x81000000,
 			handleType: HandleTypePersistent,
+			str:        "TPM_HT_PERSISTENT",
 		},
 	} {
 		t.Run(data.desc, func(t *testing.T) {
 			if data.handle.Type() != data.handleType {
 				t.Errorf("Unexpected handle type (got %x, expected %x)", data.handle.Type(), data.handleType)
 			}
+			if data.handle.Type().String() != data.str {
+				t.Errorf("Unexpected handle type string: %s", data.handle.Type())
+			}
 		})
 	}
 }
 
+func TestCommandCodeString(t *testing.T) {
+	for _, data := range []struct {
+		code CommandCode
+		str  string
+	}{
+		{code: CommandNVUndefineSpaceSpecial, str: "TPM_CC_NV_UndefineSpaceSpecial"},
+		{code: CommandPolicyLocality, str: "TPM_CC_PolicyLocality"},
+		{code: CommandECCDecrypt, str: "TPM_CC_ECC_Decrypt"},
+		{code: 0x20000001, str: "TPM_CC_0x20000001"},
+	} {
+		if data.code.String() != data.str {
+			t.Errorf("Unexpected string for command code 0x%08x: %s", uint32(data.code), data.code)
+		}
+		if s := fmt.Sprintf("%v", data.code); s != data.str {
+			t.Errorf("Unexpected formatted string for command code 0x%08x: %s", uint32(data.code), s)
+		}
+	}
+}
+
 type TestPublicIDUContainer struct {
 	Alg    ObjectTypeId
 	Unique PublicIDU `tpm2:"selector:Alg"`
@@ -407,6 +442,61 @@ func TestTaggedHash(t *testing.T) {
 	})
 }
 
+func TestTaggedHashMap(t *testing.T) {
+	sha1Hash := sha1.Sum([]byte("foo"))
+	sha256Hash := sha256.Sum256([]byte("foo"))
+
+	expected, _ := mu.MarshalToBytes(TaggedHashList{
+		{HashAlg: HashAlgorithmSHA1, Digest: sha1Hash[:]},
+		{HashAlg: HashAlgorithmSHA256, Digest: sha256Hash[:]}})
+
+	for i := 0; i < 10; i++ {
+		m := make(TaggedHashMap)
+		if i%2 == 0 {
+			m[HashAlgorithmSHA256] = sha256Hash[:]
+			m[HashAlgorithmSHA1] = sha1Hash[:]
+		} else {
+			m[HashAlgorithmSHA1] = sha1Hash[:]
+			m[HashAlgorithmSHA256] = sha256Hash[:]
+		}
+
+		out, err := mu.MarshalToBytes(&m)
+		if err != nil {
+			t.Fatalf("MarshalToBytes failed: %v", err)
+		}
+		if !bytes.Equal(out, expected) {
+			t.Fatalf("MarshalToBytes returned an unexpected byte sequence: %x", out)
+		}
+
+		var a TaggedHashMap
+		n, err := mu.UnmarshalFromBytes(out, &a)
+		if err != nil {
+			t.Fatalf("UnmarshalFromBytes failed: %v", err)
+		}
+		if n != len(out) {
+			t.Errorf("UnmarshalFromBytes consumed the wrong number of bytes (%d)", n)
+		}
+		if !reflect.DeepEqual(m, a) {
+			t.Errorf("UnmarshalFromBytes didn't return the original data")
+		}
+	}
+
+	t.Run("UnmarshalDuplicate", func(t *testing.T) {
+		b, _ := mu.MarshalToBytes(TaggedHashList{
+			{HashAlg: HashAlgorithmSHA1, Digest: sha1Hash[:]},
+			{HashAlg: HashAlgorithmSHA1, Digest: sha1Hash[:]}})
+		var a TaggedHashMap
+		_, err := mu.UnmarshalFromBytes(b, &a)
+		if err == nil {
+			t.Fatalf("UnmarshalFromBytes should fail to unmarshal duplicate algorithms")
+		}
+		if err.Error() != "cannot unmarshal argument at index 0: cannot process custom type tpm2.TaggedHashMap: duplicate digest "+
+			"for algorithm TPM_ALG_SHA1" {
+			t.Errorf("UnmarshalFromBytes returned an unexpected error: %v", err)
+		}
+	})
+}
+
 func TestPublicName(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy)
 	defer closeTPM(t, tpm)
@@ -430,6 +520,101 @@ func TestPublicName(t *testing.T) {
 	}
 }
 
+func TestPublicPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	t.Run("RSA", func(t *testing.T) {
+		pub, err := PublicFromCryptoKey(&rsaKey.PublicKey, HashAlgorithmSHA256, nil)
+		if err != nil {
+			t.Fatalf("PublicFromCryptoKey failed: %v", err)
+		}
+		key, err := pub.Public()
+		if err != nil {
+			t.Fatalf("Public failed: %v", err)
+		}
+		if !reflect.DeepEqual(key, &rsaKey.PublicKey) {
+			t.Errorf("Unexpected public key")
+		}
+	})
+
+	t.Run("RSADefaultExponent", func(t *testing.T) {
+		pub, _ := PublicFromCryptoKey(&rsaKey.PublicKey, HashAlgorithmSHA256, nil)
+		pub.Params.RSADetail().Exponent = 0
+		key, err := pub.Public()
+		if err != nil {
+			t.Fatalf("Public failed: %v", err)
+		}
+		rsaPub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			t.Fatalf("Unexpected key type %T", key)
+		}
+		if rsaPub.E != 65537 {
+			t.Errorf("Unexpected exponent %d", rsaPub.E)
+		}
+	})
+
+	for _, data := range []struct {
+		desc  string
+		curve elliptic.Curve
+		id    ECCCurve
+	}{
+		{desc: "P256", curve: elliptic.P256(), id: ECCCurveNIST_P256},
+		{desc: "P384", curve: elliptic.P384(), id: ECCCurveNIST_P384},
+		{desc: "P521", curve: elliptic.P521(), id: ECCCurveNIST_P521},
+	} {
+		t.Run("ECC/"+data.desc, func(t *testing.T) {
+			eccKey, err := ecdsa.GenerateKey(data.curve, rand.Reader)
+			if err != nil {
+				t.Fatalf("GenerateKey failed: %v", err)
+			}
+			pub, err := PublicFromCryptoKey(&eccKey.PublicKey, HashAlgorithmSHA256, nil)
+			if err != nil {
+				t.Fatalf("PublicFromCryptoKey failed: %v", err)
+			}
+			if pub.Params.ECCDetail().CurveID != data.id {
+				t.Errorf("Unexpected curve %v", pub.Params.ECCDetail().CurveID)
+			}
+			key, err := pub.Public()
+			if err != nil {
+				t.Fatalf("Public failed: %v", err)
+			}
+			if !reflect.DeepEqual(key, &eccKey.PublicKey) {
+				t.Errorf("Unexpected public key")
+			}
+		})
+	}
+
+	t.Run("UnsupportedCurve", func(t *testing.T) {
+		pub := &Public{
+			Type:    ObjectTypeECC,
+			NameAlg: HashAlgorithmSHA256,
+			Params: PublicParamsU{
+				Data: &ECCParams{
+					Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+					Scheme:    ECCScheme{Scheme: ECCSchemeNull},
+					CurveID:   ECCCurveBN_P256,
+					KDF:       KDFScheme{Scheme: KDFAlgorithmNull}}},
+			Unique: PublicIDU{Data: &ECCPoint{X: make(ECCParameter, 32), Y: make(ECCParameter, 32)}}}
+		if _, err := pub.Public(); err == nil || err.Error() != "unsupported curve: 16" {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("KeyedHash", func(t *testing.T) {
+		pub := &Public{
+			Type:    ObjectTypeKeyedHash,
+			NameAlg: HashAlgorithmSHA256,
+			Params:  PublicParamsU{Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}},
+			Unique:  PublicIDU{Data: make(Digest, 32)}}
+		if _, err := pub.Public(); err == nil {
+			t.Errorf("Public should fail for a keyed hash object")
+		}
+	})
+}
+
 func TestNVPublicName(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerPersist)
 	defer closeTPM(t, tpm)
@@ -458,6 +643,125 @@ func TestNVPublicName(t *testing.T) {
 	}
 }
 
+func TestObjectAttributesValidate(t *testing.T) {
+	for _, data := range []struct {
+		desc  string
+		attrs ObjectAttributes
+		err   string
+	}{
+		{
+			desc:  "StorageKey",
+			attrs: NewRSAStorageKeyTemplate().Attrs,
+		},
+		{
+			desc:  "SealedObject",
+			attrs: AttrFixedTPM | AttrFixedParent | AttrUserWithAuth,
+		},
+		{
+			desc:  "Reserved",
+			attrs: AttrFixedTPM | AttrFixedParent | AttrSign | (1 << 3),
+			err:   "reserved bits are set (0x00000008)",
+		},
+		{
+			desc:  "FixedTPMWithoutFixedParent",
+			attrs: AttrFixedTPM | AttrSign,
+			err:   "fixedTPM is set without fixedParent",
+		},
+		{
+			desc:  "EncryptedDuplication",
+			attrs: AttrFixedParent | AttrEncryptedDuplication | AttrDecrypt,
+			err:   "encryptedDuplication is set for an object that cannot be duplicated",
+		},
+		{
+			desc:  "RestrictedSignDecrypt",
+			attrs: AttrFixedTPM | AttrFixedParent | AttrRestricted | AttrSign | AttrDecrypt,
+			err:   "restricted is set with both sign and decrypt",
+		},
+		{
+			desc:  "RestrictedNoUsage",
+			attrs: AttrFixedTPM | AttrFixedParent | AttrRestricted,
+			err:   "restricted is set without sign or decrypt",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			err := data.attrs.Validate()
+			switch {
+			case data.err == "" && err != nil:
+				t.Errorf("Validate failed: %v", err)
+			case data.err != "" && (err == nil || err.Error() != data.err):
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNVAttributesValidate(t *testing.T) {
+	for _, data := range []struct {
+		desc  string
+		attrs NVAttributes
+		err   string
+	}{
+		{
+			desc:  "Ordinary",
+			attrs: NVTypeOrdinary.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead),
+		},
+		{
+			desc:  "PinFail",
+			attrs: NVTypePinFail.WithAttrs(AttrNVOwnerWrite | AttrNVAuthRead | AttrNVNoDA),
+		},
+		{
+			desc:  "Reserved",
+			attrs: NVTypeOrdinary.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead | (1 << 8)),
+			err:   "reserved bits are set (0x00000100)",
+		},
+		{
+			desc:  "InvalidType",
+			attrs: NVType(3).WithAttrs(AttrNVAuthWrite | AttrNVAuthRead),
+			err:   "invalid type 3",
+		},
+		{
+			desc:  "NoWriteAuth",
+			attrs: NVTypeOrdinary.WithAttrs(AttrNVAuthRead),
+			err:   "no write authorization attributes are set",
+		},
+		{
+			desc:  "NoReadAuth",
+			attrs: NVTypeOrdinary.WithAttrs(AttrNVAuthWrite),
+			err:   "no read authorization attributes are set",
+		},
+		{
+			desc:  "Written",
+			attrs: NVTypeOrdinary.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead | AttrNVWritten),
+			err:   "attributes that can only be set by the TPM are set",
+		},
+		{
+			desc:  "CounterClearStClear",
+			attrs: NVTypeCounter.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead | AttrNVClearStClear),
+			err:   "clearStClear is set for an index of type 1",
+		},
+		{
+			desc:  "PinFailWithoutNoDA",
+			attrs: NVTypePinFail.WithAttrs(AttrNVOwnerWrite | AttrNVAuthRead),
+			err:   "noDA is not set for a PIN fail index",
+		},
+		{
+			desc:  "PolicyDelete",
+			attrs: NVTypeOrdinary.WithAttrs(AttrNVAuthWrite | AttrNVAuthRead | AttrNVPolicyDelete),
+			err:   "policyDelete is set without platformCreate",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			err := data.attrs.Validate()
+			switch {
+			case data.err == "" && err != nil:
+				t.Errorf("Validate failed: %v", err)
+			case data.err != "" && (err == nil || err.Error() != data.err):
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
 func TestPCRSelectionListNormalize(t *testing.T) {
 	orig := PCRSelectionList{
 		{Hash: HashAlgorithmSHA256, Select: []int{2, 1, 5}},
@@ -475,6 +779,55 @@ func TestPCRSelectionListNormalize(t *testing.T) {
 	}
 }
 
+func TestNewPCRSelectionList(t *testing.T) {
+	l := NewPCRSelectionList(map[HashAlgorithmId][]int{
+		HashAlgorithmSHA256: {7, 0, 4, 7},
+		HashAlgorithmSHA1:   {23, 1},
+		HashAlgorithmSHA384: nil})
+	expected := PCRSelectionList{
+		{Hash: HashAlgorithmSHA1, Select: []int{1, 23}},
+		{Hash: HashAlgorithmSHA256, Select: []int{0, 4, 7}},
+		{Hash: HashAlgorithmSHA384, Select: []int{}}}
+	if !reflect.DeepEqual(l, expected) {
+		t.Errorf("Unexpected result: %v", l)
+	}
+
+	// The same selection built by merging individual selections in a different order should marshal identically.
+	merged := PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{4}}}.
+		Merge(PCRSelectionList{{Hash: HashAlgorithmSHA1, Select: []int{23}}, {Hash: HashAlgorithmSHA256, Select: []int{7, 0}}}).
+		Merge(PCRSelectionList{{Hash: HashAlgorithmSHA1, Select: []int{1}}}).
+		Sort()
+	merged = append(merged, PCRSelection{Hash: HashAlgorithmSHA384})
+
+	b1, err := mu.MarshalToBytes(l)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	b2, err := mu.MarshalToBytes(merged)
+	if err != nil {
+		t.Fatalf("MarshalToBytes failed: %v", err)
+	}
+	if !bytes.Equal(b1, b2) {
+		t.Errorf("Selections should marshal identically (%x != %x)", b1, b2)
+	}
+
+	for _, data := range []struct {
+		alg      HashAlgorithmId
+		pcr      int
+		expected bool
+	}{
+		{HashAlgorithmSHA1, 23, true},
+		{HashAlgorithmSHA1, 0, false},
+		{HashAlgorithmSHA256, 4, true},
+		{HashAlgorithmSHA384, 0, false},
+		{HashAlgorithmSHA512, 7, false},
+	} {
+		if l.Contains(data.alg, data.pcr) != data.expected {
+			t.Errorf("Unexpected result for PCR%d/%v", data.pcr, data.alg)
+		}
+	}
+}
+
 func TestPCRSelectionListSort(t *testing.T) {
 	orig := PCRSelectionList{
 		{Hash: HashAlgorithmSHA384, Select: []int{5, 3, 8}},
@@ -767,3 +1120,74 @@ func TestPCRSelectionListSubtract(t *testing.T) {
 		})
 	}
 }
+
+func TestClockAdjust(t *testing.T) {
+	for _, data := range []struct {
+		desc     string
+		in       ClockAdjust
+		expected []byte
+	}{
+		{desc: "CoarseSlower", in: ClockCoarseSlower, expected: []byte{0xfd}},
+		{desc: "MediumSlower", in: ClockMediumSlower, expected: []byte{0xfe}},
+		{desc: "FineSlower", in: ClockFineSlower, expected: []byte{0xff}},
+		{desc: "NoChange", in: ClockNoChange, expected: []byte{0x00}},
+		{desc: "FineFaster", in: ClockFineFaster, expected: []byte{0x01}},
+		{desc: "MediumFaster", in: ClockMediumFaster, expected: []byte{0x02}},
+		{desc: "CoarseFaster", in: ClockCoarseFaster, expected: []byte{0x03}},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			out, err := mu.MarshalToBytes(data.in)
+			if err != nil {
+				t.Fatalf("MarshalToBytes failed: %v", err)
+			}
+			if !bytes.Equal(out, data.expected) {
+				t.Errorf("Unexpected marshalled value %x", out)
+			}
+
+			var a ClockAdjust
+			if _, err := mu.UnmarshalFromBytes(out, &a); err != nil {
+				t.Fatalf("UnmarshalFromBytes failed: %v", err)
+			}
+			if a != data.in {
+				t.Errorf("Unexpected unmarshalled value %d", a)
+			}
+		})
+	}
+}
+
+func TestTimeInfoIsAfter(t *testing.T) {
+	newTimeInfo := func(clock uint64, resetCount, restartCount uint32) *TimeInfo {
+		return &TimeInfo{ClockInfo: ClockInfo{Clock: clock, ResetCount: resetCount, RestartCount: restartCount, Safe: true}}
+	}
+
+	for _, data := range []struct {
+		desc     string
+		a, b     *TimeInfo
+		expected bool
+	}{
+		{desc: "ClockAfter", a: newTimeInfo(2000, 1, 1), b: newTimeInfo(1000, 1, 1), expected: true},
+		{desc: "ClockBefore", a: newTimeInfo(1000, 1, 1), b: newTimeInfo(2000, 1, 1)},
+		{desc: "Equal", a: newTimeInfo(1000, 1, 1), b: newTimeInfo(1000, 1, 1)},
+		{desc: "ResetWithSmallerClock", a: newTimeInfo(500, 2, 0), b: newTimeInfo(1000, 1, 3), expected: true},
+		{desc: "BeforeReset", a: newTimeInfo(1000, 1, 3), b: newTimeInfo(500, 2, 0)},
+		{desc: "RestartWithSmallerClock", a: newTimeInfo(500, 1, 2), b: newTimeInfo(1000, 1, 1), expected: true},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			if data.a.IsAfter(data.b) != data.expected {
+				t.Errorf("Unexpected result")
+			}
+		})
+	}
+}
+
+func TestTimeInfoClockIfSafe(t *testing.T) {
+	info := TimeInfo{Time: 100, ClockInfo: ClockInfo{Clock: 5000, ResetCount: 2, Safe: true}}
+	if ms, safe := info.ClockIfSafe(); ms != 5000 || !safe {
+		t.Errorf("Unexpected result: %d, %v", ms, safe)
+	}
+
+	info.ClockInfo.Safe = false
+	if _, safe := info.ClockIfSafe(); safe {
+		t.Errorf("Clock should not be safe")
+	}
+}
@@ -5,13 +5,24 @@
 package tpm2
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
 	"encoding/binary"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"hash"
+	"io"
+	"math/big"
 	"sort"
 
 	"github.com/canonical/go-tpm2/mu"
+
+	"golang.org/x/xerrors"
 )
 
 // ComputeCpHash computes a command parameter digest from the specified command code and provided command parameters, using the
@@ -84,6 +95,8 @@ func ComputePCRDigest(alg HashAlgorithmId, pcrs PCRSelectionList, values PCRValu
 	return h.Sum(nil), nil
 }
 
+// ComputePCRDigestSimple computes a digest using the specified algorithm from all of the provided PCR values, and returns the digest
+// along with the corresponding PCR selection.
 func ComputePCRDigestSimple(alg HashAlgorithmId, values PCRValues) (PCRSelectionList, Digest, error) {
 	if !alg.Supported() {
 		return nil, nil, fmt.Errorf("unknown digest algorithm %v", alg)
@@ -100,7 +113,9 @@ func ComputePCRDigestSimple(alg HashAlgorithmId, values PCRValues) (PCRSelection
 
 // TrialAuthPolicy provides a mechanism for computing authorization policy digests without having to execute a trial authorization
 // policy session on the TPM. An advantage of this is that it is possible to compute digests for PolicySecret and PolicyNV assertions
-// without knowledge of the authorization value of the authorizing entities used for those commands.
+// without knowledge of the authorization value of the authorizing entities used for those commands. The computed digest can be
+// compared with the value returned from TPMContext.PolicyGetDigest for a policy session to which the same assertions have been
+// applied.
 type TrialAuthPolicy struct {
 	alg    HashAlgorithmId
 	digest Digest
@@ -148,6 +163,7 @@ func (p *TrialAuthPolicy) GetDigest() Digest {
 	return p.digest
 }
 
+// SetDigest sets the current digest to d, which must have the same length as the digest algorithm of this policy.
 func (p *TrialAuthPolicy) SetDigest(d Digest) error {
 	if len(d) != p.alg.Size() {
 		return errors.New("Invalid digest length")
@@ -156,18 +172,23 @@ func (p *TrialAuthPolicy) SetDigest(d Digest) error {
 	return nil
 }
 
+// Reset clears the current digest.
 func (p *TrialAuthPolicy) Reset() {
 	p.reset()
 }
 
+// PolicySigned computes a TPM2_PolicySigned assertion for the key with the name authName and the specified policyRef.
 func (p *TrialAuthPolicy) PolicySigned(authName Name, policyRef Nonce) {
 	p.update(CommandPolicySigned, authName, policyRef)
 }
 
+// PolicySecret computes a TPM2_PolicySecret assertion for the entity with the name authName and the specified policyRef.
 func (p *TrialAuthPolicy) PolicySecret(authName Name, policyRef Nonce) {
 	p.update(CommandPolicySecret, authName, policyRef)
 }
 
+// PolicyOR computes a TPM2_PolicyOR assertion for the digests in pHashList. An error will be returned if there are fewer than 2
+// or more than 8 digests.
 func (p *TrialAuthPolicy) PolicyOR(pHashList DigestList) error {
 	if len(pHashList) < 2 || len(pHashList) > 8 {
 		return errors.New("invalid number of digests")
@@ -183,6 +204,9 @@ func (p *TrialAuthPolicy) PolicyOR(pHashList DigestList) error {
 	return nil
 }
 
+// PolicyPCR computes a TPM2_PolicyPCR assertion for the PCRs selected by pcrs. The pcrDigest argument is the expected digest of the
+// selected PCR values, which should be computed with ComputePCRDigest using the same digest algorithm as this policy. Unlike
+// TPMContext.PolicyPCR, this must be provided, as there is no TPM from which to read the current PCR values.
 func (p *TrialAuthPolicy) PolicyPCR(pcrDigest Digest, pcrs PCRSelectionList) {
 	h, end := p.beginUpdateForCommand(CommandPolicyPCR)
 	if _, err := mu.MarshalToWriter(h, pcrs); err != nil {
@@ -192,6 +216,18 @@ func (p *TrialAuthPolicy) PolicyPCR(pcrDigest Digest, pcrs PCRSelectionList) {
 	end()
 }
 
+// PolicyPCRValues computes a TPM2_PolicyPCR assertion for all of the provided PCR values. The PCR digest is computed with the digest
+// algorithm of this policy, so this can be used to compute a policy for a set of expected PCR values before they are present on a
+// TPM.
+func (p *TrialAuthPolicy) PolicyPCRValues(values PCRValues) {
+	pcrs, digest, err := ComputePCRDigestSimple(p.alg, values)
+	if err != nil {
+		panic(fmt.Sprintf("cannot compute PCR digest: %v", err))
+	}
+	p.PolicyPCR(digest, pcrs)
+}
+
+// PolicyNV computes a TPM2_PolicyNV assertion for the NV index with the name nvIndexName.
 func (p *TrialAuthPolicy) PolicyNV(nvIndexName Name, operandB Operand, offset uint16, operation ArithmeticOp) {
 	h := p.alg.NewHash()
 	h.Write(operandB)
@@ -206,6 +242,8 @@ func (p *TrialAuthPolicy) PolicyNV(nvIndexName Name, operandB Operand, offset ui
 	end()
 }
 
+// PolicyCounterTimer computes a TPM2_PolicyCounterTimer assertion. The arguments have the same meaning as they do for
+// TPMContext.PolicyCounterTimer.
 func (p *TrialAuthPolicy) PolicyCounterTimer(operandB Operand, offset uint16, operation ArithmeticOp) {
 	h := p.alg.NewHash()
 	h.Write(operandB)
@@ -219,24 +257,29 @@ func (p *TrialAuthPolicy) PolicyCounterTimer(operandB Operand, offset uint16, op
 	end()
 }
 
+// PolicyCommandCode computes a TPM2_PolicyCommandCode assertion for the command code code.
 func (p *TrialAuthPolicy) PolicyCommandCode(code CommandCode) {
 	h, end := p.beginUpdateForCommand(CommandPolicyCommandCode)
 	binary.Write(h, binary.BigEndian, code)
 	end()
 }
 
+// PolicyCpHash computes a TPM2_PolicyCpHash assertion for the command parameter digest cpHashA.
 func (p *TrialAuthPolicy) PolicyCpHash(cpHashA Digest) {
 	h, end := p.beginUpdateForCommand(CommandPolicyCpHash)
 	h.Write(cpHashA)
 	end()
 }
 
+// PolicyNameHash computes a TPM2_PolicyNameHash assertion for the digest of handle names nameHash.
 func (p *TrialAuthPolicy) PolicyNameHash(nameHash Digest) {
 	h, end := p.beginUpdateForCommand(CommandPolicyNameHash)
 	h.Write(nameHash)
 	end()
 }
 
+// PolicyDuplicationSelect computes a TPM2_PolicyDuplicationSelect assertion. The name of the object is only included if
+// includeObject is true.
 func (p *TrialAuthPolicy) PolicyDuplicationSelect(objectName, newParentName Name, includeObject bool) {
 	h, end := p.beginUpdateForCommand(CommandPolicyDuplicationSelect)
 	if includeObject {
@@ -247,15 +290,21 @@ func (p *TrialAuthPolicy) PolicyDuplicationSelect(objectName, newParentName Name
 	end()
 }
 
+// PolicyAuthorize computes a TPM2_PolicyAuthorize assertion for the key with the name keySign and the specified policyRef. As with
+// the TPM, the current digest is cleared before being extended, so the result doesn't depend on any previous assertions. The
+// approved policy can be computed separately with another TrialAuthPolicy and signed by the authority associated with keySign.
 func (p *TrialAuthPolicy) PolicyAuthorize(policyRef Nonce, keySign Name) {
+	p.reset()
 	p.update(CommandPolicyAuthorize, keySign, policyRef)
 }
 
+// PolicyAuthValue computes a TPM2_PolicyAuthValue assertion.
 func (p *TrialAuthPolicy) PolicyAuthValue() {
 	_, end := p.beginUpdateForCommand(CommandPolicyAuthValue)
 	end()
 }
 
+// PolicyPassword computes a TPM2_PolicyPassword assertion. This produces the same digest as PolicyAuthValue.
 func (p *TrialAuthPolicy) PolicyPassword() {
 	// This extends the same value as PolicyAuthValue - see section 23.18 of part 3 of the "TPM 2.0 Library
 	// Specification"
@@ -263,8 +312,658 @@ func (p *TrialAuthPolicy) PolicyPassword() {
 	end()
 }
 
+// PolicyNvWritten computes a TPM2_PolicyNvWritten assertion for the value of writtenSet.
 func (p *TrialAuthPolicy) PolicyNvWritten(writtenSet bool) {
 	h, end := p.beginUpdateForCommand(CommandPolicyNvWritten)
 	binary.Write(h, binary.BigEndian, writtenSet)
 	end()
 }
+
+// PolicyTemplate computes a TPM2_PolicyTemplate assertion for the digest of the public template templateHash.
+func (p *TrialAuthPolicy) PolicyTemplate(templateHash Digest) {
+	h, end := p.beginUpdateForCommand(CommandPolicyTemplate)
+	h.Write(templateHash)
+	end()
+}
+
+// ComputeStandardEKAuthPolicy computes the authorization policy digest used by endorsement keys created from the standard templates
+// defined in the TCG EK Credential Profile, using the specified digest algorithm. This policy consists of a single TPM2_PolicySecret
+// assertion with the endorsement hierarchy as the authorizing entity. The result can be compared with the AuthPolicy field of an
+// endorsement key's public area.
+func ComputeStandardEKAuthPolicy(alg HashAlgorithmId) (Digest, error) {
+	trial, err := ComputeAuthPolicy(alg)
+	if err != nil {
+		return nil, err
+	}
+	trial.PolicySecret(makeDummyContext(HandleEndorsement).Name(), nil)
+	return trial.GetDigest(), nil
+}
+
+// NewSymCipherTemplate returns a template for creating a symmetric cipher object (an object with the type ObjectTypeSymCipher) with
+// the specified algorithm, key size and block cipher mode, suitable for passing to TPMContext.Create or TPMContext.CreateLoaded. The
+// returned object can be used with TPMContext.EncryptDecrypt and TPMContext.EncryptDecrypt2.
+//
+// The template has both the AttrDecrypt and AttrSign attributes set so that the object can be used for both decryption and
+// encryption, and the AttrUserWithAuth attribute set so that the object can be used with its authorization value. The name algorithm
+// is HashAlgorithmSHA256. A mode of SymModeNull permits the caller to select the mode for each call to TPMContext.EncryptDecrypt.
+func NewSymCipherTemplate(alg SymObjectAlgorithmId, keyBits uint16, mode SymModeId) *Public {
+	return &Public{
+		Type:    ObjectTypeSymCipher,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrDecrypt | AttrSign,
+		Params: PublicParamsU{
+			Data: &SymCipherParams{
+				Sym: SymDefObject{
+					Algorithm: alg,
+					KeyBits:   SymKeyBitsU{Data: keyBits},
+					Mode:      SymModeU{Data: mode}}}}}
+}
+
+// NewRSAStorageKeyTemplate returns a template for creating a RSA 2048-bit storage key, suitable for passing to
+// TPMContext.CreatePrimary in order to create a storage root key (SRK) in the storage hierarchy. The template matches the RSA SRK
+// template from the TCG TPM v2.0 Provisioning Guidance - it is a restricted decryption key with a 128-bit AES symmetric algorithm
+// in CFB mode, the name algorithm is HashAlgorithmSHA256 and the AttrUserWithAuth and AttrNoDA attributes are set.
+func NewRSAStorageKeyTemplate() *Public {
+	return &Public{
+		Type:    ObjectTypeRSA,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrUserWithAuth | AttrNoDA | AttrRestricted | AttrDecrypt,
+		Params: PublicParamsU{
+			Data: &RSAParams{
+				Symmetric: SymDefObject{
+					Algorithm: SymObjectAlgorithmAES,
+					KeyBits:   SymKeyBitsU{Data: uint16(128)},
+					Mode:      SymModeU{Data: SymModeCFB}},
+				Scheme:   RSAScheme{Scheme: RSASchemeNull},
+				KeyBits:  2048,
+				Exponent: 0}}}
+}
+
+// NewRSAEKTemplate returns a template for creating a RSA 2048-bit endorsement key, suitable for passing to TPMContext.CreatePrimary
+// in order to create an endorsement key in the endorsement hierarchy. The template matches the default RSA template (template L-1)
+// from the TCG EK Credential Profile, and so the public area of the created key should match the one in the endorsement certificate
+// issued by the TPM manufacturer.
+//
+// The created key can only be used with a policy session that satisfies the policy computed by ComputeStandardEKAuthPolicy.
+func NewRSAEKTemplate() *Public {
+	return &Public{
+		Type:    ObjectTypeRSA,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent | AttrSensitiveDataOrigin | AttrAdminWithPolicy | AttrRestricted | AttrDecrypt,
+		AuthPolicy: Digest{0x83, 0x71, 0x97, 0x67, 0x44, 0x84, 0xb3, 0xf8, 0x1a, 0x90, 0xcc, 0x8d, 0x46, 0xa5, 0xd7, 0x24, 0xfd, 0x52,
+			0xd7, 0x6e, 0x06, 0x52, 0x0b, 0x64, 0xf2, 0xa1, 0xda, 0x1b, 0x33, 0x14, 0x69, 0xaa},
+		Params: PublicParamsU{
+			Data: &RSAParams{
+				Symmetric: SymDefObject{
+					Algorithm: SymObjectAlgorithmAES,
+					KeyBits:   SymKeyBitsU{Data: uint16(128)},
+					Mode:      SymModeU{Data: SymModeCFB}},
+				Scheme:   RSAScheme{Scheme: RSASchemeNull},
+				KeyBits:  2048,
+				Exponent: 0}},
+		Unique: PublicIDU{Data: make(PublicKeyRSA, 256)}}
+}
+
+// PublicFromCryptoKey creates a public area from the supplied *rsa.PublicKey or *ecdsa.PublicKey, suitable for loading in to the TPM
+// with TPMContext.LoadExternal in order to use it with commands such as TPMContext.VerifySignature. The nameAlg argument specifies
+// the name algorithm of the returned public area.
+//
+// The scheme argument specifies an optional default scheme for the key. If it is nil or has a Scheme of AsymSchemeNull, the
+// returned public area will have both the AttrSign and AttrDecrypt attributes set. If it specifies a signing scheme, only the AttrSign
+// attribute will be set, and if it specifies an encryption or key exchange scheme, only the AttrDecrypt attribute will be set. An
+// error will be returned if the scheme is not valid for the type of key.
+//
+// The returned public area does not have the AttrFixedTPM or AttrFixedParent attributes set.
+func PublicFromCryptoKey(pub crypto.PublicKey, nameAlg HashAlgorithmId, scheme *AsymScheme) (*Public, error) {
+	if !nameAlg.Supported() {
+		return nil, makeInvalidArgError("nameAlg", fmt.Sprintf("unsupported digest algorithm %v", nameAlg))
+	}
+	if scheme == nil {
+		scheme = &AsymScheme{Scheme: AsymSchemeNull}
+	}
+
+	attrs := AttrUserWithAuth
+	switch scheme.Scheme {
+	case AsymSchemeNull:
+		attrs |= AttrSign | AttrDecrypt
+	case AsymSchemeRSASSA, AsymSchemeRSAPSS, AsymSchemeECDSA, AsymSchemeECDAA, AsymSchemeSM2, AsymSchemeECSCHNORR:
+		attrs |= AttrSign
+	case AsymSchemeRSAES, AsymSchemeOAEP, AsymSchemeECDH, AsymSchemeECMQV:
+		attrs |= AttrDecrypt
+	default:
+		return nil, makeInvalidArgError("scheme", fmt.Sprintf("unsupported scheme %v", scheme.Scheme))
+	}
+
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		switch scheme.Scheme {
+		case AsymSchemeNull, AsymSchemeRSASSA, AsymSchemeRSAPSS, AsymSchemeRSAES, AsymSchemeOAEP:
+		default:
+			return nil, makeInvalidArgError("scheme", fmt.Sprintf("invalid scheme %v for RSA key", scheme.Scheme))
+		}
+		return &Public{
+			Type:    ObjectTypeRSA,
+			NameAlg: nameAlg,
+			Attrs:   attrs,
+			Params: PublicParamsU{
+				Data: &RSAParams{
+					Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+					Scheme:    RSAScheme{Scheme: RSASchemeId(scheme.Scheme), Details: scheme.Details},
+					KeyBits:   uint16(p.N.BitLen()),
+					Exponent:  uint32(p.E)}},
+			Unique: PublicIDU{Data: PublicKeyRSA(p.N.Bytes())}}, nil
+	case *ecdsa.PublicKey:
+		switch scheme.Scheme {
+		case AsymSchemeRSASSA, AsymSchemeRSAPSS, AsymSchemeRSAES, AsymSchemeOAEP:
+			return nil, makeInvalidArgError("scheme", fmt.Sprintf("invalid scheme %v for ECC key", scheme.Scheme))
+		}
+		var curve ECCCurve
+		switch p.Curve {
+		case elliptic.P224():
+			curve = ECCCurveNIST_P224
+		case elliptic.P256():
+			curve = ECCCurveNIST_P256
+		case elliptic.P384():
+			curve = ECCCurveNIST_P384
+		case elliptic.P521():
+			curve = ECCCurveNIST_P521
+		default:
+			return nil, makeInvalidArgError("pub", "unsupported curve")
+		}
+		return &Public{
+			Type:    ObjectTypeECC,
+			NameAlg: nameAlg,
+			Attrs:   attrs,
+			Params: PublicParamsU{
+				Data: &ECCParams{
+					Symmetric: SymDefObject{Algorithm: SymObjectAlgorithmNull},
+					Scheme:    ECCScheme{Scheme: ECCSchemeId(scheme.Scheme), Details: scheme.Details},
+					CurveID:   curve,
+					KDF:       KDFScheme{Scheme: KDFAlgorithmNull}}},
+			Unique: PublicIDU{
+				Data: &ECCPoint{X: ECCParameter(p.X.Bytes()), Y: ECCParameter(p.Y.Bytes())}}}, nil
+	default:
+		return nil, makeInvalidArgError("pub", fmt.Sprintf("unsupported key type %T", pub))
+	}
+}
+
+// MarshalPublicToTPM2B marshals the public area p to the TPM2B_PUBLIC format, which consists of a 16-bit size field followed by the
+// TPMT_PUBLIC structure. This is the format used for the public area files produced by tpm2-tools (eg, by tpm2_create -u).
+func MarshalPublicToTPM2B(p *Public) ([]byte, error) {
+	if p == nil {
+		return nil, makeInvalidArgError("p", "nil value")
+	}
+	b, err := mu.MarshalToBytes(publicSized{p})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal public area: %v", err)
+	}
+	return b, nil
+}
+
+// UnmarshalPublicFromTPM2B unmarshals a public area from data in the TPM2B_PUBLIC format, such as the public area files produced by
+// tpm2-tools. The public area is unmarshalled without modification, so the name of the returned public area is the same as the name
+// that the TPM computes for the object. An error will be returned if the data contains trailing bytes.
+func UnmarshalPublicFromTPM2B(data []byte) (*Public, error) {
+	var p publicSized
+	if err := mu.UnmarshalFromBytesStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal public area: %v", err)
+	}
+	if p.Ptr == nil {
+		return nil, errors.New("empty public area")
+	}
+	return p.Ptr, nil
+}
+
+// MarshalPublicToDER marshals the public key associated with the public area p to the DER encoded X.509 SubjectPublicKeyInfo format,
+// which can be consumed by openssl and other tools. This only encodes the public key, so other properties of the public area (such
+// as the attributes, authorization policy and name algorithm) are lost. An error will be returned if p is not a RSA or ECC key.
+func MarshalPublicToDER(p *Public) ([]byte, error) {
+	if p == nil {
+		return nil, makeInvalidArgError("p", "nil value")
+	}
+	pub, err := p.Public()
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain public key: %v", err)
+	}
+	return x509.MarshalPKIXPublicKey(pub)
+}
+
+// UnmarshalPublicFromDER creates a public area for the public key contained in der, which must be in the DER encoded X.509
+// SubjectPublicKeyInfo format. As this format only contains the public key, the other properties of the public area are determined
+// by the nameAlg and scheme arguments in the same way as PublicFromCryptoKey.
+func UnmarshalPublicFromDER(der []byte, nameAlg HashAlgorithmId, scheme *AsymScheme) (*Public, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key: %v", err)
+	}
+	return PublicFromCryptoKey(pub, nameAlg, scheme)
+}
+
+// MarshalPublicToPEM behaves like MarshalPublicToDER, but returns the result as a PEM encoded block with the type "PUBLIC KEY".
+func MarshalPublicToPEM(p *Public) ([]byte, error) {
+	der, err := MarshalPublicToDER(p)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// UnmarshalPublicFromPEM behaves like UnmarshalPublicFromDER, but decodes the public key from the first PEM encoded block in data,
+// which must have the type "PUBLIC KEY".
+func UnmarshalPublicFromPEM(data []byte, nameAlg HashAlgorithmId, scheme *AsymScheme) (*Public, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("unexpected PEM block type %q", block.Type)
+	}
+	return UnmarshalPublicFromDER(block.Bytes, nameAlg, scheme)
+}
+
+// VerifyAttestationSignature verifies that sig is a valid signature of attest created by the private part of the key associated with
+// the public area pub. This can be used to verify the results of TPMContext.Quote, TPMContext.Certify and the other attestation
+// commands without access to a TPM. The attestation structure is marshalled to the TPM wire format and digested with the hash
+// algorithm of the signature, so it must be identical to the one that was signed.
+//
+// If the Magic field of attest is not TPMGeneratedValue, a *InvalidAttestMagicError error will be returned without verifying the
+// signature. The RSASSA, RSAPSS and ECDSA signature schemes are supported. If the signature is not valid, an error will be returned.
+//
+// This does not check that the signing key is a valid attestation key, or check any of the contents of attest other than the magic
+// value.
+func VerifyAttestationSignature(pub *Public, attest *Attest, sig *Signature) error {
+	if pub == nil {
+		return makeInvalidArgError("pub", "nil value")
+	}
+	if attest == nil {
+		return makeInvalidArgError("attest", "nil value")
+	}
+	if sig == nil {
+		return makeInvalidArgError("sig", "nil value")
+	}
+
+	if attest.Magic != TPMGeneratedValue {
+		return &InvalidAttestMagicError{Magic: attest.Magic}
+	}
+
+	key, err := cryptGetPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("cannot obtain public key: %v", err)
+	}
+
+	attestBytes, err := mu.MarshalToBytes(attest)
+	if err != nil {
+		return fmt.Errorf("cannot marshal attestation structure: %v", err)
+	}
+
+	sigHash := func(alg HashAlgorithmId) ([]byte, error) {
+		if !alg.Supported() {
+			return nil, fmt.Errorf("unsupported signature digest algorithm %v", alg)
+		}
+		h := alg.NewHash()
+		h.Write(attestBytes)
+		return h.Sum(nil), nil
+	}
+
+	switch sig.SigAlg {
+	case SigSchemeAlgRSASSA, SigSchemeAlgRSAPSS:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("invalid key type %v for signature scheme %v", pub.Type, sig.SigAlg)
+		}
+		s, ok := sig.Signature.Data.(*SignatureRSASSA)
+		if !ok {
+			if pss, isPss := sig.Signature.Data.(*SignatureRSAPSS); isPss {
+				s = (*SignatureRSASSA)(pss)
+			} else {
+				return errors.New("invalid RSA signature")
+			}
+		}
+		digest, err := sigHash(s.Hash)
+		if err != nil {
+			return err
+		}
+		if sig.SigAlg == SigSchemeAlgRSASSA {
+			err = rsa.VerifyPKCS1v15(rsaKey, s.Hash.GetHash(), digest, s.Sig)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, s.Hash.GetHash(), digest, s.Sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		}
+		if err != nil {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+	case SigSchemeAlgECDSA:
+		ecdsaKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("invalid key type %v for signature scheme %v", pub.Type, sig.SigAlg)
+		}
+		s, ok := sig.Signature.Data.(*SignatureECDSA)
+		if !ok {
+			return errors.New("invalid ECDSA signature")
+		}
+		digest, err := sigHash(s.Hash)
+		if err != nil {
+			return err
+		}
+		if !ecdsa.Verify(ecdsaKey, digest, new(big.Int).SetBytes(s.SignatureR), new(big.Int).SetBytes(s.SignatureS)) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported signature scheme %v", sig.SigAlg)
+	}
+
+	return nil
+}
+
+func hashAlgorithmIdFromCryptoHash(h crypto.Hash) HashAlgorithmId {
+	switch h {
+	case crypto.SHA1:
+		return HashAlgorithmSHA1
+	case crypto.SHA256:
+		return HashAlgorithmSHA256
+	case crypto.SHA384:
+		return HashAlgorithmSHA384
+	case crypto.SHA512:
+		return HashAlgorithmSHA512
+	default:
+		return HashAlgorithmNull
+	}
+}
+
+type tpmSigner struct {
+	tpm     *TPMContext
+	key     ResourceContext
+	scheme  SigSchemeId
+	session SessionContext
+	public  crypto.PublicKey
+}
+
+func (s *tpmSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *tpmSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hashAlg := hashAlgorithmIdFromCryptoHash(opts.HashFunc())
+	if hashAlg == HashAlgorithmNull {
+		return nil, fmt.Errorf("unsupported digest algorithm %v", opts.HashFunc())
+	}
+
+	scheme := s.scheme
+	if scheme == SigSchemeAlgNull {
+		switch s.public.(type) {
+		case *rsa.PublicKey:
+			scheme = SigSchemeAlgRSASSA
+			if _, isPss := opts.(*rsa.PSSOptions); isPss {
+				scheme = SigSchemeAlgRSAPSS
+			}
+		case *ecdsa.PublicKey:
+			scheme = SigSchemeAlgECDSA
+		}
+	}
+
+	if _, isPss := opts.(*rsa.PSSOptions); isPss != (scheme == SigSchemeAlgRSAPSS) {
+		return nil, fmt.Errorf("signature scheme %v is not compatible with the supplied options of type %T", scheme, opts)
+	}
+
+	var details interface{}
+	switch scheme {
+	case SigSchemeAlgRSASSA:
+		details = &SigSchemeRSASSA{HashAlg: hashAlg}
+	case SigSchemeAlgRSAPSS:
+		details = &SigSchemeRSAPSS{HashAlg: hashAlg}
+	case SigSchemeAlgECDSA:
+		details = &SigSchemeECDSA{HashAlg: hashAlg}
+	default:
+		return nil, fmt.Errorf("unsupported signature scheme %v", scheme)
+	}
+
+	sig, err := s.tpm.Sign(s.key, digest, &SigScheme{Scheme: scheme, Details: SigSchemeU{Data: details}}, nil, s.session)
+	if err != nil {
+		return nil, err
+	}
+
+	switch sig.SigAlg {
+	case SigSchemeAlgRSASSA, SigSchemeAlgRSAPSS:
+		switch d := sig.Signature.Data.(type) {
+		case *SignatureRSASSA:
+			return d.Sig, nil
+		case *SignatureRSAPSS:
+			return d.Sig, nil
+		}
+	case SigSchemeAlgECDSA:
+		if d, ok := sig.Signature.Data.(*SignatureECDSA); ok {
+			return asn1.Marshal(struct {
+				R, S *big.Int
+			}{new(big.Int).SetBytes(d.SignatureR), new(big.Int).SetBytes(d.SignatureS)})
+		}
+	}
+	return nil, fmt.Errorf("TPM returned an unexpected signature type %v", sig.SigAlg)
+}
+
+// NewTPMSigner returns a crypto.Signer that signs digests with the signing key associated with key by executing the TPM2_Sign
+// command. This allows keys that reside on the TPM to be used with packages such as crypto/tls and crypto/x509. The session
+// argument is used for authorization with the user auth role for key, and the authorization value of key must be set with
+// ResourceContext.SetAuthValue if it is used, or if session is nil.
+//
+// The signing scheme is selected by scheme. If scheme is nil or has a Scheme field of SigSchemeAlgNull, the scheme is chosen
+// based on the type of key and the options passed to the Sign method - RSA keys use RSASSA-PKCS1-v1_5, unless the options are
+// *rsa.PSSOptions in which case RSASSA-PSS is used, and ECC keys use ECDSA. The RSASSA, RSAPSS and ECDSA schemes are supported. In
+// all cases, the digest algorithm is the one specified by the options passed to the Sign method. If scheme specifies RSASSA-PSS,
+// the options passed to the Sign method must be *rsa.PSSOptions, and if scheme specifies any other scheme, the options must not be
+// *rsa.PSSOptions. The Sign method returns an error if this isn't the case.
+//
+// Signatures are returned in the forms expected by the standard library - RSA signatures are returned as is and ECDSA signatures
+// are returned as an ASN.1 DER encoded sequence. RSASSA-PSS signatures are created by the TPM with a salt length that is normally
+// equal to the digest size, and can be verified with rsa.PSSSaltLengthEqualsHash or rsa.PSSSaltLengthAuto.
+//
+// If the public area of key is not known, it will be read from the TPM with TPMContext.ReadPublic. An error will be returned if
+// the key is not a RSA or ECC key.
+func NewTPMSigner(t *TPMContext, key ResourceContext, scheme *SigScheme, session SessionContext) (crypto.Signer, error) {
+	if key == nil {
+		return nil, makeInvalidArgError("key", "nil value")
+	}
+
+	var public *Public
+	if o, isObject := unwrapHandleContext(key).(*objectContext); isObject && o.public() != nil {
+		public = o.public()
+	} else {
+		var err error
+		public, _, _, err = t.ReadPublic(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pub, err := cryptGetPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain public key: %v", err)
+	}
+
+	schemeId := SigSchemeAlgNull
+	if scheme != nil {
+		schemeId = scheme.Scheme
+	}
+
+	return &tpmSigner{tpm: t, key: key, scheme: schemeId, session: session, public: pub}, nil
+}
+
+// PolicySecretTicketCache is a helper for satisfying TPM2_PolicySecret assertions in multiple policy sessions whilst only proving
+// knowledge of the authorization value of the authorizing entity once. The first time it is used, it executes TPMContext.PolicySecret
+// and requests a ticket. On subsequent uses, it executes TPMContext.PolicyTicket with the cached ticket instead until the ticket
+// expires, at which point it executes TPMContext.PolicySecret again.
+type PolicySecretTicketCache struct {
+	authContext ResourceContext
+	cpHashA     Digest
+	policyRef   Nonce
+	expiration  int32
+
+	timeout Timeout
+	ticket  *TkAuth
+}
+
+// NewPolicySecretTicketCache creates a new PolicySecretTicketCache for TPM2_PolicySecret assertions with the entity associated with
+// authContext. The cpHashA, policyRef and expiration arguments have the same meaning as they do for TPMContext.PolicySecret, except
+// that the absolute value of expiration is used (so that a ticket is always requested). As tickets are only produced for
+// authorizations that expire, expiration must not be zero.
+func NewPolicySecretTicketCache(authContext ResourceContext, cpHashA Digest, policyRef Nonce, expiration int32) (*PolicySecretTicketCache, error) {
+	if authContext == nil {
+		return nil, makeInvalidArgError("authContext", "nil value")
+	}
+	if expiration == 0 {
+		return nil, makeInvalidArgError("expiration", "must not be zero")
+	}
+	if expiration > 0 {
+		expiration = -expiration
+	}
+	return &PolicySecretTicketCache{authContext: authContext, cpHashA: cpHashA, policyRef: policyRef, expiration: expiration}, nil
+}
+
+// ticketExpired determines whether the cached ticket has expired by comparing its timeout against the current value of time.
+func (c *PolicySecretTicketCache) ticketExpired(tpm *TPMContext, sessions ...SessionContext) (bool, error) {
+	if c.ticket == nil {
+		return true, nil
+	}
+	if len(c.timeout) != binary.Size(uint64(0)) {
+		// Let the TPM decide.
+		return false, nil
+	}
+	currentTime, err := tpm.ReadClock(sessions...)
+	if err != nil {
+		return false, err
+	}
+	return currentTime.Time >= binary.BigEndian.Uint64(c.timeout), nil
+}
+
+// Run satisfies a TPM2_PolicySecret assertion for the policy session associated with policySession. If there is a cached ticket that
+// hasn't expired, this executes TPMContext.PolicyTicket. If there isn't a valid ticket, or the TPM rejects the ticket (eg, because
+// it has expired or there has been a TPM reset or restart since it was created), this executes TPMContext.PolicySecret and caches
+// the returned ticket. In this case, authorization with the user auth role for the entity associated with this cache is required,
+// with session based authorization provided via authContextAuthSession.
+//
+// Policy sessions can only be used with TPMContext.PolicyTicket if they are not trial sessions. A trial session will always result
+// in TPMContext.PolicySecret being executed, and doesn't update the cached ticket.
+func (c *PolicySecretTicketCache) Run(tpm *TPMContext, policySession SessionContext, authContextAuthSession SessionContext, sessions ...SessionContext) error {
+	expired, err := c.ticketExpired(tpm, sessions...)
+	if err != nil {
+		return err
+	}
+	if !expired {
+		err := tpm.PolicyTicket(policySession, c.timeout, c.cpHashA, c.policyRef, c.authContext.Name(), c.ticket, sessions...)
+		switch {
+		case err == nil:
+			return nil
+		case IsTPMParameterError(err, ErrorExpired, CommandPolicyTicket, AnyParameterIndex):
+		case IsTPMParameterError(err, ErrorTicket, CommandPolicyTicket, AnyParameterIndex):
+		case IsTPMHandleError(err, ErrorAttributes, CommandPolicyTicket, 1):
+			// Trial session
+		default:
+			return err
+		}
+	}
+
+	timeout, ticket, err := tpm.PolicySecret(c.authContext, policySession, c.cpHashA, c.policyRef, c.expiration, authContextAuthSession,
+		sessions...)
+	if err != nil {
+		return err
+	}
+	if ticket == nil || ticket.Hierarchy == HandleNull {
+		// A NULL ticket is produced for a trial session.
+		return nil
+	}
+	c.timeout = timeout
+	c.ticket = ticket
+	return nil
+}
+
+// computeSealedObjectPolicy computes the authorization policy for a sealed data object created by TPMContext.SealData.
+func computeSealedObjectPolicy(alg HashAlgorithmId, pcrs PCRSelectionList, values PCRValues, auth []byte) (Digest, error) {
+	pcrDigest, err := ComputePCRDigest(alg, pcrs, values)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute PCR digest: %v", err)
+	}
+
+	trial, _ := ComputeAuthPolicy(alg)
+	trial.PolicyPCR(pcrDigest, pcrs)
+	if len(auth) > 0 {
+		trial.PolicyAuthValue()
+	}
+	return trial.GetDigest(), nil
+}
+
+// SealData creates a sealed data object containing secret as a child of the storage key associated with parent, by executing the
+// TPM2_Create command. The command requires authorization with the user auth role for parent, and the authorization value of parent
+// must be set with ResourceContext.SetAuthValue if it has one. The returned private and public areas can be passed to
+// TPMContext.UnsealData in order to recover secret.
+//
+// If pcrs is not empty, the sealed object is bound to the current values of the selected PCRs. These are read from the TPM with
+// TPMContext.PCRRead and used to compute an authorization policy containing a TPM2_PolicyPCR assertion, and a TPM2_PolicyAuthValue
+// assertion if auth is not empty. In this case, the object can only be unsealed with a policy session, and its authorization value
+// can't be used directly.
+//
+// If pcrs is empty, the object can be unsealed by providing its authorization value, which is set to auth. An empty auth is
+// permitted.
+func (t *TPMContext) SealData(parent ResourceContext, secret []byte, pcrs PCRSelectionList, auth []byte) (Private, *Public, error) {
+	template := Public{
+		Type:    ObjectTypeKeyedHash,
+		NameAlg: HashAlgorithmSHA256,
+		Attrs:   AttrFixedTPM | AttrFixedParent,
+		Params:  PublicParamsU{Data: &KeyedHashParams{Scheme: KeyedHashScheme{Scheme: KeyedHashSchemeNull}}}}
+
+	if pcrs.IsEmpty() {
+		template.Attrs |= AttrUserWithAuth
+	} else {
+		_, values, err := t.PCRRead(pcrs)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("cannot read PCR values: %w", err)
+		}
+		template.AuthPolicy, err = computeSealedObjectPolicy(template.NameAlg, pcrs, values, auth)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	priv, pub, _, _, _, err := t.Create(parent, &SensitiveCreate{UserAuth: auth, Data: secret}, &template, nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, pub, nil
+}
+
+// UnsealData loads the sealed data object created by TPMContext.SealData with the specified private and public areas in to the TPM
+// as a child of the storage key associated with parent, and then recovers its secret data by executing the TPM2_Unseal command.
+// The loaded object is flushed before this function returns. Loading the object requires authorization with the user auth role for
+// parent, and the authorization value of parent must be set with ResourceContext.SetAuthValue if it has one.
+//
+// The pcrs and auth arguments must be the same as those passed to TPMContext.SealData. If pcrs is not empty, a policy session is
+// used to satisfy the object's authorization policy. If the current values of the selected PCRs are different to those at the time
+// that the object was sealed, a *TPMSessionError error with an error code of ErrorPolicyFail will be returned for session index 1,
+// which can be tested for with IsTPMSessionError. If the authorization value is incorrect, a *TPMSessionError error with an error
+// code of ErrorAuthFail will be returned for session index 1.
+func (t *TPMContext) UnsealData(parent ResourceContext, private Private, public *Public, pcrs PCRSelectionList, auth []byte) ([]byte, error) {
+	item, err := t.Load(parent, private, public, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot load sealed object: %w", err)
+	}
+	defer t.FlushContext(item)
+	item.SetAuthValue(auth)
+
+	if pcrs.IsEmpty() {
+		return t.Unseal(item, nil)
+	}
+
+	session, err := t.StartAuthSession(nil, nil, SessionTypePolicy, nil, public.NameAlg)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot start policy session: %w", err)
+	}
+	defer t.FlushContext(session)
+
+	if err := t.PolicyPCR(session, nil, pcrs); err != nil {
+		return nil, xerrors.Errorf("cannot execute PCR assertion: %w", err)
+	}
+	if len(auth) > 0 {
+		if err := t.PolicyAuthValue(session); err != nil {
+			return nil, xerrors.Errorf("cannot execute auth value assertion: %w", err)
+		}
+	}
+
+	return t.Unseal(item, session.WithAttrs(AttrContinueSession))
+}
@@ -2,9 +2,18 @@ package tpm2_test
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"encoding/asn1"
 	"encoding/binary"
 	"io"
+	"math/big"
+	"reflect"
 	"testing"
 
 	. "github.com/canonical/go-tpm2"
@@ -533,6 +542,60 @@ func TestTrialPolicyPCR(t *testing.T) {
 	}
 }
 
+func TestTrialPolicyPCRValues(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	for _, data := range []struct {
+		desc string
+		alg  HashAlgorithmId
+		pcrs PCRSelectionList
+	}{
+		{
+			desc: "SHA256",
+			alg:  HashAlgorithmSHA256,
+			pcrs: PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{7, 8}}},
+		},
+		{
+			desc: "SHA1",
+			alg:  HashAlgorithmSHA1,
+			pcrs: PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{4, 7}}},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			_, values, err := tpm.PCRRead(data.pcrs)
+			if err != nil {
+				t.Fatalf("PCRRead failed: %v", err)
+			}
+
+			sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, data.alg)
+			if err != nil {
+				t.Fatalf("StartAuthSession failed: %v", err)
+			}
+			defer flushContext(t, tpm, sessionContext)
+
+			if err := tpm.PolicyPCR(sessionContext, nil, data.pcrs); err != nil {
+				t.Fatalf("PolicyPCR failed: %v", err)
+			}
+
+			trial, err := ComputeAuthPolicy(data.alg)
+			if err != nil {
+				t.Fatalf("ComputeAuthPolicy failed: %v", err)
+			}
+			trial.PolicyPCRValues(values)
+
+			tpmDigest, err := tpm.PolicyGetDigest(sessionContext)
+			if err != nil {
+				t.Fatalf("PolicyGetDigest failed: %v", err)
+			}
+
+			if !bytes.Equal(tpmDigest, trial.GetDigest()) {
+				t.Errorf("Unexpected digest")
+			}
+		})
+	}
+}
+
 func TestTrialPolicyNV(t *testing.T) {
 	tpm := openTPMForTesting(t, testCapabilityOwnerPersist)
 	defer closeTPM(t, tpm)
@@ -974,6 +1037,27 @@ func TestTrialPolicyAuthorize(t *testing.T) {
 	}
 }
 
+func TestTrialPolicyAuthorizeResetsDigest(t *testing.T) {
+	keySign, _ := mu.MarshalToBytes(HashAlgorithmSHA256, mu.RawBytes(make([]byte, 32)))
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicyCommandCode(CommandUnseal)
+	trial.PolicyAuthValue()
+	trial.PolicyAuthorize(Nonce("foo"), keySign)
+
+	h := sha256.New()
+	h.Write(make([]byte, 32))
+	mu.MarshalToWriter(h, CommandPolicyAuthorize, mu.RawBytes(keySign))
+	digest := h.Sum(nil)
+	h = sha256.New()
+	h.Write(digest)
+	h.Write([]byte("foo"))
+
+	if !bytes.Equal(trial.GetDigest(), h.Sum(nil)) {
+		t.Errorf("Unexpected digest: %x", trial.GetDigest())
+	}
+}
+
 func TestTrialPolicyAuthValue(t *testing.T) {
 	tpm := openTPMForTesting(t, 0)
 	defer closeTPM(t, tpm)
@@ -1119,3 +1203,894 @@ func TestTrialPolicyNvWritten(t *testing.T) {
 		})
 	}
 }
+
+func TestTrialPolicyTemplate(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	for _, data := range []struct {
+		desc string
+		alg  HashAlgorithmId
+	}{
+		{
+			desc: "SHA256",
+			alg:  HashAlgorithmSHA256,
+		},
+		{
+			desc: "SHA1",
+			alg:  HashAlgorithmSHA1,
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			template, _ := mu.MarshalToBytes(NewRSAStorageKeyTemplate())
+			h := data.alg.NewHash()
+			h.Write(template)
+			templateHash := h.Sum(nil)
+
+			sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypeTrial, nil, data.alg)
+			if err != nil {
+				t.Fatalf("StartAuthSession failed: %v", err)
+			}
+			defer flushContext(t, tpm, sessionContext)
+
+			if err := tpm.PolicyTemplate(sessionContext, templateHash); err != nil {
+				t.Fatalf("PolicyTemplate failed: %v", err)
+			}
+
+			trial, err := ComputeAuthPolicy(data.alg)
+			if err != nil {
+				t.Fatalf("ComputeAuthPolicy failed: %v", err)
+			}
+			trial.PolicyTemplate(templateHash)
+
+			tpmDigest, err := tpm.PolicyGetDigest(sessionContext)
+			if err != nil {
+				t.Fatalf("PolicyGetDigest failed: %v", err)
+			}
+
+			if !bytes.Equal(tpmDigest, trial.GetDigest()) {
+				t.Errorf("Unexpected digest")
+			}
+		})
+	}
+}
+
+func TestTrialPolicyAuthValueAndPCR(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	for _, data := range []struct {
+		desc string
+		alg  HashAlgorithmId
+		pcrs PCRSelectionList
+	}{
+		{
+			desc: "SHA256",
+			alg:  HashAlgorithmSHA256,
+			pcrs: PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{7}}},
+		},
+		{
+			desc: "SHA1",
+			alg:  HashAlgorithmSHA1,
+			pcrs: PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{4, 7}}},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, data.alg)
+			if err != nil {
+				t.Fatalf("StartAuthSession failed: %v", err)
+			}
+			defer flushContext(t, tpm, sessionContext)
+
+			if err := tpm.PolicyAuthValue(sessionContext); err != nil {
+				t.Fatalf("PolicyAuthValue failed: %v", err)
+			}
+			if err := tpm.PolicyPCR(sessionContext, nil, data.pcrs); err != nil {
+				t.Fatalf("PolicyPCR failed: %v", err)
+			}
+
+			trial, err := ComputeAuthPolicy(data.alg)
+			if err != nil {
+				t.Fatalf("ComputeAuthPolicy failed: %v", err)
+			}
+			trial.PolicyAuthValue()
+			trial.PolicyPCR(computePCRDigestFromTPM(t, tpm, data.alg, data.pcrs), data.pcrs)
+
+			tpmDigest, err := tpm.PolicyGetDigest(sessionContext)
+			if err != nil {
+				t.Fatalf("PolicyGetDigest failed: %v", err)
+			}
+
+			if !bytes.Equal(tpmDigest, trial.GetDigest()) {
+				t.Errorf("Unexpected digest")
+			}
+		})
+	}
+}
+
+func TestComputeStandardEKAuthPolicy(t *testing.T) {
+	for _, data := range []struct {
+		desc     string
+		alg      HashAlgorithmId
+		expected Digest
+	}{
+		{
+			desc: "SHA1",
+			alg:  HashAlgorithmSHA1,
+			expected: Digest{0xa7, 0x21, 0x41, 0x1a, 0x81, 0x9a, 0x07, 0xeb, 0xce, 0xe9, 0xc6, 0xf1, 0xb1, 0x9d, 0xa3, 0x85, 0xce, 0x16,
+				0x59, 0x10},
+		},
+		{
+			desc: "SHA256",
+			alg:  HashAlgorithmSHA256,
+			expected: Digest{0x83, 0x71, 0x97, 0x67, 0x44, 0x84, 0xb3, 0xf8, 0x1a, 0x90, 0xcc, 0x8d, 0x46, 0xa5, 0xd7, 0x24, 0xfd, 0x52,
+				0xd7, 0x6e, 0x06, 0x52, 0x0b, 0x64, 0xf2, 0xa1, 0xda, 0x1b, 0x33, 0x14, 0x69, 0xaa},
+		},
+		{
+			desc: "SHA384",
+			alg:  HashAlgorithmSHA384,
+			expected: Digest{0x8b, 0xbf, 0x22, 0x66, 0x53, 0x7c, 0x17, 0x1c, 0xb5, 0x6e, 0x40, 0x3c, 0x4d, 0xc1, 0xd4, 0xb6, 0x4f, 0x43,
+				0x26, 0x11, 0xdc, 0x38, 0x6e, 0x6f, 0x53, 0x20, 0x50, 0xc3, 0x27, 0x8c, 0x93, 0x0e, 0x14, 0x3e, 0x8b, 0xb1, 0x13, 0x38,
+				0x24, 0xcc, 0xb4, 0x31, 0x05, 0x38, 0x71, 0xc6, 0xdb, 0x53},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			digest, err := ComputeStandardEKAuthPolicy(data.alg)
+			if err != nil {
+				t.Fatalf("ComputeStandardEKAuthPolicy failed: %v", err)
+			}
+			if !bytes.Equal(digest, data.expected) {
+				t.Errorf("Unexpected digest (got %x, expected %x)", digest, data.expected)
+			}
+		})
+	}
+
+	if _, err := ComputeStandardEKAuthPolicy(HashAlgorithmNull); err == nil {
+		t.Errorf("ComputeStandardEKAuthPolicy should fail with an invalid algorithm")
+	}
+}
+
+func TestNewRSAEKTemplatePolicy(t *testing.T) {
+	expected, err := ComputeStandardEKAuthPolicy(HashAlgorithmSHA256)
+	if err != nil {
+		t.Fatalf("ComputeStandardEKAuthPolicy failed: %v", err)
+	}
+	if !bytes.Equal(NewRSAEKTemplate().AuthPolicy, expected) {
+		t.Errorf("Unexpected auth policy")
+	}
+}
+
+func TestTCGTemplates(t *testing.T) {
+	tpm := openTPMForTesting(t, testCapabilityOwnerHierarchy|testCapabilityEndorsementHierarchy)
+	defer closeTPM(t, tpm)
+
+	for _, data := range []struct {
+		desc      string
+		hierarchy ResourceContext
+		template  *Public
+	}{
+		{desc: "SRK", hierarchy: tpm.OwnerHandleContext(), template: NewRSAStorageKeyTemplate()},
+		{desc: "EK", hierarchy: tpm.EndorsementHandleContext(), template: NewRSAEKTemplate()},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			rc, pub, _, _, _, err := tpm.CreatePrimary(data.hierarchy, nil, data.template, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("CreatePrimary failed: %v", err)
+			}
+			defer flushContext(t, tpm, rc)
+
+			name, err := pub.Name()
+			if err != nil {
+				t.Fatalf("Name failed: %v", err)
+			}
+			if !bytes.Equal(rc.Name(), name) {
+				t.Errorf("CreatePrimary returned a context with the wrong name")
+			}
+			if pub.Attrs != data.template.Attrs {
+				t.Errorf("Unexpected attributes: %v", pub.Attrs)
+			}
+		})
+	}
+}
+
+func TestPublicFromCryptoKey(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	msg := []byte("this is a message for signing")
+	h := crypto.SHA256.New()
+	h.Write(msg)
+	digest := h.Sum(nil)
+
+	t.Run("RSA", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+
+		pub, err := PublicFromCryptoKey(&key.PublicKey, HashAlgorithmSHA256,
+			&AsymScheme{Scheme: AsymSchemeRSASSA, Details: AsymSchemeU{Data: &SigSchemeRSASSA{HashAlg: HashAlgorithmSHA256}}})
+		if err != nil {
+			t.Fatalf("PublicFromCryptoKey failed: %v", err)
+		}
+
+		context, err := tpm.LoadExternal(nil, pub, HandleOwner)
+		if err != nil {
+			t.Fatalf("LoadExternal failed: %v", err)
+		}
+		defer flushContext(t, tpm, context)
+
+		s, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+		if err != nil {
+			t.Fatalf("SignPKCS1v15 failed: %v", err)
+		}
+
+		signature := Signature{
+			SigAlg:    SigSchemeAlgRSASSA,
+			Signature: SignatureU{Data: &SignatureRSASSA{Hash: HashAlgorithmSHA256, Sig: PublicKeyRSA(s)}}}
+		if _, err := tpm.VerifySignature(context, digest, &signature); err != nil {
+			t.Errorf("VerifySignature failed: %v", err)
+		}
+	})
+
+	t.Run("ECC", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey failed: %v", err)
+		}
+
+		pub, err := PublicFromCryptoKey(&key.PublicKey, HashAlgorithmSHA256, nil)
+		if err != nil {
+			t.Fatalf("PublicFromCryptoKey failed: %v", err)
+		}
+
+		context, err := tpm.LoadExternal(nil, pub, HandleOwner)
+		if err != nil {
+			t.Fatalf("LoadExternal failed: %v", err)
+		}
+		defer flushContext(t, tpm, context)
+
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+
+		signature := Signature{
+			SigAlg: SigSchemeAlgECDSA,
+			Signature: SignatureU{
+				Data: &SignatureECC{
+					Hash:       HashAlgorithmSHA256,
+					SignatureR: ECCParameter(r.Bytes()),
+					SignatureS: ECCParameter(s.Bytes())}}}
+		if _, err := tpm.VerifySignature(context, digest, &signature); err != nil {
+			t.Errorf("VerifySignature failed: %v", err)
+		}
+	})
+}
+
+func TestPublicFromCryptoKeyAttrs(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	eccKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	for _, data := range []struct {
+		desc   string
+		key    crypto.PublicKey
+		scheme *AsymScheme
+		attrs  ObjectAttributes
+		err    string
+	}{
+		{
+			desc:  "RSANoScheme",
+			key:   &rsaKey.PublicKey,
+			attrs: AttrUserWithAuth | AttrSign | AttrDecrypt,
+		},
+		{
+			desc:   "RSASign",
+			key:    &rsaKey.PublicKey,
+			scheme: &AsymScheme{Scheme: AsymSchemeRSAPSS, Details: AsymSchemeU{Data: &SigSchemeRSAPSS{HashAlg: HashAlgorithmSHA256}}},
+			attrs:  AttrUserWithAuth | AttrSign,
+		},
+		{
+			desc:   "RSADecrypt",
+			key:    &rsaKey.PublicKey,
+			scheme: &AsymScheme{Scheme: AsymSchemeOAEP, Details: AsymSchemeU{Data: &EncSchemeOAEP{HashAlg: HashAlgorithmSHA256}}},
+			attrs:  AttrUserWithAuth | AttrDecrypt,
+		},
+		{
+			desc:   "RSAInvalidScheme",
+			key:    &rsaKey.PublicKey,
+			scheme: &AsymScheme{Scheme: AsymSchemeECDSA, Details: AsymSchemeU{Data: &SigSchemeECDSA{HashAlg: HashAlgorithmSHA256}}},
+			err:    "invalid scheme argument: invalid scheme TPM_ALG_ECDSA for RSA key",
+		},
+		{
+			desc:   "ECCSign",
+			key:    &eccKey.PublicKey,
+			scheme: &AsymScheme{Scheme: AsymSchemeECDSA, Details: AsymSchemeU{Data: &SigSchemeECDSA{HashAlg: HashAlgorithmSHA256}}},
+			attrs:  AttrUserWithAuth | AttrSign,
+		},
+		{
+			desc:   "ECCInvalidScheme",
+			key:    &eccKey.PublicKey,
+			scheme: &AsymScheme{Scheme: AsymSchemeRSASSA, Details: AsymSchemeU{Data: &SigSchemeRSASSA{HashAlg: HashAlgorithmSHA256}}},
+			err:    "invalid scheme argument: invalid scheme TPM_ALG_RSASSA for ECC key",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			pub, err := PublicFromCryptoKey(data.key, HashAlgorithmSHA256, data.scheme)
+			if data.err != "" {
+				if err == nil || err.Error() != data.err {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PublicFromCryptoKey failed: %v", err)
+			}
+			if pub.Attrs != data.attrs {
+				t.Errorf("Unexpected attributes: %v", pub.Attrs)
+			}
+			if pub.Attrs&(AttrFixedTPM|AttrFixedParent) != 0 {
+				t.Errorf("Public area should not be fixed")
+			}
+			if _, err := pub.Name(); err != nil {
+				t.Errorf("Cannot compute name: %v", err)
+			}
+		})
+	}
+}
+
+func TestPolicySecretTicketCache(t *testing.T) {
+	tpm := openTPMForTesting(t, 0)
+	defer closeTPM(t, tpm)
+
+	endorsement := tpm.EndorsementHandleContext()
+	defer endorsement.SetAuthValue(nil)
+
+	trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+	trial.PolicySecret(endorsement.Name(), []byte("foo"))
+
+	cache, err := NewPolicySecretTicketCache(endorsement, nil, []byte("foo"), 100)
+	if err != nil {
+		t.Fatalf("NewPolicySecretTicketCache failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		sessionContext, err := tpm.StartAuthSession(nil, nil, SessionTypePolicy, nil, HashAlgorithmSHA256)
+		if err != nil {
+			t.Fatalf("StartAuthSession failed: %v", err)
+		}
+		defer flushContext(t, tpm, sessionContext)
+
+		if err := cache.Run(tpm, sessionContext, nil); err != nil {
+			t.Fatalf("Run failed for session %d: %v", i, err)
+		}
+
+		digest, err := tpm.PolicyGetDigest(sessionContext)
+		if err != nil {
+			t.Fatalf("PolicyGetDigest failed: %v", err)
+		}
+		if !bytes.Equal(digest, trial.GetDigest()) {
+			t.Errorf("Unexpected digest for session %d", i)
+		}
+
+		// Subsequent sessions should be satisfied with the ticket, so the authorization value shouldn't be needed.
+		endorsement.SetAuthValue([]byte("wrong"))
+	}
+}
+
+func TestNewPolicySecretTicketCacheZeroExpiration(t *testing.T) {
+	tpm, _ := NewTPMContext(&mockTcti{})
+	if _, err := NewPolicySecretTicketCache(tpm.EndorsementHandleContext(), nil, nil, 0); err == nil {
+		t.Errorf("NewPolicySecretTicketCache should fail with a zero expiration")
+	}
+}
+
+func TestVerifyAttestationSignature(t *testing.T) {
+	attest := &Attest{
+		Magic:           TPMGeneratedValue,
+		Type:            TagAttestQuote,
+		QualifiedSigner: Name{0x40, 0x00, 0x00, 0x01},
+		ExtraData:       Data("nonce"),
+		ClockInfo:       ClockInfo{Clock: 1000, Safe: true},
+		Attested: AttestU{
+			Data: &QuoteInfo{
+				PCRSelect: PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{7}}},
+				PCRDigest: make(Digest, 32)}}}
+	attestBytes, _ := mu.MarshalToBytes(attest)
+	digest := sha256.Sum256(attestBytes)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	rsaPub, err := PublicFromCryptoKey(&rsaKey.PublicKey, HashAlgorithmSHA256, nil)
+	if err != nil {
+		t.Fatalf("PublicFromCryptoKey failed: %v", err)
+	}
+	eccKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	eccPub, err := PublicFromCryptoKey(&eccKey.PublicKey, HashAlgorithmSHA256, nil)
+	if err != nil {
+		t.Fatalf("PublicFromCryptoKey failed: %v", err)
+	}
+
+	rsassa, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15 failed: %v", err)
+	}
+	rsapss, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		t.Fatalf("SignPSS failed: %v", err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, eccKey, digest[:])
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	for _, data := range []struct {
+		desc string
+		pub  *Public
+		sig  *Signature
+	}{
+		{
+			desc: "RSASSA",
+			pub:  rsaPub,
+			sig: &Signature{
+				SigAlg:    SigSchemeAlgRSASSA,
+				Signature: SignatureU{Data: &SignatureRSASSA{Hash: HashAlgorithmSHA256, Sig: rsassa}}},
+		},
+		{
+			desc: "RSAPSS",
+			pub:  rsaPub,
+			sig: &Signature{
+				SigAlg:    SigSchemeAlgRSAPSS,
+				Signature: SignatureU{Data: &SignatureRSAPSS{Hash: HashAlgorithmSHA256, Sig: rsapss}}},
+		},
+		{
+			desc: "ECDSA",
+			pub:  eccPub,
+			sig: &Signature{
+				SigAlg:    SigSchemeAlgECDSA,
+				Signature: SignatureU{Data: &SignatureECDSA{Hash: HashAlgorithmSHA256, SignatureR: r.Bytes(), SignatureS: s.Bytes()}}},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			if err := VerifyAttestationSignature(data.pub, attest, data.sig); err != nil {
+				t.Errorf("VerifyAttestationSignature failed: %v", err)
+			}
+
+			modified := *attest
+			modified.ExtraData = Data("other")
+			if err := VerifyAttestationSignature(data.pub, &modified, data.sig); err == nil {
+				t.Errorf("VerifyAttestationSignature should fail for a modified attestation")
+			}
+
+			modified = *attest
+			modified.Magic = 0
+			err := VerifyAttestationSignature(data.pub, &modified, data.sig)
+			if _, ok := err.(*InvalidAttestMagicError); !ok {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	t.Run("WrongKeyType", func(t *testing.T) {
+		sig := &Signature{
+			SigAlg:    SigSchemeAlgRSASSA,
+			Signature: SignatureU{Data: &SignatureRSASSA{Hash: HashAlgorithmSHA256, Sig: rsassa}}}
+		if err := VerifyAttestationSignature(eccPub, attest, sig); err == nil {
+			t.Errorf("VerifyAttestationSignature should fail with the wrong key type")
+		}
+	})
+}
+
+func TestTPMSignerMock(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	eccKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	// respond signs the digest in a TPM2_Sign command with the software key corresponding to the scheme in the command.
+	respond := func(cmd []byte) []byte {
+		var digest Digest
+		var scheme SigScheme
+		if _, err := mu.UnmarshalFromBytes(decodeMockCommand(t, cmd, 1).Params, &digest, &scheme); err != nil {
+			t.Fatalf("Cannot unmarshal command: %v", err)
+		}
+		hashAlg := scheme.Details.Any().HashAlg
+
+		sig := Signature{SigAlg: scheme.Scheme}
+		switch scheme.Scheme {
+		case SigSchemeAlgRSASSA:
+			s, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, hashAlg.GetHash(), digest)
+			sig.Signature.Data = &SignatureRSASSA{Hash: hashAlg, Sig: s}
+		case SigSchemeAlgRSAPSS:
+			s, _ := rsa.SignPSS(rand.Reader, rsaKey, hashAlg.GetHash(), digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			sig.Signature.Data = &SignatureRSAPSS{Hash: hashAlg, Sig: s}
+		case SigSchemeAlgECDSA:
+			r, s, _ := ecdsa.Sign(rand.Reader, eccKey, digest)
+			sig.Signature.Data = &SignatureECDSA{Hash: hashAlg, SignatureR: r.Bytes(), SignatureS: s.Bytes()}
+		}
+
+		params, _ := mu.MarshalToBytes(&sig)
+		return makeMockResponse(Success, nil, params, mockPasswordAuth)
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+
+	for _, data := range []struct {
+		desc   string
+		pub    crypto.PublicKey
+		opts   crypto.SignerOpts
+		verify func(sig []byte) bool
+	}{
+		{
+			desc: "RSASSA",
+			pub:  &rsaKey.PublicKey,
+			opts: crypto.SHA256,
+			verify: func(sig []byte) bool {
+				return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig) == nil
+			},
+		},
+		{
+			desc: "RSAPSS",
+			pub:  &rsaKey.PublicKey,
+			opts: &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256},
+			verify: func(sig []byte) bool {
+				return rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+			},
+		},
+		{
+			desc: "ECDSA",
+			pub:  &eccKey.PublicKey,
+			opts: crypto.SHA256,
+			verify: func(sig []byte) bool {
+				var s struct {
+					R, S *big.Int
+				}
+				if _, err := asn1.Unmarshal(sig, &s); err != nil {
+					return false
+				}
+				return ecdsa.Verify(&eccKey.PublicKey, digest[:], s.R, s.S)
+			},
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			pub, err := PublicFromCryptoKey(data.pub, HashAlgorithmSHA256, nil)
+			if err != nil {
+				t.Fatalf("PublicFromCryptoKey failed: %v", err)
+			}
+			key, err := CreateObjectResourceContextFromPublic(0x80000001, pub)
+			if err != nil {
+				t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+			}
+
+			tpm, _ := NewTPMContext(&mockTcti{respond: respond})
+			signer, err := NewTPMSigner(tpm, key, nil, nil)
+			if err != nil {
+				t.Fatalf("NewTPMSigner failed: %v", err)
+			}
+			if !reflect.DeepEqual(signer.Public(), data.pub) {
+				t.Errorf("Unexpected public key")
+			}
+
+			sig, err := signer.Sign(rand.Reader, digest[:], data.opts)
+			if err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+			if !data.verify(sig) {
+				t.Errorf("Invalid signature")
+			}
+		})
+	}
+}
+
+func TestTPMSignerSchemeMismatch(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pub, err := PublicFromCryptoKey(&rsaKey.PublicKey, HashAlgorithmSHA256, nil)
+	if err != nil {
+		t.Fatalf("PublicFromCryptoKey failed: %v", err)
+	}
+	key, err := CreateObjectResourceContextFromPublic(0x80000001, pub)
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+
+	for _, data := range []struct {
+		desc   string
+		scheme SigSchemeId
+		opts   crypto.SignerOpts
+		errMsg string
+	}{
+		{
+			desc:   "RSASSAWithPSSOptions",
+			scheme: SigSchemeAlgRSASSA,
+			opts:   &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256},
+			errMsg: "signature scheme TPM_ALG_RSASSA is not compatible with the supplied options of type *rsa.PSSOptions",
+		},
+		{
+			desc:   "RSAPSSWithoutPSSOptions",
+			scheme: SigSchemeAlgRSAPSS,
+			opts:   crypto.SHA256,
+			errMsg: "signature scheme TPM_ALG_RSAPSS is not compatible with the supplied options of type crypto.Hash",
+		},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			tcti := &mockTcti{}
+			tpm, _ := NewTPMContext(tcti)
+			signer, err := NewTPMSigner(tpm, key, &SigScheme{Scheme: data.scheme}, nil)
+			if err != nil {
+				t.Fatalf("NewTPMSigner failed: %v", err)
+			}
+
+			_, err = signer.Sign(rand.Reader, digest[:], data.opts)
+			if err == nil || err.Error() != data.errMsg {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tcti.commands.Len() != 0 {
+				t.Errorf("No command should have been sent to the TPM")
+			}
+		})
+	}
+}
+
+func TestPublicTPM2B(t *testing.T) {
+	pub := NewRSAStorageKeyTemplate()
+	pub.Unique.Data = make(PublicKeyRSA, 256)
+	rand.Read(pub.Unique.Data.(PublicKeyRSA))
+
+	// Construct the TPM2B_PUBLIC encoding by hand, as tpm2-tools would write it.
+	raw, _ := mu.MarshalToBytes(pub)
+	data, _ := mu.MarshalToBytes(uint16(len(raw)), mu.RawBytes(raw))
+
+	p, err := UnmarshalPublicFromTPM2B(data)
+	if err != nil {
+		t.Fatalf("UnmarshalPublicFromTPM2B failed: %v", err)
+	}
+	expectedName, _ := pub.Name()
+	name, err := p.Name()
+	if err != nil {
+		t.Fatalf("Name failed: %v", err)
+	}
+	if !bytes.Equal(name, expectedName) {
+		t.Errorf("Unexpected name: %x", name)
+	}
+
+	b, err := MarshalPublicToTPM2B(p)
+	if err != nil {
+		t.Fatalf("MarshalPublicToTPM2B failed: %v", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("MarshalPublicToTPM2B returned unexpected bytes: %x", b)
+	}
+
+	if _, err := UnmarshalPublicFromTPM2B(append(data, 0)); err == nil {
+		t.Errorf("UnmarshalPublicFromTPM2B should fail with trailing bytes")
+	}
+	if _, err := UnmarshalPublicFromTPM2B([]byte{0, 0}); err == nil {
+		t.Errorf("UnmarshalPublicFromTPM2B should fail with an empty public area")
+	}
+}
+
+func TestPublicDERAndPEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	eccKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	for _, data := range []struct {
+		desc string
+		key  crypto.PublicKey
+	}{
+		{desc: "RSA", key: &rsaKey.PublicKey},
+		{desc: "ECC", key: &eccKey.PublicKey},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			pub, err := PublicFromCryptoKey(data.key, HashAlgorithmSHA256, nil)
+			if err != nil {
+				t.Fatalf("PublicFromCryptoKey failed: %v", err)
+			}
+			expectedName, _ := pub.Name()
+
+			der, err := MarshalPublicToDER(pub)
+			if err != nil {
+				t.Fatalf("MarshalPublicToDER failed: %v", err)
+			}
+			p, err := UnmarshalPublicFromDER(der, HashAlgorithmSHA256, nil)
+			if err != nil {
+				t.Fatalf("UnmarshalPublicFromDER failed: %v", err)
+			}
+			if name, _ := p.Name(); !bytes.Equal(name, expectedName) {
+				t.Errorf("Unexpected name: %x", name)
+			}
+
+			pemData, err := MarshalPublicToPEM(pub)
+			if err != nil {
+				t.Fatalf("MarshalPublicToPEM failed: %v", err)
+			}
+			if !bytes.HasPrefix(pemData, []byte("-----BEGIN PUBLIC KEY-----\n")) {
+				t.Errorf("Unexpected PEM data: %s", pemData)
+			}
+			p, err = UnmarshalPublicFromPEM(pemData, HashAlgorithmSHA256, nil)
+			if err != nil {
+				t.Fatalf("UnmarshalPublicFromPEM failed: %v", err)
+			}
+			if name, _ := p.Name(); !bytes.Equal(name, expectedName) {
+				t.Errorf("Unexpected name: %x", name)
+			}
+		})
+	}
+}
+
+// mockSealingTPM is a minimal mock of the commands used by TPMContext.SealData and TPMContext.UnsealData.
+type mockSealingTPM struct {
+	t          *testing.T
+	pcrValue   byte
+	auth       Auth
+	secret     SensitiveData
+	template   *Public
+	commands   []CommandCode
+	policyPCRs bool
+}
+
+func (m *mockSealingTPM) respond(cmd []byte) []byte {
+	withSessions := func(params ...interface{}) []byte {
+		p, _ := mu.MarshalToBytes(params...)
+		return makeMockResponse(Success, nil, p, mockPasswordAuth)
+	}
+	noSessions := func(params ...interface{}) []byte {
+		p, _ := mu.MarshalToBytes(params...)
+		return makeMockResponse(Success, nil, p)
+	}
+
+	var code CommandCode
+	mu.UnmarshalFromBytes(cmd[6:], &code)
+	m.commands = append(m.commands, code)
+
+	switch code {
+	case CommandPCRRead:
+		var pcrs PCRSelectionList
+		mu.UnmarshalFromBytes(decodeMockCommand(m.t, cmd, 0).Params, &pcrs)
+		var values DigestList
+		for _, s := range pcrs {
+			for range s.Select {
+				values = append(values, bytes.Repeat([]byte{m.pcrValue}, s.Hash.Size()))
+			}
+		}
+		return noSessions(uint32(0), pcrs, values)
+	case CommandCreate:
+		var sensitiveSize, publicSize uint16
+		var public Public
+		mu.UnmarshalFromBytes(decodeMockCommand(m.t, cmd, 1).Params, &sensitiveSize, &m.auth, &m.secret, &publicSize, &public)
+		public.Unique = PublicIDU{Data: make(Digest, 32)}
+		m.template = &public
+		pub, _ := mu.MarshalToBytes(&public)
+		return withSessions(Private("private"), uint16(len(pub)), mu.RawBytes(pub), uint16(0), Digest(nil),
+			TkCreation{Tag: TagCreation, Hierarchy: HandleOwner})
+	case CommandLoad:
+		name, _ := m.template.Name()
+		p, _ := mu.MarshalToBytes(name)
+		return makeMockResponse(Success, []Handle{Handle(0x80000001)}, p, mockPasswordAuth)
+	case CommandReadPublic:
+		pub, _ := mu.MarshalToBytes(m.template)
+		name, _ := m.template.Name()
+		return noSessions(uint16(len(pub)), mu.RawBytes(pub), name, Name(nil))
+	case CommandStartAuthSession:
+		return noSessions(Handle(0x03000000), Nonce(make([]byte, 32)))
+	case CommandPolicyPCR:
+		m.policyPCRs = m.pcrValue == 0x01
+		return noSessions()
+	case CommandPolicyAuthValue, CommandFlushContext:
+		return noSessions()
+	case CommandUnseal:
+		if len(m.template.AuthPolicy) > 0 && !m.policyPCRs {
+			// TPM_RC_POLICY_FAIL for session index 1
+			return makeMockResponse(ResponseCode(0x99d), nil, nil)
+		}
+		if len(m.template.AuthPolicy) == 0 || len(m.auth) == 0 {
+			return withSessions(m.secret)
+		}
+		// The policy includes a TPM2_PolicyAuthValue assertion, so the response requires a HMAC keyed with the authorization
+		// value of the object.
+		nonceCaller := decodeMockCommand(m.t, cmd, 1).Auths[0].Nonce
+		rp, _ := mu.MarshalToBytes(m.secret)
+		rpHash := sha256.New()
+		mu.MarshalToWriter(rpHash, Success, CommandUnseal, mu.RawBytes(rp))
+		h := hmac.New(sha256.New, m.auth)
+		h.Write(rpHash.Sum(nil))
+		h.Write(nonceCaller)
+		h.Write([]byte{uint8(AttrContinueSession)})
+		return makeMockResponse(Success, nil, rp, mockResponseAuth{Attrs: uint8(AttrContinueSession), HMAC: h.Sum(nil)})
+	}
+	return nil
+}
+
+func TestSealDataMock(t *testing.T) {
+	pcrs := PCRSelectionList{{Hash: HashAlgorithmSHA256, Select: []int{7}}}
+	parent, err := CreateObjectResourceContextFromPublic(0x81000001, NewRSAStorageKeyTemplate())
+	if err != nil {
+		t.Fatalf("CreateObjectResourceContextFromPublic failed: %v", err)
+	}
+
+	for _, data := range []struct {
+		desc string
+		pcrs PCRSelectionList
+		auth []byte
+	}{
+		{desc: "NoPCRsNoAuth"},
+		{desc: "NoPCRs", auth: []byte("1234")},
+		{desc: "PCRsNoAuth", pcrs: pcrs},
+		{desc: "PCRs", pcrs: pcrs, auth: []byte("1234")},
+	} {
+		t.Run(data.desc, func(t *testing.T) {
+			m := &mockSealingTPM{t: t, pcrValue: 0x01}
+			tpm, _ := NewTPMContext(&mockTcti{respond: m.respond})
+
+			priv, pub, err := tpm.SealData(parent, []byte("secret"), data.pcrs, data.auth)
+			if err != nil {
+				t.Fatalf("SealData failed: %v", err)
+			}
+			if !bytes.Equal(priv, []byte("private")) {
+				t.Errorf("Unexpected private area: %x", priv)
+			}
+
+			var expectedPolicy Digest
+			if len(data.pcrs) > 0 {
+				trial, _ := ComputeAuthPolicy(HashAlgorithmSHA256)
+				trial.PolicyPCRValues(PCRValues{HashAlgorithmSHA256: {7: bytes.Repeat([]byte{0x01}, 32)}})
+				if len(data.auth) > 0 {
+					trial.PolicyAuthValue()
+				}
+				expectedPolicy = trial.GetDigest()
+			}
+			if !bytes.Equal(pub.AuthPolicy, expectedPolicy) {
+				t.Errorf("Unexpected authorization policy: %x", pub.AuthPolicy)
+			}
+			if pub.Attrs&AttrUserWithAuth != 0 && len(data.pcrs) > 0 {
+				t.Errorf("Object with PCR policy shouldn't permit authorization with its authorization value")
+			}
+
+			secret, err := tpm.UnsealData(parent, priv, pub, data.pcrs, data.auth)
+			if err != nil {
+				t.Fatalf("UnsealData failed: %v", err)
+			}
+			if !bytes.Equal(secret, []byte("secret")) {
+				t.Errorf("Unexpected secret: %q", secret)
+			}
+			if m.commands[len(m.commands)-1] != CommandFlushContext {
+				t.Errorf("The sealed object should have been flushed")
+			}
+
+			if len(data.pcrs) == 0 {
+				return
+			}
+
+			m.pcrValue = 0x02
+			_, err = tpm.UnsealData(parent, priv, pub, data.pcrs, data.auth)
+			if !IsTPMSessionError(err, ErrorPolicyFail, CommandUnseal, 1) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}